@@ -0,0 +1,98 @@
+package carstore
+
+import (
+	"context"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Blockstore returns bs, falling back to the registered CAR files for the
+// blocks it does not have. The blocks of the CAR files are read-only: they
+// are neither listed by AllKeysChan nor removed by DeleteBlock, so garbage
+// collection ignores them.
+//
+// Has only reports the blocks of bs, so that the blocks written through the
+// returned blockstore, e.g. by adds and imports, are stored even when a CAR
+// file holds them.
+func (s *Store) Blockstore(bs blockstore.Blockstore) blockstore.Blockstore {
+	return &carBlockstore{Blockstore: bs, store: s}
+}
+
+type carBlockstore struct {
+	blockstore.Blockstore
+	store *Store
+}
+
+func (bs *carBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	b, err := bs.Blockstore.Get(c)
+	if err == blockstore.ErrNotFound {
+		return bs.store.Get(c)
+	}
+	return b, err
+}
+
+func (bs *carBlockstore) GetSize(c cid.Cid) (int, error) {
+	size, err := bs.Blockstore.GetSize(c)
+	if err == blockstore.ErrNotFound {
+		b, err := bs.store.Get(c)
+		if err != nil {
+			return -1, err
+		}
+		return len(b.RawData()), nil
+	}
+	return size, err
+}
+
+// Copy writes the blocks of the DAG under root, or only root if not
+// recursive, that are held by the CAR files and not by bs, into bs. Pinned
+// DAGs are copied so that they outlive the files. bs must be backed by
+// the Blockstore of s.
+func (s *Store) Copy(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, recursive bool) error {
+	if s == nil || s.empty() {
+		return nil
+	}
+
+	seen := cid.NewSet()
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+
+		b, err := bs.Get(c)
+		if err != nil {
+			return err
+		}
+		if s.Has(c) {
+			has, err := bs.Has(c)
+			if err != nil {
+				return err
+			}
+			if !has {
+				if err := bs.Put(b); err != nil {
+					return err
+				}
+			}
+		}
+		if !recursive {
+			return nil
+		}
+
+		nd, err := ipld.Decode(b)
+		if err != nil {
+			return err
+		}
+		for _, l := range nd.Links() {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return nil
+}
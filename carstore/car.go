@@ -0,0 +1,110 @@
+package carstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	gocar "github.com/ipld/go-car"
+)
+
+// Pragma is the beginning of every CARv2 file: a CARv1 header announcing
+// version 2.
+var Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// HeaderSize is the size of the CARv2 header following the pragma.
+const HeaderSize = 40
+
+// Header is the CARv2 header, locating the CARv1 data payload and the index
+// in the file.
+type Header struct {
+	Characteristics [2]uint64
+	DataOffset      uint64
+	DataSize        uint64
+	// IndexOffset is 0 when there is no index.
+	IndexOffset uint64
+}
+
+// NewHeader returns the header of a CARv2 file whose data payload of the
+// given size directly follows the header, followed by the index.
+func NewHeader(dataSize uint64) Header {
+	offset := uint64(len(Pragma) + HeaderSize)
+	return Header{
+		DataOffset:  offset,
+		DataSize:    dataSize,
+		IndexOffset: offset + dataSize,
+	}
+}
+
+// WriteTo writes the pragma and the header.
+func (h Header) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, len(Pragma)+HeaderSize)
+	copy(buf, Pragma)
+	b := buf[len(Pragma):]
+	binary.LittleEndian.PutUint64(b[0:], h.Characteristics[0])
+	binary.LittleEndian.PutUint64(b[8:], h.Characteristics[1])
+	binary.LittleEndian.PutUint64(b[16:], h.DataOffset)
+	binary.LittleEndian.PutUint64(b[24:], h.DataSize)
+	binary.LittleEndian.PutUint64(b[32:], h.IndexOffset)
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+func readHeader(r io.Reader) (Header, error) {
+	var b [HeaderSize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return Header{}, fmt.Errorf("reading CARv2 header: %w", err)
+	}
+	h := Header{
+		Characteristics: [2]uint64{
+			binary.LittleEndian.Uint64(b[0:]),
+			binary.LittleEndian.Uint64(b[8:]),
+		},
+		DataOffset:  binary.LittleEndian.Uint64(b[16:]),
+		DataSize:    binary.LittleEndian.Uint64(b[24:]),
+		IndexOffset: binary.LittleEndian.Uint64(b[32:]),
+	}
+	if h.DataOffset < uint64(len(Pragma)+HeaderSize) {
+		return Header{}, errors.New("invalid CARv2 header: data payload overlaps the header")
+	}
+	return h, nil
+}
+
+// NewReader reads the beginning of a CARv1 or CARv2 stream, and returns its
+// CARv1 header along with a reader positioned on the first block of the CARv1
+// data payload. For CARv2, the reader stops at the end of the payload.
+func NewReader(r io.Reader) (*gocar.CarHeader, *bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	peek, err := br.Peek(len(Pragma))
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(peek, Pragma) {
+		if _, err := br.Discard(len(Pragma)); err != nil {
+			return nil, nil, err
+		}
+		h, err := readHeader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		skip := int64(h.DataOffset) - int64(len(Pragma)+HeaderSize)
+		if _, err := io.CopyN(ioutil.Discard, br, skip); err != nil {
+			return nil, nil, fmt.Errorf("seeking CARv2 data payload: %w", err)
+		}
+		br = bufio.NewReader(io.LimitReader(br, int64(h.DataSize)))
+	}
+
+	ch, err := gocar.ReadHeader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ch.Version != 1 {
+		return nil, nil, fmt.Errorf("invalid car version: %d", ch.Version)
+	}
+	return ch, br, nil
+}
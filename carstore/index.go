@@ -0,0 +1,283 @@
+package carstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Index codecs of CARv2.
+const (
+	// IndexSorted buckets the digests by width only.
+	IndexSorted = 0x0400
+	// MultihashIndexSorted buckets the digests by multihash code, then by
+	// width. It is the codec written by Index.WriteTo.
+	MultihashIndexSorted = 0x0401
+)
+
+// Index locates the sections of the blocks in a CARv1 data payload, by
+// multihash. Offsets are relative to the start of the payload.
+type Index struct {
+	// codes maps multihash codes to their buckets, by digest width. Digests
+	// of IndexSorted indexes are filed under code -1.
+	codes map[int64]map[uint32]*bucket
+}
+
+// bucket holds fixed-width records: a digest followed by a uint64 offset.
+type bucket struct {
+	width uint32
+	data  []byte
+}
+
+func (b *bucket) Len() int { return len(b.data) / int(b.width) }
+
+func (b *bucket) digest(i int) []byte {
+	return b.data[i*int(b.width) : (i+1)*int(b.width)-8]
+}
+
+func (b *bucket) offset(i int) uint64 {
+	return binary.LittleEndian.Uint64(b.data[(i+1)*int(b.width)-8:])
+}
+
+func (b *bucket) Less(i, j int) bool { return bytes.Compare(b.digest(i), b.digest(j)) < 0 }
+
+func (b *bucket) Swap(i, j int) {
+	w := int(b.width)
+	ri, rj := b.data[i*w:(i+1)*w], b.data[j*w:(j+1)*w]
+	for k := range ri {
+		ri[k], rj[k] = rj[k], ri[k]
+	}
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{codes: make(map[int64]map[uint32]*bucket)}
+}
+
+// Add records the offset of the section of c. Call Sort once all the blocks
+// are added.
+func (idx *Index) Add(c cid.Cid, offset uint64) error {
+	dmh, err := mh.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	idx.add(int64(dmh.Code), dmh.Digest, offset)
+	return nil
+}
+
+func (idx *Index) add(code int64, digest []byte, offset uint64) {
+	buckets, ok := idx.codes[code]
+	if !ok {
+		buckets = make(map[uint32]*bucket)
+		idx.codes[code] = buckets
+	}
+	width := uint32(len(digest) + 8)
+	b, ok := buckets[width]
+	if !ok {
+		b = &bucket{width: width}
+		buckets[width] = b
+	}
+	var off [8]byte
+	binary.LittleEndian.PutUint64(off[:], offset)
+	b.data = append(append(b.data, digest...), off[:]...)
+}
+
+// Sort sorts the records, as required by Find and WriteTo.
+func (idx *Index) Sort() {
+	for _, buckets := range idx.codes {
+		for _, b := range buckets {
+			sort.Sort(b)
+		}
+	}
+}
+
+// Len returns the number of records.
+func (idx *Index) Len() int {
+	n := 0
+	for _, buckets := range idx.codes {
+		for _, b := range buckets {
+			n += b.Len()
+		}
+	}
+	return n
+}
+
+// Find returns the offset of the section of the block with multihash h.
+func (idx *Index) Find(h mh.Multihash) (uint64, bool) {
+	dmh, err := mh.Decode(h)
+	if err != nil {
+		return 0, false
+	}
+	width := uint32(len(dmh.Digest) + 8)
+	for _, code := range []int64{int64(dmh.Code), -1} {
+		b, ok := idx.codes[code][width]
+		if !ok {
+			continue
+		}
+		i := sort.Search(b.Len(), func(i int) bool {
+			return bytes.Compare(b.digest(i), dmh.Digest) >= 0
+		})
+		if i < b.Len() && bytes.Equal(b.digest(i), dmh.Digest) {
+			return b.offset(i), true
+		}
+	}
+	return 0, false
+}
+
+// WriteTo writes the index in the MultihashIndexSorted format, preceded by
+// its codec.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	if _, err := cw.Write(uvarint(MultihashIndexSorted)); err != nil {
+		return cw.n, err
+	}
+
+	codes := make([]int64, 0, len(idx.codes))
+	for code := range idx.codes {
+		if code < 0 {
+			return cw.n, errors.New("cannot write an index read without multihash codes")
+		}
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	if err := binary.Write(cw, binary.LittleEndian, int32(len(codes))); err != nil {
+		return cw.n, err
+	}
+	for _, code := range codes {
+		if err := binary.Write(cw, binary.LittleEndian, uint64(code)); err != nil {
+			return cw.n, err
+		}
+		if err := writeBuckets(cw, idx.codes[code]); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+func writeBuckets(w io.Writer, buckets map[uint32]*bucket) error {
+	widths := make([]uint32, 0, len(buckets))
+	for width := range buckets {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+
+	if err := binary.Write(w, binary.LittleEndian, int32(len(widths))); err != nil {
+		return err
+	}
+	for _, width := range widths {
+		b := buckets[width]
+		if err := binary.Write(w, binary.LittleEndian, width); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, int64(len(b.data))); err != nil {
+			return err
+		}
+		if _, err := w.Write(b.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadIndex reads an IndexSorted or MultihashIndexSorted index, preceded by
+// its codec.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := &byteReader{r: r}
+	codec, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading index codec: %w", err)
+	}
+
+	idx := NewIndex()
+	switch codec {
+	case IndexSorted:
+		buckets, err := readBuckets(r)
+		if err != nil {
+			return nil, err
+		}
+		idx.codes[-1] = buckets
+	case MultihashIndexSorted:
+		var count int32
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return nil, err
+		}
+		for i := int32(0); i < count; i++ {
+			var code uint64
+			if err := binary.Read(r, binary.LittleEndian, &code); err != nil {
+				return nil, err
+			}
+			buckets, err := readBuckets(r)
+			if err != nil {
+				return nil, err
+			}
+			idx.codes[int64(code)] = buckets
+		}
+	default:
+		return nil, fmt.Errorf("unsupported index codec 0x%x", codec)
+	}
+	return idx, nil
+}
+
+func readBuckets(r io.Reader) (map[uint32]*bucket, error) {
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, errors.New("invalid index: negative bucket count")
+	}
+
+	buckets := make(map[uint32]*bucket, count)
+	for i := int32(0); i < count; i++ {
+		var width uint32
+		var size int64
+		if err := binary.Read(r, binary.LittleEndian, &width); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		if width <= 8 || size < 0 || size%int64(width) != 0 {
+			return nil, fmt.Errorf("invalid index bucket: width %d, size %d", width, size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		buckets[width] = &bucket{width: width, data: data}
+	}
+	return buckets, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type byteReader struct {
+	r io.Reader
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(br.r, b[:])
+	return b[0], err
+}
+
+func uvarint(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, v)]
+}
@@ -0,0 +1,350 @@
+// Package carstore serves blocks straight from CAR files registered in the
+// repo, without copying them into the blockstore. Like the filestore, it only
+// references the files: they must stay in place, unmodified.
+package carstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	logging "github.com/ipfs/go-log"
+	gocar "github.com/ipld/go-car"
+)
+
+var log = logging.Logger("carstore")
+
+var carPrefix = ds.NewKey("/local/carstore")
+
+// ErrNotRegistered is returned when removing a CAR file which is not
+// registered.
+var ErrNotRegistered = errors.New("CAR file not registered")
+
+// Car is a CAR file registered in the store.
+type Car struct {
+	Path    string
+	Version uint64
+	Roots   []cid.Cid
+	Blocks  int
+	// Indexed is whether the file carries an index. The other files are
+	// scanned every time the store is opened.
+	Indexed bool
+	// Err is why the file cannot be read, its blocks are not served then.
+	Err error
+}
+
+type carFile struct {
+	Car
+	f *os.File
+	// offset of the CARv1 data payload in f
+	dataOffset int64
+	idx        *Index
+}
+
+// Store serves the blocks of the registered CAR files. Registrations persist
+// in the datastore.
+type Store struct {
+	dstore ds.Datastore
+
+	lk   sync.RWMutex
+	cars map[string]*carFile
+}
+
+// NewStore opens the CAR files registered in the datastore. Files failing to
+// open are kept registered, and reported by List.
+func NewStore(dstore ds.Datastore) (*Store, error) {
+	s := &Store{
+		dstore: dstore,
+		cars:   make(map[string]*carFile),
+	}
+
+	res, err := dstore.Query(query.Query{Prefix: carPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		path := string(r.Value)
+		cf, err := openCar(path)
+		if err != nil {
+			log.Errorf("opening CAR file %s: %s", path, err)
+			cf = &carFile{Car: Car{Path: path, Err: err}}
+		}
+		s.cars[path] = cf
+	}
+	return s, nil
+}
+
+func carKey(path string) ds.Key {
+	return carPrefix.Child(ds.NewKey(path))
+}
+
+// Add registers the CAR file at the given absolute path.
+func (s *Store) Add(path string) (*Car, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("%s is not an absolute path", path)
+	}
+	path = filepath.Clean(path)
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if _, ok := s.cars[path]; ok {
+		return nil, fmt.Errorf("%s is already registered", path)
+	}
+
+	cf, err := openCar(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dstore.Put(carKey(path), []byte(path)); err != nil {
+		cf.f.Close()
+		return nil, err
+	}
+	s.cars[path] = cf
+	return &cf.Car, nil
+}
+
+// Remove unregisters the CAR file at the given path.
+func (s *Store) Remove(path string) error {
+	path = filepath.Clean(path)
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	cf, ok := s.cars[path]
+	if !ok {
+		return ErrNotRegistered
+	}
+	if err := s.dstore.Delete(carKey(path)); err != nil {
+		return err
+	}
+	delete(s.cars, path)
+	if cf.f != nil {
+		return cf.f.Close()
+	}
+	return nil
+}
+
+// List returns the registered CAR files, sorted by path.
+func (s *Store) List() []*Car {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	cars := make([]*Car, 0, len(s.cars))
+	for _, cf := range s.cars {
+		c := cf.Car
+		cars = append(cars, &c)
+	}
+	sort.Slice(cars, func(i, j int) bool { return cars[i].Path < cars[j].Path })
+	return cars
+}
+
+// Close closes the CAR files.
+func (s *Store) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	var err error
+	for _, cf := range s.cars {
+		if cf.f == nil {
+			continue
+		}
+		if cerr := cf.f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// find returns the CAR file holding the block with the given cid, and the
+// offset of its section.
+func (s *Store) find(c cid.Cid) (*carFile, int64, bool) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	for _, cf := range s.cars {
+		if cf.idx == nil {
+			continue
+		}
+		if off, ok := cf.idx.Find(c.Hash()); ok {
+			return cf, cf.dataOffset + int64(off), true
+		}
+	}
+	return nil, 0, false
+}
+
+// Get returns the block with the given cid, checking its data against it.
+func (s *Store) Get(c cid.Cid) (blocks.Block, error) {
+	cf, off, ok := s.find(c)
+	if !ok {
+		return nil, blockstore.ErrNotFound
+	}
+
+	_, data, _, err := readSection(cf.f, off)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s from %s: %w", c, cf.Path, err)
+	}
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sum.Equals(c) {
+		return nil, fmt.Errorf("block %s in %s does not match its hash, was the file modified?", c, cf.Path)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// empty returns whether no CAR file is registered.
+func (s *Store) empty() bool {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return len(s.cars) == 0
+}
+
+// Has returns whether a registered CAR file holds the block with the given
+// cid.
+func (s *Store) Has(c cid.Cid) bool {
+	_, _, ok := s.find(c)
+	return ok
+}
+
+// openCar opens a CARv1 or CARv2 file, reading its index or building one.
+func openCar(path string) (*carFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := readCar(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	cf.Path = path
+	return cf, nil
+}
+
+func readCar(f *os.File) (*carFile, error) {
+	cf := &carFile{f: f}
+	dataSize := int64(-1)
+
+	pragma := make([]byte, len(Pragma))
+	if _, err := f.ReadAt(pragma, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(pragma) == string(Pragma) {
+		h, err := readHeader(io.NewSectionReader(f, int64(len(Pragma)), HeaderSize))
+		if err != nil {
+			return nil, err
+		}
+		cf.Version = 2
+		cf.dataOffset = int64(h.DataOffset)
+		dataSize = int64(h.DataSize)
+		if h.IndexOffset != 0 {
+			idx, err := ReadIndex(bufio.NewReader(io.NewSectionReader(f, int64(h.IndexOffset), 1<<62)))
+			if err != nil {
+				return nil, fmt.Errorf("reading index: %w", err)
+			}
+			cf.idx = idx
+			cf.Indexed = true
+		}
+	} else {
+		cf.Version = 1
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(f, cf.dataOffset, 1<<62))
+	ch, err := gocar.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if ch.Version != 1 {
+		return nil, fmt.Errorf("invalid car version: %d", ch.Version)
+	}
+	cf.Roots = ch.Roots
+
+	if cf.idx == nil {
+		// the payload starts with the length of the header
+		_, headerSize, err := readUvarintAt(f, cf.dataOffset)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := scan(f, cf.dataOffset, headerSize, dataSize)
+		if err != nil {
+			return nil, fmt.Errorf("indexing: %w", err)
+		}
+		cf.idx = idx
+	}
+	cf.Blocks = cf.idx.Len()
+	return cf, nil
+}
+
+// scan indexes the sections of the payload at the given offset, starting after
+// its header.
+func scan(f *os.File, dataOffset, headerSize, dataSize int64) (*Index, error) {
+	idx := NewIndex()
+	for pos := headerSize; dataSize < 0 || pos < dataSize; {
+		c, _, n, err := readSection(f, dataOffset+pos)
+		if err == io.EOF && dataSize < 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := idx.Add(c, uint64(pos)); err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	idx.Sort()
+	return idx, nil
+}
+
+// readUvarintAt reads the uvarint at the given offset, and returns it along
+// with the total size of the varint and of the data it prefixes.
+func readUvarintAt(f io.ReaderAt, off int64) (uint64, int64, error) {
+	var buf [binary.MaxVarintLen64]byte
+	n, err := f.ReadAt(buf[:], off)
+	if n == 0 {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	l, vn := binary.Uvarint(buf[:n])
+	if vn <= 0 {
+		return 0, 0, errors.New("invalid section length")
+	}
+	return l, int64(vn) + int64(l), nil
+}
+
+// readSection reads the section at the given offset, returning its cid, its
+// data and its total size.
+func readSection(f io.ReaderAt, off int64) (cid.Cid, []byte, int64, error) {
+	l, n, err := readUvarintAt(f, off)
+	if err != nil {
+		return cid.Undef, nil, 0, err
+	}
+	buf := make([]byte, l)
+	if _, err := f.ReadAt(buf, off+n-int64(l)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return cid.Undef, nil, 0, err
+	}
+	cn, c, err := cid.CidFromBytes(buf)
+	if err != nil {
+		return cid.Undef, nil, 0, err
+	}
+	return c, buf[cn:], n, nil
+}
@@ -0,0 +1,267 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	mdag "github.com/ipfs/go-merkledag"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+func testBlocks(n int) []blocks.Block {
+	var bl []blocks.Block
+	for i := 0; i < n; i++ {
+		bl = append(bl, blocks.NewBlock([]byte(fmt.Sprintf("block %d", i))))
+	}
+	return bl
+}
+
+// writeCarV1 writes the blocks as a CARv1 file rooted at the first one.
+func writeCarV1(t *testing.T, path string, bl []blocks.Block) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Version: 1, Roots: []cid.Cid{bl[0].Cid()}}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range bl {
+		if err := carutil.LdWrite(&buf, b.Cid().Bytes(), b.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bl := testBlocks(10)
+
+	path := filepath.Join(dir, "v1.car")
+	writeCarV1(t, path, bl)
+
+	s, err := NewStore(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("v1.car"); err == nil {
+		t.Fatal("expected relative paths to be rejected")
+	}
+	car, err := s.Add(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if car.Version != 1 || car.Indexed || car.Blocks != len(bl) || !car.Roots[0].Equals(bl[0].Cid()) {
+		t.Fatalf("unexpected CAR file %+v", car)
+	}
+	if _, err := s.Add(path); err == nil {
+		t.Fatal("expected registering the file twice to fail")
+	}
+
+	// the repo blockstore falls back to the CAR file
+	repoBlock := blocks.NewBlock([]byte("repo block"))
+	base := blockstore.NewBlockstore(dstore)
+	if err := base.Put(repoBlock); err != nil {
+		t.Fatal(err)
+	}
+	bs := s.Blockstore(base)
+	for _, b := range append(bl, repoBlock) {
+		got, err := bs.Get(b.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.RawData(), b.RawData()) {
+			t.Fatalf("block %s differs", b.Cid())
+		}
+		// only the blocks of the repo are reported, the others are
+		// written when added
+		if has, err := bs.Has(b.Cid()); err != nil || has != (b == repoBlock) {
+			t.Fatalf("expected Has(%s) to only report the repo block, got %t, %v", b.Cid(), has, err)
+		}
+		if size, err := bs.GetSize(b.Cid()); err != nil || size != len(b.RawData()) {
+			t.Fatalf("expected %s to be %d bytes, got %d, %v", b.Cid(), len(b.RawData()), size, err)
+		}
+	}
+	missing := blocks.NewBlock([]byte("missing"))
+	if _, err := bs.Get(missing.Cid()); err != blockstore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// the blocks of the CAR file are not listed
+	ch, err := bs.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("expected to list the repo block only, got %d blocks", n)
+	}
+
+	// registrations persist
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewStore(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cars := s.List(); len(cars) != 1 || cars[0].Path != path || cars[0].Err != nil {
+		t.Fatalf("unexpected CAR files %+v", cars)
+	}
+
+	// modified blocks are not served
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, bl[3].RawData(), []byte("BLOCK 3"), 1)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(bl[3].Cid()); err == nil {
+		t.Fatal("expected the modified block to fail")
+	}
+
+	// missing files are listed with their error
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewStore(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cars := s.List(); len(cars) != 1 || cars[0].Err == nil {
+		t.Fatalf("expected the missing file to be listed with an error, got %+v", cars)
+	}
+	if s.Has(bl[0].Cid()) {
+		t.Fatal("expected the blocks of the missing file to be gone")
+	}
+
+	if err := s.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(path); err != ErrNotRegistered {
+		t.Fatalf("expected ErrNotRegistered, got %v", err)
+	}
+	if cars := s.List(); len(cars) != 0 {
+		t.Fatalf("expected no CAR file, got %+v", cars)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	bl := testBlocks(20)
+	idx := NewIndex()
+	for i, b := range bl {
+		if err := idx.Add(b.Cid(), uint64(i*100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx.Sort()
+
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range bl {
+		if off, ok := read.Find(b.Cid().Hash()); !ok || off != uint64(i*100) {
+			t.Fatalf("expected %s at %d, got %d, %t", b.Cid(), i*100, off, ok)
+		}
+	}
+
+	// IndexSorted has a single set of buckets for all multihash codes
+	buf.Reset()
+	buf.Write(uvarint(IndexSorted))
+	if err := writeBuckets(&buf, idx.codes[int64(bl[0].Cid().Prefix().MhType)]); err != nil {
+		t.Fatal(err)
+	}
+	read, err = ReadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off, ok := read.Find(bl[7].Cid().Hash()); !ok || off != 700 {
+		t.Fatalf("expected %s at 700, got %d, %t", bl[7].Cid(), off, ok)
+	}
+	if _, ok := read.Find(blocks.NewBlock([]byte("missing")).Cid().Hash()); ok {
+		t.Fatal("found a missing block")
+	}
+
+	// the header locates the payload and the index
+	buf.Reset()
+	if _, err := NewHeader(1000).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), Pragma) {
+		t.Fatal("expected the header to start with the pragma")
+	}
+	if got := binary.LittleEndian.Uint64(buf.Bytes()[len(Pragma)+32:]); got != 1051 {
+		t.Fatalf("expected the index at 1051, got %d", got)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	// root -> child, in the CAR file, and other
+	child := mdag.NodeWithData([]byte("child"))
+	root := mdag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	other := mdag.NodeWithData([]byte("other"))
+	path := filepath.Join(t.TempDir(), "dag.car")
+	writeCarV1(t, path, []blocks.Block{root, child, other})
+
+	s, err := NewStore(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := blockstore.NewBlockstore(dstore)
+	bs := s.Blockstore(base)
+
+	// nothing is read without CAR files
+	if err := s.Copy(ctx, bs, root.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Copy(ctx, bs, other.Cid(), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Copy(ctx, bs, root.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*mdag.ProtoNode{root, child, other} {
+		if has, err := base.Has(nd.Cid()); err != nil || !has {
+			t.Fatalf("expected %s to be copied into the repo, got %t, %v", nd.Cid(), has, err)
+		}
+	}
+}
@@ -414,7 +414,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		}
 	}
 
-	// repo blockstore GC - if --enable-gc flag is present
+	// repo blockstore GC - if --enable-gc flag is present, and pin expiry
 	gcErrc, err := maybeRunGC(req, node)
 	if err != nil {
 		return err
@@ -444,6 +444,19 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// start MFS pinning thread
 	startPinMFS(daemonConfigPollInterval, cctx, &ipfsPinMFSNode{node})
 
+	// start retrying queued remote pins
+	startRemotePinQueue(daemonConfigPollInterval, cctx, node)
+
+	// start mirroring local pins following the remote pinning policies
+	startPinPolicies(daemonConfigPollInterval, cctx, node)
+
+	// start syncing the directories watched with 'ipfs filestore watch'
+	if node.Filestore != nil {
+		if err := node.FileWatches.Start(cctx.Context()); err != nil {
+			return fmt.Errorf("starting the filestore watches: %w", err)
+		}
+	}
+
 	// The daemon is *finally* ready.
 	fmt.Printf("Daemon is ready\n")
 	notifyReady()
@@ -533,6 +546,7 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("api"),
+		corehttp.RateLimitOption(),
 		corehttp.MetricsOpenCensusCollectionOption(),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsOption(*cctx),
@@ -664,6 +678,7 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("gateway"),
+		corehttp.RateLimitOption(),
 		corehttp.HostnameOption(),
 		corehttp.GatewayOption(writable, "/ipfs", "/ipns"),
 		corehttp.VersionOption(),
@@ -739,13 +754,16 @@ func mountFuse(req *cmds.Request, cctx *oldcmds.Context) error {
 
 func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 	enableGC, _ := req.Options[enableGCKwd].(bool)
-	if !enableGC {
-		return nil, nil
-	}
 
 	errc := make(chan error)
 	go func() {
-		errc <- corerepo.PeriodicGC(req.Context, node)
+		if enableGC {
+			errc <- corerepo.PeriodicGC(req.Context, node)
+		} else {
+			// expired pins are still removed, PeriodicGC does it otherwise,
+			// until the node is closed by 'ipfs shutdown'
+			errc <- corerepo.PeriodicUnpinExpired(node.Context(), node)
+		}
 		close(errc)
 	}()
 	return errc, nil
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+
+	logging "github.com/ipfs/go-log"
+
+	"github.com/ipfs/go-ipfs/core"
+)
+
+// policylog is the logger for the remote pinning policies
+var policylog = logging.Logger("remotepinning/policy")
+
+// startPinPolicies applies the Pins policy of the remote pinning services
+// every interval. The Add and IPNS policies are applied as data is added and
+// published.
+func startPinPolicies(interval time.Duration, cctx pinMFSContext, node *core.IpfsNode) {
+	go func() {
+		tmo := time.NewTimer(interval)
+		defer tmo.Stop()
+
+		for {
+			select {
+			case <-cctx.Context().Done():
+				return
+			case <-tmo.C:
+			}
+
+			n, err := node.PinPolicies.MirrorPins(cctx.Context(), node.Pinning, node.PinMeta)
+			if err != nil {
+				policylog.Errorf("mirroring local pins: %v", err)
+			} else if n > 0 {
+				policylog.Infof("queued %d local pins for remote pinning", n)
+			}
+			tmo.Reset(interval)
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+
+	"github.com/ipfs/go-ipfs/core"
+)
+
+// startRemotePinQueue sends the queued remote pins to their services, reading
+// the services from the current config every time.
+func startRemotePinQueue(interval time.Duration, cctx pinMFSContext, node *core.IpfsNode) {
+	clients := func(name string) (*pinclient.Client, error) {
+		cfg, err := cctx.GetConfigNoCache()
+		if err != nil {
+			return nil, fmt.Errorf("pinning reading config (%v)", err)
+		}
+		svc, ok := cfg.Pinning.RemoteServices[name]
+		if !ok {
+			return nil, fmt.Errorf("remote pinning service %s is not configured", name)
+		}
+		return pinclient.NewClient(svc.API.Endpoint, svc.API.Key), nil
+	}
+
+	origins := func() []ma.Multiaddr {
+		if node.PeerHost == nil {
+			return nil
+		}
+		addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(node.PeerHost))
+		if err != nil {
+			return nil
+		}
+		return addrs
+	}
+
+	go node.RemotePins.Run(cctx.Context(), interval, clients, origins)
+}
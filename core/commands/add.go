@@ -9,8 +9,11 @@ import (
 	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/coreunix"
 
 	"github.com/cheggaaa/pb"
+	humanize "github.com/dustin/go-humanize"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
@@ -23,9 +26,10 @@ var ErrDepthLimitExceeded = fmt.Errorf("depth limit exceeded")
 
 type AddEvent struct {
 	Name  string
-	Hash  string `json:",omitempty"`
-	Bytes int64  `json:",omitempty"`
-	Size  string `json:",omitempty"`
+	Hash  string               `json:",omitempty"`
+	Bytes int64                `json:",omitempty"`
+	Size  string               `json:",omitempty"`
+	Dedup *coreunix.DedupStats `json:",omitempty"`
 }
 
 const (
@@ -45,6 +49,11 @@ const (
 	hashOptionName        = "hash"
 	inlineOptionName      = "inline"
 	inlineLimitOptionName = "inline-limit"
+
+	preserveModeOptionName  = "preserve-mode"
+	preserveMtimeOptionName = "preserve-mtime"
+	dedupReportOptionName   = "dedup-report"
+	resumeOptionName        = "resume"
 )
 
 const adderOutChanSize = 8
@@ -107,10 +116,54 @@ You can now check what blocks have been created by:
   QmerURi9k4XzKCaaPbsK6BL5pMEjF7PGphjDvkkjDtsVf3 868
   QmQB28iwSriSUSMqG2nXDTLtdPHgWb4rebBrU7Q1j4vxPv 338
 
+To compare chunkers on a dataset, '--dedup-report' prints how many chunks
+of each file, and how many bytes, were already present in the repo. Combined
+with '--only-hash' it does not store anything:
+
+  > ipfs add --only-hash --dedup-report --chunker=buzhash data.bin
+  added QmcNzG7yvUhRHW6cD5cG4sWdQAkQeStqhm9vkBAQAyN3mB data.bin
+  dedup data.bin: 3 of 40 chunks new, 1.2 MB of 10 MB new (88.0% deduplicated)
+  dedup total: 3 of 40 chunks new, 1.2 MB of 10 MB new (88.0% deduplicated)
+
 Finally, a note on hash determinism. While not guaranteed, adding the same
 file/directory with the same flags will almost always result in the same output
 hash. However, almost all of the flags provided by this command (other than pin,
 only-hash, and progress/status related flags) will change the final hash.
+
+The permissions and modification times of the added files, directories and
+symlinks are recorded in the UnixFS metadata with '--preserve-mode' and
+'--preserve-mtime'. 'ipfs get' restores them. A single-block file added with
+'--raw-leaves' gets an extra root block holding them. The CLI sends them to
+the daemon with each file, as parameters of the Content-Disposition header of
+its part in the multipart request: 'mode' in octal, 'mtime' in seconds since
+the epoch and 'mtime-nsecs'. HTTP API clients set them the same way; adding a
+file sent without them fails instead of dropping them:
+
+  Content-Disposition: form-data; name="file"; filename="dir%2Frun.sh"; mode=755; mtime=1577977445
+
+The files of the added directories can be filtered with .gitignore-style
+patterns, matched against their paths relative to the added directory:
+'--exclude' skips the matching files and directories, and when '--include'
+is given, only the files matching one of its patterns are added. Both can be
+repeated. The patterns of the '.ipfsignore' files found in the added
+directories apply to their directory and below; the files themselves are not
+added, and '--ipfsignore=false' disregards them. Unlike '--ignore', which the
+client applies, these filters are applied by the node as well, before the
+files are chunked, so they behave the same over the HTTP API:
+
+  > ipfs add -r --exclude=.git --exclude='*.o' --include='*.go' src
+
+With '--resume', the progress of the added files is checkpointed in the repo
+every 64 MiB. If the add is interrupted, running it again with '--resume'
+picks up each file where its checkpoint stopped, as long as the file, its
+size and modification time, and the flags are the same. When the file can be
+read from where it stopped, as when adding without a daemon, the data before
+it is not read again; otherwise it is checked against the checkpoint. The
+checkpoint of a file is removed once it is added; those left by adds that
+were not resumed within a week are removed by 'ipfs repo gc', which keeps
+the blocks of the other ones:
+
+  > ipfs add --resume --progress huge.img
 `,
 	},
 
@@ -140,8 +193,25 @@ only-hash, and progress/status related flags) will change the final hash.
 		cmds.StringOption(hashOptionName, "Hash function to use. Implies CIDv1 if not sha2-256. (experimental)").WithDefault("sha2-256"),
 		cmds.BoolOption(inlineOptionName, "Inline small blocks into CIDs. (experimental)"),
 		cmds.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
+		cmds.BoolOption(preserveModeOptionName, "Record the permissions of the files in the UnixFS metadata."),
+		cmds.BoolOption(preserveMtimeOptionName, "Record the modification times of the files in the UnixFS metadata."),
+		cmds.BoolOption(dedupReportOptionName, "Report how many chunks of each file were already present."),
+		cmds.StringsOption(excludeOptionName, "A .gitignore-style pattern of the files of the added directories to skip."),
+		cmds.StringsOption(includeOptionName, "A .gitignore-style pattern of the only files of the added directories to add."),
+		cmds.BoolOption(ipfsignoreOptionName, "Skip the files matching the patterns of the "+coreunix.IgnoreFileName+" files of the added directories.").WithDefault(true),
+		cmds.BoolOption(resumeOptionName, "Checkpoint large files while adding them, and resume the files an interrupted add checkpointed."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		filter, err := addFilter(req)
+		if err != nil {
+			return err
+		}
+		if filter != nil {
+			if err := filterAddedFiles(req, filter); err != nil {
+				return err
+			}
+		}
+
 		quiet, _ := req.Options[quietOptionName].(bool)
 		quieter, _ := req.Options[quieterOptionName].(bool)
 		quiet = quiet || quieter
@@ -165,6 +235,10 @@ only-hash, and progress/status related flags) will change the final hash.
 		if err != nil {
 			return err
 		}
+		unixfs, ok := api.Unixfs().(*coreapi.UnixfsAPI)
+		if !ok {
+			return fmt.Errorf("unexpected unixfs API %T", api.Unixfs())
+		}
 
 		progress, _ := req.Options[progressOptionName].(bool)
 		trickle, _ := req.Options[trickleOptionName].(bool)
@@ -180,6 +254,15 @@ only-hash, and progress/status related flags) will change the final hash.
 		hashFunStr, _ := req.Options[hashOptionName].(string)
 		inline, _ := req.Options[inlineOptionName].(bool)
 		inlineLimit, _ := req.Options[inlineLimitOptionName].(int)
+		preserveMode, _ := req.Options[preserveModeOptionName].(bool)
+		preserveMtime, _ := req.Options[preserveMtimeOptionName].(bool)
+		dedupReport, _ := req.Options[dedupReportOptionName].(bool)
+		resume, _ := req.Options[resumeOptionName].(bool)
+
+		filter, err := addFilter(req)
+		if err != nil {
+			return err
+		}
 
 		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
 		if !ok {
@@ -192,9 +275,15 @@ only-hash, and progress/status related flags) will change the final hash.
 		}
 
 		toadd := req.Files
+		if preserveMode || preserveMtime {
+			toadd = coreunix.WithMeta(toadd, addMetaFunc(preserveMode, preserveMtime)).(files.Directory)
+		}
+		if filter != nil {
+			toadd = &filteredEntriesDir{Directory: toadd, filter: filter}
+		}
 		if wrap {
 			toadd = files.NewSliceDirectory([]files.DirEntry{
-				files.FileEntry("", req.Files),
+				files.FileEntry("", toadd),
 			})
 		}
 
@@ -232,15 +321,30 @@ only-hash, and progress/status related flags) will change the final hash.
 		var added int
 		addit := toadd.Entries()
 		for addit.Next() {
-			_, dir := addit.Node().(files.Directory)
+			addNode := addit.Node()
+			_, dir := addNode.(files.Directory)
 			errCh := make(chan error, 1)
 			events := make(chan interface{}, adderOutChanSize)
 			opts[len(opts)-1] = options.Unixfs.Events(events)
 
+			var addOpts []coreapi.AddOption
+			var report *coreunix.DedupReport
+			if dedupReport {
+				n, err := cmdenv.GetNode(env)
+				if err != nil {
+					return err
+				}
+				report = coreunix.NewDedupReport(n.Blockstore)
+				addOpts = append(addOpts, coreapi.AddDedupReport(report))
+			}
+			if resume {
+				addOpts = append(addOpts, coreapi.AddResume())
+			}
+
 			go func() {
 				var err error
 				defer close(events)
-				_, err = api.Unixfs().Add(req.Context, addit.Node(), opts...)
+				_, err = unixfs.AddWithOptions(req.Context, addNode, opts, addOpts...)
 				errCh <- err
 			}()
 
@@ -274,6 +378,18 @@ only-hash, and progress/status related flags) will change the final hash.
 			if err := <-errCh; err != nil {
 				return err
 			}
+			if report != nil {
+				for _, f := range report.Files {
+					name := path.Join(addit.Name(), f.Path)
+					if !dir && addit.Name() != "" {
+						name = addit.Name()
+					}
+					stats := f.DedupStats
+					if err := res.Emit(&AddEvent{Name: name, Dedup: &stats}); err != nil {
+						return err
+					}
+				}
+			}
 			added++
 		}
 
@@ -326,6 +442,11 @@ only-hash, and progress/status related flags) will change the final hash.
 
 				lastFile := ""
 				lastHash := ""
+				var dedupTotal *coreunix.DedupStats
+				dedupOut := os.Stdout
+				if quiet {
+					dedupOut = os.Stderr
+				}
 				var totalProgress, prevFiles, lastBytes int64
 
 			LOOP:
@@ -336,10 +457,24 @@ only-hash, and progress/status related flags) will change the final hash.
 							if quieter {
 								fmt.Fprintln(os.Stdout, lastHash)
 							}
+							if dedupTotal != nil {
+								printDedup(dedupOut, "total", dedupTotal)
+							}
 
 							break LOOP
 						}
 						output := out.(*AddEvent)
+						if output.Dedup != nil {
+							if progress {
+								fmt.Fprintf(os.Stderr, "\033[2K\r")
+							}
+							printDedup(dedupOut, cmdenv.EscNonPrint(output.Name), output.Dedup)
+							if dedupTotal == nil {
+								dedupTotal = new(coreunix.DedupStats)
+							}
+							dedupTotal.Add(*output.Dedup)
+							continue
+						}
 						if len(output.Hash) > 0 {
 							lastHash = output.Hash
 							if quieter {
@@ -429,3 +564,14 @@ only-hash, and progress/status related flags) will change the final hash.
 	},
 	Type: AddEvent{},
 }
+
+func printDedup(w io.Writer, name string, s *coreunix.DedupStats) {
+	chunks := s.NewChunks + s.DupChunks
+	size := s.NewBytes + s.DupBytes
+	dedup := 0.0
+	if size > 0 {
+		dedup = 100 * float64(s.DupBytes) / float64(size)
+	}
+	fmt.Fprintf(w, "dedup %s: %d of %d chunks new, %s of %s new (%.1f%% deduplicated)\n",
+		name, s.NewChunks, chunks, humanize.Bytes(s.NewBytes), humanize.Bytes(size), dedup)
+}
@@ -0,0 +1,184 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	files "github.com/ipfs/go-ipfs-files"
+
+	"github.com/ipfs/go-ipfs/core/coreunix"
+)
+
+const (
+	excludeOptionName    = "exclude"
+	includeOptionName    = "include"
+	ipfsignoreOptionName = "ipfsignore"
+)
+
+// addFilter returns the filter requested by the options of 'ipfs add', or nil
+// when there is none.
+func addFilter(req *cmds.Request) (*coreunix.Filter, error) {
+	exclude, _ := req.Options[excludeOptionName].([]string)
+	include, _ := req.Options[includeOptionName].([]string)
+	useIgnoreFiles, _ := req.Options[ipfsignoreOptionName].(bool)
+
+	var ignoreFiles []string
+	if useIgnoreFiles {
+		ignoreFiles = []string{coreunix.IgnoreFileName}
+	}
+	if len(exclude) == 0 && len(include) == 0 && len(ignoreFiles) == 0 {
+		return nil, nil
+	}
+	return coreunix.NewFilter(exclude, include, ignoreFiles)
+}
+
+// filterAddedFiles applies the filter of the request to the added
+// directories, before they are sent to the daemon. The ignore files are read
+// from disk, as hidden files are not sent without '--hidden', and listed
+// first.
+func filterAddedFiles(req *cmds.Request, filter *coreunix.Filter) error {
+	useIgnoreFiles, _ := req.Options[ipfsignoreOptionName].(bool)
+
+	var entries []files.DirEntry
+	it := req.Files.Entries()
+	for it.Next() {
+		nd := it.Node()
+		if dir, ok := nd.(files.Directory); ok {
+			if useIgnoreFiles {
+				if path := localDirPath(dir); path != "" {
+					dir = &ignoreFilesDir{Directory: dir, path: path}
+				}
+			}
+			nd = filter.Apply(dir, true)
+		}
+		entries = append(entries, files.FileEntry(it.Name(), nd))
+	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+	req.Files = files.NewSliceDirectory(entries)
+	return nil
+}
+
+// localDirPath returns the path of a directory read from disk by the client,
+// from the paths of the files it contains, or "" if it has none.
+func localDirPath(dir files.Directory) string {
+	it := dir.Entries()
+	for it.Next() {
+		var path string
+		switch nd := it.Node().(type) {
+		case files.FileInfo:
+			if nd.AbsPath() != "" {
+				path = filepath.Dir(nd.AbsPath())
+			}
+		case files.Directory:
+			if sub := localDirPath(nd); sub != "" {
+				path = filepath.Dir(sub)
+			}
+		}
+		// the files are opened again when sent
+		it.Node().Close()
+		if path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// ignoreFilesDir is a directory read from disk at path, listing its ignore
+// files first.
+type ignoreFilesDir struct {
+	files.Directory
+	path string
+}
+
+// Stat returns the stat of the directory, sent along with it.
+func (d *ignoreFilesDir) Stat() os.FileInfo {
+	if s, ok := d.Directory.(interface{ Stat() os.FileInfo }); ok {
+		return s.Stat()
+	}
+	return nil
+}
+
+func (d *ignoreFilesDir) Entries() files.DirIterator {
+	it := &ignoreFilesIterator{it: d.Directory.Entries(), dir: d}
+	data, err := ioutil.ReadFile(filepath.Join(d.path, coreunix.IgnoreFileName))
+	switch {
+	case err == nil:
+		it.first = files.NewBytesFile(data)
+	case !os.IsNotExist(err):
+		it.err = err
+	}
+	return it
+}
+
+type ignoreFilesIterator struct {
+	it    files.DirIterator
+	dir   *ignoreFilesDir
+	first files.Node
+
+	name string
+	node files.Node
+	err  error
+}
+
+func (it *ignoreFilesIterator) Name() string     { return it.name }
+func (it *ignoreFilesIterator) Node() files.Node { return it.node }
+
+func (it *ignoreFilesIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
+
+func (it *ignoreFilesIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.first != nil {
+		it.name, it.node = coreunix.IgnoreFileName, it.first
+		it.first = nil
+		return true
+	}
+	for it.it.Next() {
+		name, nd := it.it.Name(), it.it.Node()
+		if name == coreunix.IgnoreFileName {
+			// already listed
+			nd.Close()
+			continue
+		}
+		if dir, ok := nd.(files.Directory); ok {
+			nd = &ignoreFilesDir{Directory: dir, path: filepath.Join(it.dir.path, name)}
+		}
+		it.name, it.node = name, nd
+		return true
+	}
+	return false
+}
+
+// filteredEntriesDir applies a filter to the directories it lists, which are
+// the added ones: the paths matched by the filter are relative to them.
+type filteredEntriesDir struct {
+	files.Directory
+	filter *coreunix.Filter
+}
+
+func (d *filteredEntriesDir) Entries() files.DirIterator {
+	return &filteredEntriesIterator{DirIterator: d.Directory.Entries(), filter: d.filter}
+}
+
+type filteredEntriesIterator struct {
+	files.DirIterator
+	filter *coreunix.Filter
+}
+
+func (it *filteredEntriesIterator) Node() files.Node {
+	nd := it.DirIterator.Node()
+	if dir, ok := nd.(files.Directory); ok {
+		return it.filter.Apply(dir, false)
+	}
+	return nd
+}
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
+)
+
+// addMetaFunc returns the metadata of the added files, as sent by the client
+// with each file: the files read from disk have their stat, and the parts of
+// the multipart requests of the API carry their mode and modification time.
+// Symlinks and the directory wrapping the added files have none. Files sent without the requested metadata fail the add,
+// rather than being added without it.
+func addMetaFunc(mode, mtime bool) coreunix.MetaFunc {
+	return func(p string, nd files.Node) (unixfsmeta.Meta, error) {
+		var meta unixfsmeta.Meta
+		if _, ok := nd.(*files.Symlink); ok || p == "" {
+			return meta, nil
+		}
+
+		fmode, fmtime := fileMeta(nd)
+		if mode {
+			if fmode == 0 {
+				return meta, fmt.Errorf("--%s: the mode of %q was not sent with it", preserveModeOptionName, p)
+			}
+			meta.Mode = unixfsmeta.FromFileMode(fmode)
+			meta.HasMode = true
+		}
+		if mtime {
+			if fmtime.IsZero() {
+				return meta, fmt.Errorf("--%s: the modification time of %q was not sent with it", preserveMtimeOptionName, p)
+			}
+			meta.Mtime = fmtime
+		}
+		return meta, nil
+	}
+}
+
+// fileMeta returns the mode and modification time of nd, zero when unknown.
+func fileMeta(nd files.Node) (os.FileMode, time.Time) {
+	if s, ok := nd.(interface{ Stat() os.FileInfo }); ok && s.Stat() != nil {
+		return s.Stat().Mode(), s.Stat().ModTime()
+	}
+	if m, ok := nd.(interface {
+		Mode() os.FileMode
+		ModTime() time.Time
+	}); ok {
+		return m.Mode(), m.ModTime()
+	}
+	return 0, time.Time{}
+}
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	config "github.com/ipfs/go-ipfs-config"
+	files "github.com/ipfs/go-ipfs-files"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
+)
+
+// sendFiles returns dir as read by the API from the multipart request of the
+// CLI.
+func sendFiles(t *testing.T, dir files.Directory) files.Directory {
+	mfr := files.NewMultiFileReader(dir, true)
+	d, err := files.NewFileFromPartReader(multipart.NewReader(mfr, mfr.Boundary()), "multipart/form-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func newMetaAdder(t *testing.T) (*core.IpfsNode, *coreunix.Adder) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe",
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder, err := coreunix.NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan interface{}, adderOutChanSize)
+	adder.Out = out
+	go func() {
+		for range out {
+		}
+	}()
+	return node, adder
+}
+
+func TestAddMetaManyFiles(t *testing.T) {
+	const dirs, perDir = 40, 50
+
+	tmp, err := ioutil.TempDir("", "addmeta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	modes := []os.FileMode{0600, 0644, 0700, 0755 | os.ModeSetgid}
+	base := time.Unix(1577977445, 0)
+	want := make(map[string]unixfsmeta.Meta)
+	for i := 0; i < dirs; i++ {
+		sub := fmt.Sprintf("sub%02d", i)
+		if err := os.Mkdir(filepath.Join(tmp, sub), 0750); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < perDir; j++ {
+			n := i*perDir + j
+			name := filepath.Join(tmp, sub, fmt.Sprintf("file%02d", j))
+			if err := ioutil.WriteFile(name, []byte(name), 0600); err != nil {
+				t.Fatal(err)
+			}
+			mode, mtime := modes[n%len(modes)], base.Add(time.Duration(n)*time.Second+time.Duration(n))
+			if err := os.Chmod(name, mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(name, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			want[fmt.Sprintf("%s/file%02d", sub, j)] = unixfsmeta.Meta{Mode: mode, HasMode: true, Mtime: mtime}
+		}
+		mtime := base.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filepath.Join(tmp, sub), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		want[sub] = unixfsmeta.Meta{Mode: 0750, HasMode: true, Mtime: mtime}
+	}
+
+	st, err := os.Stat(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := files.NewSerialFile(tmp, false, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := sendFiles(t, files.NewSliceDirectory([]files.DirEntry{files.FileEntry("dir", sf)}))
+
+	node, adder := newMetaAdder(t)
+	root, err := adder.AddAllAndPin(coreunix.WithMeta(dir, addMetaFunc(true, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for p, m := range want {
+		nd := root
+		for _, name := range strings.Split("dir/"+p, "/") {
+			l, _, err := nd.ResolveLink([]string{name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nd, err = l.GetNode(ctx, node.DAG); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := unixfsmeta.FromNode(nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.HasMode != m.HasMode || got.Mode != m.Mode || !got.Mtime.Equal(m.Mtime) {
+			t.Fatalf("%s: expected %+v, got %+v", p, m, got)
+		}
+	}
+}
+
+func TestAddMetaNotSent(t *testing.T) {
+	dir := sendFiles(t, files.NewSliceDirectory([]files.DirEntry{
+		files.FileEntry("file", files.NewBytesFile([]byte("no metadata"))),
+	}))
+
+	_, adder := newMetaAdder(t)
+	_, err := adder.AddAllAndPin(coreunix.WithMeta(dir, addMetaFunc(false, true)))
+	if err == nil || !strings.Contains(err.Error(), preserveMtimeOptionName) {
+		t.Fatalf("expected the add to fail without the mtime, got %v", err)
+	}
+}
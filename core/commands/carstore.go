@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/carstore"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+)
+
+var CarStoreCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Serve blocks straight from CAR files.",
+		ShortDescription: `
+The carstore serves the blocks of registered CAR files without copying them
+into the repo. The files are only referenced: they must stay in place and
+unmodified, the blocks read from them are checked against their hash.
+
+The blocks of registered files are read-only: they are not listed by
+'ipfs refs local' and garbage collection ignores them. Adding or pinning the
+blocks held in a CAR file copies them into the repo, so that they stay
+available once the file is removed.
+
+CARv2 files carry an index, as written by 'ipfs dag export --car-version=2'.
+Other files are scanned every time the node starts.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add": addCarStore,
+		"ls":  lsCarStore,
+		"rm":  rmCarStore,
+	},
+}
+
+// CarStoreEntry is a CAR file registered in the carstore.
+type CarStoreEntry struct {
+	Path    string
+	Version uint64
+	Roots   []cid.Cid
+	Blocks  int
+	Indexed bool
+	Error   string `json:",omitempty"`
+}
+
+func toCarStoreEntry(c *carstore.Car) *CarStoreEntry {
+	e := &CarStoreEntry{
+		Path:    c.Path,
+		Version: c.Version,
+		Roots:   c.Roots,
+		Blocks:  c.Blocks,
+		Indexed: c.Indexed,
+	}
+	if c.Err != nil {
+		e.Error = c.Err.Error()
+	}
+	return e
+}
+
+var carStoreEncoders = cmds.EncoderMap{
+	cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *CarStoreEntry) error {
+		if out.Error != "" {
+			_, err := fmt.Fprintf(w, "%s\tERROR: %s\n", out.Path, out.Error)
+			return err
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+		roots := make([]string, len(out.Roots))
+		for i, c := range out.Roots {
+			roots[i] = enc.Encode(c)
+		}
+		index := "indexed"
+		if !out.Indexed {
+			index = "scanned"
+		}
+		_, err = fmt.Fprintf(w, "%s\tv%d\t%d blocks (%s)\t%s\n", out.Path, out.Version, out.Blocks, index, strings.Join(roots, ","))
+		return err
+	}),
+}
+
+var addCarStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Register CAR files in the carstore.",
+		ShortDescription: `
+Registers CARv1 or CARv2 files, their blocks are then served by the node.
+Relative paths are resolved by the client, the files must be readable by the
+daemon at the same path.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, true, "Path of the CAR file."),
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		return absCarStorePaths(req)
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		for _, path := range req.Arguments {
+			c, err := n.CarStore.Add(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if err := res.Emit(toCarStoreEntry(c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type:     CarStoreEntry{},
+	Encoders: carStoreEncoders,
+}
+
+var lsCarStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the CAR files registered in the carstore.",
+		ShortDescription: `
+Lists the registered CAR files, with their version, number of blocks and roots.
+Files which cannot be read anymore are listed with the error.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		for _, c := range n.CarStore.List() {
+			if err := res.Emit(toCarStoreEntry(c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type:     CarStoreEntry{},
+	Encoders: carStoreEncoders,
+}
+
+var rmCarStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Unregister CAR files from the carstore.",
+		ShortDescription: "Stops serving the blocks of the given CAR files, the files are left untouched.",
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, true, "Path of the CAR file."),
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		return absCarStorePaths(req)
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		for _, path := range req.Arguments {
+			if err := n.CarStore.Remove(path); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if err := res.Emit(&CarStoreEntry{Path: path}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: CarStoreEntry{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *CarStoreEntry) error {
+			_, err := fmt.Fprintf(w, "removed %s\n", out.Path)
+			return err
+		}),
+	},
+}
+
+func absCarStorePaths(req *cmds.Request) error {
+	for i, path := range req.Arguments {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		req.Arguments[i] = abs
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/denylist"
 
 	"github.com/ipfs/go-ipfs-cmds"
 	"github.com/ipfs/go-ipfs-files"
@@ -34,10 +36,19 @@ var CatCmd = &cmds.Command{
 		cmds.Int64Option(lengthOptionName, "l", "Maximum number of bytes to read."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
 			return err
 		}
+		// the blocked content is not read under the paths either
+		api, err = coreapi.WithDenylist(api, node.Denylist)
+		if err != nil {
+			return err
+		}
 
 		offset, _ := req.Options[offsetOptionName].(int64)
 		if offset < 0 {
@@ -58,7 +69,7 @@ var CatCmd = &cmds.Command{
 			return err
 		}
 
-		readers, length, err := cat(req.Context, api, req.Arguments, int64(offset), int64(max))
+		readers, length, err := cat(req.Context, api, node.Denylist, req.Arguments, int64(offset), int64(max))
 		if err != nil {
 			return err
 		}
@@ -111,14 +122,25 @@ var CatCmd = &cmds.Command{
 	},
 }
 
-func cat(ctx context.Context, api iface.CoreAPI, paths []string, offset int64, max int64) ([]io.Reader, uint64, error) {
+func cat(ctx context.Context, api iface.CoreAPI, deny *denylist.Denylist, paths []string, offset int64, max int64) ([]io.Reader, uint64, error) {
 	readers := make([]io.Reader, 0, len(paths))
 	length := uint64(0)
 	if max == 0 {
 		return nil, 0, nil
 	}
 	for _, p := range paths {
-		f, err := api.Unixfs().Get(ctx, path.New(p))
+		if err := deny.Check(p); err != nil {
+			return nil, 0, err
+		}
+		rp, err := api.ResolvePath(ctx, path.New(p))
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := deny.CheckResolved(p, rp); err != nil {
+			return nil, 0, err
+		}
+
+		f, err := api.Unixfs().Get(ctx, rp)
 		if err != nil {
 			return nil, 0, err
 		}
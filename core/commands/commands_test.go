@@ -108,6 +108,10 @@ func TestCommands(t *testing.T) {
 		"/dht/provide",
 		"/dht/put",
 		"/dht/query",
+		"/denylist",
+		"/denylist/add",
+		"/denylist/ls",
+		"/denylist/rm",
 		"/diag",
 		"/diag/cmds",
 		"/diag/cmds/clear",
@@ -126,10 +130,18 @@ func TestCommands(t *testing.T) {
 		"/files/read",
 		"/files/rm",
 		"/files/stat",
+		"/carstore",
+		"/carstore/add",
+		"/carstore/ls",
+		"/carstore/rm",
 		"/filestore",
 		"/filestore/dups",
+		"/filestore/fix",
 		"/filestore/ls",
 		"/filestore/verify",
+		"/filestore/watch",
+		"/filestore/watch/ls",
+		"/filestore/watch/rm",
 		"/files/write",
 		"/get",
 		"/id",
@@ -181,11 +193,18 @@ func TestCommands(t *testing.T) {
 		"/pin/remote",
 		"/pin/remote/add",
 		"/pin/remote/ls",
+		"/pin/remote/policy",
+		"/pin/remote/policy/status",
+		"/pin/remote/queue",
+		"/pin/remote/queue/cancel",
+		"/pin/remote/queue/ls",
+		"/pin/remote/queue/retry",
 		"/pin/remote/rm",
 		"/pin/remote/service",
 		"/pin/remote/service/add",
 		"/pin/remote/service/ls",
 		"/pin/remote/service/rm",
+		"/pin/remote/sync",
 		"/pin/rm",
 		"/pin/update",
 		"/pin/verify",
@@ -226,6 +245,9 @@ func TestCommands(t *testing.T) {
 		"/update",
 		"/urlstore",
 		"/urlstore/add",
+		"/urlstore/add-dir",
+		"/urlstore/rm",
+		"/urlstore/verify",
 		"/version",
 		"/version/deps",
 		"/cid",
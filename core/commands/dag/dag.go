@@ -3,6 +3,7 @@ package dagcmd
 import (
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 
@@ -16,9 +17,14 @@ import (
 )
 
 const (
-	progressOptionName = "progress"
-	silentOptionName   = "silent"
-	pinRootsOptionName = "pin-roots"
+	progressOptionName   = "progress"
+	silentOptionName     = "silent"
+	pinRootsOptionName   = "pin-roots"
+	depthOptionName      = "depth"
+	rangeOptionName      = "range"
+	offsetOptionName     = "offset"
+	checkRootsOptionName = "check-roots"
+	carVersionOptionName = "car-version"
 )
 
 // DagCmd provides a subset of commands for interacting with ipld dag objects
@@ -55,13 +61,24 @@ type ResolveOutput struct {
 
 // CarImportOutput is the output type of the 'dag import' commands
 type CarImportOutput struct {
-	Root RootMeta
+	Root  *RootMeta       `json:",omitempty"`
+	Stats *CarImportStats `json:",omitempty"`
 }
 
 // RootMeta is the metadata for a root pinning response
 type RootMeta struct {
 	Cid         cid.Cid
 	PinErrorMsg string
+	// MissingCount is the number of blocks of the DAG of the root missing
+	// after the import, the first ones being listed in Missing.
+	MissingCount int       `json:",omitempty"`
+	Missing      []cid.Cid `json:",omitempty"`
+}
+
+// CarImportStats is the progress of a 'dag import'
+type CarImportStats struct {
+	BlockCount      uint64
+	BlockBytesCount uint64
 }
 
 // DagPutCmd is a command for adding a dag node
@@ -181,9 +198,15 @@ Note:
   Pinning takes place in offline-mode exclusively, one root at a time.
   If the combination of blocks from the imported CAR files and what is
   currently present in the blockstore does not represent a complete DAG,
-  pinning of that individual root will fail.
+  that individual root is not pinned, and its missing blocks are reported.
+  Pass --check-roots to get the same report without pinning.
+
+  Garbage collection is held back during the whole import.
 
-Maximum supported CAR version: 1
+  The blocks are verified and decoded in parallel. Pass --progress to get
+  the number of blocks and bytes imported every second.
+
+Maximum supported CAR version: 2. The index of CARv2 files is ignored.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -192,6 +215,8 @@ Maximum supported CAR version: 1
 	Options: []cmds.Option{
 		cmds.BoolOption(silentOptionName, "No output."),
 		cmds.BoolOption(pinRootsOptionName, "Pin optional roots listed in the .car headers after importing.").WithDefault(true),
+		cmds.BoolOption(checkRootsOptionName, "Report the roots listed in the .car headers whose DAG is incomplete after importing, implied by --pin-roots.").WithDefault(false),
+		cmds.BoolOption(progressOptionName, "p", "Report the number of blocks and bytes imported every second.").WithDefault(false),
 	},
 	Type: CarImportOutput{},
 	Run:  dagImport,
@@ -203,22 +228,42 @@ Maximum supported CAR version: 1
 				return nil
 			}
 
+			if event.Stats != nil {
+				_, err := fmt.Fprintf(w, "Imported\t%d blocks\t%d bytes\n", event.Stats.BlockCount, event.Stats.BlockBytesCount)
+				return err
+			}
+
 			enc, err := cmdenv.GetLowLevelCidEncoder(req)
 			if err != nil {
 				return err
 			}
 
+			pin, _ := req.Options[pinRootsOptionName].(bool)
+			label, status := "Pinned root", "success"
+			if !pin {
+				label, status = "Checked root", "complete"
+			}
 			if event.Root.PinErrorMsg != "" {
-				event.Root.PinErrorMsg = fmt.Sprintf("FAILED: %s", event.Root.PinErrorMsg)
-			} else {
-				event.Root.PinErrorMsg = "success"
+				status = fmt.Sprintf("FAILED: %s", event.Root.PinErrorMsg)
+			}
+			if len(event.Root.Missing) > 0 {
+				missing := make([]string, len(event.Root.Missing))
+				for i, c := range event.Root.Missing {
+					missing[i] = enc.Encode(c)
+				}
+				status += " (" + strings.Join(missing, ", ")
+				if event.Root.MissingCount > len(missing) {
+					status += ", ..."
+				}
+				status += ")"
 			}
 
 			_, err = fmt.Fprintf(
 				w,
-				"Pinned root\t%s\t%s\n",
+				"%s\t%s\t%s\n",
+				label,
 				enc.Encode(event.Root.Cid),
-				event.Root.PinErrorMsg,
+				status,
 			)
 			return err
 		}),
@@ -231,15 +276,45 @@ var DagExportCmd = &cmds.Command{
 		Tagline: "Streams the selected DAG as a .car stream on stdout.",
 		ShortDescription: `
 'ipfs dag export' fetches a DAG and streams it out as a well-formed .car file.
-Note that at present only single root selections / .car files are supported.
 The output of blocks happens in strict DAG-traversal, first-seen, order.
+`,
+		LongDescription: `
+'ipfs dag export' fetches a DAG and streams it out as a well-formed .car file.
+The output of blocks happens in strict DAG-traversal, first-seen, order.
+
+Several roots can be given, they are all listed in the .car header. A root can
+also be an /ipfs/ path: the root is then the CID the path starts from, and the
+blocks along the path are exported before its target, so that the target can
+be verified from the root.
+
+Only a part of the DAG under each target is selected with:
+
+  --depth   follows the links down to the given depth only, 0 exporting the
+            target block alone
+  --range   exports only the blocks holding the given byte range of a unixfs
+            file, e.g. '--range=0-1048575' for its first MiB, or '--range=1024-'
+            to skip its first KiB
+
+For a given selection, the .car stream is always the same. An interrupted
+export is resumed by skipping the bytes already received with '--offset':
+
+  $ ipfs dag export --offset=$(stat -c %s partial.car) <root> >> partial.car
+
+With '--car-version=2', the .car stream is wrapped in a CARv2 file followed by
+an index of its blocks, which can be registered with 'ipfs carstore add' to
+serve them straight from the file. Writing the size of the stream in the CARv2
+header requires walking the DAG twice.
 `,
 	},
 	Arguments: []cmds.Argument{
-		cmds.StringArg("root", true, false, "CID of a root to recursively export").EnableStdin(),
+		cmds.StringArg("root", true, true, "CID or /ipfs/ path of a root to export").EnableStdin(),
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(progressOptionName, "p", "Display progress on CLI. Defaults to true when STDERR is a TTY."),
+		cmds.IntOption(depthOptionName, "Depth of the links followed from each root, -1 for unlimited.").WithDefault(-1),
+		cmds.StringOption(rangeOptionName, "Byte range of the unixfs file roots to export, as <start>-<end> (end included) or <start>-."),
+		cmds.Int64Option(offsetOptionName, "Skip the given number of bytes of the stream, to resume an interrupted export.").WithDefault(int64(0)),
+		cmds.IntOption(carVersionOptionName, "Version of the .car file to write, 1 or 2 to add an index.").WithDefault(1),
 	},
 	Run: dagExport,
 	PostRun: cmds.PostRunMap{
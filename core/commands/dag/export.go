@@ -1,30 +1,64 @@
 package dagcmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cheggaaa/pb"
 	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/carstore"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	ipld "github.com/ipfs/go-ipld-format"
 	mdag "github.com/ipfs/go-merkledag"
+	ipfspath "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	"github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
 )
 
 func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 
-	c, err := cid.Decode(req.Arguments[0])
-	if err != nil {
-		return fmt.Errorf(
-			"unable to parse root specification (currently only bare CIDs are supported): %s",
-			err,
-		)
+	var roots []exportRoot
+	for _, arg := range req.Arguments {
+		r, err := parseExportRoot(arg)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, r)
+	}
+
+	carVersion, _ := req.Options[carVersionOptionName].(int)
+	if carVersion != 1 && carVersion != 2 {
+		return fmt.Errorf("unsupported --%s %d, expecting 1 or 2", carVersionOptionName, carVersion)
+	}
+
+	depth, _ := req.Options[depthOptionName].(int)
+	offset, _ := req.Options[offsetOptionName].(int64)
+	if offset < 0 {
+		return fmt.Errorf("invalid --%s: %d", offsetOptionName, offset)
+	}
+	var byteRange *exportRange
+	if rs, ok := req.Options[rangeOptionName].(string); ok {
+		if depth >= 0 {
+			return fmt.Errorf("--%s and --%s cannot be used together", rangeOptionName, depthOptionName)
+		}
+		r, err := parseExportRange(rs)
+		if err != nil {
+			return err
+		}
+		byteRange = &r
 	}
 
 	api, err := cmdenv.GetApi(env, req)
@@ -49,6 +83,9 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	// )
 	// ...
 	// if err := car.Write(pipeW); err != nil {}
+	//
+	// Until then, the common selectors (depth limit, unixfs path and file
+	// byte range) are implemented by exportWalker.
 
 	pipeR, pipeW := io.Pipe()
 
@@ -61,15 +98,25 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 			close(errCh)
 		}()
 
-		if err := gocar.WriteCar(
-			req.Context,
-			mdag.NewSession(
-				req.Context,
-				api.Dag(),
-			),
-			[]cid.Cid{c},
-			pipeW,
-		); err != nil {
+		dag := mdag.NewSession(req.Context, api.Dag())
+		newWalker := func(w io.Writer) *exportWalker {
+			return &exportWalker{
+				ctx:     req.Context,
+				dag:     dag,
+				w:       w,
+				written: cid.NewSet(),
+				depths:  make(map[cid.Cid]int),
+			}
+		}
+
+		w := &skipWriter{w: pipeW, skip: offset}
+		var err error
+		if carVersion == 2 {
+			err = exportV2(newWalker, w, roots, depth, byteRange)
+		} else {
+			err = newWalker(w).export(roots, depth, byteRange)
+		}
+		if err != nil {
 			errCh <- err
 		}
 	}()
@@ -153,3 +200,309 @@ func finishCLIExport(res cmds.Response, re cmds.ResponseEmitter) error {
 		}
 	}
 }
+
+// exportRoot is a root of the exported DAG: a CID, or the path of the exported
+// target under it.
+type exportRoot struct {
+	root cid.Cid
+	path ipfspath.Path
+}
+
+func parseExportRoot(arg string) (exportRoot, error) {
+	if c, err := cid.Decode(arg); err == nil {
+		return exportRoot{root: c}, nil
+	}
+
+	p, err := ipfspath.ParsePath(arg)
+	if err != nil {
+		return exportRoot{}, fmt.Errorf("unable to parse root specification %q, expecting a CID or an /ipfs/ path: %s", arg, err)
+	}
+	if ns := p.Segments()[0]; ns != "ipfs" && ns != "ipld" {
+		return exportRoot{}, fmt.Errorf("unable to export %q: only /ipfs/ and /ipld/ paths are supported, resolve %s names first", arg, ns)
+	}
+	c, _, err := ipfspath.SplitAbsPath(p)
+	if err != nil {
+		return exportRoot{}, err
+	}
+	return exportRoot{root: c, path: p}, nil
+}
+
+// exportRange is a byte range of a unixfs file, end excluded.
+type exportRange struct {
+	start, end uint64
+}
+
+// parseExportRange parses an HTTP-style byte range: "start-end" with the end
+// included, or "start-" up to the end of the file.
+func parseExportRange(s string) (exportRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return exportRange{}, fmt.Errorf("invalid --%s %q, expecting <start>-<end>", rangeOptionName, s)
+	}
+	start, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return exportRange{}, fmt.Errorf("invalid --%s start: %s", rangeOptionName, err)
+	}
+	r := exportRange{start: start, end: math.MaxUint64}
+	if parts[1] != "" {
+		end, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return exportRange{}, fmt.Errorf("invalid --%s end: %s", rangeOptionName, err)
+		}
+		if end < start {
+			return exportRange{}, fmt.Errorf("invalid --%s %q, the end is before the start", rangeOptionName, s)
+		}
+		r.end = end + 1
+	}
+	return r, nil
+}
+
+// exportWalker writes the selected blocks of the exported DAGs as a CAR
+// stream, in strict DAG-traversal, first-seen, order. The order only depends
+// on the DAGs, which allows resuming an interrupted export from an offset.
+type exportWalker struct {
+	ctx     context.Context
+	dag     ipld.NodeGetter
+	w       io.Writer
+	written *cid.Set
+	// depths is the remaining depth each node was walked with, a node is
+	// walked again when reached with a larger one.
+	depths map[cid.Cid]int
+	// size is the size of the CARv1 stream written so far. When index is set,
+	// the offset of each block is recorded in it.
+	size  uint64
+	index *carstore.Index
+}
+
+// exportV2 writes a CARv2 file: the CARv1 stream written by the walkers,
+// followed by its index. The header of the file holds the size of the stream,
+// so the DAG is walked twice: once to compute the size and the index, then to
+// write the stream.
+func exportV2(newWalker func(io.Writer) *exportWalker, w io.Writer, roots []exportRoot, depth int, byteRange *exportRange) error {
+	ew := newWalker(ioutil.Discard)
+	ew.index = carstore.NewIndex()
+	if err := ew.export(roots, depth, byteRange); err != nil {
+		return err
+	}
+	ew.index.Sort()
+
+	if _, err := carstore.NewHeader(ew.size).WriteTo(w); err != nil {
+		return err
+	}
+	data := newWalker(w)
+	if err := data.export(roots, depth, byteRange); err != nil {
+		return err
+	}
+	if data.size != ew.size {
+		return errors.New("the exported DAG changed while writing the CARv2 file")
+	}
+	_, err := ew.index.WriteTo(w)
+	return err
+}
+
+// export writes the CAR header and the blocks of the given roots: the blocks
+// along their path, then their target followed down to depth (-1 for
+// unlimited), or only the blocks holding the byte range of the target file.
+func (ew *exportWalker) export(roots []exportRoot, depth int, byteRange *exportRange) error {
+	header := &gocar.CarHeader{Version: 1}
+	seen := cid.NewSet()
+	for _, r := range roots {
+		if seen.Visit(r.root) {
+			header.Roots = append(header.Roots, r.root)
+		}
+	}
+	if err := gocar.WriteHeader(header, ew.w); err != nil {
+		return err
+	}
+	size, err := gocar.HeaderSize(header)
+	if err != nil {
+		return err
+	}
+	ew.size = size
+
+	for _, r := range roots {
+		target, err := ew.resolve(r)
+		if err != nil {
+			return err
+		}
+		if byteRange != nil {
+			nd, err := ew.dag.Get(ew.ctx, target)
+			if err != nil {
+				return err
+			}
+			err = ew.walkRange(nd, byteRange.start, byteRange.end)
+		} else {
+			err = ew.walk(target, depth)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve writes the blocks along the path of the root, so that the target
+// can be verified from the root, and returns the target.
+func (ew *exportWalker) resolve(r exportRoot) (cid.Cid, error) {
+	if r.path == "" {
+		return r.root, nil
+	}
+
+	ng := &recordingGetter{NodeGetter: ew.dag}
+	res := &resolver.Resolver{DAG: ng, ResolveOnce: uio.ResolveUnixfsOnce}
+	if r.path.Segments()[0] == "ipld" {
+		res.ResolveOnce = resolver.ResolveSingle
+	}
+	target, _, err := res.ResolveToLastNode(ew.ctx, r.path)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	for _, nd := range ng.nodes {
+		if err := ew.write(nd); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return target, nil
+}
+
+// walk writes the DAG under c, following the links down to depth levels.
+func (ew *exportWalker) walk(c cid.Cid, depth int) error {
+	if d, ok := ew.depths[c]; ok && (d < 0 || (depth >= 0 && d >= depth)) {
+		return nil
+	}
+	ew.depths[c] = depth
+
+	nd, err := ew.dag.Get(ew.ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := ew.write(nd); err != nil {
+		return err
+	}
+	if depth == 0 {
+		return nil
+	}
+
+	next := depth - 1
+	if depth < 0 {
+		next = -1
+	}
+	for _, l := range nd.Links() {
+		if err := ew.walk(l.Cid, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkRange writes the blocks of the unixfs file nd holding the bytes from
+// start to end.
+func (ew *exportWalker) walkRange(nd ipld.Node, start, end uint64) error {
+	if err := ew.write(nd); err != nil {
+		return err
+	}
+
+	switch nd := nd.(type) {
+	case *mdag.RawNode:
+		return nil
+	case *mdag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return fmt.Errorf("cannot export a byte range of %s: %s", nd.Cid(), err)
+		}
+		if t := fsn.Type(); t != unixfs.TFile && t != unixfs.TRaw {
+			return fmt.Errorf("cannot export a byte range of %s: not a file", nd.Cid())
+		}
+		if fsn.NumChildren() != len(nd.Links()) {
+			return fmt.Errorf("cannot export a byte range of %s: inconsistent unixfs node", nd.Cid())
+		}
+
+		offset := uint64(len(fsn.Data()))
+		for i, l := range nd.Links() {
+			size := fsn.BlockSize(i)
+			if offset < end && offset+size > start {
+				child, err := l.GetNode(ew.ctx, ew.dag)
+				if err != nil {
+					return err
+				}
+				var childStart uint64
+				if start > offset {
+					childStart = start - offset
+				}
+				childEnd := size
+				if end-offset < size {
+					childEnd = end - offset
+				}
+				if err := ew.walkRange(child, childStart, childEnd); err != nil {
+					return err
+				}
+			}
+			offset += size
+			if offset >= end {
+				break
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("cannot export a byte range of %s: not a unixfs node", nd.Cid())
+	}
+}
+
+func (ew *exportWalker) write(nd ipld.Node) error {
+	if !ew.written.Visit(nd.Cid()) {
+		return nil
+	}
+	if ew.index != nil {
+		if err := ew.index.Add(nd.Cid(), ew.size); err != nil {
+			return err
+		}
+	}
+	ew.size += carutil.LdSize(nd.Cid().Bytes(), nd.RawData())
+	return carutil.LdWrite(ew.w, nd.Cid().Bytes(), nd.RawData())
+}
+
+// recordingGetter records the nodes fetched through it, in order. GetMany
+// fetches the nodes one by one to keep that order deterministic.
+type recordingGetter struct {
+	ipld.NodeGetter
+	nodes []ipld.Node
+}
+
+func (r *recordingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, err := r.NodeGetter.Get(ctx, c)
+	if err == nil {
+		r.nodes = append(r.nodes, nd)
+	}
+	return nd, err
+}
+
+func (r *recordingGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	for _, c := range cids {
+		nd, err := r.Get(ctx, c)
+		out <- &ipld.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+// skipWriter discards the first skip bytes written to it.
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (sw *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if sw.skip >= int64(n) {
+		sw.skip -= int64(n)
+		return n, nil
+	}
+	p = p[sw.skip:]
+	sw.skip = 0
+	if _, err := sw.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,214 @@
+package dagcmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs/carstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	importer "github.com/ipfs/go-unixfs/importer"
+	uio "github.com/ipfs/go-unixfs/io"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+func exportCar(t *testing.T, dag ipld.DAGService, args []string, depth int, byteRange *exportRange, offset int64) []byte {
+	t.Helper()
+	var roots []exportRoot
+	for _, arg := range args {
+		r, err := parseExportRoot(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, r)
+	}
+
+	var buf bytes.Buffer
+	ew := &exportWalker{
+		ctx:     context.Background(),
+		dag:     dag,
+		w:       &skipWriter{w: &buf, skip: offset},
+		written: cid.NewSet(),
+		depths:  make(map[cid.Cid]int),
+	}
+	if err := ew.export(roots, depth, byteRange); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func carBlocks(t *testing.T, car []byte) (*gocar.CarHeader, []cid.Cid) {
+	t.Helper()
+	cr, err := gocar.NewCarReader(bytes.NewReader(car))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cids []cid.Cid
+	for {
+		b, err := cr.Next()
+		if err == io.EOF {
+			return cr.Header, cids
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, b.Cid())
+	}
+}
+
+func TestExportSelection(t *testing.T) {
+	ctx := context.Background()
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dag := mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	// a file of 10 leaves of 100 bytes, in a directory
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	file, err := importer.BuildDagFromReader(dag, chunker.NewSizeSplitter(bytes.NewReader(data), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Links()) != 10 {
+		t.Fatalf("expected 10 leaves, got %d", len(file.Links()))
+	}
+	dir := uio.NewDirectory(dag)
+	if err := dir.AddChild(ctx, "file", file); err != nil {
+		t.Fatal(err)
+	}
+	dirNd, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dag.Add(ctx, dirNd); err != nil {
+		t.Fatal(err)
+	}
+
+	full := exportCar(t, dag, []string{dirNd.Cid().String()}, -1, nil, 0)
+	if _, cids := carBlocks(t, full); len(cids) != 12 {
+		t.Fatalf("expected the full DAG of 12 blocks, got %d", len(cids))
+	}
+
+	// depth limit
+	if _, cids := carBlocks(t, exportCar(t, dag, []string{dirNd.Cid().String()}, 1, nil, 0)); len(cids) != 2 {
+		t.Fatalf("expected 2 blocks down to depth 1, got %d", len(cids))
+	}
+
+	// unixfs path and byte range: the directory, the file root and the two
+	// leaves holding the range
+	p := "/ipfs/" + dirNd.Cid().String() + "/file"
+	header, cids := carBlocks(t, exportCar(t, dag, []string{p}, -1, &exportRange{start: 150, end: 250}, 0))
+	if len(header.Roots) != 1 || !header.Roots[0].Equals(dirNd.Cid()) {
+		t.Fatalf("expected the path root in the header, got %v", header.Roots)
+	}
+	want := []cid.Cid{dirNd.Cid(), file.Cid(), file.Links()[1].Cid, file.Links()[2].Cid}
+	if len(cids) != len(want) {
+		t.Fatalf("expected %d blocks for the range, got %d", len(want), len(cids))
+	}
+	for i := range want {
+		if !cids[i].Equals(want[i]) {
+			t.Fatalf("block %d is %s, expected %s", i, cids[i], want[i])
+		}
+	}
+
+	// resuming gives the rest of the same stream
+	if resumed := exportCar(t, dag, []string{dirNd.Cid().String()}, -1, nil, 100); !bytes.Equal(resumed, full[100:]) {
+		t.Fatal("resumed export differs from the end of the full export")
+	}
+}
+
+func TestExportV2(t *testing.T) {
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dag := mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	file, err := importer.BuildDagFromReader(dag, chunker.NewSizeSplitter(bytes.NewReader(data), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := []exportRoot{{root: file.Cid()}}
+	v1 := exportCar(t, dag, []string{file.Cid().String()}, -1, nil, 0)
+
+	var buf bytes.Buffer
+	newWalker := func(w io.Writer) *exportWalker {
+		return &exportWalker{
+			ctx:     context.Background(),
+			dag:     dag,
+			w:       w,
+			written: cid.NewSet(),
+			depths:  make(map[cid.Cid]int),
+		}
+	}
+	if err := exportV2(newWalker, &buf, roots, -1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the payload is the CARv1 stream
+	header, br, err := carstore.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header.Roots) != 1 || !header.Roots[0].Equals(file.Cid()) {
+		t.Fatalf("unexpected roots %v", header.Roots)
+	}
+	_, want := carBlocks(t, v1)
+	for _, c := range want {
+		got, _, err := carutil.ReadNode(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(c) {
+			t.Fatalf("expected block %s, got %s", c, got)
+		}
+	}
+	if _, _, err := carutil.ReadNode(br); err != io.EOF {
+		t.Fatalf("expected the payload to end, got %v", err)
+	}
+
+	// its index serves the blocks
+	path := filepath.Join(t.TempDir(), "dag.car")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := carstore.NewStore(ds.NewMapDatastore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	car, err := cs.Add(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if car.Version != 2 || !car.Indexed || car.Blocks != len(want) {
+		t.Fatalf("unexpected CAR file %+v", car)
+	}
+	for _, c := range want {
+		b, err := cs.Get(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := bs.Get(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.RawData(), expected.RawData()) {
+			t.Fatalf("block %s differs", c)
+		}
+	}
+}
@@ -1,21 +1,34 @@
 package dagcmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"golang.org/x/sync/errgroup"
+
+	blockformat "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-ipfs/carstore"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	ipld "github.com/ipfs/go-ipld-format"
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/options"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
-	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
 )
 
+// progressInterval is how often the import progress is reported.
+const progressInterval = time.Second
+
 func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 
 	node, err := cmdenv.GetNode(env)
@@ -36,6 +49,10 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	doPinRoots, _ := req.Options[pinRootsOptionName].(bool)
+	doCheckRoots, _ := req.Options[checkRootsOptionName].(bool)
+	showProgress, _ := req.Options[progressOptionName].(bool)
+
 	// grab a pinlock ( which doubles as a GC lock ) so that regardless of the
 	// size of the streamed-in cars nothing will disappear on us before we had
 	// a chance to roots that may show up at the very end
@@ -45,12 +62,31 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	unlocker := node.Blockstore.PinLock()
 	defer unlocker.Unlock()
 
-	doPinRoots, _ := req.Options[pinRootsOptionName].(bool)
-
+	stats := new(importStats)
 	retCh := make(chan importResult, 1)
-	go importWorker(req, res, api, retCh)
-
-	done := <-retCh
+	go importWorker(req, api, stats, retCh)
+
+	var done importResult
+	if showProgress {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+	progress:
+		for {
+			select {
+			case done = <-retCh:
+				break progress
+			case <-ticker.C:
+				if err := res.Emit(&CarImportOutput{Stats: stats.snapshot()}); err != nil {
+					return err
+				}
+			}
+		}
+		if err := res.Emit(&CarImportOutput{Stats: stats.snapshot()}); err != nil {
+			return err
+		}
+	} else {
+		done = <-retCh
+	}
 	if done.err != nil {
 		return done.err
 	}
@@ -63,30 +99,27 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	// The boolean value indicates whether we have encountered the root within the car file's
 	roots := done.roots
 
-	// opportunistic pinning: try whatever sticks
-	if doPinRoots {
+	if !doPinRoots && !doCheckRoots {
+		return nil
+	}
 
-		var failedPins int
-		for c := range roots {
+	// each root is pinned only when its whole DAG is present, the others
+	// are reported with their missing blocks
+	var failed int
+	for c := range roots {
+		ret := &RootMeta{Cid: c}
 
-			// We need to re-retrieve a block, convert it to ipld, and feed it
-			// to the Pinning interface, sigh...
-			//
-			// If we didn't have the problem of inability to take multiple pinlocks,
-			// we could use the api directly like so (though internally it does the same):
-			//
-			// // not ideal, but the pinning api takes only paths :(
-			// rp := path.NewResolvedPath(
-			// 	ipfspath.FromCid(c),
-			// 	c,
-			// 	c,
-			// 	"",
-			// )
-			//
-			// if err := api.Pin().Add(req.Context, rp, options.Pin.Recursive(true)); err != nil {
-
-			ret := RootMeta{Cid: c}
+		missing, err := missingBlocks(req.Context, node.Blockstore, c, &ret.Missing)
+		if err != nil {
+			return err
+		}
+		ret.MissingCount = missing
 
+		switch {
+		case missing > 0:
+			ret.PinErrorMsg = fmt.Sprintf("incomplete DAG, %d blocks missing", missing)
+		case !doPinRoots:
+		default:
 			if block, err := node.Blockstore.Get(c); err != nil {
 				ret.PinErrorMsg = err.Error()
 			} else if nd, err := ipld.Decode(block); err != nil {
@@ -96,29 +129,102 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 			} else if err := node.Pinning.Flush(req.Context); err != nil {
 				ret.PinErrorMsg = err.Error()
 			}
+		}
 
-			if ret.PinErrorMsg != "" {
-				failedPins++
-			}
+		if ret.PinErrorMsg != "" {
+			failed++
+		}
 
-			if err := res.Emit(&CarImportOutput{Root: ret}); err != nil {
-				return err
-			}
+		if err := res.Emit(&CarImportOutput{Root: ret}); err != nil {
+			return err
 		}
+	}
 
-		if failedPins > 0 {
-			return fmt.Errorf(
-				"unable to pin all roots: %d out of %d failed",
-				failedPins,
-				len(roots),
-			)
+	if failed > 0 {
+		if !doPinRoots {
+			return fmt.Errorf("%d out of %d roots are incomplete", failed, len(roots))
 		}
+		return fmt.Errorf(
+			"unable to pin all roots: %d out of %d failed",
+			failed,
+			len(roots),
+		)
 	}
 
 	return nil
 }
 
-func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI, ret chan importResult) {
+// maxReportedMissing is the number of missing blocks listed per root.
+const maxReportedMissing = 10
+
+// missingBlocks walks the DAG under root in the blockstore and returns the
+// number of its blocks that are missing, the first ones being appended to
+// list.
+func missingBlocks(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, list *[]cid.Cid) (int, error) {
+	seen := cid.NewSet()
+	missing := 0
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+
+		block, err := bs.Get(c)
+		if err == blockstore.ErrNotFound {
+			missing++
+			if len(*list) < maxReportedMissing {
+				*list = append(*list, c)
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		nd, err := ipld.Decode(block)
+		if err != nil {
+			return 0, err
+		}
+		links := nd.Links()
+		for i := len(links) - 1; i >= 0; i-- {
+			stack = append(stack, links[i].Cid)
+		}
+	}
+	return missing, nil
+}
+
+// importStats counts the imported blocks, updated concurrently.
+type importStats struct {
+	blocks uint64
+	bytes  uint64
+}
+
+func (s *importStats) add(size int) {
+	atomic.AddUint64(&s.blocks, 1)
+	atomic.AddUint64(&s.bytes, uint64(size))
+}
+
+func (s *importStats) snapshot() *CarImportStats {
+	return &CarImportStats{
+		BlockCount:      atomic.LoadUint64(&s.blocks),
+		BlockBytesCount: atomic.LoadUint64(&s.bytes),
+	}
+}
+
+// carBlock is a block read from a .car file, not verified yet.
+type carBlock struct {
+	cid  cid.Cid
+	data []byte
+}
+
+// importWorker reads the .car files one after the other, while their blocks
+// are verified and decoded by parallel workers, and added to the blockstore
+// in batches.
+func importWorker(req *cmds.Request, api iface.CoreAPI, stats *importStats, ret chan importResult) {
 
 	// this is *not* a transaction
 	// it is simply a way to relieve pressure on the blockstore
@@ -127,67 +233,117 @@ func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI,
 
 	roots := make(map[cid.Cid]struct{})
 
-	it := req.Files.Entries()
-	for it.Next() {
-
-		file := files.FileFromEntry(it)
-		if file == nil {
-			ret <- importResult{err: errors.New("expected a file handle")}
-			return
-		}
+	g, ctx := errgroup.WithContext(req.Context)
+	blocks := make(chan carBlock, 4*runtime.NumCPU())
+	nodes := make(chan ipld.Node, 4*runtime.NumCPU())
+
+	// verify and decode
+	var decoders sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		decoders.Add(1)
+		g.Go(func() error {
+			defer decoders.Done()
+			for b := range blocks {
+				hashed, err := b.cid.Prefix().Sum(b.data)
+				if err != nil {
+					return err
+				}
+				if !hashed.Equals(b.cid) {
+					return fmt.Errorf("mismatch in content integrity, name: %s, data: %s", b.cid, hashed)
+				}
 
-		// wrap a defer-closer-scope
-		//
-		// every single file in it() is already open before we start
-		// just close here sooner rather than later for neatness
-		// and to surface potential errors writing on closed fifos
-		// this won't/can't help with not running out of handles
-		err := func() error {
-			defer file.Close()
+				block, err := blockformat.NewBlockWithCid(b.data, b.cid)
+				if err != nil {
+					return err
+				}
+				// the double-decode is suboptimal, but we need it for batching
+				nd, err := ipld.Decode(block)
+				if err != nil {
+					return err
+				}
 
-			car, err := gocar.NewCarReader(file)
-			if err != nil {
+				select {
+				case nodes <- nd:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+	go func() {
+		decoders.Wait()
+		close(nodes)
+	}()
+
+	// store
+	g.Go(func() error {
+		for nd := range nodes {
+			if err := batch.Add(ctx, nd); err != nil {
 				return err
 			}
+			stats.add(len(nd.RawData()))
+		}
+		return nil
+	})
 
-			// Be explicit here, until the spec is finished
-			if car.Header.Version != 1 {
-				return errors.New("only car files version 1 supported at present")
-			}
+	// read
+	g.Go(func() error {
+		defer close(blocks)
+
+		it := req.Files.Entries()
+		for it.Next() {
 
-			for _, c := range car.Header.Roots {
-				roots[c] = struct{}{}
+			file := files.FileFromEntry(it)
+			if file == nil {
+				return errors.New("expected a file handle")
 			}
 
-			for {
-				block, err := car.Next()
-				if err != nil && err != io.EOF {
+			// wrap a defer-closer-scope
+			//
+			// every single file in it() is already open before we start
+			// just close here sooner rather than later for neatness
+			// and to surface potential errors writing on closed fifos
+			// this won't/can't help with not running out of handles
+			err := func() error {
+				defer file.Close()
+
+				header, br, err := carstore.NewReader(file)
+				if err != nil {
 					return err
-				} else if block == nil {
-					break
+				}
+				if len(header.Roots) == 0 {
+					return errors.New("empty car")
 				}
 
-				// the double-decode is suboptimal, but we need it for batching
-				nd, err := ipld.Decode(block)
-				if err != nil {
-					return err
+				for _, c := range header.Roots {
+					roots[c] = struct{}{}
 				}
 
-				if err := batch.Add(req.Context, nd); err != nil {
-					return err
+				for {
+					c, data, err := carutil.ReadNode(br)
+					if err == io.EOF {
+						return nil
+					} else if err != nil {
+						return err
+					}
+
+					select {
+					case blocks <- carBlock{cid: c, data: data}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				}
+			}()
+			if err != nil {
+				return err
 			}
-
-			return nil
-		}()
-
-		if err != nil {
-			ret <- importResult{err: err}
-			return
 		}
-	}
 
-	if err := it.Err(); err != nil {
+		return it.Err()
+	})
+
+	if err := g.Wait(); err != nil {
 		ret <- importResult{err: err}
 		return
 	}
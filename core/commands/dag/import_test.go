@@ -0,0 +1,192 @@
+package dagcmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	files "github.com/ipfs/go-ipfs-files"
+	mdag "github.com/ipfs/go-merkledag"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+func TestMissingBlocks(t *testing.T) {
+	ctx := context.Background()
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+
+	// root -> a -> b, with b missing
+	b := mdag.NodeWithData([]byte("b"))
+	a := mdag.NodeWithData([]byte("a"))
+	if err := a.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	root := mdag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*mdag.ProtoNode{root, a} {
+		if err := bs.Put(nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var list []cid.Cid
+	n, err := missingBlocks(ctx, bs, root.Cid(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(list) != 1 || !list[0].Equals(b.Cid()) {
+		t.Fatalf("expected %s to be missing, got %d %v", b.Cid(), n, list)
+	}
+
+	if err := bs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	list = nil
+	if n, err := missingBlocks(ctx, bs, root.Cid(), &list); err != nil || n != 0 {
+		t.Fatalf("expected a complete DAG, got %d missing (%v)", n, err)
+	}
+}
+
+// testCar writes a .car file with the given roots and blocks, whose data may
+// not match their CIDs.
+func testCar(t *testing.T, roots []cid.Cid, blocks map[cid.Cid][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for c, data := range blocks {
+		if err := carutil.LdWrite(&buf, c.Bytes(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// runImport runs 'ipfs dag import' on the given .car files and returns its
+// outputs.
+func runImport(t *testing.T, n *core.IpfsNode, opts cmds.OptMap, cars ...[]byte) ([]*CarImportOutput, error) {
+	t.Helper()
+	entries := make([]files.DirEntry, len(cars))
+	for i, car := range cars {
+		entries[i] = files.FileEntry(fmt.Sprintf("%d.car", i), files.NewBytesFile(car))
+	}
+	req, err := cmds.NewRequest(context.Background(), nil, opts, nil, files.NewSliceDirectory(entries), DagImportCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &commands.Context{ConstructNode: func() (*core.IpfsNode, error) { return n, nil }}
+
+	re, res := cmds.NewChanResponsePair(req)
+	go func() {
+		re.CloseWithError(dagImport(req, re, env))
+	}()
+
+	var outs []*CarImportOutput
+	for {
+		v, err := res.Next()
+		if err == io.EOF {
+			return outs, nil
+		}
+		if err != nil {
+			return outs, err
+		}
+		outs = append(outs, v.(*CarImportOutput))
+	}
+}
+
+func TestImport(t *testing.T) {
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	// root -> a, b
+	a := mdag.NodeWithData([]byte("a"))
+	b := mdag.NodeWithData([]byte("b"))
+	root := mdag.NodeWithData([]byte("root"))
+	for _, l := range []*mdag.ProtoNode{a, b} {
+		if err := root.AddNodeLink(l.Cid().String(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	noPin := cmds.OptMap{pinRootsOptionName: false}
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		car := testCar(t, []cid.Cid{root.Cid()}, map[cid.Cid][]byte{
+			root.Cid(): root.RawData(),
+			a.Cid():    b.RawData(),
+		})
+		_, err := runImport(t, n, noPin, car)
+		if err == nil || !strings.Contains(err.Error(), "mismatch in content integrity") {
+			t.Fatalf("expected the import to fail on the mismatched block, got %v", err)
+		}
+		if has, err := n.Blockstore.Has(a.Cid()); err != nil || has {
+			t.Fatalf("expected the mismatched block not to be stored, got %v %v", has, err)
+		}
+	})
+
+	t.Run("check roots", func(t *testing.T) {
+		car := testCar(t, []cid.Cid{root.Cid()}, map[cid.Cid][]byte{
+			root.Cid(): root.RawData(),
+			a.Cid():    a.RawData(),
+		})
+		outs, err := runImport(t, n, cmds.OptMap{pinRootsOptionName: false, checkRootsOptionName: true}, car)
+		if err == nil || !strings.Contains(err.Error(), "1 out of 1 roots are incomplete") {
+			t.Fatalf("expected the incomplete root to fail the import, got %v", err)
+		}
+		if len(outs) != 1 || outs[0].Root == nil {
+			t.Fatalf("expected the root to be reported, got %v", outs)
+		}
+		ret := outs[0].Root
+		if !ret.Cid.Equals(root.Cid()) || ret.MissingCount != 1 || len(ret.Missing) != 1 || !ret.Missing[0].Equals(b.Cid()) {
+			t.Fatalf("expected %s to be missing under %s, got %+v", b.Cid(), root.Cid(), ret)
+		}
+		if pinned, err := n.Pinning.RecursiveKeys(context.Background()); err != nil || len(pinned) != 0 {
+			t.Fatalf("expected nothing to be pinned, got %v %v", pinned, err)
+		}
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		blocks := map[cid.Cid][]byte{
+			root.Cid(): root.RawData(),
+			a.Cid():    a.RawData(),
+			b.Cid():    b.RawData(),
+		}
+		size := len(root.RawData()) + len(a.RawData()) + len(b.RawData())
+		outs, err := runImport(t, n, cmds.OptMap{progressOptionName: true, pinRootsOptionName: true}, testCar(t, []cid.Cid{root.Cid()}, blocks))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stats *CarImportStats
+		var pinned *RootMeta
+		for _, out := range outs {
+			if out.Stats != nil {
+				stats = out.Stats
+			}
+			if out.Root != nil {
+				pinned = out.Root
+			}
+		}
+		if stats == nil || stats.BlockCount != 3 || stats.BlockBytesCount != uint64(size) {
+			t.Fatalf("expected the final stats to count 3 blocks of %d bytes, got %+v", size, stats)
+		}
+		if pinned == nil || !pinned.Cid.Equals(root.Cid()) || pinned.PinErrorMsg != "" {
+			t.Fatalf("expected the root to be pinned, got %+v", pinned)
+		}
+	})
+}
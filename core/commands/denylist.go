@@ -0,0 +1,152 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/denylist"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+const denylistPlainOptionName = "plain"
+
+// DenylistEntry is an entry of the denylist.
+type DenylistEntry struct {
+	Entry string
+	Hash  string
+}
+
+var DenylistCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the content and names the node refuses to serve.",
+		ShortDescription: `
+The denylist lists the content, paths and names the gateway answers with
+'410 Gone', and 'ipfs cat' refuses to read, such as after takedown requests.
+`,
+		LongDescription: `
+The denylist lists the content, paths and names the gateway answers with
+'410 Gone', and 'ipfs cat' refuses to read, such as after takedown requests.
+An entry blocks all the paths under it:
+
+  /ipfs/{cid}          the content of the CID, whatever the path to it
+  /ipfs/{cid}/{path}   the content at the path
+  /ipns/{name}         an IPNS name or DNSLink domain
+  //{hash}             an entry above, double-hashed
+
+The entries are double-hashed when added, unless --plain is given, so that
+the list does not publish what it blocks. The hash of an entry is the hex
+encoded SHA-256 of "{multihash}/{path}" for content, with the multihash of
+the CID in base58 and the path without its leading slash, and of
+"/ipns/{name}" for names. A CID blocks its content whatever the version and
+codec of the CIDs it is requested with.
+
+The denylist is the 'denylist' file of the repo, one entry per line, which
+can also be edited by hand or replaced. The node reloads it when it changes.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add": denylistAddCmd,
+		"rm":  denylistRmCmd,
+		"ls":  denylistLsCmd,
+	},
+}
+
+var denylistEntryEncoders = cmds.EncoderMap{
+	cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, e *DenylistEntry) error {
+		_, err := fmt.Fprintln(w, e.Entry)
+		return err
+	}),
+}
+
+func emitDenylistEntries(res cmds.ResponseEmitter, entries []denylist.Entry) error {
+	for _, e := range entries {
+		if err := res.Emit(&DenylistEntry{Entry: e.Line, Hash: e.Hash}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var denylistAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Block content, paths or names.",
+		ShortDescription: `
+Adds entries to the denylist, double-hashed unless --plain is given, and
+outputs those added. The entries already in the denylist are skipped.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("entry", true, true, "/ipfs/ path, /ipns/ name or double-hashed entry to block.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(denylistPlainOptionName, "Write the entries as given, not double-hashed."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if err := req.ParseBodyArgs(); err != nil {
+			return err
+		}
+		plain, _ := req.Options[denylistPlainOptionName].(bool)
+
+		added, err := n.Denylist.Add(req.Arguments, !plain)
+		if err != nil {
+			return err
+		}
+		return emitDenylistEntries(res, added)
+	},
+	Encoders: denylistEntryEncoders,
+	Type:     DenylistEntry{},
+}
+
+var denylistRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Unblock content, paths or names.",
+		ShortDescription: `
+Removes the entries of the denylist blocking the given paths or names, plain
+or double-hashed, and outputs those removed.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("entry", true, true, "/ipfs/ path, /ipns/ name or double-hashed entry to unblock.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if err := req.ParseBodyArgs(); err != nil {
+			return err
+		}
+
+		removed, err := n.Denylist.Remove(req.Arguments)
+		if err != nil {
+			return err
+		}
+		if len(removed) == 0 {
+			return fmt.Errorf("no entry of the denylist matches")
+		}
+		return emitDenylistEntries(res, removed)
+	},
+	Encoders: denylistEntryEncoders,
+	Type:     DenylistEntry{},
+}
+
+var denylistLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the entries of the denylist.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		return emitDenylistEntries(res, n.Denylist.List())
+	},
+	Encoders: denylistEntryEncoders,
+	Type:     DenylistEntry{},
+}
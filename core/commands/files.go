@@ -9,10 +9,12 @@ import (
 	gopath "path"
 	"sort"
 	"strings"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
 
 	bservice "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
@@ -99,6 +101,8 @@ type statOutput struct {
 	CumulativeSize uint64
 	Blocks         int
 	Type           string
+	Mode           string `json:",omitempty"`
+	Mtime          string `json:",omitempty"`
 	WithLocality   bool   `json:",omitempty"`
 	Local          bool   `json:",omitempty"`
 	SizeLocal      uint64 `json:",omitempty"`
@@ -125,7 +129,7 @@ var filesStatCmd = &cmds.Command{
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(filesFormatOptionName, "Print statistics in given format. Allowed tokens: "+
-			"<hash> <size> <cumulsize> <type> <childs>. Conflicts with other format options.").WithDefault(defaultStatFormat),
+			"<hash> <size> <cumulsize> <type> <childs> <mode> <mtime>. Conflicts with other format options.").WithDefault(defaultStatFormat),
 		cmds.BoolOption(filesHashOptionName, "Print only hash. Implies '--format=<hash>'. Conflicts with other format options."),
 		cmds.BoolOption(filesSizeOptionName, "Print only size. Implies '--format=<cumulsize>'. Conflicts with other format options."),
 		cmds.BoolOption(filesWithLocalOptionName, "Compute the amount of the dag that is local, and if possible the total size"),
@@ -197,15 +201,26 @@ var filesStatCmd = &cmds.Command{
 	},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *statOutput) error {
-			s, _ := statGetFormatOptions(req)
-			s = strings.Replace(s, "<hash>", out.Hash, -1)
+			format, _ := statGetFormatOptions(req)
+			s := strings.Replace(format, "<hash>", out.Hash, -1)
 			s = strings.Replace(s, "<size>", fmt.Sprintf("%d", out.Size), -1)
 			s = strings.Replace(s, "<cumulsize>", fmt.Sprintf("%d", out.CumulativeSize), -1)
 			s = strings.Replace(s, "<childs>", fmt.Sprintf("%d", out.Blocks), -1)
 			s = strings.Replace(s, "<type>", out.Type, -1)
+			s = strings.Replace(s, "<mode>", out.Mode, -1)
+			s = strings.Replace(s, "<mtime>", out.Mtime, -1)
 
 			fmt.Fprintln(w, s)
 
+			if format == defaultStatFormat {
+				if out.Mode != "" {
+					fmt.Fprintf(w, "Mode: %s\n", out.Mode)
+				}
+				if out.Mtime != "" {
+					fmt.Fprintf(w, "Mtime: %s\n", out.Mtime)
+				}
+			}
+
 			if out.WithLocality {
 				fmt.Fprintf(w, "Local: %s of %s (%.2f%%)\n",
 					humanize.Bytes(out.SizeLocal),
@@ -268,13 +283,25 @@ func statNode(nd ipld.Node, enc cidenc.Encoder) (*statOutput, error) {
 			return nil, fmt.Errorf("unrecognized node type: %s", d.Type())
 		}
 
-		return &statOutput{
+		meta, err := unixfsmeta.Get(n.Data())
+		if err != nil {
+			return nil, err
+		}
+
+		out := &statOutput{
 			Hash:           enc.Encode(c),
 			Blocks:         len(nd.Links()),
 			Size:           d.FileSize(),
 			CumulativeSize: cumulsize,
 			Type:           ndtype,
-		}, nil
+		}
+		if meta.HasMode {
+			out.Mode = fmt.Sprintf("%04o", unixfsmeta.ToPosix(meta.Mode))
+		}
+		if !meta.Mtime.IsZero() {
+			out.Mtime = meta.Mtime.UTC().Format(time.RFC3339Nano)
+		}
+		return out, nil
 	case *dag.RawNode:
 		return &statOutput{
 			Hash:           enc.Encode(c),
@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	filestore "github.com/ipfs/go-filestore"
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	"github.com/ipfs/go-ipfs/core/corerepo"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs-cmds"
@@ -22,13 +25,57 @@ var FileStoreCmd = &cmds.Command{
 		"ls":     lsFileStore,
 		"verify": verifyFileStore,
 		"dups":   dupsFileStore,
+		"fix":    fixFileStore,
+		"watch":  watchFileStore,
 	},
 }
 
 const (
 	fileOrderOptionName = "file-order"
+	statusOptionName    = "status"
+	searchOptionName    = "search"
+	readdOptionName     = "readd"
+	fixPinOptionName    = "pin"
+	fixDryRunOptionName = "dry-run"
+	fixForceOptionName  = "force"
 )
 
+// fileStoreStatuses are the statuses of the filestore entries, by name.
+var fileStoreStatuses = map[string]filestore.Status{}
+
+func init() {
+	for _, s := range []filestore.Status{
+		filestore.StatusOk,
+		filestore.StatusFileError,
+		filestore.StatusFileNotFound,
+		filestore.StatusFileChanged,
+		filestore.StatusOtherError,
+		filestore.StatusKeyNotFound,
+	} {
+		fileStoreStatuses[s.String()] = s
+	}
+}
+
+// statusFilter returns the statuses selected by the --status option, or nil
+// if it is not set.
+func statusFilter(req *cmds.Request) (map[filestore.Status]bool, error) {
+	names, _ := req.Options[statusOptionName].([]string)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	filter := make(map[filestore.Status]bool)
+	for _, name := range names {
+		for _, name := range strings.Split(name, ",") {
+			s, ok := fileStoreStatuses[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown filestore status %q", name)
+			}
+			filter[s] = true
+		}
+	}
+	return filter, nil
+}
+
 var lsFileStore = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "List objects in filestore.",
@@ -41,6 +88,12 @@ otherwise list all objects.
 The output is:
 
 <hash> <size> <path> <offset>
+
+With '--status', the objects are verified and only those with one of the
+given statuses are listed, prefixed by their status. The statuses are those
+of 'ipfs filestore verify', and can be comma separated:
+
+  > ipfs filestore ls --status=changed,no-file
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -48,19 +101,36 @@ The output is:
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(fileOrderOptionName, "sort the results based on the path of the backing file"),
+		cmds.StringsOption(statusOptionName, "Only list the objects with this status: ok, changed, no-file, error, ERROR or missing."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		_, fs, err := getFilestore(env)
 		if err != nil {
 			return err
 		}
+		statuses, err := statusFilter(req)
+		if err != nil {
+			return err
+		}
+		emit := func(r *filestore.ListRes) error {
+			if statuses != nil && !statuses[r.Status] {
+				return nil
+			}
+			return res.Emit(r)
+		}
+
 		args := req.Arguments
 		if len(args) > 0 {
-			return listByArgs(res, fs, args)
+			return listByArgs(emit, fs, args)
 		}
 
 		fileOrder, _ := req.Options[fileOrderOptionName].(bool)
-		next, err := filestore.ListAll(fs, fileOrder)
+		var next func() *filestore.ListRes
+		if statuses != nil {
+			next, err = filestore.VerifyAll(fs, fileOrder)
+		} else {
+			next, err = filestore.ListAll(fs, fileOrder)
+		}
 		if err != nil {
 			return err
 		}
@@ -70,7 +140,7 @@ The output is:
 			if r == nil {
 				break
 			}
-			if err := res.Emit(r); err != nil {
+			if err := emit(r); err != nil {
 				return err
 			}
 		}
@@ -83,6 +153,13 @@ The output is:
 			if err != nil {
 				return err
 			}
+			if statuses, _ := res.Request().Options[statusOptionName].([]string); len(statuses) > 0 {
+				return streamResult(func(v interface{}, out io.Writer) nonFatalError {
+					r := v.(*filestore.ListRes)
+					fmt.Fprintf(out, "%s %s\n", r.Status.Format(), r.FormatLong(enc.Encode))
+					return ""
+				})(res, re)
+			}
 			return streamResult(func(v interface{}, out io.Writer) nonFatalError {
 				r := v.(*filestore.ListRes)
 				if r.ErrorMsg != "" {
@@ -133,7 +210,7 @@ For ERROR entries the error will also be printed to stderr.
 		}
 		args := req.Arguments
 		if len(args) > 0 {
-			return listByArgs(res, fs, args)
+			return listByArgs(func(r *filestore.ListRes) error { return res.Emit(r) }, fs, args)
 		}
 
 		fileOrder, _ := req.Options[fileOrderOptionName].(bool)
@@ -223,6 +300,125 @@ var dupsFileStore = &cmds.Command{
 	Type:     RefWrapper{},
 }
 
+var fixFileStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Repair the objects of the filestore whose backing file changed or moved.",
+		ShortDescription: `
+Verifies the objects of the filestore, like 'ipfs filestore verify', and
+repairs those that cannot be read, file by file.
+`,
+		LongDescription: `
+Verifies the objects of the filestore, like 'ipfs filestore verify', and
+repairs those that cannot be read, file by file:
+
+- When the backing file is gone, the directories given with '--search' are
+  looked into for a file of the same size holding the same blocks, as when
+  the file was moved or renamed. The objects are then re-indexed at its path.
+  Otherwise they are removed. Only the files under the filestore root, the
+  parent directory of the repo, can be found.
+- When the backing file changed, it is added again with '--nocopy', with the
+  chunk size and hash function of its objects, and pinned unless '--pin=false'
+  is given. The objects that no longer match the file are then removed. With
+  '--readd=false', they are only removed.
+- Corrupt objects are removed.
+
+Pinned objects are not removed, and are reported as failed, unless '--force'
+is given. The objects of files that could not be read for other reasons, such as their
+permissions, are left alone and reported as failed. The output lists what was
+done:
+
+  > ipfs filestore fix --search=/data/archive
+  moved photos/a.jpg to /data/archive/2020/a.jpg (12 blocks)
+  re-added notes.txt as QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB
+  removed 3 blocks of notes.txt (changed)
+  removed 40 blocks of tmp/build.tar (no-file)
+
+With '--dry-run', nothing is changed.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringsOption(searchOptionName, "A directory to look for the files that moved in."),
+		cmds.BoolOption(readdOptionName, "Add the changed files again.").WithDefault(true),
+		cmds.BoolOption(fixPinOptionName, "Pin the files added again.").WithDefault(true),
+		cmds.BoolOption(fixDryRunOptionName, "Only report what would be done."),
+		cmds.BoolOption(fixForceOptionName, "Remove the objects of pinned blocks too."),
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// the search paths are relative to the client
+		search, _ := req.Options[searchOptionName].([]string)
+		for i, dir := range search {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			search[i] = abs
+		}
+		return nil
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, _, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		opts := corerepo.FilestoreFixOptions{Root: filepath.Dir(cfgRoot)}
+		opts.Search, _ = req.Options[searchOptionName].([]string)
+		opts.ReAdd, _ = req.Options[readdOptionName].(bool)
+		opts.Pin, _ = req.Options[fixPinOptionName].(bool)
+		opts.DryRun, _ = req.Options[fixDryRunOptionName].(bool)
+		opts.Force, _ = req.Options[fixForceOptionName].(bool)
+		for _, dir := range opts.Search {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("search path %q is not absolute", dir)
+			}
+		}
+
+		return corerepo.FixFilestore(req.Context, n, opts, func(fix *corerepo.FilestoreFix) error {
+			return res.Emit(fix)
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, fix *corerepo.FilestoreFix) error {
+			enc, err := cmdenv.GetCidEncoder(req)
+			if err != nil {
+				return err
+			}
+			dryRun, _ := req.Options[fixDryRunOptionName].(bool)
+
+			var verb, line string
+			switch fix.Action {
+			case corerepo.FixRemoved:
+				verb = "remove"
+				line = fmt.Sprintf("%d blocks of %s (%s)", fix.Blocks, fix.FilePath, fix.Status)
+			case corerepo.FixMoved:
+				verb = "move"
+				line = fmt.Sprintf("%s to %s (%d blocks)", fix.FilePath, fix.NewPath, fix.Blocks)
+			case corerepo.FixReAdded:
+				verb = "re-add"
+				line = fix.FilePath
+				if fix.Root.Defined() {
+					line += " as " + enc.Encode(fix.Root)
+				}
+			default:
+				_, err := fmt.Fprintf(w, "failed to fix %s (%s): %s\n", fix.FilePath, fix.Status, fix.Error)
+				return err
+			}
+
+			if dryRun {
+				_, err = fmt.Fprintf(w, "would %s %s\n", verb, line)
+			} else {
+				_, err = fmt.Fprintf(w, "%s %s\n", strings.TrimSuffix(verb, "e")+"ed", line)
+			}
+			return err
+		}),
+	},
+	Type: corerepo.FilestoreFix{},
+}
+
 func getFilestore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, error) {
 	n, err := cmdenv.GetNode(env)
 	if err != nil {
@@ -235,7 +431,7 @@ func getFilestore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, e
 	return n, fs, err
 }
 
-func listByArgs(res cmds.ResponseEmitter, fs *filestore.Filestore, args []string) error {
+func listByArgs(emit func(*filestore.ListRes) error, fs *filestore.Filestore, args []string) error {
 	for _, arg := range args {
 		c, err := cid.Decode(arg)
 		if err != nil {
@@ -243,13 +439,13 @@ func listByArgs(res cmds.ResponseEmitter, fs *filestore.Filestore, args []string
 				Status:   filestore.StatusOtherError,
 				ErrorMsg: fmt.Sprintf("%s: %v", arg, err),
 			}
-			if err := res.Emit(ret); err != nil {
+			if err := emit(ret); err != nil {
 				return err
 			}
 			continue
 		}
 		r := filestore.Verify(fs, c)
-		if err := res.Emit(r); err != nil {
+		if err := emit(r); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,172 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/filewatch"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+const watchMFSPathOptionName = "mfs-path"
+
+// FileWatch is a directory watched with 'ipfs filestore watch'.
+type FileWatch struct {
+	Dir     string
+	MFSPath string
+	// Added, Unchanged and Removed count the files of the first sync.
+	Added     int `json:",omitempty"`
+	Unchanged int `json:",omitempty"`
+	Removed   int `json:",omitempty"`
+}
+
+// absDirArg makes the directory argument of a watch command absolute, as it
+// is relative to the client.
+func absDirArg(req *cmds.Request, env cmds.Environment) error {
+	abs, err := filepath.Abs(req.Arguments[0])
+	if err != nil {
+		return err
+	}
+	req.Arguments[0] = abs
+	return nil
+}
+
+var watchFileStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Keep a directory added with '--nocopy' in sync with MFS.",
+		ShortDescription: `
+Adds the files of a directory to the filestore, as 'ipfs add --nocopy' does,
+at the given MFS path, and keeps them in sync as they are created, modified
+or removed while the daemon runs.
+`,
+		LongDescription: `
+Adds the files of a directory to the filestore, as 'ipfs add --nocopy' does,
+at the given MFS path, and keeps them in sync as they are created, modified
+or removed while the daemon runs:
+
+  > ipfs filestore watch ~/photos --mfs-path=/photos
+  watching /home/user/photos at /photos (120 added, 0 unchanged, 0 removed)
+
+Changes are synced once the directory stayed quiet for a second. Hidden files
+and directories, whose name starts with a dot, are left out. The directory
+must be under the filestore root, the parent directory of the repo.
+
+Watches are kept in the repo: the daemon syncs them again when it starts,
+adding only the files whose size or modification time changed. Without a
+running daemon, the directory is synced once and watched from the next start
+of the daemon.
+
+Use 'ipfs filestore watch ls' to list the watched directories, and
+'ipfs filestore watch rm' to stop watching one. The files mirrored in MFS are
+left in place.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("dir", true, false, "Directory to watch."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(watchMFSPathOptionName, "MFS directory to mirror the directory at."),
+	},
+	Subcommands: map[string]*cmds.Command{
+		"ls": watchLsFileStore,
+		"rm": watchRmFileStore,
+	},
+	PreRun: absDirArg,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, _, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		mfsPath, _ := req.Options[watchMFSPathOptionName].(string)
+		if mfsPath == "" {
+			return fmt.Errorf("the MFS path to mirror the directory at must be given with --%s", watchMFSPathOptionName)
+		}
+		dir := req.Arguments[0]
+		root := filepath.Dir(cfgRoot)
+		if rel, err := filepath.Rel(root, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("cannot watch %s, outside of the filestore root %s", dir, root)
+		}
+
+		w, stats, err := n.FileWatches.Add(req.Context, dir, mfsPath)
+		if err != nil {
+			return err
+		}
+		return res.Emit(&FileWatch{
+			Dir:       w.Dir,
+			MFSPath:   w.MFSPath,
+			Added:     stats.Added,
+			Unchanged: stats.Unchanged,
+			Removed:   stats.Removed,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, fw *FileWatch) error {
+			_, err := fmt.Fprintf(w, "watching %s at %s (%d added, %d unchanged, %d removed)\n", fw.Dir, fw.MFSPath, fw.Added, fw.Unchanged, fw.Removed)
+			return err
+		}),
+	},
+	Type: FileWatch{},
+}
+
+var watchLsFileStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the directories watched with 'ipfs filestore watch'.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		watches, err := n.FileWatches.List()
+		if err != nil {
+			return err
+		}
+		for _, w := range watches {
+			if err := res.Emit(&FileWatch{Dir: w.Dir, MFSPath: w.MFSPath}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, fw *FileWatch) error {
+			_, err := fmt.Fprintf(w, "%s %s\n", fw.Dir, fw.MFSPath)
+			return err
+		}),
+	},
+	Type: FileWatch{},
+}
+
+var watchRmFileStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Stop watching a directory.",
+		ShortDescription: `
+Stops keeping a directory watched with 'ipfs filestore watch' in sync. The
+files mirrored in MFS, and their filestore objects, are left in place.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("dir", true, false, "Watched directory."),
+	},
+	PreRun: absDirArg,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		err = n.FileWatches.Remove(req.Arguments[0])
+		if err == filewatch.ErrNotWatched {
+			return fmt.Errorf("%s is not watched", req.Arguments[0])
+		}
+		return err
+	},
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,10 +14,12 @@ import (
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	"github.com/ipfs/go-ipfs/core/commands/e"
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
 
 	"github.com/cheggaaa/pb"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/interface-go-ipfs-core/path"
 	"github.com/whyrusleeping/tar-utils"
 )
@@ -43,6 +46,10 @@ To output a TAR archive instead of unpacked files, use '--archive' or '-a'.
 
 To compress the output with GZIP compression, use '--compress' or '-C'. You
 may also specify the level of compression by specifying '-l=<1-9>'.
+
+The permissions and modification times recorded by 'ipfs add --preserve-mode'
+and '--preserve-mtime' are restored, except on symlinks. They are written to
+the TAR archives as well.
 `,
 	},
 
@@ -77,6 +84,11 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 			return err
 		}
 
+		nd, err := api.ResolveNode(req.Context, p)
+		if err != nil {
+			return err
+		}
+
 		size, err := file.Size()
 		if err != nil {
 			return err
@@ -85,7 +97,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		res.SetLength(uint64(size))
 
 		archive, _ := req.Options[archiveOptionName].(bool)
-		reader, err := fileArchive(file, p.String(), archive, cmplvl)
+		reader, err := fileArchive(req.Context, api.Dag(), file, nd, p.String(), archive, cmplvl)
 		if err != nil {
 			return err
 		}
@@ -229,7 +241,7 @@ func (gw *getWriter) writeExtracted(r io.Reader, fpath string) error {
 	defer bar.Set64(gw.Size)
 
 	extractor := &tar.Extractor{Path: fpath, Progress: bar.Add64}
-	return extractor.Extract(r)
+	return extractWithMeta(extractor.Extract, r, fpath)
 }
 
 func getCompressOptions(req *cmds.Request) (int, error) {
@@ -262,7 +274,7 @@ func (i *identityWriteCloser) Close() error {
 	return nil
 }
 
-func fileArchive(f files.Node, name string, archive bool, compression int) (io.Reader, error) {
+func fileArchive(ctx context.Context, dag ipld.DAGService, f files.Node, nd ipld.Node, name string, archive bool, compression int) (io.Reader, error) {
 	cleaned := gopath.Clean(name)
 	_, filename := gopath.Split(cleaned)
 
@@ -312,14 +324,11 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 		// the case for 1. archive, and 2. not archived and not compressed, in which tar is used anyway as a transport format
 
 		// construct the tar writer
-		w, err := files.NewTarWriter(maybeGzw)
-		if checkErrAndClosePipe(err) {
-			return nil, err
-		}
+		w := unixfsmeta.NewTarWriter(ctx, dag, maybeGzw)
 
 		go func() {
 			// write all the nodes recursively
-			if err := w.WriteFile(f, filename); checkErrAndClosePipe(err) {
+			if err := w.WriteNode(nd, filename); checkErrAndClosePipe(err) {
 				return
 			}
 			w.Close()         // close tar writer
@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
+)
+
+// tarMeta is the metadata of an extracted tar entry.
+type tarMeta struct {
+	path    string
+	dir     bool
+	hasMode bool
+	mode    os.FileMode
+	mtime   time.Time
+}
+
+// readTarMeta collects the metadata recorded in a tar stream, following
+// tar.Extractor to compute where the entries are extracted to: like it, a
+// single file is put inside root when it is an existing directory.
+func readTarMeta(r io.Reader, root string, rootIsDir bool) ([]tarMeta, error) {
+	var metas []tarMeta
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, hasMode := h.PAXRecords[unixfsmeta.PAXModeRecord]
+		_, hasMtime := h.PAXRecords[unixfsmeta.PAXMtimeRecord]
+		if h.Typeflag == tar.TypeSymlink || (!hasMode && !hasMtime) {
+			continue
+		}
+
+		elems := strings.Split(h.Name, "/")
+		p := filepath.Join(root, filepath.FromSlash(strings.Join(elems[1:], "/")))
+		if i == 0 && h.Typeflag == tar.TypeReg && rootIsDir {
+			p = filepath.Join(p, gopath.Base(h.Name))
+		}
+		m := tarMeta{path: p, dir: h.Typeflag == tar.TypeDir, hasMode: hasMode}
+		if hasMode {
+			m.mode = unixfsmeta.FromPosix(uint64(h.Mode))
+		}
+		if hasMtime {
+			m.mtime = h.ModTime
+		}
+		metas = append(metas, m)
+	}
+	return metas, nil
+}
+
+// restoreTarMeta applies the metadata of the extracted entries. Directories
+// are handled last, deepest first, as extracting into them changes their
+// modification time and might need the permissions they are restored with.
+func restoreTarMeta(metas []tarMeta) error {
+	sort.SliceStable(metas, func(i, j int) bool {
+		if metas[i].dir != metas[j].dir {
+			return !metas[i].dir
+		}
+		return metas[i].dir && len(metas[i].path) > len(metas[j].path)
+	})
+	for _, m := range metas {
+		if m.hasMode {
+			if err := os.Chmod(m.path, m.mode); err != nil {
+				return err
+			}
+		}
+		if !m.mtime.IsZero() {
+			if err := os.Chtimes(m.path, m.mtime, m.mtime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extractWithMeta extracts the tar stream to root with extract, then restores
+// the recorded metadata.
+func extractWithMeta(extract func(io.Reader) error, r io.Reader, root string) error {
+	rootIsDir := false
+	if fi, err := os.Stat(root); err == nil && fi.IsDir() {
+		rootIsDir = true
+	}
+
+	pr, pw := io.Pipe()
+	type result struct {
+		metas []tarMeta
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		metas, err := readTarMeta(pr, root, rootIsDir)
+		// drain the padding past the end of the archive
+		_, _ = io.Copy(ioutil.Discard, pr)
+		pr.CloseWithError(err)
+		done <- result{metas, err}
+	}()
+
+	err := extract(io.TeeReader(r, pw))
+	pw.CloseWithError(err)
+	res := <-done
+	if err != nil {
+		return err
+	}
+	if res.err != nil {
+		return res.err
+	}
+	return restoreTarMeta(res.metas)
+}
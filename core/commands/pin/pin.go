@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	bserv "github.com/ipfs/go-blockservice"
@@ -22,6 +23,7 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	"github.com/ipfs/go-ipfs/pinmeta"
 )
 
 var PinCmd = &cmds.Command{
@@ -51,12 +53,27 @@ type AddPinOutput struct {
 const (
 	pinRecursiveOptionName = "recursive"
 	pinProgressOptionName  = "progress"
+	pinExpiresInOptionName = "expires-in"
+	pinMetaOptionName      = "meta"
 )
 
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+Use --name to name the pins and --meta to annotate them with key=value pairs,
+which can be given several times. Names need not be unique. Pinning an object
+again with --name replaces its name, and --meta only updates the given
+annotations; an empty value as in '--meta key=' removes one.
+
+Use --expires-in to only keep the pin for some time, e.g. '720h'. Expired
+pins are removed by the daemon every Datastore.GCPeriod, and before automatic
+garbage collections. Pinning the object again without --expires-in makes the
+pin permanent, and 'ipfs pin update' carries the expiry over to the new pin.
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -65,6 +82,9 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.StringOption(pinNameOptionName, "An optional name for the pin."),
+		cmds.StringsOption(pinMetaOptionName, "Annotate the pin with a key=value pair."),
+		cmds.StringOption(pinExpiresInOptionName, "Remove the pin after the given duration, e.g. '720h'."),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -77,6 +97,11 @@ var addPinCmd = &cmds.Command{
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
 
+		annotation, err := parsePinAnnotation(req, env)
+		if err != nil {
+			return err
+		}
+
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
 		}
@@ -87,7 +112,7 @@ var addPinCmd = &cmds.Command{
 		}
 
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, annotation)
 			if err != nil {
 				return err
 			}
@@ -105,7 +130,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, annotation)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -181,7 +206,84 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+// pinAnnotation is the metadata set on the pins made by pin add.
+type pinAnnotation struct {
+	store   *pinmeta.Store
+	name    *string
+	attrs   map[string]string
+	expires time.Time
+}
+
+// parsePinAnnotation returns the metadata requested by the pin add options,
+// or nil if there is none.
+func parsePinAnnotation(req *cmds.Request, env cmds.Environment) (*pinAnnotation, error) {
+	a := new(pinAnnotation)
+	if name, ok := req.Options[pinNameOptionName].(string); ok {
+		a.name = &name
+	}
+	if attrs, ok := req.Options[pinMetaOptionName].([]string); ok && len(attrs) > 0 {
+		a.attrs = make(map[string]string, len(attrs))
+		for _, attr := range attrs {
+			kv := strings.SplitN(attr, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid %s %q, must be key=value", pinMetaOptionName, attr)
+			}
+			a.attrs[kv[0]] = kv[1]
+		}
+	}
+	if expiresIn, ok := req.Options[pinExpiresInOptionName].(string); ok {
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", pinExpiresInOptionName, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("%s must be positive", pinExpiresInOptionName)
+		}
+		a.expires = time.Now().Add(d)
+	}
+
+	if a.name == nil && a.attrs == nil && a.expires.IsZero() {
+		return nil, nil
+	}
+
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return nil, err
+	}
+	a.store = n.PinMeta
+	return a, nil
+}
+
+// set merges the annotation into the metadata of the given pin.
+func (a *pinAnnotation) set(c cid.Cid) error {
+	m, err := a.store.Get(c)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = new(pinmeta.Meta)
+	}
+
+	if a.name != nil {
+		m.Name = *a.name
+	}
+	for k, v := range a.attrs {
+		if m.Attrs == nil {
+			m.Attrs = make(map[string]string)
+		}
+		if v == "" {
+			delete(m.Attrs, k)
+		} else {
+			m.Attrs[k] = v
+		}
+	}
+	if !a.expires.IsZero() {
+		m.Expires = a.expires
+	}
+	return a.store.Put(c, m)
+}
+
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, annotation *pinAnnotation) ([]string, error) {
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -192,6 +294,11 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
 			return nil, err
 		}
+		if annotation != nil {
+			if err := annotation.set(rp.Cid()); err != nil {
+				return nil, err
+			}
+		}
 		added[i] = enc.Encode(rp.Cid())
 	}
 
@@ -296,6 +403,10 @@ Valid values are:
     * "indirect": pinned indirectly by an ancestor (like a refcount)
     * "all"
 
+Pins are listed with their name and expiry, if any. Use --name=<prefix> to
+only list the pins whose name starts with the given prefix. Annotations added
+with 'ipfs pin add --meta' are included in the JSON output.
+
 With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
@@ -324,6 +435,7 @@ Example:
 		cmds.StringOption(pinTypeOptionName, "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
+		cmds.StringOption(pinNameOptionName, "Only list the pins whose name starts with the given prefix."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -331,6 +443,11 @@ Example:
 			return err
 		}
 
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
 		typeStr, _ := req.Options[pinTypeOptionName].(string)
 		stream, _ := req.Options[pinStreamOptionName].(bool)
 
@@ -347,13 +464,18 @@ Example:
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = PinLsType{
+					Type:    obj.PinLsObject.Type,
+					Name:    obj.PinLsObject.Name,
+					Meta:    obj.PinLsObject.Meta,
+					Expires: obj.PinLsObject.Expires,
+				}
 				return nil
 			}
 		}
 
 		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, api, emit)
+			err = pinLsKeys(req, typeStr, api, n.PinMeta, emit)
 		} else {
 			err = pinLsAll(req, typeStr, api, emit)
 		}
@@ -390,7 +512,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					fmt.Fprintf(w, "%s %s%s\n", out.PinLsObject.Cid, out.PinLsObject.Type, formatPinMeta(out.PinLsObject.Name, out.PinLsObject.Expires))
 				}
 				return nil
 			}
@@ -399,7 +521,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					fmt.Fprintf(w, "%s %s%s\n", k, v.Type, formatPinMeta(v.Name, v.Expires))
 				}
 			}
 
@@ -423,16 +545,57 @@ type PinLsList struct {
 
 // PinLsType contains the type of a pin
 type PinLsType struct {
-	Type string
+	Type    string
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid     string            `json:",omitempty"`
+	Type    string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+// newPinLsObject describes a pin with the given metadata, which may be nil.
+func newPinLsObject(c string, pinType string, m *pinmeta.Meta) *PinLsOutputWrapper {
+	obj := PinLsObject{Cid: c, Type: pinType}
+	if m != nil {
+		obj.Name = m.Name
+		obj.Meta = m.Attrs
+		if !m.Expires.IsZero() {
+			expires := m.Expires
+			obj.Expires = &expires
+		}
+	}
+	return &PinLsOutputWrapper{PinLsObject: obj}
+}
+
+// nameMatches returns whether a pin with the given metadata is selected by the
+// pin ls --name option.
+func nameMatches(req *cmds.Request, m *pinmeta.Meta) bool {
+	prefix, ok := req.Options[pinNameOptionName].(string)
+	if !ok {
+		return true
+	}
+	return m != nil && strings.HasPrefix(m.Name, prefix)
+}
+
+func formatPinMeta(name string, expires *time.Time) string {
+	var out string
+	if name != "" {
+		out += " " + cmdenv.EscNonPrint(name)
+	}
+	if expires != nil {
+		out += " expires " + expires.Format(time.RFC3339)
+	}
+	return out
+}
+
+func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, meta *pinmeta.Store, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -470,12 +633,17 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 			pinType = "indirect through " + pinType
 		}
 
-		err = emit(&PinLsOutputWrapper{
-			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(rp.Cid()),
-			},
-		})
+		var m *pinmeta.Meta
+		if pinType == "direct" || pinType == "recursive" {
+			if m, err = meta.Get(rp.Cid()); err != nil {
+				return err
+			}
+		}
+		if !nameMatches(req, m) {
+			continue
+		}
+
+		err = emit(newPinLsObject(enc.Encode(rp.Cid()), pinType, m))
 		if err != nil {
 			return err
 		}
@@ -511,12 +679,14 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		if err := p.Err(); err != nil {
 			return err
 		}
-		err = emit(&PinLsOutputWrapper{
-			PinLsObject: PinLsObject{
-				Type: p.Type(),
-				Cid:  enc.Encode(p.Path().Cid()),
-			},
-		})
+		var m *pinmeta.Meta
+		if p, ok := p.(pinmeta.Pin); ok {
+			m = p.Meta()
+		}
+		if !nameMatches(req, m) {
+			continue
+		}
+		err = emit(newPinLsObject(enc.Encode(p.Path().Cid()), p.Type(), m))
 		if err != nil {
 			return err
 		}
@@ -540,7 +710,8 @@ This command is useful when the new pin contains many similarities or is a
 derivative of an existing one, particularly for large objects. This allows a more
 efficient DAG-traversal which fully skips already-pinned branches from the old
 object. As a requirement, the old object needs to be an existing recursive
-pin.
+pin. The name, annotations and expiry of the old pin are carried over to the
+new one.
 `,
 	},
 
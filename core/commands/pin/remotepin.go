@@ -18,6 +18,7 @@ import (
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	config "github.com/ipfs/go-ipfs-config"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/remotepin"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 	logging "github.com/ipfs/go-log"
 	pinclient "github.com/ipfs/go-pinning-service-http-client"
@@ -37,6 +38,9 @@ var remotePinCmd = &cmds.Command{
 		"add":     addRemotePinCmd,
 		"ls":      listRemotePinCmd,
 		"rm":      rmRemotePinCmd,
+		"sync":    syncRemotePinCmd,
+		"queue":   remotePinQueueCmd,
+		"policy":  remotePinPolicyCmd,
 		"service": remotePinServiceCmd,
 	},
 }
@@ -63,6 +67,7 @@ const pinServiceKeyArgName = "key"
 const pinServiceStatOptionName = "stat"
 const pinBackgroundOptionName = "background"
 const pinForceOptionName = "force"
+const pinQueueOptionName = "queue"
 
 type RemotePinOutput struct {
 	Status string
@@ -115,6 +120,14 @@ after remote service confirms 'queued' status, add the '--background' flag:
 
 Status of background pin requests can be inspected with the 'ls' command.
 
+To not lose the pin request when the service is unreachable, add the '--queue'
+flag. The request is then stored in the repo and sent by the daemon, which
+retries with a backoff until the service reports the pin as pinned:
+
+  $ ipfs pin remote add --service=mysrv --name=mypin --queue bafkqaaa
+
+Queued requests can be inspected with 'ipfs pin remote queue ls'.
+
 To list all pins for the CID across all statuses:
 
   $ ipfs pin remote ls --service=mysrv --cid=bafkqaaa --status=queued \
@@ -134,18 +147,13 @@ NOTE: a comma-separated notation is supported in CLI for convenience:
 		pinServiceNameOption,
 		cmds.StringOption(pinNameOptionName, "An optional name for the pin."),
 		cmds.BoolOption(pinBackgroundOptionName, "Add to the queue on the remote service and return immediately (does not wait for pinned status).").WithDefault(false),
+		cmds.BoolOption(pinQueueOptionName, "Add to the local queue of the daemon, which sends the request and retries it until pinned, and return immediately.").WithDefault(false),
 	},
 	Type: RemotePinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		ctx, cancel := context.WithCancel(req.Context)
 		defer cancel()
 
-		// Get remote service
-		c, err := getRemotePinServiceFromRequest(req, env)
-		if err != nil {
-			return err
-		}
-
 		// Prepare value for Pin.cid
 		if len(req.Arguments) != 1 {
 			return fmt.Errorf("expecting one CID argument")
@@ -161,18 +169,55 @@ NOTE: a comma-separated notation is supported in CLI for convenience:
 
 		// Prepare Pin.name
 		opts := []pinclient.AddOption{}
-		if name, nameFound := req.Options[pinNameOptionName]; nameFound {
-			nameStr := name.(string)
+		nameStr, _ := req.Options[pinNameOptionName].(string)
+		if nameStr != "" {
 			opts = append(opts, pinclient.PinOpts.WithName(nameStr))
 		}
 
-		// Prepare Pin.origins
-		// Add own multiaddrs to the 'origins' array, so Pinning Service can
-		// use that as a hint and connect back to us (if possible)
 		node, err := cmdenv.GetNode(env)
 		if err != nil {
 			return err
 		}
+
+		// Leave the request to the daemon if --queue is passed
+		if req.Options[pinQueueOptionName].(bool) {
+			// the daemon would retry the requests of an unknown service
+			// forever, check it now
+			service, _ := req.Options[pinServiceNameOptionName].(string)
+			if service == "" {
+				return fmt.Errorf("a service name must be passed")
+			}
+			cfg, err := node.Repo.Config()
+			if err != nil {
+				return err
+			}
+			if _, ok := cfg.Pinning.RemoteServices[service]; !ok {
+				return fmt.Errorf("service %q not found in Pinning.RemoteServices", service)
+			}
+			in, err := node.RemotePins.Add(service, rp.Cid(), nameStr)
+			if err != nil {
+				return err
+			}
+			return res.Emit(RemotePinOutput{
+				Status: string(in.State),
+				Cid:    in.Cid.String(),
+				Name:   in.Name,
+			})
+		}
+
+		// Get remote service
+		c, err := getRemotePinServiceFromRequest(req, env)
+		if err != nil {
+			return err
+		}
+
+		// Record the node as the creator of the pin, for 'pin remote sync
+		// --prune'
+		opts = append(opts, remotepin.CreatorMeta(node.Identity))
+
+		// Prepare Pin.origins
+		// Add own multiaddrs to the 'origins' array, so Pinning Service can
+		// use that as a hint and connect back to us (if possible)
 		if node.PeerHost != nil {
 			addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(node.PeerHost))
 			if err != nil {
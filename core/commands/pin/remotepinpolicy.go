@@ -0,0 +1,122 @@
+package pin
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+)
+
+var remotePinPolicyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the automatic remote pinning policies.",
+		ShortDescription: `
+Besides the MFS policy, each remote pinning service can be configured with
+policies mirroring local data to it automatically:
+
+    * Pinning.RemoteServices.<service>.Policies.Pins mirrors every local
+      recursive pin, or only the ones whose name starts with its NamePrefix
+    * Pinning.RemoteServices.<service>.Policies.Add mirrors the result of every
+      'ipfs add'
+    * Pinning.RemoteServices.<service>.Policies.IPNS mirrors the value of every
+      IPNS record published with its Key ("self" by default)
+
+The pins are sent through the queue of 'ipfs pin remote queue'.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"status": statusRemotePinPolicyCmd,
+	},
+}
+
+// RemotePinPolicyStatus is the status of an automatic remote pinning policy.
+type RemotePinPolicyStatus struct {
+	Service    string
+	Policy     string
+	Enable     bool
+	NamePrefix string `json:",omitempty"`
+	Key        string `json:",omitempty"`
+	PinName    string `json:",omitempty"`
+	Mirrored   int
+	Queued     int
+	Failed     int
+	Last       string `json:",omitempty"`
+}
+
+var statusRemotePinPolicyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the status of the automatic remote pinning policies.",
+		ShortDescription: `
+Lists the configured policies with the number of pins they mirrored so far, and
+the number of these still queued or failed in 'ipfs pin remote queue'.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(pinServiceNameOptionName, "Only list the policies of the given remote pinning service."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		statuses, err := node.PinPolicies.Status()
+		if err != nil {
+			return err
+		}
+
+		service, _ := req.Options[pinServiceNameOptionName].(string)
+		for _, st := range statuses {
+			if service != "" && st.Service != service {
+				continue
+			}
+			out := &RemotePinPolicyStatus{
+				Service:    st.Service,
+				Policy:     string(st.Kind),
+				Enable:     st.Enable,
+				NamePrefix: st.NamePrefix,
+				Key:        st.Key,
+				PinName:    st.PinName,
+				Mirrored:   st.Mirrored,
+				Queued:     st.Queued,
+				Failed:     st.Failed,
+			}
+			if st.Last.Defined() {
+				out.Last = st.Last.String()
+			}
+			if err := res.Emit(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: RemotePinPolicyStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinPolicyStatus) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			defer tw.Flush()
+
+			state := "disabled"
+			if out.Enable {
+				state = "enabled"
+			}
+			filter := ""
+			switch {
+			case out.NamePrefix != "":
+				filter = "name=" + out.NamePrefix + "*"
+			case out.Key != "":
+				filter = "key=" + out.Key
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d mirrored, %d queued, %d failed", out.Service, out.Policy, state, cmdenv.EscNonPrint(filter), out.Mirrored, out.Queued, out.Failed)
+			if out.Last != "" {
+				fmt.Fprintf(tw, ", last %s", out.Last)
+			}
+			fmt.Fprintln(tw)
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,180 @@
+package pin
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/remotepin"
+)
+
+var remotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the local queue of remote pin requests.",
+		ShortDescription: `
+Pins added with 'ipfs pin remote add --queue' are kept in a local queue until
+the remote service reports them as pinned. The daemon sends them and retries
+with a backoff when the service is unreachable or fails to pin.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls":     lsRemotePinQueueCmd,
+		"retry":  retryRemotePinQueueCmd,
+		"cancel": cancelRemotePinQueueCmd,
+	},
+}
+
+// RemotePinIntent is a queued remote pin request.
+type RemotePinIntent struct {
+	ID          string
+	Service     string
+	Cid         string
+	Name        string
+	State       string
+	RequestID   string `json:",omitempty"`
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string `json:",omitempty"`
+}
+
+func toRemotePinIntent(in *remotepin.Intent) *RemotePinIntent {
+	return &RemotePinIntent{
+		ID:          in.ID,
+		Service:     in.Service,
+		Cid:         in.Cid.String(),
+		Name:        in.Name,
+		State:       string(in.State),
+		RequestID:   in.RequestID,
+		Attempts:    in.Attempts,
+		NextAttempt: in.NextAttempt,
+		LastError:   in.LastError,
+	}
+}
+
+var lsRemotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List queued remote pin requests.",
+		ShortDescription: `
+Lists the queued remote pin requests, oldest first, with their state:
+    * "queued": waiting to be sent to the service, or to be retried
+    * "pending": accepted by the service, which is still pinning
+    * "failed": not retried anymore, see 'ipfs pin remote queue retry'
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(pinServiceNameOptionName, "Only list the requests to the given remote pinning service."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		intents, err := node.RemotePins.List()
+		if err != nil {
+			return err
+		}
+
+		service, _ := req.Options[pinServiceNameOptionName].(string)
+		for _, in := range intents {
+			if service != "" && in.Service != service {
+				continue
+			}
+			if err := res.Emit(toRemotePinIntent(in)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: RemotePinIntent{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinIntent) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			defer tw.Flush()
+			status := out.State
+			if out.Attempts > 0 {
+				status = fmt.Sprintf("%s (%d attempts: %s)", status, out.Attempts, out.LastError)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", out.ID, out.Service, out.Cid, cmdenv.EscNonPrint(out.Name), status)
+			return nil
+		}),
+	},
+}
+
+var retryRemotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Retry queued remote pin requests now.",
+		ShortDescription: "Retries the given queued requests right away, including failed ones.",
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, true, "ID of the queued request, as listed by 'ipfs pin remote queue ls'."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range req.Arguments {
+			in, err := node.RemotePins.Retry(id)
+			if err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+			if err := res.Emit(toRemotePinIntent(in)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: RemotePinIntent{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinIntent) error {
+			fmt.Fprintf(w, "retrying %s\n", out.ID)
+			return nil
+		}),
+	},
+}
+
+var cancelRemotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Cancel queued remote pin requests.",
+		ShortDescription: `
+Marks the given requests as canceled. The daemon removes them from the queue,
+after removing their pin from the service if it was requested already,
+including by an attempt under way. Requests whose pin could not be removed
+stay queued as canceled and are retried with a backoff.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, true, "ID of the queued request, as listed by 'ipfs pin remote queue ls'."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range req.Arguments {
+			in, err := node.RemotePins.Cancel(id)
+			if err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+			if err := res.Emit(toRemotePinIntent(in)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: RemotePinIntent{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinIntent) error {
+			fmt.Fprintf(w, "canceled %s\n", out.ID)
+			return nil
+		}),
+	},
+}
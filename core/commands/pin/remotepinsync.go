@@ -0,0 +1,283 @@
+package pin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	path "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/remotepin"
+)
+
+const (
+	pinSyncPushOptionName   = "push"
+	pinSyncPruneOptionName  = "prune"
+	pinSyncPullOptionName   = "pull"
+	pinSyncDryRunOptionName = "dry-run"
+)
+
+// Actions of 'ipfs pin remote sync'.
+const (
+	pinSyncPush   = "push"
+	pinSyncRemove = "remove"
+	pinSyncPull   = "pull"
+)
+
+// RemotePinSyncOutput is an action of 'ipfs pin remote sync'.
+type RemotePinSyncOutput struct {
+	Action string
+	Cid    string
+	Name   string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// remotePinSyncEntry is a pin on one side only.
+type remotePinSyncEntry struct {
+	cid       cid.Cid
+	name      string
+	requestID string
+	// mine is set on the remote pins created by the node, other than the
+	// pins of the remote pinning policies
+	mine bool
+}
+
+var syncRemotePinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Reconcile local pins with a remote pinning service.",
+		ShortDescription: "Compares the local recursive pins with the pins of a remote pinning service.",
+		LongDescription: `
+Compares the local recursive pins with the queued, pinning and pinned pins of a
+remote pinning service, and reconciles them:
+
+  --push   requests the local pins missing on the service, named after the
+           local pins
+  --prune  removes the remote pins created by this node whose CID is not
+           pinned locally
+  --pull   pins the remote-only pins locally, fetching their data, named after
+           the remote pins
+
+At least one of them is required, and '--pull' cannot be combined with
+'--prune', which would remove the pulled pins. Pins are compared by multihash,
+so a CIDv0 pin matches the CIDv1 pin of the same data.
+
+The pins created by this node record its peer ID in their meta, under
+'ipfs-creator'. '--prune' leaves alone the pins without it, created by other
+nodes sharing the service or before the meta was recorded, and the pins of the
+remote pinning policies, named 'policy/...'.
+
+To only report the differences, add '--dry-run':
+
+  $ ipfs pin remote sync --service=mysrv --push --prune --dry-run
+
+Pushed pins are queued on the service, follow their progress with
+'ipfs pin remote ls'.
+`,
+	},
+
+	Options: []cmds.Option{
+		pinServiceNameOption,
+		cmds.BoolOption(pinSyncPushOptionName, "Pin the local pins missing on the remote service.").WithDefault(false),
+		cmds.BoolOption(pinSyncPruneOptionName, "Remove the remote pins created by this node and missing locally.").WithDefault(false),
+		cmds.BoolOption(pinSyncPullOptionName, "Pin the remote pins missing locally.").WithDefault(false),
+		cmds.BoolOption(pinSyncDryRunOptionName, "Only report the actions that would be taken.").WithDefault(false),
+	},
+	Type: RemotePinSyncOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		var opts remotePinSyncOptions
+		opts.push, _ = req.Options[pinSyncPushOptionName].(bool)
+		opts.prune, _ = req.Options[pinSyncPruneOptionName].(bool)
+		opts.pull, _ = req.Options[pinSyncPullOptionName].(bool)
+		opts.dryRun, _ = req.Options[pinSyncDryRunOptionName].(bool)
+		if !opts.push && !opts.prune && !opts.pull {
+			return fmt.Errorf("nothing to do, pass --%s, --%s or --%s", pinSyncPushOptionName, pinSyncPruneOptionName, pinSyncPullOptionName)
+		}
+		if opts.pull && opts.prune {
+			return fmt.Errorf("--%s and --%s cannot be combined: the pulled pins would be removed from the service", pinSyncPullOptionName, pinSyncPruneOptionName)
+		}
+
+		c, err := getRemotePinServiceFromRequest(req, env)
+		if err != nil {
+			return err
+		}
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		failed, err := syncRemotePins(req.Context, c, node, api, opts, func(out *RemotePinSyncOutput) error {
+			return res.Emit(out)
+		})
+		if err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d sync actions failed", failed)
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinSyncOutput) error {
+			action := out.Action
+			if dryRun, _ := req.Options[pinSyncDryRunOptionName].(bool); dryRun {
+				action = "would " + action
+			}
+			if out.Error != "" {
+				action += " failed"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s", action, out.Cid, cmdenv.EscNonPrint(out.Name))
+			if out.Error != "" {
+				fmt.Fprintf(w, "\t%s", out.Error)
+			}
+			fmt.Fprintln(w)
+			return nil
+		}),
+	},
+}
+
+type remotePinSyncOptions struct {
+	push, prune, pull, dryRun bool
+}
+
+// syncRemotePins reconciles the local recursive pins with the pins of the
+// service c, emitting each action taken, and returns how many failed.
+func syncRemotePins(ctx context.Context, c *pinclient.Client, node *core.IpfsNode, api coreiface.CoreAPI, opts remotePinSyncOptions, emit func(*RemotePinSyncOutput) error) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// local recursive pins, by multihash
+	keys, err := node.Pinning.RecursiveKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	local := make(map[string]*remotePinSyncEntry, len(keys))
+	for _, k := range keys {
+		e := &remotePinSyncEntry{cid: k}
+		m, err := node.PinMeta.Get(k)
+		if err != nil {
+			return 0, err
+		}
+		if m != nil {
+			e.name = m.Name
+		}
+		local[string(k.Hash())] = e
+	}
+
+	// remote pins, by multihash, the service may hold several pins of the
+	// same data
+	remote := make(map[string][]*remotePinSyncEntry)
+	var remoteOrder []string
+	psCh, errCh := c.Ls(ctx, pinclient.PinOpts.FilterStatus(pinclient.StatusQueued, pinclient.StatusPinning, pinclient.StatusPinned))
+	for ps := range psCh {
+		k := string(ps.GetPin().GetCid().Hash())
+		if _, ok := remote[k]; !ok {
+			remoteOrder = append(remoteOrder, k)
+		}
+		remote[k] = append(remote[k], &remotePinSyncEntry{
+			cid:       ps.GetPin().GetCid(),
+			name:      ps.GetPin().GetName(),
+			requestID: ps.GetRequestId(),
+			mine: remotepin.CreatedBy(ps.GetPin(), node.Identity) &&
+				!strings.HasPrefix(ps.GetPin().GetName(), remotepin.PolicyPinPrefix),
+		})
+	}
+	if err := <-errCh; err != nil {
+		return 0, fmt.Errorf("error while listing remote pins: %v", err)
+	}
+
+	failed := 0
+	emitAction := func(action string, e *remotePinSyncEntry, err error) error {
+		out := &RemotePinSyncOutput{Action: action, Cid: e.cid.String(), Name: e.name}
+		if err != nil {
+			failed++
+			out.Error = err.Error()
+		}
+		return emit(out)
+	}
+
+	if opts.push {
+		addOpts := []pinclient.AddOption{remotepin.CreatorMeta(node.Identity)}
+		if node.PeerHost != nil {
+			addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(node.PeerHost))
+			if err != nil {
+				return failed, err
+			}
+			addOpts = append(addOpts, pinclient.PinOpts.WithOrigins(addrs...))
+		}
+
+		for _, k := range keys {
+			e := local[string(k.Hash())]
+			if _, ok := remote[string(k.Hash())]; ok {
+				continue
+			}
+			var err error
+			if !opts.dryRun {
+				pinOpts := append([]pinclient.AddOption{}, addOpts...)
+				if e.name != "" {
+					pinOpts = append(pinOpts, pinclient.PinOpts.WithName(e.name))
+				}
+				_, err = c.Add(ctx, e.cid, pinOpts...)
+			}
+			if err := emitAction(pinSyncPush, e, err); err != nil {
+				return failed, err
+			}
+		}
+	}
+
+	for _, k := range remoteOrder {
+		if _, ok := local[k]; ok {
+			continue
+		}
+		if opts.pull {
+			e := remote[k][0]
+			var err error
+			if !opts.dryRun {
+				err = pullRemotePin(ctx, api, node.PinMeta, e)
+			}
+			if err := emitAction(pinSyncPull, e, err); err != nil {
+				return failed, err
+			}
+		}
+		if opts.prune {
+			for _, e := range remote[k] {
+				if !e.mine {
+					continue
+				}
+				var err error
+				if !opts.dryRun {
+					err = c.DeleteByID(ctx, e.requestID)
+				}
+				if err := emitAction(pinSyncRemove, e, err); err != nil {
+					return failed, err
+				}
+			}
+		}
+	}
+	return failed, nil
+}
+
+// pullRemotePin pins e locally, with the name of the remote pin.
+func pullRemotePin(ctx context.Context, api coreiface.CoreAPI, meta *pinmeta.Store, e *remotePinSyncEntry) error {
+	if err := api.Pin().Add(ctx, path.IpfsPath(e.cid), options.Pin.Recursive(true)); err != nil {
+		return err
+	}
+	if e.name == "" {
+		return nil
+	}
+	return (&pinAnnotation{store: meta, name: &e.name}).set(e.cid)
+}
@@ -0,0 +1,243 @@
+package pin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/remotepin"
+
+	cid "github.com/ipfs/go-cid"
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	path "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// syncTestService is a minimal stand-in of the Pinning Service API.
+type syncTestService struct {
+	lk    sync.Mutex
+	pins  map[string]*syncTestPin
+	added int
+}
+
+type syncTestPin struct {
+	Requestid string                 `json:"requestid"`
+	Status    string                 `json:"status"`
+	Created   time.Time              `json:"created"`
+	Pin       map[string]interface{} `json:"pin"`
+	Delegates []string               `json:"delegates"`
+}
+
+func (s *syncTestService) add(c cid.Cid, name string, meta interface{}) {
+	s.added++
+	p := &syncTestPin{
+		Requestid: fmt.Sprint(s.added),
+		Status:    "pinned",
+		Created:   time.Now(),
+		Pin:       map[string]interface{}{"cid": c.String()},
+		Delegates: []string{"/ip4/127.0.0.1/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupGMd"},
+	}
+	if name != "" {
+		p.Pin["name"] = name
+	}
+	if meta != nil {
+		p.Pin["meta"] = meta
+	}
+	s.pins[p.Requestid] = p
+}
+
+// cids returns the CIDs pinned on the service, sorted.
+func (s *syncTestService) cids() []string {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	var cids []string
+	for _, p := range s.pins {
+		cids = append(cids, p.Pin["cid"].(string))
+	}
+	sort.Strings(cids)
+	return cids
+}
+
+func (s *syncTestService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/pins":
+		var pin map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, err := cid.Decode(pin["cid"].(string))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, _ := pin["name"].(string)
+		s.add(c, name, pin["meta"])
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(s.pins[fmt.Sprint(s.added)])
+	case r.Method == http.MethodGet && r.URL.Path == "/pins":
+		results := []*syncTestPin{}
+		for _, p := range s.pins {
+			if st := r.URL.Query().Get("status"); st != "" && !strings.Contains(st, p.Status) {
+				continue
+			}
+			results = append(results, p)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"count": len(results), "results": results})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/pins/"):
+		id := strings.TrimPrefix(r.URL.Path, "/pins/")
+		if _, ok := s.pins[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.pins, id)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type syncTestNode struct {
+	node *core.IpfsNode
+	api  coreiface.CoreAPI
+}
+
+func newSyncTestNode(t *testing.T) *syncTestNode {
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &syncTestNode{node: node, api: api}
+}
+
+// put stores data in the node, pinned or not.
+func (n *syncTestNode) put(t *testing.T, data string, pin bool) cid.Cid {
+	b, err := n.api.Block().Put(context.Background(), strings.NewReader(data), options.Block.Format("raw"), options.Block.Pin(pin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Path().Cid()
+}
+
+func (n *syncTestNode) pinned(t *testing.T, c cid.Cid) bool {
+	_, pinned, err := n.api.Pin().IsPinned(context.Background(), path.IpfsPath(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pinned
+}
+
+func runSync(t *testing.T, n *syncTestNode, c *pinclient.Client, opts remotePinSyncOptions) []*RemotePinSyncOutput {
+	var outs []*RemotePinSyncOutput
+	failed, err := syncRemotePins(context.Background(), c, n.node, n.api, opts, func(out *RemotePinSyncOutput) error {
+		outs = append(outs, out)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed > 0 {
+		t.Fatalf("%d sync actions failed: %v", failed, outs)
+	}
+	return outs
+}
+
+func TestSyncRemotePins(t *testing.T) {
+	n := newSyncTestNode(t)
+	svc := &syncTestService{pins: make(map[string]*syncTestPin)}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+	c := pinclient.NewClient(srv.URL, "secret")
+
+	both := n.put(t, "both", true)
+	localOnly := n.put(t, "local only", true)
+	// the data of the remote pins is at hand, not to be fetched
+	remoteOnly := n.put(t, "remote only", false)
+	mine := map[string]string{remotepin.CreatorMetaKey: n.node.Identity.Pretty()}
+	svc.add(both, "", mine)
+	svc.add(remoteOnly, "remote", nil)
+
+	expectActions := func(outs []*RemotePinSyncOutput, action string, cids ...cid.Cid) {
+		t.Helper()
+		if len(outs) != len(cids) {
+			t.Fatalf("expected %d actions, got %v", len(cids), outs)
+		}
+		for i, out := range outs {
+			if out.Action != action || out.Cid != cids[i].String() {
+				t.Fatalf("expected to %s %s, got %v", action, cids[i], out)
+			}
+		}
+	}
+
+	// a dry run changes nothing, the remote pin was not created by the node
+	// and is not pruned
+	outs := runSync(t, n, c, remotePinSyncOptions{push: true, prune: true, dryRun: true})
+	if len(outs) != 1 || len(svc.cids()) != 2 {
+		t.Fatalf("expected the actions to only be reported, got %v and the pins %v", outs, svc.cids())
+	}
+
+	expectActions(runSync(t, n, c, remotePinSyncOptions{pull: true}), pinSyncPull, remoteOnly)
+	if !n.pinned(t, remoteOnly) {
+		t.Fatal("expected the remote pin to be pinned locally")
+	}
+	if m, err := n.node.PinMeta.Get(remoteOnly); err != nil || m == nil || m.Name != "remote" {
+		t.Fatalf("expected the pulled pin to be named after the remote one, got %v, %v", m, err)
+	}
+
+	expectActions(runSync(t, n, c, remotePinSyncOptions{push: true}), pinSyncPush, localOnly)
+	if len(svc.cids()) != 3 {
+		t.Fatalf("expected the local pin to be pushed, got %v", svc.cids())
+	}
+	for _, p := range svc.pins {
+		if p.Pin["cid"] == localOnly.String() {
+			if meta, _ := p.Pin["meta"].(map[string]interface{}); meta[remotepin.CreatorMetaKey] != n.node.Identity.Pretty() {
+				t.Fatalf("expected the pushed pin to record the node as its creator, got %v", p.Pin)
+			}
+		}
+	}
+
+	if err := n.api.Pin().Rm(context.Background(), path.IpfsPath(both)); err != nil {
+		t.Fatal(err)
+	}
+	expectActions(runSync(t, n, c, remotePinSyncOptions{prune: true}), pinSyncRemove, both)
+	for _, k := range svc.cids() {
+		if k == both.String() {
+			t.Fatal("expected the pin removed locally to be removed from the service")
+		}
+	}
+	if outs := runSync(t, n, c, remotePinSyncOptions{push: true, prune: true}); len(outs) != 0 {
+		t.Fatalf("expected the pins to be in sync, got %v", outs)
+	}
+
+	// the pins of other nodes and of the policies are never pruned
+	other := n.put(t, "other", false)
+	policy := n.put(t, "policy", false)
+	svc.add(other, "other", map[string]string{remotepin.CreatorMetaKey: "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupGMd"})
+	svc.add(policy, remotepin.PolicyPinPrefix+n.node.Identity.Pretty()+"/ipns/self", mine)
+	if outs := runSync(t, n, c, remotePinSyncOptions{prune: true}); len(outs) != 0 {
+		t.Fatalf("expected the pins of other nodes and policies to be kept, got %v", outs)
+	}
+	if len(svc.cids()) != 4 {
+		t.Fatalf("expected the pins of other nodes and policies to be kept, got %v", svc.cids())
+	}
+}
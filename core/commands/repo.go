@@ -14,6 +14,7 @@ import (
 	humanize "github.com/dustin/go-humanize"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/gc"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	cid "github.com/ipfs/go-cid"
@@ -46,11 +47,35 @@ var RepoCmd = &cmds.Command{
 type GcResult struct {
 	Key   cid.Cid
 	Error string `json:",omitempty"`
+
+	// Size of the block that would be removed, in a dry run.
+	Size uint64 `json:",omitempty"`
+	// Total is set on the last result of a dry run.
+	Total *GcTotal `json:",omitempty"`
+	// Explanation is set when explaining why Key is kept.
+	Explanation *GcExplanation `json:",omitempty"`
+}
+
+// GcTotal sums up the blocks that a dry run of "repo gc" would remove.
+type GcTotal struct {
+	Blocks uint64
+	Size   uint64
+}
+
+// GcExplanation tells why a block is kept by garbage collection, as returned
+// by "repo gc --explain".
+type GcExplanation struct {
+	Stored   bool
+	Retained []gc.Retention
 }
 
 const (
 	repoStreamErrorsOptionName = "stream-errors"
 	repoQuietOptionName        = "quiet"
+	repoGcModeOptionName       = "mode"
+	repoRebuildIndexOptionName = "rebuild-index"
+	repoDryRunOptionName       = "dry-run"
+	repoExplainOptionName      = "explain"
 )
 
 var repoGcCmd = &cmds.Command{
@@ -60,11 +85,43 @@ var repoGcCmd = &cmds.Command{
 'ipfs repo gc' is a plumbing command that will sweep the local
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
+`,
+		LongDescription: `
+'ipfs repo gc' is a plumbing command that will sweep the local
+set of stored objects and remove ones that are not pinned in
+order to reclaim hard disk space.
+
+By default the whole collection runs with the GC lock held, which blocks
+'ipfs add', 'ipfs pin add' and 'ipfs dag import' until it is done. With
+'--mode=concurrent' the set of pinned blocks is computed without the lock,
+blocks written in the meantime are kept, and the lock is only held for the
+final sweep. The default mode can be set with the 'Datastore.GCMode' config
+key, which is also used by the periodic GC of the daemon.
+
+When the 'Datastore.GCIndex' config key is set to true, the pinned blocks
+are tracked in a persistent index as pins are added and removed, and the
+collection does not need to walk the pinned DAGs. If the index was disabled
+or could not be updated, it is not used until rebuilt with '--rebuild-index'.
+
+With '--dry-run', the blocks that would be removed are listed along with
+their size, followed by the total, and nothing is removed or rebuilt, so it
+cannot be combined with '--rebuild-index'. A dry run does not take the GC lock
+so blocks added or pinned while it runs may be listed.
+
+'--explain <cid>' does not collect anything but reports why the given block
+is kept: which direct or recursive pin, which MFS root, or which block used
+internally by the pinner references it, or whether the checkpoint of an
+interrupted 'ipfs add --resume' records it. As every pin is walked, this is
+about as slow as a collection.
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoStreamErrorsOptionName, "Stream errors."),
 		cmds.BoolOption(repoQuietOptionName, "q", "Write minimal output."),
+		cmds.StringOption(repoGcModeOptionName, "Collection mode: 'full' or 'concurrent'. Defaults to the value of Datastore.GCMode."),
+		cmds.BoolOption(repoRebuildIndexOptionName, "Rebuild the gc index from the pins before collecting."),
+		cmds.BoolOption(repoDryRunOptionName, "List the blocks that would be removed without removing them."),
+		cmds.StringOption(repoExplainOptionName, "Report why the given block is kept instead of collecting."),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -73,8 +130,72 @@ order to reclaim hard disk space.
 		}
 
 		streamErrors, _ := req.Options[repoStreamErrorsOptionName].(bool)
+		dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+		rebuild, _ := req.Options[repoRebuildIndexOptionName].(bool)
+		if dryRun && rebuild {
+			return fmt.Errorf("cannot rebuild the gc index in a dry run")
+		}
+
+		if explain, ok := req.Options[repoExplainOptionName].(string); ok {
+			c, err := cid.Decode(explain)
+			if err != nil {
+				return err
+			}
+			stored, err := n.Blockstore.Has(c)
+			if err != nil {
+				return err
+			}
+			retained, err := corerepo.ExplainRetention(req.Context, n, c)
+			if err != nil {
+				return err
+			}
+			return cmds.EmitOnce(re, &GcResult{
+				Key:         c,
+				Explanation: &GcExplanation{Stored: stored, Retained: retained},
+			})
+		}
 
-		gcOutChan := corerepo.GarbageCollectAsync(n, req.Context)
+		mode, ok := req.Options[repoGcModeOptionName].(string)
+		if !ok {
+			mode, err = corerepo.GCMode(n.Repo)
+			if err != nil {
+				return err
+			}
+		}
+		gcOpts, err := corerepo.GCOptions(n, mode)
+		if err != nil {
+			return err
+		}
+
+		if rebuild {
+			if err := corerepo.RebuildGCIndex(req.Context, n); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			gcOpts = append(gcOpts, gc.DryRun())
+		} else if err := corerepo.RemoveStaleCheckpoints(req.Context, n); err != nil {
+			return err
+		}
+
+		var total GcTotal
+		emitRemoved := func(k cid.Cid) error {
+			if !dryRun {
+				return re.Emit(&GcResult{Key: k})
+			}
+
+			size, err := n.Blockstore.GetSize(k)
+			if err != nil {
+				// removed since, don't count it
+				return nil
+			}
+			total.Blocks++
+			total.Size += uint64(size)
+			return re.Emit(&GcResult{Key: k, Size: uint64(size)})
+		}
+
+		gcOutChan := corerepo.GarbageCollectAsync(n, req.Context, gcOpts...)
 
 		if streamErrors {
 			errs := false
@@ -85,7 +206,7 @@ order to reclaim hard disk space.
 					}
 					errs = true
 				} else {
-					if err := re.Emit(&GcResult{Key: res.KeyRemoved}); err != nil {
+					if err := emitRemoved(res.KeyRemoved); err != nil {
 						return err
 					}
 				}
@@ -98,13 +219,16 @@ order to reclaim hard disk space.
 				// Nothing to do with this error, really. This
 				// most likely means that the client is gone but
 				// we still need to let the GC finish.
-				_ = re.Emit(&GcResult{Key: k})
+				_ = emitRemoved(k)
 			})
 			if err != nil {
 				return err
 			}
 		}
 
+		if dryRun {
+			return re.Emit(&GcResult{Total: &total})
+		}
 		return nil
 	},
 	Type: GcResult{},
@@ -112,12 +236,26 @@ order to reclaim hard disk space.
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, gcr *GcResult) error {
 			quiet, _ := req.Options[repoQuietOptionName].(bool)
 
-			if gcr.Error != "" {
+			dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+
+			switch {
+			case gcr.Error != "":
 				_, err := fmt.Fprintf(w, "Error: %s\n", gcr.Error)
 				return err
+			case gcr.Explanation != nil:
+				return writeGcExplanation(w, gcr.Key, gcr.Explanation)
+			case gcr.Total != nil:
+				if quiet {
+					return nil
+				}
+				_, err := fmt.Fprintf(w, "would remove %d blocks, %s\n", gcr.Total.Blocks, humanize.Bytes(gcr.Total.Size))
+				return err
 			}
 
 			prefix := "removed "
+			if dryRun {
+				prefix = "would remove "
+			}
 			if quiet {
 				prefix = ""
 			}
@@ -128,6 +266,47 @@ order to reclaim hard disk space.
 	},
 }
 
+func writeGcExplanation(w io.Writer, c cid.Cid, e *GcExplanation) error {
+	if !e.Stored {
+		_, err := fmt.Fprintf(w, "%s is not stored in the repo\n", c)
+		return err
+	}
+	if len(e.Retained) == 0 {
+		_, err := fmt.Fprintf(w, "%s is not retained and will be removed by the next gc\n", c)
+		return err
+	}
+
+	for _, r := range e.Retained {
+		var err error
+		switch r.Reason {
+		case gc.RetainedDirect:
+			_, err = fmt.Fprintf(w, "%s is pinned directly\n", c)
+		case gc.RetainedRecursive:
+			_, err = fmt.Fprintf(w, "%s is pinned recursively by %s\n", c, formatRetentionPath(r.Path))
+		case gc.RetainedBestEffort:
+			_, err = fmt.Fprintf(w, "%s is referenced by the MFS root %s\n", c, formatRetentionPath(r.Path))
+		case gc.RetainedCheckpoint:
+			_, err = fmt.Fprintf(w, "%s is checkpointed by an interrupted add\n", c)
+		case gc.RetainedInternal:
+			_, err = fmt.Fprintf(w, "%s is used internally by the pinner, via %s\n", c, formatRetentionPath(r.Path))
+		default:
+			_, err = fmt.Fprintf(w, "%s is retained (%s) by %s\n", c, r.Reason, r.Root)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatRetentionPath(path []cid.Cid) string {
+	strs := make([]string, len(path))
+	for i, c := range path {
+		strs[i] = c.String()
+	}
+	return strings.Join(strs, " -> ")
+}
+
 const (
 	repoSizeOnlyOptionName = "size-only"
 	repoHumanOptionName    = "human"
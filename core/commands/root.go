@@ -58,6 +58,8 @@ ADVANCED COMMANDS
   stats         Various operational stats
   p2p           Libp2p stream mounting
   filestore     Manage the filestore (experimental)
+  carstore      Serve blocks straight from CAR files
+  denylist      Block content and names from being served
 
 NETWORK COMMANDS
   id            Show info about IPFS peers
@@ -118,6 +120,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"add":       AddCmd,
 	"bitswap":   BitswapCmd,
 	"block":     BlockCmd,
+	"carstore":  CarStoreCmd,
 	"cat":       CatCmd,
 	"commands":  CommandsDaemonCmd,
 	"files":     FilesCmd,
@@ -131,6 +134,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"dag":       dag.DagCmd,
 	"dht":       DhtCmd,
 	"diag":      DiagCmd,
+	"denylist":  DenylistCmd,
 	"dns":       DNSCmd,
 	"id":        IDCmd,
 	"key":       KeyCmd,
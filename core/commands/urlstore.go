@@ -1,15 +1,24 @@
 package commands
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	gopath "path"
+	"strings"
+	"time"
 
 	filestore "github.com/ipfs/go-filestore"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/corerepo"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+	mfs "github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
 	"github.com/ipfs/interface-go-ipfs-core/options"
 )
 
@@ -18,10 +27,19 @@ var urlStoreCmd = &cmds.Command{
 		Tagline: "Interact with urlstore.",
 	},
 	Subcommands: map[string]*cmds.Command{
-		"add": urlAdd,
+		"add":     urlAdd,
+		"add-dir": urlAddDir,
+		"verify":  urlVerify,
+		"rm":      urlRm,
 	},
 }
 
+const (
+	urlRefreshOptionName = "refresh"
+	urlTimeoutOptionName = "request-timeout"
+	urlForceOptionName   = "force"
+)
+
 var urlAdd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Add URL via urlstore.",
@@ -102,3 +120,354 @@ settings for 'ipfs add'.
 		}),
 	},
 }
+
+// urlListingEntry is a file of a directory added with 'ipfs urlstore add-dir'.
+type urlListingEntry struct {
+	Path    string
+	URL     string
+	Chunker string
+}
+
+// parseURLListing reads the entries of a listing of URLs, one per line:
+// the path of the file in the directory, its URL and, optionally, its
+// chunker. Empty lines and lines starting with '#' are skipped.
+func parseURLListing(r io.Reader, chunker string) ([]urlListingEntry, error) {
+	var entries []urlListingEntry
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected a path, a URL and an optional chunker", line)
+		}
+		e := urlListingEntry{Path: gopath.Clean("/" + fields[0]), URL: fields[1], Chunker: chunker}
+		if len(fields) == 3 {
+			e.Chunker = fields[2]
+		}
+		if e.Path == "/" || strings.Contains(fields[0], "..") {
+			return nil, fmt.Errorf("line %d: invalid path %q", line, fields[0])
+		}
+		if seen[e.Path] {
+			return nil, fmt.Errorf("line %d: duplicate path %q", line, fields[0])
+		}
+		if !filestore.IsURL(e.URL) {
+			return nil, fmt.Errorf("line %d: unsupported url syntax: %s", line, e.URL)
+		}
+		seen[e.Path] = true
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// URLDirEntry is a file added by 'ipfs urlstore add-dir', or the directory
+// itself when its Path is empty.
+type URLDirEntry struct {
+	Path string `json:",omitempty"`
+	URL  string `json:",omitempty"`
+	Hash string
+	Size int64
+}
+
+var urlAddDir = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add a directory of URLs via urlstore.",
+		ShortDescription: `
+Adds the URLs of a listing to ipfs without storing their data locally, as
+'ipfs urlstore add' does, as the files of a directory.
+`,
+		LongDescription: `
+Adds the URLs of a listing to ipfs without storing their data locally, as
+'ipfs urlstore add' does, as the files of a directory.
+
+The listing has a line per file: its path in the directory, its URL and,
+optionally, the chunker to add it with, '--chunker' by default. Empty lines
+and lines starting with '#' are skipped:
+
+  # path          url                                     chunker
+  iso/disk.iso    https://mirror.example.com/disk.iso     size-1048576
+  iso/SHA256SUMS  https://mirror.example.com/SHA256SUMS
+
+  > ipfs urlstore add-dir listing.txt
+  added bafkrei...vq iso/disk.iso
+  added bafkrei...xm iso/SHA256SUMS
+  added bafybei...ai
+
+Only the directory is pinned, unless '--pin=false' is given. Use
+'ipfs urlstore verify' to check that the URLs still serve the same content.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("listing", true, false, "The listing of the URLs of the directory.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(trickleOptionName, "t", "Use trickle-dag format for dag generation."),
+		cmds.StringOption(chunkerOptionName, "s", "Chunking algorithm of the URLs whose chunker is not listed.").WithDefault("size-262144"),
+		cmds.BoolOption(pinOptionName, "Pin the directory when adding.").WithDefault(true),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		file, err := cmdenv.GetFileArg(req.Files.Entries())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		chunker, _ := req.Options[chunkerOptionName].(string)
+		entries, err := parseURLListing(file, chunker)
+		if err != nil {
+			return err
+		}
+
+		useTrickledag, _ := req.Options[trickleOptionName].(bool)
+		dopin, _ := req.Options[pinOptionName].(bool)
+
+		prefix, err := dag.PrefixForCidVersion(1)
+		if err != nil {
+			return err
+		}
+		rootNode := ft.EmptyDirNode()
+		rootNode.SetCidBuilder(prefix)
+		root, err := mfs.NewRoot(req.Context, n.DAG, rootNode, nil)
+		if err != nil {
+			return err
+		}
+
+		// GC must not remove the files before the directory is pinned
+		defer n.Blockstore.PinLock().Unlock()
+
+		for _, e := range entries {
+			u, err := url.Parse(e.URL)
+			if err != nil {
+				return err
+			}
+			opts := []options.UnixfsAddOption{
+				options.Unixfs.Pin(false),
+				options.Unixfs.CidVersion(1),
+				options.Unixfs.RawLeaves(true),
+				options.Unixfs.Nocopy(true),
+				options.Unixfs.Chunker(e.Chunker),
+			}
+			if useTrickledag {
+				opts = append(opts, options.Unixfs.Layout(options.TrickleLayout))
+			}
+
+			webFile := files.NewWebFile(u)
+			p, err := api.Unixfs().Add(req.Context, webFile, opts...)
+			if err != nil {
+				return fmt.Errorf("adding %s: %w", e.URL, err)
+			}
+			nd, err := api.Dag().Get(req.Context, p.Cid())
+			if err != nil {
+				return err
+			}
+			if dir := gopath.Dir(e.Path); dir != "/" {
+				if err := mfs.Mkdir(root, dir, mfs.MkdirOpts{Mkparents: true}); err != nil {
+					return err
+				}
+			}
+			if err := mfs.PutNode(root, e.Path, nd); err != nil {
+				return fmt.Errorf("adding %s: %w", e.Path, err)
+			}
+
+			size, _ := webFile.Size()
+			err = res.Emit(&URLDirEntry{
+				Path: e.Path[1:],
+				URL:  e.URL,
+				Hash: enc.Encode(p.Cid()),
+				Size: size,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		nd, err := root.GetDirectory().GetNode()
+		if err != nil {
+			return err
+		}
+		if dopin {
+			if err := n.Pinning.Pin(req.Context, nd, true); err != nil {
+				return err
+			}
+			if err := n.Pinning.Flush(req.Context); err != nil {
+				return err
+			}
+		}
+		size, err := nd.Size()
+		if err != nil {
+			return err
+		}
+		return res.Emit(&URLDirEntry{Hash: enc.Encode(nd.Cid()), Size: int64(size)})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, e *URLDirEntry) error {
+			var err error
+			if e.Path == "" {
+				_, err = fmt.Fprintf(w, "added %s\n", e.Hash)
+			} else {
+				_, err = fmt.Fprintf(w, "added %s %s\n", e.Hash, e.Path)
+			}
+			return err
+		}),
+	},
+	Type: URLDirEntry{},
+}
+
+var urlVerify = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Verify that the URLs of the urlstore still serve the same content.",
+		ShortDescription: `
+Checks, for each URL of the urlstore or only for the given ones, that the
+server still serves the content of its objects.
+`,
+		LongDescription: `
+Checks, for each URL of the urlstore or only for the given ones, that the
+server still serves the content of its objects: the size of the content is
+asked with a HEAD request, and the objects are then fetched with as few
+range requests as possible and hashed.
+
+The output is:
+
+<status> <url> (<blocks> blocks)
+
+The status is the worst status of the objects of the URL, as in
+'ipfs filestore verify': 'changed' when some objects no longer match or the
+content grew, 'no-file' when the server reports that the URL is gone, and
+'error' when it could not be fetched or when the server ignores range
+requests, which the urlstore reads the objects with.
+
+With '--refresh', the URLs whose content changed are added again, with the
+chunk size and hash function of their objects, and pinned unless '--pin=false'
+is given. The objects that no longer match are then removed, except those of
+blocks that are still pinned, such as by the pins of the previous content,
+unless '--force' is given.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("url", false, true, "URLs to verify."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(urlRefreshOptionName, "Add the URLs whose content changed again."),
+		cmds.BoolOption(pinOptionName, "Pin the URLs added again.").WithDefault(true),
+		cmds.StringOption(urlTimeoutOptionName, "Timeout of the requests to each URL.").WithDefault("1m"),
+		cmds.BoolOption(urlForceOptionName, "Remove the objects of pinned blocks that no longer match too."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		timeout, err := time.ParseDuration(req.Options[urlTimeoutOptionName].(string))
+		if err != nil {
+			return err
+		}
+
+		opts := corerepo.URLVerifyOptions{
+			URLs:   req.Arguments,
+			Client: &http.Client{Timeout: timeout},
+		}
+		opts.Refresh, _ = req.Options[urlRefreshOptionName].(bool)
+		opts.Pin, _ = req.Options[pinOptionName].(bool)
+		opts.Force, _ = req.Options[urlForceOptionName].(bool)
+
+		found := make(map[string]bool)
+		err = corerepo.VerifyURLs(req.Context, n, opts, func(v *corerepo.URLVerify) error {
+			found[v.URL] = true
+			return res.Emit(v)
+		})
+		if err != nil {
+			return err
+		}
+		for _, u := range req.Arguments {
+			if !found[u] {
+				return fmt.Errorf("%s is not in the urlstore", u)
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, v *corerepo.URLVerify) error {
+			enc, err := cmdenv.GetCidEncoder(req)
+			if err != nil {
+				return err
+			}
+			line := fmt.Sprintf("%-7s %s (%d blocks", v.Status, v.URL, v.Blocks)
+			if v.Broken > 0 && v.Broken < v.Blocks {
+				line += fmt.Sprintf(", %d broken", v.Broken)
+			}
+			line += ")"
+			if v.Error != "" {
+				line += ": " + v.Error
+			}
+			if v.Root.Defined() {
+				line += fmt.Sprintf("\n        refreshed as %s, %d blocks removed", enc.Encode(v.Root), v.Removed)
+				if v.Pinned > 0 {
+					line += fmt.Sprintf(", kept %d pinned blocks", v.Pinned)
+				}
+			}
+			_, err = fmt.Fprintln(w, line)
+			return err
+		}),
+	},
+	Type: corerepo.URLVerify{},
+}
+
+var urlRm = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove the objects of URLs from the urlstore.",
+		ShortDescription: `
+Removes the objects referencing the given URLs from the urlstore. The objects
+of pinned blocks are kept, unless '--force' is given: use 'ipfs pin rm' to
+remove the pins of the files added from these URLs first.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("url", true, true, "URLs to remove."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(urlForceOptionName, "Remove the objects of pinned blocks too."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		force, _ := req.Options[urlForceOptionName].(bool)
+		removed, err := corerepo.RemoveURLs(req.Context, n, req.Arguments, force)
+		if err != nil {
+			return err
+		}
+		for _, r := range removed {
+			if err := res.Emit(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, r *corerepo.URLRemoved) error {
+			line := fmt.Sprintf("removed %d blocks of %s", r.Blocks, r.URL)
+			if r.Pinned > 0 {
+				line += fmt.Sprintf(", kept %d pinned blocks", r.Pinned)
+			}
+			_, err := fmt.Fprintln(w, line)
+			return err
+		}),
+	},
+	Type: corerepo.URLRemoved{},
+}
@@ -41,12 +41,18 @@ import (
 	p2pbhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	ma "github.com/multiformats/go-multiaddr"
 
+	"github.com/ipfs/go-ipfs/carstore"
 	"github.com/ipfs/go-ipfs/core/bootstrap"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/filewatch"
 	"github.com/ipfs/go-ipfs/fuse/mount"
+	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/p2p"
 	"github.com/ipfs/go-ipfs/peering"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/remotepin"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-namesys"
 	ipnsrp "github.com/ipfs/go-namesys/republisher"
@@ -64,6 +70,11 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	PinMeta         *pinmeta.Store         // metadata of the local pins, such as their expiry
+	RemotePins      *remotepin.Queue       // remote pin requests retried by the daemon
+	PinPolicies     *remotepin.Mirror      // automatic remote pinning policies
+	FileWatches     *filewatch.Manager     // directories kept in sync with MFS by the daemon
+	Denylist        *denylist.Denylist     // content and names not served
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -72,8 +83,12 @@ type IpfsNode struct {
 	Peerstore       pstore.Peerstore          `optional:"true"` // storage for other Peer instances
 	Blockstore      bstore.GCBlockstore       // the block store (lower level)
 	Filestore       *filestore.Filestore      `optional:"true"` // the filestore blockstore
+	CarStore        *carstore.Store           // CAR files serving blocks read-only
 	BaseBlocks      node.BaseBlocks           // the raw blockstore, no filestore wrapping
 	GCLocker        bstore.GCLocker           // the locker used to protect the blockstore during gc
+	GCBarrier       *gc.WriteBarrier          // records writes made during a concurrent gc
+	GCIndex         *gc.Index                 `optional:"true"` // reference count index of the pinned blocks, if enabled
+	GCAccessTimes   *gc.AccessTimes           `optional:"true"` // block access times, if gc evicts least recently used blocks
 	Blocks          bserv.BlockService        // the block service, get/add blocks.
 	DAG             ipld.DAGService           // the merkle dag service, get/add objects.
 	Resolver        *resolver.Resolver        // the path resolution system
@@ -90,6 +105,7 @@ type IpfsNode struct {
 	Routing       routing.Routing         `optional:"true"` // the routing system. recommend ipfs-dht
 	Exchange      exchange.Interface      // the block exchange + strategy (bitswap)
 	Namesys       namesys.NameSystem      // the name system, resolves paths to hashes
+	NameTTLs      *node.NameTTLs          // the TTLs of the IPNS records resolved
 	Provider      provider.System         // the value provider system
 	IpnsRepub     *ipnsrp.Republisher     `optional:"true"`
 	GraphExchange graphsync.GraphExchange `optional:"true"`
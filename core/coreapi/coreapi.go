@@ -26,6 +26,7 @@ import (
 	"github.com/ipfs/go-ipfs-provider"
 	offlineroute "github.com/ipfs/go-ipfs-routing/offline"
 	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
 	dag "github.com/ipfs/go-merkledag"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/options"
@@ -37,12 +38,18 @@ import (
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	record "github.com/libp2p/go-libp2p-record"
 
+	"github.com/ipfs/go-ipfs/carstore"
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/node"
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/remotepin"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-namesys"
 )
 
+var log = logging.Logger("core/coreapi")
+
 type CoreAPI struct {
 	nctx context.Context
 
@@ -52,10 +59,15 @@ type CoreAPI struct {
 	repo       repo.Repo
 	blockstore blockstore.GCBlockstore
 	baseBlocks blockstore.Blockstore
+	carStore   *carstore.Store
 	pinning    pin.Pinner
+	pinMeta    *pinmeta.Store
+	policies   *remotepin.Mirror
 
 	blocks bserv.BlockService
 	dag    ipld.DAGService
+	// denylist blocks the nodes of dag, see WithDenylist
+	denylist *denylist.Denylist
 
 	peerstore       pstore.Peerstore
 	peerHost        p2phost.Host
@@ -163,10 +175,14 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		repo:       n.Repo,
 		blockstore: n.Blockstore,
 		baseBlocks: n.BaseBlocks,
+		carStore:   n.CarStore,
 		pinning:    n.Pinning,
+		pinMeta:    n.PinMeta,
+		policies:   n.PinPolicies,
 
-		blocks: n.Blocks,
-		dag:    n.DAG,
+		blocks:   n.Blocks,
+		dag:      n.DAG,
+		denylist: api.denylist,
 
 		peerstore:       n.Peerstore,
 		peerHost:        n.PeerHost,
@@ -225,10 +241,26 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		subApi.blocks = bserv.New(subApi.blockstore, subApi.exchange)
 		subApi.dag = dag.NewDAGService(subApi.blocks)
 	}
+	subApi.dag = subApi.denylist.DAGService(subApi.dag)
 
 	return subApi, nil
 }
 
+// WithDenylist returns api reading the DAG through the denylist d: the nodes
+// it blocks are not fetched, and neither read nor resolved through. api must
+// be a CoreAPI returned by NewCoreAPI.
+func WithDenylist(api coreiface.CoreAPI, d *denylist.Denylist) (coreiface.CoreAPI, error) {
+	capi, ok := api.(*CoreAPI)
+	if !ok {
+		return nil, fmt.Errorf("cannot apply the denylist to %T", api)
+	}
+
+	subApi := *capi
+	subApi.denylist = d
+	subApi.dag = d.DAGService(capi.dag)
+	return &subApi, nil
+}
+
 // getSession returns new api backed by the same node with a read-only session DAG
 func (api *CoreAPI) getSession(ctx context.Context) *CoreAPI {
 	sesApi := *api
@@ -15,6 +15,8 @@ import (
 	path "github.com/ipfs/interface-go-ipfs-core/path"
 	ci "github.com/libp2p/go-libp2p-core/crypto"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-ipfs/remotepin"
 )
 
 type NameAPI CoreAPI
@@ -75,6 +77,11 @@ func (api *NameAPI) Publish(ctx context.Context, p path.Path, opts ...caopts.Nam
 		return nil, err
 	}
 
+	// the name was published, whatever becomes of its remote pins
+	if err := api.mirrorPublished(ctx, options.Key, p); err != nil {
+		log.Errorf("applying remote pinning policies to %s: %s", p, err)
+	}
+
 	return &ipnsEntry{
 		name:  coreiface.FormatKeyID(pid),
 		value: p,
@@ -141,6 +148,21 @@ func (api *NameAPI) Resolve(ctx context.Context, name string, opts ...caopts.Nam
 	return p, err
 }
 
+// mirrorPublished applies the IPNS policies following the key to the
+// published path, which is only resolved when there are some.
+func (api *NameAPI) mirrorPublished(ctx context.Context, key string, p path.Path) error {
+	policies, err := api.policies.Policies(remotepin.PolicyIPNS)
+	if err != nil || len(policies) == 0 {
+		return err
+	}
+
+	rp, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return err
+	}
+	return api.policies.Published(key, rp.Cid())
+}
+
 func keylookup(self ci.PrivKey, kstore keystore.Keystore, k string) (ci.PrivKey, error) {
 	////////////////////
 	// Lookup by name //
@@ -202,3 +224,7 @@ func keylookup(self ci.PrivKey, kstore keystore.Keystore, k string) (ci.PrivKey,
 
 	return nil, fmt.Errorf("no key by the given name or PeerID was found")
 }
+
+func (api *NameAPI) core() coreiface.CoreAPI {
+	return (*CoreAPI)(api)
+}
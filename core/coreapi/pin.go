@@ -12,6 +12,8 @@ import (
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
 )
 
 type PinAPI CoreAPI
@@ -29,6 +31,10 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 
 	defer api.blockstore.PinLock().Unlock()
 
+	if err := api.carStore.Copy(ctx, api.blockstore, dagNode.Cid(), settings.Recursive); err != nil {
+		return fmt.Errorf("pin: %s", err)
+	}
+
 	err = api.pinning.Pin(ctx, dagNode, settings.Recursive)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
@@ -116,6 +122,10 @@ func (api *PinAPI) Update(ctx context.Context, from path.Path, to path.Path, opt
 
 	defer api.blockstore.PinLock().Unlock()
 
+	if err := api.carStore.Copy(ctx, api.blockstore, tp.Cid(), true); err != nil {
+		return err
+	}
+
 	err = api.pinning.Update(ctx, fp.Cid(), tp.Cid(), settings.Unpin)
 	if err != nil {
 		return err
@@ -203,9 +213,12 @@ func (api *PinAPI) Verify(ctx context.Context) (<-chan coreiface.PinStatus, erro
 type pinInfo struct {
 	pinType string
 	path    path.Resolved
+	meta    *pinmeta.Meta
 	err     error
 }
 
+var _ pinmeta.Pin = (*pinInfo)(nil)
+
 func (p *pinInfo) Path() path.Resolved {
 	return p.path
 }
@@ -218,6 +231,11 @@ func (p *pinInfo) Err() error {
 	return p.err
 }
 
+// Meta returns the metadata of direct and recursive pins, see pinmeta.Pin.
+func (p *pinInfo) Meta() *pinmeta.Meta {
+	return p.meta
+}
+
 // pinLsAll is an internal function for returning a list of pins
 //
 // The caller must keep reading results until the channel is closed to prevent
@@ -230,10 +248,19 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 	AddToResultKeys := func(keyList []cid.Cid, typeStr string) error {
 		for _, c := range keyList {
 			if keys.Visit(c) {
+				var meta *pinmeta.Meta
+				if typeStr != "indirect" {
+					var err error
+					if meta, err = api.pinMeta.Get(c); err != nil {
+						return err
+					}
+				}
+
 				select {
 				case out <- &pinInfo{
 					pinType: typeStr,
 					path:    path.IpldPath(c),
+					meta:    meta,
 				}:
 				case <-ctx.Done():
 					return ctx.Err()
@@ -52,9 +52,52 @@ func getOrCreateNilNode() (*core.IpfsNode, error) {
 	return nilNode, nil
 }
 
+// AddOption sets the options of AddWithOptions which interface-go-ipfs-core
+// does not define.
+type AddOption func(*addSettings)
+
+type addSettings struct {
+	dedup  *coreunix.DedupReport
+	filter *coreunix.Filter
+	resume bool
+}
+
+// AddDedupReport makes the add collect in report how much of the added files
+// was already present, see coreunix.NewDedupReport.
+func AddDedupReport(report *coreunix.DedupReport) AddOption {
+	return func(s *addSettings) {
+		s.dedup = report
+	}
+}
+
+// AddFilter makes the add skip the files of the added directories filtered
+// out by f.
+func AddFilter(f *coreunix.Filter) AddOption {
+	return func(s *addSettings) {
+		s.filter = f
+	}
+}
+
+// AddResume makes the add checkpoint the added files in the repo, resuming
+// those an interrupted add checkpointed.
+func AddResume() AddOption {
+	return func(s *addSettings) {
+		s.resume = true
+	}
+}
+
 // Add builds a merkledag node from a reader, adds it to the blockstore,
 // and returns the key representing that node.
 func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options.UnixfsAddOption) (path.Resolved, error) {
+	return api.AddWithOptions(ctx, files, opts)
+}
+
+// AddWithOptions is Add with the options specific to go-ipfs.
+func (api *UnixfsAPI) AddWithOptions(ctx context.Context, files files.Node, opts []options.UnixfsAddOption, addOpts ...AddOption) (path.Resolved, error) {
+	var addSet addSettings
+	for _, opt := range addOpts {
+		opt(&addSet)
+	}
 	settings, prefix, err := options.UnixfsAddOptions(opts...)
 	if err != nil {
 		return nil, err
@@ -134,6 +177,11 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	fileAdder.RawLeaves = settings.RawLeaves
 	fileAdder.NoCopy = settings.NoCopy
 	fileAdder.CidBuilder = prefix
+	fileAdder.Dedup = addSet.dedup
+	fileAdder.Filter = addSet.filter
+	if addSet.resume && !settings.OnlyHash {
+		fileAdder.Checkpoints = coreunix.NewCheckpoints(api.repo.Datastore())
+	}
 
 	switch settings.Layout {
 	case options.BalancedLayout:
@@ -173,6 +221,10 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 		if err := api.provider.Provide(nd.Cid()); err != nil {
 			return nil, err
 		}
+		// the content was added, whatever becomes of its remote pins
+		if err := api.policies.Added(nd.Cid()); err != nil {
+			log.Errorf("applying remote pinning policies to %s: %s", nd.Cid(), err)
+		}
 	}
 
 	return path.IpfsPath(nd.Cid()), nil
@@ -9,6 +9,8 @@ import (
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/node"
+	"github.com/ipfs/go-ipfs/denylist"
 
 	options "github.com/ipfs/interface-go-ipfs-core/options"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
@@ -18,6 +20,11 @@ type GatewayConfig struct {
 	Headers      map[string][]string
 	Writable     bool
 	PathPrefixes []string
+	// Denylist is the content and names answered with 410 Gone.
+	Denylist *denylist.Denylist
+	// NameTTLs are the TTLs of the IPNS records resolved by the node, for
+	// the gateway to cache the paths of their names as long.
+	NameTTLs *node.NameTTLs
 }
 
 // A helper function to clean up a set of headers:
@@ -51,6 +58,11 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 		if err != nil {
 			return nil, err
 		}
+		// the blocked content is neither served nor resolved through
+		api, err = coreapi.WithDenylist(api, n.Denylist)
+		if err != nil {
+			return nil, err
+		}
 
 		headers := make(map[string][]string, len(cfg.Gateway.HTTPHeaders))
 		for h, v := range cfg.Gateway.HTTPHeaders {
@@ -91,6 +103,8 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			Headers:      headers,
 			Writable:     writable,
 			PathPrefixes: cfg.Gateway.PathPrefixes,
+			Denylist:     n.Denylist,
+			NameTTLs:     n.NameTTLs,
 		}, api)
 
 		for _, p := range paths {
@@ -0,0 +1,321 @@
+package corehttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipfspath "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+)
+
+// archiveFormat is a format the whole DAG of a path can be downloaded in with
+// the ?format= query parameter.
+type archiveFormat struct {
+	contentType string
+	// ranges is whether the archives are served with byte ranges, which
+	// requires knowing their size upfront.
+	ranges bool
+	write  func(a *archive, w io.Writer) error
+	size   func(a *archive) (int64, error)
+}
+
+// archive is the DAG of a path written in an archive.
+type archive struct {
+	ctx context.Context
+	dag ipld.DAGService
+	// path is the resolved path, whose root is the root of a CAR archive.
+	path ipath.Resolved
+	nd   ipld.Node
+	// name is the name of the root entry of a tar archive.
+	name string
+}
+
+var archiveFormats = map[string]archiveFormat{
+	"tar": {
+		contentType: "application/x-tar",
+		ranges:      true,
+		write: func(a *archive, w io.Writer) error {
+			tw := newArchiveTarWriter(a.ctx, a.dag, w)
+			if err := tw.WriteNode(a.nd, a.name); err != nil {
+				return err
+			}
+			return tw.Close()
+		},
+		size: func(a *archive) (int64, error) {
+			return newArchiveTarWriter(a.ctx, a.dag, ioutil.Discard).Size(a.nd, a.name)
+		},
+	},
+	"car": {
+		contentType: "application/vnd.ipld.car; version=1",
+		write:       writePathCar,
+	},
+}
+
+// newArchiveTarWriter returns a tar writer giving the entries without a
+// recorded modification time the same one as the files served, so that the
+// archive of a CID is always the same and can be served in ranges.
+func newArchiveTarWriter(ctx context.Context, dag ipld.DAGService, w io.Writer) *unixfsmeta.TarWriter {
+	tw := unixfsmeta.NewTarWriter(ctx, dag, w)
+	tw.ModTime = time.Unix(1, 0)
+	return tw
+}
+
+// writePathCar writes a CAR archive rooted at the root of the path, holding
+// the blocks resolving the path followed by the whole DAG it resolves to, so
+// that clients can verify the content from the root CID alone.
+func writePathCar(a *archive, w io.Writer) error {
+	// the blocks resolving the path are those the resolver fetches, each
+	// linked from the previous one
+	rec := &recordingNodeGetter{NodeGetter: a.dag}
+	r := &resolver.Resolver{DAG: rec, ResolveOnce: uio.ResolveUnixfsOnce}
+	if _, _, err := r.ResolveToLastNode(a.ctx, ipfspath.Path(a.path.String())); err != nil {
+		return err
+	}
+	next := make(map[cid.Cid]cid.Cid)
+	for i, c := range rec.cids {
+		if i+1 < len(rec.cids) {
+			next[c] = rec.cids[i+1]
+		} else if !c.Equals(a.nd.Cid()) {
+			next[c] = a.nd.Cid()
+		}
+	}
+
+	// the walk only follows the path until it reaches the DAG of the path
+	walk := func(nd ipld.Node) ([]*ipld.Link, error) {
+		if c, ok := next[nd.Cid()]; ok {
+			return []*ipld.Link{{Cid: c}}, nil
+		}
+		return nd.Links(), nil
+	}
+	return gocar.WriteCarWithWalker(a.ctx, a.dag, []cid.Cid{a.path.Root()}, w, walk)
+}
+
+// recordingNodeGetter records the CIDs of the nodes fetched through it, in
+// order.
+type recordingNodeGetter struct {
+	ipld.NodeGetter
+	cids []cid.Cid
+}
+
+func (g *recordingNodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	g.cids = append(g.cids, c)
+	return g.NodeGetter.Get(ctx, c)
+}
+
+// responseFormat returns the format requested with the ?format= query
+// parameter, or else with the Accept header, or "" for the default response.
+func responseFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, v := range strings.Split(accept, ",") {
+			mt, _, err := mime.ParseMediaType(v)
+			if err != nil {
+				continue
+			}
+			switch mt {
+			case rawBlockContentType:
+				return "raw"
+			case "application/vnd.ipld.car":
+				return "car"
+			}
+		}
+	}
+	return ""
+}
+
+// archiveEtag returns the ETag of the response of the path in the format. The
+// CAR archive of a path also depends on the blocks resolving it.
+func archiveEtag(resolvedPath ipath.Resolved, format string) string {
+	if format == "car" && !resolvedPath.Root().Equals(resolvedPath.Cid()) {
+		sum := sha256.Sum256([]byte(resolvedPath.String()))
+		return fmt.Sprintf(`"%s.car-%x"`, resolvedPath.Cid(), sum[:8])
+	}
+	return `"` + resolvedPath.Cid().String() + "." + format + `"`
+}
+
+// setDownloadHeaders sets the headers of the responses in the formats
+// requested with ?format= or the Accept header.
+func (i *gatewayHandler) setDownloadHeaders(w http.ResponseWriter, urlPath string, etag string, contentType string, filename string) {
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", urlPath)
+	w.Header().Set("Etag", etag)
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	setContentDisposition(w, "attachment", filename)
+	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+}
+
+const rawBlockContentType = "application/vnd.ipld.raw"
+
+// serveRawBlock serves the block the path resolves to as is, for clients to
+// hash it and compare it with its CID.
+func (i *gatewayHandler) serveRawBlock(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string) {
+	responseEtag := archiveEtag(resolvedPath, "raw")
+	if r.Header.Get("If-None-Match") == responseEtag || r.Header.Get("If-None-Match") == `W/`+responseEtag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	br, err := i.api.Block().Get(r.Context(), resolvedPath)
+	if err != nil {
+		webError(w, "ipfs block get "+r.URL.EscapedPath(), err, http.StatusNotFound)
+		return
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		filename = resolvedPath.Cid().String() + ".bin"
+	}
+	i.setDownloadHeaders(w, urlPath, responseEtag, rawBlockContentType, filename)
+	http.ServeContent(&statusResponseWriter{w}, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// serveArchive serves the DAG of resolvedPath as an archive of the given
+// format, streamed as it is walked.
+func (i *gatewayHandler) serveArchive(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string, format string) {
+	if format == "raw" {
+		i.serveRawBlock(w, r, resolvedPath, urlPath)
+		return
+	}
+	af, ok := archiveFormats[format]
+	if !ok {
+		webErrorWithCode(w, "invalid format", fmt.Errorf("%q is not one of raw, car, tar", format), http.StatusBadRequest)
+		return
+	}
+
+	// the archive of a path never changes
+	responseEtag := archiveEtag(resolvedPath, format)
+	if r.Header.Get("If-None-Match") == responseEtag || r.Header.Get("If-None-Match") == `W/`+responseEtag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	nd, err := i.api.Dag().Get(r.Context(), resolvedPath.Cid())
+	if err != nil {
+		webError(w, "ipfs dag get "+r.URL.EscapedPath(), err, http.StatusNotFound)
+		return
+	}
+
+	name := getFilename(urlPath)
+	if name == "" || name == "/" {
+		name = resolvedPath.Cid().String()
+	}
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		filename = name + "." + format
+	}
+	i.setDownloadHeaders(w, urlPath, responseEtag, af.contentType, filename)
+
+	a := &archive{ctx: r.Context(), dag: i.api.Dag(), path: resolvedPath, nd: nd, name: name}
+	if af.ranges && r.Header.Get("Range") != "" {
+		// the archive is written again up to the start of each range
+		size, err := af.size(a)
+		if err != nil {
+			webError(w, "ipfs get "+r.URL.EscapedPath(), err, http.StatusInternalServerError)
+			return
+		}
+		content := &lazySeeker{
+			size: size,
+			reader: &streamSeeker{open: func() io.ReadCloser {
+				pr, pw := io.Pipe()
+				go func() {
+					pw.CloseWithError(af.write(a, pw))
+				}()
+				return pr
+			}},
+		}
+		defer content.Close()
+		http.ServeContent(&statusResponseWriter{w}, r, "", time.Time{}, content)
+		return
+	}
+
+	if af.ranges {
+		w.Header().Set("Accept-Ranges", "bytes")
+	} else {
+		w.Header().Set("Accept-Ranges", "none")
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	err = af.write(a, bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// the status was sent already, break the connection for the client
+		// not to take the archive as complete
+		log.Warnf("writing the %s archive of %s: %s", format, urlPath, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// streamSeeker makes a stream seekable: seeking forward skips the bytes in
+// between, seeking backward opens the stream again.
+type streamSeeker struct {
+	open   func() io.ReadCloser
+	r      io.ReadCloser
+	offset int64
+}
+
+func (s *streamSeeker) Read(p []byte) (int, error) {
+	if s.r == nil {
+		s.r = s.open()
+	}
+	n, err := s.r.Read(p)
+	s.offset += int64(n)
+	return n, err
+}
+
+func (s *streamSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return s.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < s.offset {
+		if err := s.Close(); err != nil {
+			return s.offset, err
+		}
+		s.offset = 0
+	}
+	if offset > s.offset {
+		if _, err := io.CopyN(ioutil.Discard, s, offset-s.offset); err != nil {
+			return s.offset, err
+		}
+	}
+	return s.offset, nil
+}
+
+func (s *streamSeeker) Close() error {
+	if s.r == nil {
+		return nil
+	}
+	err := s.r.Close()
+	s.r = nil
+	return err
+}
@@ -0,0 +1,206 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/node"
+	"github.com/ipfs/go-ipfs/denylist"
+
+	humanize "github.com/dustin/go-humanize"
+	lru "github.com/hashicorp/golang-lru"
+	namesys "github.com/ipfs/go-namesys"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	pathCacheSize    = 1024
+	listingCacheSize = 128
+	siteCacheSize    = 128
+
+	// the listings of larger directories are not cached
+	maxCachedListing = 10000
+)
+
+var (
+	// defaultNameTTL is how long the paths of a name are cached when its
+	// TTL is not known.
+	defaultNameTTL = namesys.DefaultResolverCacheTTL
+	// maxNameTTL caps the TTL of the names.
+	maxNameTTL = time.Hour
+)
+
+// gatewayCache keeps the paths resolved by the gateway, the listings of the
+// directories it served, and the _redirects and _headers rules of the
+// websites, for the next requests of the same paths not to resolve their
+// names, walk the DAG or parse the files again.
+//
+// The paths under /ipfs/ never change, and are kept until evicted. Those
+// under /ipns/ are kept for the TTL of their name, up to maxNameTTL: the TTL
+// of the IPNS record or DNSLink TXT record the node resolved it with, or
+// defaultNameTTL when it is not known.
+type gatewayCache struct {
+	api coreiface.CoreAPI
+	// nameTTL returns the TTL of a name just resolved, and whether it is
+	// known.
+	nameTTL func(name string) (time.Duration, bool)
+
+	paths    *lru.Cache // path string -> *cachedPath
+	listings *lru.Cache // directory cid.Cid -> []listingEntry
+	sites    *lru.Cache // website root cid.Cid -> *siteFiles
+}
+
+// cachedPath is a path resolved by the gateway.
+type cachedPath struct {
+	resolved ipath.Resolved
+	// name is the IPNS name or DNSLink domain of an /ipns/ path
+	name string
+	// expires is when the path of a name is to be resolved again
+	expires time.Time
+	// modified is when the path was first seen resolving to its CID, the
+	// modification time of the content of /ipns/ paths
+	modified time.Time
+}
+
+// listingEntry is an entry of a directory listing, without the parts that
+// depend on the request.
+type listingEntry struct {
+	Name string
+	Size string
+	Hash string
+}
+
+// newGatewayCache returns the cache of the paths resolved with api. The TTLs
+// of the IPNS names and DNSLink domains are read from ttls, unless it is nil.
+func newGatewayCache(api coreiface.CoreAPI, ttls *node.NameTTLs) *gatewayCache {
+	c := &gatewayCache{
+		api: api,
+		nameTTL: func(name string) (time.Duration, bool) {
+			if ttls == nil {
+				return 0, false
+			}
+			if id, err := peer.Decode(name); err == nil {
+				return ttls.TTL(id)
+			}
+			return ttls.DNSLinkTTL(name)
+		},
+	}
+	// lru.New only fails for non-positive sizes
+	c.paths, _ = lru.New(pathCacheSize)
+	c.listings, _ = lru.New(listingCacheSize)
+	c.sites, _ = lru.New(siteCacheSize)
+	return c
+}
+
+// resolvePath resolves p, or returns it from the cache while its name is
+// within its TTL.
+func (c *gatewayCache) resolvePath(ctx context.Context, p ipath.Path) (*cachedPath, error) {
+	key := p.String()
+	now := time.Now()
+	var previous *cachedPath
+	if v, ok := c.paths.Get(key); ok {
+		previous = v.(*cachedPath)
+		if previous.name == "" || now.Before(previous.expires) {
+			gatewayCacheHits.WithLabelValues("paths").Inc()
+			return previous, nil
+		}
+	}
+	gatewayCacheMisses.WithLabelValues("paths").Inc()
+
+	resolved, err := c.api.ResolvePath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	e := &cachedPath{resolved: resolved, modified: now}
+	if p.Namespace() == "ipns" {
+		e.name = strings.SplitN(strings.TrimPrefix(key, ipnsPathPrefix), "/", 2)[0]
+		if previous != nil && previous.resolved.Cid().Equals(resolved.Cid()) {
+			e.modified = previous.modified
+		}
+		ttl, ok := c.nameTTL(e.name)
+		if !ok {
+			ttl = defaultNameTTL
+		}
+		if ttl > maxNameTTL {
+			ttl = maxNameTTL
+		}
+		e.expires = now.Add(ttl)
+	} else {
+		// the content of /ipfs/ paths never changes
+		e.modified = time.Unix(1, 0)
+	}
+	c.paths.Add(key, e)
+	return e, nil
+}
+
+// listing returns the entries of the directory at p, from the cache if it
+// was listed already. The entries blocked by the denylist are left out.
+func (c *gatewayCache) listing(ctx context.Context, p ipath.Resolved) ([]listingEntry, error) {
+	if v, ok := c.listings.Get(p.Cid()); ok {
+		gatewayCacheHits.WithLabelValues("listings").Inc()
+		return v.([]listingEntry), nil
+	}
+	gatewayCacheMisses.WithLabelValues("listings").Inc()
+
+	links, err := c.api.Unixfs().Ls(ctx, p, options.Unixfs.ResolveChildren(false))
+	if err != nil {
+		return nil, err
+	}
+	var entries []listingEntry
+	for l := range links {
+		if l.Err != nil {
+			return nil, l.Err
+		}
+		nd, err := c.api.Unixfs().Get(ctx, ipath.IpfsPath(l.Cid))
+		if errors.Is(err, denylist.ErrBlocked) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		size := "?"
+		if s, err := nd.Size(); err == nil {
+			// Size may not be defined/supported. Continue anyways.
+			size = humanize.Bytes(uint64(s))
+		}
+		nd.Close()
+
+		entries = append(entries, listingEntry{
+			Name: l.Name,
+			Size: size,
+			Hash: l.Cid.String(),
+		})
+	}
+
+	if len(entries) <= maxCachedListing {
+		c.listings.Add(p.Cid(), entries)
+	}
+	return entries, nil
+}
+
+// site returns the rules of the _redirects and _headers files of the website
+// at root, parsed once for each CID it resolves to.
+func (c *gatewayCache) site(ctx context.Context, root string) (*siteFiles, error) {
+	resolved, err := c.resolvePath(ctx, ipath.New(root))
+	if err != nil {
+		return nil, err
+	}
+	key := resolved.resolved.Cid()
+	if v, ok := c.sites.Get(key); ok {
+		gatewayCacheHits.WithLabelValues("sites").Inc()
+		return v.(*siteFiles), nil
+	}
+	gatewayCacheMisses.WithLabelValues("sites").Inc()
+
+	site, err := loadSiteFiles(ctx, c.api, resolved.resolved)
+	if err != nil {
+		return nil, err
+	}
+	c.sites.Add(key, site)
+	return site, nil
+}
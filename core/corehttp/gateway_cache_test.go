@@ -0,0 +1,165 @@
+package corehttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+
+	files "github.com/ipfs/go-ipfs-files"
+	path "github.com/ipfs/go-path"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	testutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGatewayCache(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw := newGatewayHandler(GatewayConfig{}, api)
+	gw.cache.nameTTL = func(name string) (time.Duration, bool) {
+		if name == "short.example.com" {
+			return 0, true
+		}
+		return 2 * maxNameTTL, true
+	}
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+
+	var versions []path.Path
+	for _, content := range []string{"one", "two"} {
+		k, err := api.Unixfs().Add(n.Context(), files.NewMapDirectory(map[string]files.Node{
+			"a.txt": files.NewBytesFile([]byte(content)),
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, path.FromString(k.String()))
+	}
+	ns["/ipns/example.com"] = versions[0]
+	ns["/ipns/short.example.com"] = versions[0]
+
+	get := func(p string, header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+	res, body := get("/ipns/example.com/a.txt", nil)
+	if body != "one" {
+		t.Fatalf("expected the first version, got %q", body)
+	}
+	etag, lastModified := res.Header.Get("Etag"), res.Header.Get("Last-Modified")
+	get("/ipns/short.example.com/a.txt", nil)
+
+	hits := testutil.ToFloat64(gatewayCacheHits.WithLabelValues("paths"))
+	ns["/ipns/example.com"] = versions[1]
+	ns["/ipns/short.example.com"] = versions[1]
+	if _, body := get("/ipns/example.com/a.txt", nil); body != "one" {
+		t.Fatalf("expected the path to be cached for the TTL of its name, got %q", body)
+	}
+	if testutil.ToFloat64(gatewayCacheHits.WithLabelValues("paths")) != hits+1 {
+		t.Fatal("expected the hit to be counted")
+	}
+	if _, body := get("/ipns/short.example.com/a.txt", nil); body != "two" {
+		t.Fatalf("expected the path to be resolved again once its TTL expired, got %q", body)
+	}
+
+	for _, test := range []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{"If-None-Match": {`"other", W/` + etag}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {lastModified}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}}, http.StatusOK},
+		// If-None-Match wins
+		{http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}}, http.StatusOK},
+	} {
+		if res, _ := get("/ipns/example.com/a.txt", test.header); res.StatusCode != test.status {
+			t.Errorf("%v: expected status %d, got %d", test.header, test.status, res.StatusCode)
+		}
+	}
+
+	// directory listings are cached and conditional too
+	listings := testutil.ToFloat64(gatewayCacheHits.WithLabelValues("listings"))
+	res, body = get("/ipns/example.com/", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Last-Modified") == "" {
+		t.Fatalf("expected a listing with its modification time, got %d %v", res.StatusCode, res.Header)
+	}
+	if _, again := get("/ipns/example.com/", nil); again != body {
+		t.Fatal("expected the same listing from the cache")
+	}
+	if testutil.ToFloat64(gatewayCacheHits.WithLabelValues("listings")) != listings+1 {
+		t.Fatal("expected the listing to be found in the cache")
+	}
+	if res, _ := get("/ipns/example.com/", http.Header{"If-Modified-Since": {res.Header.Get("Last-Modified")}}); res.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected the listing not to be modified, got %d", res.StatusCode)
+	}
+}
+
+func TestGatewayCacheNameTTL(t *testing.T) {
+	ctx := context.Background()
+	n, err := core.NewNode(ctx, &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("ttl")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// publish with a name system of its own, for the record to be resolved
+	// by the one of the node
+	offline, err := api.WithOptions(options.Api.Offline(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := offline.Name().Publish(ctx, k, options.Name.TTL(10*time.Minute), options.Name.AllowOffline(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newGatewayCache(api, n.NameTTLs)
+	name := n.Identity.Pretty()
+	if _, ok := c.nameTTL(name); ok {
+		t.Fatal("expected the TTL of a name not resolved yet to be unknown")
+	}
+	if _, err := api.Name().Resolve(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok := c.nameTTL(name); !ok || ttl <= 9*time.Minute || ttl > 10*time.Minute {
+		t.Fatalf("expected the TTL of the record, got %s, %t", ttl, ok)
+	}
+	if _, ok := c.nameTTL("example.com"); ok {
+		t.Fatal("expected the TTL of a DNSLink name to be unknown")
+	}
+}
@@ -2,6 +2,7 @@ package corehttp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -12,7 +13,6 @@ import (
 	gopath "path"
 	"regexp"
 	"runtime/debug"
-	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +21,8 @@ import (
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
 	assets "github.com/ipfs/go-ipfs/assets"
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
 	dag "github.com/ipfs/go-merkledag"
 	mfs "github.com/ipfs/go-mfs"
 	path "github.com/ipfs/go-path"
@@ -62,6 +64,7 @@ type redirectTemplateData struct {
 type gatewayHandler struct {
 	config GatewayConfig
 	api    coreiface.CoreAPI
+	cache  *gatewayCache
 }
 
 // StatusResponseWriter enables us to override HTTP Status Code passed to
@@ -87,6 +90,7 @@ func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
 	i := &gatewayHandler{
 		config: c,
 		api:    api,
+		cache:  newGatewayCache(api, c.NameTTLs),
 	}
 	return i
 }
@@ -119,6 +123,10 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	defer func() {
 		if r := recover(); r != nil {
+			if r == http.ErrAbortHandler {
+				// the response is cut short on purpose
+				panic(r)
+			}
 			log.Error("A panic occurred in the gateway handler!")
 			log.Error(r)
 			debug.PrintStack()
@@ -249,14 +257,32 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// websites set their own headers with a _headers file
+	if r.Context().Value(rewrittenKey{}) == nil {
+		w = i.withSiteHeaders(w, r)
+	}
+
+	// the names and paths blocked are not resolved
+	if err := i.config.Denylist.Check(urlPath); err != nil {
+		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusInternalServerError)
+		return
+	}
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(r.Context(), parsedPath)
+	resolved, err := i.cache.resolvePath(r.Context(), parsedPath)
 	switch err {
 	case nil:
+		if err := i.config.Denylist.CheckResolved(urlPath, resolved.resolved); err != nil {
+			webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusInternalServerError)
+			return
+		}
 	case coreiface.ErrOffline:
 		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusServiceUnavailable)
 		return
 	default:
+		if i.serveRedirectsIfPresent(w, r) {
+			return
+		}
 		if i.servePretty404IfPresent(w, r, parsedPath) {
 			return
 		}
@@ -264,6 +290,14 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusNotFound)
 		return
 	}
+	resolvedPath := resolved.resolved
+
+	// ?format= or the Accept header select the raw block, or an archive of
+	// the whole DAG, instead of the file or directory listing
+	if format := responseFormat(r); format != "" {
+		i.serveArchive(w, r, resolvedPath, urlPath, format)
+		return
+	}
 
 	dr, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
 	if err != nil {
@@ -286,8 +320,23 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		responseEtag = `"` + resolvedPath.Cid().String() + `"`
 	}
 
-	// Check etag sent back to us
-	if r.Header.Get("If-None-Match") == responseEtag || r.Header.Get("If-None-Match") == `W/`+responseEtag {
+	// the content of /ipfs/ paths is from a really long time ago, since it
+	// is immutable and should stay cached, and that of /ipns/ paths from
+	// when the gateway first saw their name resolving to it
+	modtime := resolved.modified
+	f, isFile := dr.(files.File)
+	if isFile {
+		// the modification time recorded by 'ipfs add --preserve-mtime' wins
+		if nd, err := i.api.Dag().Get(r.Context(), resolvedPath.Cid()); err == nil {
+			if meta, err := unixfsmeta.FromNode(nd); err == nil && !meta.Mtime.IsZero() {
+				modtime = meta.Mtime
+			}
+		}
+	}
+
+	// Check etag or modification time sent back to us
+	if notModified(r, responseEtag, modtime) {
+		w.Header().Set("Etag", responseEtag)
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
@@ -295,19 +344,16 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	i.addUserHeaders(w) // ok, _now_ write user's headers.
 	w.Header().Set("X-IPFS-Path", urlPath)
 	w.Header().Set("Etag", responseEtag)
-
-	// set these headers _after_ the error, for we may just not have it
-	// and don't want the client to cache a 500 response...
-	// and only if it's /ipfs!
-	// TODO: break this out when we split /ipfs /ipns routes.
-	modtime := time.Now()
-
-	if f, ok := dr.(files.File); ok {
+	// the response depends on the formats accepted, see responseFormat
+	w.Header().Add("Vary", "Accept")
+
+	if isFile {
+		// set these headers _after_ the error, for we may just not have it
+		// and don't want the client to cache a 500 response...
+		// and only if it's /ipfs!
+		// TODO: break this out when we split /ipfs /ipns routes.
 		if strings.HasPrefix(urlPath, ipfsPathPrefix) {
 			w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
-
-			// set modtime to a really long time ago, since files are immutable and should stay cached
-			modtime = time.Unix(1, 0)
 		}
 
 		urlFilename := r.URL.Query().Get("filename")
@@ -317,9 +363,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 			if r.URL.Query().Get("download") == "true" {
 				disposition = "attachment"
 			}
-			utf8Name := url.PathEscape(urlFilename)
-			asciiName := url.PathEscape(onlyAscii.ReplaceAllLiteralString(urlFilename, "_"))
-			w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", disposition, asciiName, utf8Name))
+			setContentDisposition(w, disposition, urlFilename)
 			name = urlFilename
 		} else {
 			name = getFilename(urlPath)
@@ -377,40 +421,29 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	// A HTML directory index will be presented, be sure to set the correct
 	// type instead of relying on autodetection (which may fail).
 	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
 	if r.Method == http.MethodHead {
 		return
 	}
 
 	// storage for directory listing
-	var dirListing []directoryItem
-	dirit := dir.Entries()
-	for dirit.Next() {
-		size := "?"
-		if s, err := dirit.Node().Size(); err == nil {
-			// Size may not be defined/supported. Continue anyways.
-			size = humanize.Bytes(uint64(s))
-		}
-
-		hash := ""
-		if r, err := i.api.ResolvePath(r.Context(), ipath.Join(resolvedPath, dirit.Name())); err == nil {
-			// Path may not be resolved. Continue anyways.
-			hash = r.Cid().String()
-		}
-
+	entries, err := i.cache.listing(r.Context(), resolvedPath)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	dirListing := make([]directoryItem, 0, len(entries))
+	for _, e := range entries {
 		// See comment above where originalUrlPath is declared.
 		di := directoryItem{
-			Size:      size,
-			Name:      dirit.Name(),
-			Path:      gopath.Join(originalUrlPath, dirit.Name()),
-			Hash:      hash,
-			ShortHash: shortHash(hash),
+			Size:      e.Size,
+			Name:      e.Name,
+			Path:      gopath.Join(originalUrlPath, e.Name),
+			Hash:      e.Hash,
+			ShortHash: shortHash(e.Hash),
 		}
 		dirListing = append(dirListing, di)
 	}
-	if dirit.Err() != nil {
-		internalWebError(w, dirit.Err())
-		return
-	}
 
 	// construct the correct back link
 	// https://github.com/ipfs/go-ipfs/issues/1365
@@ -530,28 +563,8 @@ func (i *gatewayHandler) servePretty404IfPresent(w http.ResponseWriter, r *http.
 		return false
 	}
 
-	dr, err := i.api.Unixfs().Get(r.Context(), resolved404Path)
-	if err != nil {
-		return false
-	}
-	defer dr.Close()
-
-	f, ok := dr.(files.File)
-	if !ok {
-		return false
-	}
-
-	size, err := f.Size()
-	if err != nil {
-		return false
-	}
-
 	log.Debugf("using pretty 404 file for %s", parsedPath.String())
-	w.Header().Set("Content-Type", ctype)
-	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
-	w.WriteHeader(http.StatusNotFound)
-	_, err = io.CopyN(w, f, size)
-	return err == nil
+	return i.serveStatusFile(w, r, resolved404Path, ctype, http.StatusNotFound)
 }
 
 func (i *gatewayHandler) postHandler(w http.ResponseWriter, r *http.Request) {
@@ -739,6 +752,8 @@ func (i *gatewayHandler) addUserHeaders(w http.ResponseWriter) {
 func webError(w http.ResponseWriter, message string, err error, defaultCode int) {
 	if _, ok := err.(resolver.ErrNoLink); ok {
 		webErrorWithCode(w, message, err, http.StatusNotFound)
+	} else if errors.Is(err, denylist.ErrBlocked) {
+		webErrorWithCode(w, message, err, http.StatusGone)
 	} else if err == routing.ErrNotFound {
 		webErrorWithCode(w, message, err, http.StatusNotFound)
 	} else if err == context.DeadlineExceeded {
@@ -760,6 +775,37 @@ func internalWebError(w http.ResponseWriter, err error) {
 	webErrorWithCode(w, "internalWebError", err, http.StatusInternalServerError)
 }
 
+// setContentDisposition sets the Content-Disposition header of a response
+// saved as filename, with an ASCII fallback for older clients.
+func setContentDisposition(w http.ResponseWriter, disposition string, filename string) {
+	utf8Name := url.PathEscape(filename)
+	asciiName := url.PathEscape(onlyAscii.ReplaceAllLiteralString(filename, "_"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", disposition, asciiName, utf8Name))
+}
+
+// notModified reports whether the client has the response already: whether
+// If-None-Match lists etag, or, without If-None-Match, whether the response
+// was not modified since If-Modified-Since.
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			// the comparison is weak, as for GET and HEAD requests
+			if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modtime.IsZero() {
+		return false
+	}
+	// the header has a precision of a second
+	return !modtime.Truncate(time.Second).After(ims)
+}
+
 func getFilename(s string) string {
 	if (strings.HasPrefix(s, ipfsPathPrefix) || strings.HasPrefix(s, ipnsPathPrefix)) && strings.Count(gopath.Clean(s), "/") <= 2 {
 		// Don't want to treat ipfs.io in /ipns/ipfs.io as a filename.
@@ -0,0 +1,417 @@
+package corehttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	gopath "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-path/resolver"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// The files configuring the websites served on their own origin, from
+// subdomain and DNSLink gateways, at their root.
+const (
+	redirectsFile = "_redirects"
+	headersFile   = "_headers"
+
+	maxSiteFileSize = 64 << 10
+)
+
+// sitePattern is a path pattern of the rules of _redirects and _headers
+// files. A segment starting with a colon matches any segment, captured under
+// its name, and a trailing * matches the rest of the path, captured as splat.
+type sitePattern struct {
+	segments []string
+	splat    bool
+}
+
+func parseSitePattern(s string) (sitePattern, error) {
+	if !strings.HasPrefix(s, "/") {
+		return sitePattern{}, fmt.Errorf("path %q does not start with /", s)
+	}
+	var p sitePattern
+	s = strings.Trim(s, "/")
+	if s == "*" || strings.HasSuffix(s, "/*") {
+		p.splat = true
+		s = strings.TrimSuffix(strings.TrimSuffix(s, "*"), "/")
+	}
+	if s != "" {
+		p.segments = strings.Split(s, "/")
+	}
+	for _, seg := range p.segments {
+		if strings.Contains(seg, "*") {
+			return sitePattern{}, fmt.Errorf("path %q has a * before its end", s)
+		}
+	}
+	return p, nil
+}
+
+// match returns the values captured by the pattern in urlPath, and whether it
+// matched. Trailing slashes are ignored.
+func (p sitePattern) match(urlPath string) (map[string]string, bool) {
+	var segs []string
+	if s := strings.Trim(urlPath, "/"); s != "" {
+		segs = strings.Split(s, "/")
+	}
+	if len(segs) < len(p.segments) || len(segs) > len(p.segments) && !p.splat {
+		return nil, false
+	}
+
+	values := make(map[string]string)
+	for i, seg := range p.segments {
+		if strings.HasPrefix(seg, ":") {
+			values[seg[1:]] = segs[i]
+		} else if seg != segs[i] {
+			return nil, false
+		}
+	}
+	if p.splat {
+		values["splat"] = strings.Join(segs[len(p.segments):], "/")
+	}
+	return values, true
+}
+
+var placeholderRegexp = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// expand replaces the placeholders of the values captured by a pattern in s.
+func expand(s string, values map[string]string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(s, func(placeholder string) string {
+		if v, ok := values[placeholder[1:]]; ok {
+			return v
+		}
+		return placeholder
+	})
+}
+
+// redirectRule is a line of a _redirects file: "from to [status]".
+type redirectRule struct {
+	from sitePattern
+	to   string
+	// status is 200 to serve the content of to in place of the missing
+	// path, 404 or 410 to serve it as an error page, or the status of the
+	// redirect to to.
+	status int
+}
+
+func parseRedirects(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected 'from to [status]'", redirectsFile, line)
+		}
+		from, err := parseSitePattern(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", redirectsFile, line, err)
+		}
+		rule := redirectRule{from: from, to: fields[1], status: http.StatusMovedPermanently}
+		if len(fields) == 3 {
+			if rule.status, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid status %q", redirectsFile, line, fields[2])
+			}
+		}
+		switch rule.status {
+		case http.StatusOK, http.StatusNotFound, http.StatusGone:
+			if !strings.HasPrefix(rule.to, "/") {
+				return nil, fmt.Errorf("%s:%d: the content served with status %d must be a path of the website", redirectsFile, line, rule.status)
+			}
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("%s:%d: unsupported status %d", redirectsFile, line, rule.status)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, s.Err()
+}
+
+// headerRule is a path of a _headers file, followed by the headers of its
+// responses on indented lines.
+type headerRule struct {
+	path   sitePattern
+	header http.Header
+}
+
+// reservedSiteHeaders are the headers websites cannot set, as the gateway
+// relies on them to serve the responses.
+var reservedSiteHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Transfer-Encoding": true,
+}
+
+func parseHeaders(r io.Reader) ([]headerRule, error) {
+	var rules []headerRule
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimRightFunc(s.Text(), unicode.IsSpace)
+		trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if trimmed == text {
+			p, err := parseSitePattern(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", headersFile, line, err)
+			}
+			rules = append(rules, headerRule{path: p, header: make(http.Header)})
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("%s:%d: header before the first path", headersFile, line)
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected 'Name: value'", headersFile, line)
+		}
+		name := http.CanonicalHeaderKey(strings.TrimSpace(trimmed[:colon]))
+		if reservedSiteHeaders[name] {
+			return nil, fmt.Errorf("%s:%d: the %s header cannot be set", headersFile, line, name)
+		}
+		rules[len(rules)-1].header.Add(name, strings.TrimSpace(trimmed[colon+1:]))
+	}
+	return rules, s.Err()
+}
+
+// siteRoot returns the root of the website the request is for, /ipfs/{cid}
+// or /ipns/{name}, and the path of the request in it. Only the websites on
+// their own origin, served from subdomain and DNSLink gateways, are
+// configured by their files.
+func siteRoot(r *http.Request) (root string, sitePath string, ok bool) {
+	if _, ok := r.Context().Value("gw-hostname").(string); !ok {
+		return "", "", false
+	}
+	segs := strings.SplitN(r.URL.Path, "/", 4)
+	if len(segs) < 3 {
+		return "", "", false
+	}
+	sitePath = "/"
+	if len(segs) == 4 {
+		sitePath += segs[3]
+	}
+	return "/" + segs[1] + "/" + segs[2], sitePath, true
+}
+
+// siteFiles are the rules of the _redirects and _headers files of a website.
+type siteFiles struct {
+	redirects []redirectRule
+	headers   []headerRule
+}
+
+// loadSiteFiles reads and parses the files of the website at root. The files
+// that cannot be parsed are ignored.
+func loadSiteFiles(ctx context.Context, api coreiface.CoreAPI, root ipath.Resolved) (*siteFiles, error) {
+	site := new(siteFiles)
+	data, err := readSiteFile(ctx, api, root, redirectsFile)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if site.redirects, err = parseRedirects(bytes.NewReader(data)); err != nil {
+			log.Debugf("ignoring the %s of %s: %s", redirectsFile, root, err)
+		}
+	}
+	data, err = readSiteFile(ctx, api, root, headersFile)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if site.headers, err = parseHeaders(bytes.NewReader(data)); err != nil {
+			log.Debugf("ignoring the %s of %s: %s", headersFile, root, err)
+		}
+	}
+	return site, nil
+}
+
+// readSiteFile returns the content of the file of the website at root with
+// the given name, or nil if there is none.
+func readSiteFile(ctx context.Context, api coreiface.CoreAPI, root ipath.Resolved, name string) ([]byte, error) {
+	nd, err := api.Unixfs().Get(ctx, ipath.Join(root, name))
+	if _, ok := err.(resolver.ErrNoLink); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer nd.Close()
+	f, ok := nd.(files.File)
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxSiteFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSiteFileSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxSiteFileSize)
+	}
+	return data, nil
+}
+
+// withSiteHeaders returns a response writer setting the headers of the
+// _headers file of the website of the request on the response.
+func (i *gatewayHandler) withSiteHeaders(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	root, sitePath, ok := siteRoot(r)
+	if !ok {
+		return w
+	}
+	site, err := i.cache.site(r.Context(), root)
+	if err != nil {
+		log.Debugf("ignoring the %s of %s: %s", headersFile, root, err)
+		return w
+	}
+
+	header := make(http.Header)
+	for _, rule := range site.headers {
+		if _, ok := rule.path.match(sitePath); ok {
+			// the last rule matching the path wins
+			for k, v := range rule.header {
+				header[k] = v
+			}
+		}
+	}
+	if len(header) == 0 {
+		return w
+	}
+	return &siteHeadersWriter{ResponseWriter: w, header: header}
+}
+
+// siteHeadersWriter sets the headers of a website on its responses, over
+// those of the gateway.
+type siteHeadersWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (sw *siteHeadersWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.wroteHeader = true
+		for k, v := range sw.header {
+			sw.ResponseWriter.Header()[k] = v
+		}
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *siteHeadersWriter) Write(p []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush lets the gateway stream its responses.
+func (sw *siteHeadersWriter) Flush() {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// rewrittenKey marks the requests rewritten by a _redirects rule, which are
+// not rewritten again.
+type rewrittenKey struct{}
+
+// serveRedirectsIfPresent serves the request for a missing path of a website
+// according to the first matching rule of its _redirects file, if any.
+func (i *gatewayHandler) serveRedirectsIfPresent(w http.ResponseWriter, r *http.Request) bool {
+	root, sitePath, ok := siteRoot(r)
+	if !ok || r.Context().Value(rewrittenKey{}) != nil {
+		return false
+	}
+	site, err := i.cache.site(r.Context(), root)
+	if err != nil {
+		log.Debugf("ignoring the %s of %s: %s", redirectsFile, root, err)
+		return false
+	}
+
+	for _, rule := range site.redirects {
+		values, ok := rule.from.match(sitePath)
+		if !ok {
+			continue
+		}
+		to := expand(rule.to, values)
+
+		switch rule.status {
+		case http.StatusOK:
+			// serve the content of to as if it was requested, the site
+			// headers of the request being already set
+			rewritten := r.WithContext(context.WithValue(r.Context(), rewrittenKey{}, true))
+			u := *r.URL
+			u.Path = root + to
+			rewritten.URL = &u
+			i.getOrHeadHandler(w, rewritten)
+			return true
+		case http.StatusNotFound, http.StatusGone:
+			if !i.serveStatusFile(w, r, ipath.New(root+to), "", rule.status) {
+				// the error page is missing, keep looking for another
+				continue
+			}
+			return true
+		default:
+			if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, to, rule.status)
+			return true
+		}
+	}
+	return false
+}
+
+// serveStatusFile serves the file at p as the body of a response with the
+// given status, with the content type guessed from its name if ctype is empty.
+// It returns false if there is no such file.
+func (i *gatewayHandler) serveStatusFile(w http.ResponseWriter, r *http.Request, p ipath.Path, ctype string, status int) bool {
+	dr, err := i.api.Unixfs().Get(r.Context(), p)
+	if err != nil {
+		return false
+	}
+	defer dr.Close()
+
+	f, ok := dr.(files.File)
+	if !ok {
+		return false
+	}
+
+	size, err := f.Size()
+	if err != nil {
+		return false
+	}
+
+	if ctype == "" {
+		ctype = mime.TypeByExtension(gopath.Ext(p.String()))
+		if ctype == "" {
+			ctype = "text/html"
+		}
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return true
+	}
+	_, err = io.CopyN(w, f, size)
+	return err == nil
+}
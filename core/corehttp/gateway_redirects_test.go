@@ -0,0 +1,182 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	path "github.com/ipfs/go-path"
+	testutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseRedirects(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		err  string
+	}{
+		{"/a /b", ""},
+		{"/a/:id/* /b/:id/:splat 302", ""},
+		{"/* /index.html 200", ""},
+		{"# comment", ""},
+		{"/a", "expected 'from to [status]'"},
+		{"a /b", "does not start with /"},
+		{"/a/*/b /b", "has a * before its end"},
+		{"/a /b 500", "unsupported status 500"},
+		{"/a https://example.net 200", "must be a path of the website"},
+	} {
+		_, err := parseRedirects(strings.NewReader(test.line))
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("parsing %q: expected error %q, got %v", test.line, test.err, err)
+		}
+	}
+
+	rules, err := parseRedirects(strings.NewReader("/posts/:year/* /blog/:year/:splat/:other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for urlPath, expected := range map[string]string{
+		"/posts/2020/a/b": "/blog/2020/a/b/:other",
+		"/posts/2020/":    "/blog/2020//:other",
+		"/posts":          "",
+		"/other/2020/a":   "",
+	} {
+		to := ""
+		if values, ok := rules[0].from.match(urlPath); ok {
+			to = expand(rules[0].to, values)
+		}
+		if to != expected {
+			t.Errorf("expected %s to be redirected to %q, got %q", urlPath, expected, to)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	rules, err := parseHeaders(strings.NewReader(`
+# all the pages
+/*
+  X-Frame-Options: DENY
+  Link: </a.css>; rel=preload
+  Link: </b.css>; rel=preload
+
+/static/*
+  Cache-Control: max-age=3600
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || len(rules[0].header["Link"]) != 2 || rules[1].header.Get("Cache-Control") != "max-age=3600" {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+
+	for _, test := range []struct {
+		file string
+		err  string
+	}{
+		{"  X-A: b", "header before the first path"},
+		{"/a\n  X-A", "expected 'Name: value'"},
+		{"/a\n  content-length: 1", "the Content-Length header cannot be set"},
+	} {
+		if _, err := parseHeaders(strings.NewReader(test.file)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parsing %q: expected error %q, got %v", test.file, test.err, err)
+		}
+	}
+}
+
+func TestGatewayRedirects(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+
+	site := files.NewMapDirectory(map[string]files.Node{
+		"index.html": files.NewBytesFile([]byte("home")),
+		"404.html":   files.NewBytesFile([]byte("not here")),
+		"new": files.NewMapDirectory(map[string]files.Node{
+			"page.html": files.NewBytesFile([]byte("page")),
+		}),
+		"_redirects": files.NewBytesFile([]byte(`
+/old/:name  /new/:name  302
+/blog/*     https://blog.example.net/:splat
+/app/*      /index.html 200
+/gone       /404.html   410
+/*          /404.html   404
+`)),
+		"_headers": files.NewBytesFile([]byte(`
+/*
+  X-Frame-Options: DENY
+/new/*
+  Cache-Control: no-store
+`)),
+	})
+	k, err := api.Unixfs().Add(ctx, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(k.String())
+
+	misses := testutil.ToFloat64(gatewayCacheMisses.WithLabelValues("sites"))
+	for _, test := range []struct {
+		host     string
+		path     string
+		status   int
+		body     string
+		location string
+		header   http.Header
+	}{
+		{"example.net", "/old/page.html", http.StatusFound, "", "/new/page.html", nil},
+		{"example.net", "/blog/2020/post?lang=en", http.StatusMovedPermanently, "", "https://blog.example.net/2020/post?lang=en", nil},
+		{"example.net", "/app/settings", http.StatusOK, "home", "", http.Header{"X-Frame-Options": {"DENY"}}},
+		{"example.net", "/gone", http.StatusGone, "not here", "", nil},
+		{"example.net", "/missing", http.StatusNotFound, "not here", "", http.Header{"X-Frame-Options": {"DENY"}}},
+		{"example.net", "/new/page.html", http.StatusOK, "page", "", http.Header{"Cache-Control": {"no-store"}, "X-Frame-Options": {"DENY"}}},
+		// websites are only configured on their own origin
+		{"127.0.0.1:8080", k.String() + "/old/page.html", http.StatusNotFound, "", "", http.Header{"X-Frame-Options": nil}},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = test.host
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the body of redirects is closed already
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("%s%s: expected status %d, got %d", test.host, test.path, test.status, res.StatusCode)
+		}
+		if test.body != "" && string(body) != test.body {
+			t.Errorf("%s%s: expected body %q, got %q", test.host, test.path, test.body, body)
+		}
+		if loc := res.Header.Get("Location"); loc != test.location {
+			t.Errorf("%s%s: expected location %q, got %q", test.host, test.path, test.location, loc)
+		}
+		for k, v := range test.header {
+			if strings.Join(res.Header.Values(k), ",") != strings.Join(v, ",") {
+				t.Errorf("%s%s: expected header %s to be %v, got %v", test.host, test.path, k, v, res.Header.Values(k))
+			}
+		}
+	}
+
+	// the files of the website are parsed once
+	if m := testutil.ToFloat64(gatewayCacheMisses.WithLabelValues("sites")) - misses; m != 1 {
+		t.Errorf("expected the files of the website to be read once, got %v times", m)
+	}
+}
+
+func TestSiteHeadersWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = &siteHeadersWriter{ResponseWriter: rec, header: http.Header{"X-Frame-Options": {"DENY"}}}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("expected the writer to be a flusher")
+	}
+	f.Flush()
+	if !rec.Flushed || rec.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatalf("expected the response to be flushed with the headers of the website, got %v", rec.Header())
+	}
+}
@@ -1,8 +1,11 @@
 package corehttp
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -17,6 +20,7 @@ import (
 	repo "github.com/ipfs/go-ipfs/repo"
 	namesys "github.com/ipfs/go-namesys"
 
+	cid "github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
 	syncds "github.com/ipfs/go-datastore/sync"
 	config "github.com/ipfs/go-ipfs-config"
@@ -25,6 +29,7 @@ import (
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	nsopts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	car "github.com/ipld/go-car"
 	ci "github.com/libp2p/go-libp2p-core/crypto"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
 )
@@ -125,7 +130,11 @@ func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, iface
 	if err != nil {
 		t.Fatal(err)
 	}
+	ts, api := newTestServer(t, n)
+	return ts, api, n.Context()
+}
 
+func newTestServer(t *testing.T, n *core.IpfsNode) (*httptest.Server, iface.CoreAPI) {
 	cfg, err := n.Repo.Config()
 	if err != nil {
 		t.Fatal(err)
@@ -153,7 +162,7 @@ func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, iface
 		t.Fatal(err)
 	}
 
-	return ts, api, n.Context()
+	return ts, api
 }
 
 func matchPathOrBreadcrumbs(s string, expected string) bool {
@@ -740,3 +749,234 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("response doesn't contain protocol version:\n%s", s)
 	}
 }
+
+func TestGatewayArchive(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("aaa")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("bbbb")),
+		}),
+	})
+	k, err := api.Unixfs().Add(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(p string, header http.Header) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	res, archive := get(k.String()+"?format=tar", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("expected a tar archive, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	etag := `"` + k.Cid().String() + `.tar"`
+	if res.Header.Get("Etag") != etag {
+		t.Fatalf("expected etag %s, got %s", etag, res.Header.Get("Etag"))
+	}
+	if d := res.Header.Get("Content-Disposition"); !strings.HasPrefix(d, `attachment; filename="`+k.Cid().String()+`.tar"`) {
+		t.Fatalf("expected the archive to be named after the CID, got %s", d)
+	}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+	}
+	root := k.Cid().String()
+	if strings.Join(names, ",") != root+","+root+"/a.txt,"+root+"/sub,"+root+"/sub/b.txt" {
+		t.Fatalf("unexpected entries %v", names)
+	}
+
+	// the archive is the same every time, so it can be served in ranges
+	res, part := get(k.String()+"?format=tar", http.Header{"Range": {"bytes=1000-1599"}})
+	if res.StatusCode != http.StatusPartialContent || !bytes.Equal(part, archive[1000:1600]) {
+		t.Fatalf("expected bytes 1000-1599 of the archive, got %d with %d bytes", res.StatusCode, len(part))
+	}
+	res, part = get(k.String()+"?format=tar", http.Header{"Range": {"bytes=-700"}})
+	if res.StatusCode != http.StatusPartialContent || !bytes.Equal(part, archive[len(archive)-700:]) {
+		t.Fatalf("expected the last 700 bytes of the archive, got %d with %d bytes", res.StatusCode, len(part))
+	}
+	res, _ = get(k.String()+"?format=tar", http.Header{"If-None-Match": {etag}})
+	if res.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected %d, got %d", http.StatusNotModified, res.StatusCode)
+	}
+
+	res, archive = get(k.String()+"/sub?format=car&filename=x.car", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/vnd.ipld.car; version=1" {
+		t.Fatalf("expected a CAR archive, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if d := res.Header.Get("Content-Disposition"); !strings.HasPrefix(d, `attachment; filename="x.car"`) {
+		t.Fatalf("expected the archive to be named x.car, got %s", d)
+	}
+	sub, err := api.ResolvePath(ctx, ipath.Join(k, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := api.ResolvePath(ctx, ipath.Join(k, "sub/b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the CAR archive is rooted at the root of the path, with the blocks
+	// resolving the path before the DAG of the path
+	cr, err := car.NewCarReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Header.Roots) != 1 || !cr.Header.Roots[0].Equals(k.Cid()) {
+		t.Fatalf("expected the root of the CAR archive to be %s, got %v", k.Cid(), cr.Header.Roots)
+	}
+	var blocks []string
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, blk.Cid().String())
+	}
+	if strings.Join(blocks, ",") != k.Cid().String()+","+sub.Cid().String()+","+b.Cid().String() {
+		t.Fatalf("expected the blocks of the root, sub and sub/b.txt, got %v", blocks)
+	}
+
+	// the format can also be requested with the Accept header
+	res, block := get(b.String(), http.Header{"Accept": {"application/vnd.ipld.raw"}})
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/vnd.ipld.raw" || res.Header.Get("Etag") != `"`+b.Cid().String()+`.raw"` {
+		t.Fatalf("expected a raw block, got %d %s %s", res.StatusCode, res.Header.Get("Content-Type"), res.Header.Get("Etag"))
+	}
+	if c, err := b.Cid().Prefix().Sum(block); err != nil || !c.Equals(b.Cid()) {
+		t.Fatalf("expected the block of %s, got %s, %v", b.Cid(), c, err)
+	}
+	res, _ = get(k.String()+"/sub", http.Header{"Accept": {"text/html, application/vnd.ipld.car;version=1"}})
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/vnd.ipld.car; version=1" || res.Header.Get("Vary") != "Accept" {
+		t.Fatalf("expected a CAR archive varying with Accept, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if res, _ := get(k.String()+"/sub", http.Header{"If-None-Match": {res.Header.Get("Etag")}, "Accept": {"application/vnd.ipld.car"}}); res.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected %d, got %d", http.StatusNotModified, res.StatusCode)
+	}
+
+	if res, _ := get(k.String()+"?format=zip", nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected %d for an unknown format, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestGatewayDenylist(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, api := newTestServer(t, n)
+	ctx := n.Context()
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"ok.txt":      files.NewBytesFile([]byte("ok")),
+		"blocked.txt": files.NewBytesFile([]byte("blocked")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"a.txt": files.NewBytesFile([]byte("a")),
+		}),
+		"private": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("b")),
+		}),
+	})
+	k, err := api.Unixfs().Add(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked, err := api.ResolvePath(ctx, ipath.Join(k, "blocked.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := api.ResolvePath(ctx, ipath.Join(k, "private"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(k.String())
+	ns["/ipns/blocked.example.net"] = path.FromString(k.String() + "/ok.txt")
+
+	if _, err := n.Denylist.Add([]string{
+		blocked.Cid().String(),
+		private.Cid().String(),
+		k.String() + "/sub",
+		"/ipns/blocked.example.net",
+	}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{k.String() + "/ok.txt", http.StatusOK},
+		// the content is blocked whatever the path to it
+		{k.String() + "/blocked.txt", http.StatusGone},
+		{"/ipfs/" + blocked.Cid().String(), http.StatusGone},
+		{"/ipfs/" + cid.NewCidV1(cid.Raw, blocked.Cid().Hash()).String(), http.StatusGone},
+		// and so are the paths going through it
+		{k.String() + "/private/b.txt", http.StatusGone},
+		{"/ipns/example.net/blocked.txt", http.StatusGone},
+		{k.String() + "/sub/a.txt", http.StatusGone},
+		{"/ipns/blocked.example.net", http.StatusGone},
+		{"/ipns/example.net/ok.txt", http.StatusOK},
+		// archives of a parent stop at the blocked content
+		{k.String() + "?format=tar", http.StatusGone},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(test.path, "format=tar") {
+			req.Header.Set("Range", "bytes=0-10")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, res.StatusCode)
+		}
+	}
+
+	// the listings leave the blocked content out
+	res, err := http.Get(ts.URL + k.String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "ok.txt") {
+		t.Fatalf("expected the directory to be listed, got %d: %s", res.StatusCode, body)
+	}
+	if strings.Contains(string(body), "blocked.txt") || strings.Contains(string(body), "private") {
+		t.Fatalf("expected the blocked entries to be left out, got %s", body)
+	}
+}
@@ -143,8 +143,53 @@ var (
 		Name:      "unixfs_get_latency_seconds",
 		Help:      "The time till the first block is received when 'getting' a file from the gateway.",
 	}, []string{"namespace"})
+
+	gatewayCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "http",
+		Name:      "gw_cache_hits_total",
+		Help:      "Number of paths, directory listings and website rules the gateway found in its cache.",
+	}, []string{"cache"})
+
+	gatewayCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "http",
+		Name:      "gw_cache_misses_total",
+		Help:      "Number of paths, directory listings and website rules the gateway did not find in its cache.",
+	}, []string{"cache"})
+
+	rateLimitRejectedMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "http",
+		Name:      "ratelimit_rejected_total",
+		Help:      "Number of requests refused for being over the rate or concurrency limit of their client.",
+	}, []string{"handler", "limit"})
+
+	rateLimitThrottledMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "http",
+		Name:      "ratelimit_throttled_seconds_total",
+		Help:      "Time the large responses were delayed to cap the bandwidth of their client.",
+	}, []string{"handler"})
+
+	rateLimitClientsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ipfs",
+		Subsystem: "http",
+		Name:      "ratelimit_clients",
+		Help:      "Number of clients whose requests are being limited.",
+	}, []string{"handler"})
 )
 
+func init() {
+	prometheus.MustRegister(
+		gatewayCacheHits,
+		gatewayCacheMisses,
+		rateLimitRejectedMetric,
+		rateLimitThrottledMetric,
+		rateLimitClientsMetric,
+	)
+}
+
 type IpfsNodeCollector struct {
 	Node *core.IpfsNode
 }
@@ -0,0 +1,344 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+
+	config "github.com/ipfs/go-ipfs-config"
+)
+
+const (
+	// defaultLargeResponseBytes is used when the LargeResponseBytes of the
+	// limits is not set.
+	defaultLargeResponseBytes = 1 << 20
+
+	// throttleChunk is how much of a response is written at once when its
+	// bandwidth is capped.
+	throttleChunk = 32 << 10
+
+	// clientIdleTimeout is how long the clients with no request are kept,
+	// at least, once their budgets are full again.
+	clientIdleTimeout = time.Minute
+)
+
+// RateLimitOption limits the requests of each client of the handlers added
+// after it, with the limits of API.RateLimit for the API, wherever it is
+// mounted, and of Gateway.RateLimit for the rest. The API and the gateway have
+// separate budgets. The requests over the
+// limits are answered with 429 Too Many Requests.
+//
+// Clients are identified by their IP address, their /64 prefix for IPv6, or
+// by the X-Forwarded-For header of the trusted proxies.
+func RateLimitOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		apiLimiter, err := newRateLimiter("api", "API.RateLimit", cfg.API.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		gatewayLimiter, err := newRateLimiter("gateway", "Gateway.RateLimit", cfg.Gateway.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		childMux := http.NewServeMux()
+		mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := gatewayLimiter
+			if strings.HasPrefix(r.URL.Path, APIPath+"/") {
+				l = apiLimiter
+			}
+			l.serve(childMux, w, r)
+		}))
+		return childMux, nil
+	}
+}
+
+// rateLimiter applies the limits of the config to the clients of a handler. The requests
+// are not limited by a nil rateLimiter.
+type rateLimiter struct {
+	name    string
+	limits  config.RateLimits
+	trusted []*net.IPNet
+
+	mu        sync.Mutex
+	clients   map[string]*rateLimitedClient
+	lastSweep time.Time
+}
+
+type rateLimitedClient struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+	inFlight int
+	lastSeen time.Time
+}
+
+// newRateLimiter returns the limiter of the limits set under the config key,
+// named name in the metrics, or nil if they are not set.
+func newRateLimiter(name, key string, set *config.RateLimits) (*rateLimiter, error) {
+	if set == nil {
+		return nil, nil // not set
+	}
+
+	limits := *set
+	if limits.RequestsPerSecond < 0 || limits.Burst < 0 || limits.MaxConcurrent < 0 || limits.BytesPerSecond < 0 || limits.LargeResponseBytes < 0 {
+		return nil, fmt.Errorf("invalid %s: the limits cannot be negative", key)
+	}
+	if limits.RequestsPerSecond == 0 && limits.MaxConcurrent == 0 && limits.BytesPerSecond == 0 {
+		return nil, nil
+	}
+	if limits.Burst == 0 {
+		limits.Burst = int(math.Max(1, math.Ceil(limits.RequestsPerSecond)))
+	}
+	if limits.LargeResponseBytes == 0 {
+		limits.LargeResponseBytes = defaultLargeResponseBytes
+	}
+
+	l := &rateLimiter{
+		name:    name,
+		limits:  limits,
+		clients: make(map[string]*rateLimitedClient),
+	}
+	for _, p := range limits.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", key, err)
+		}
+		l.trusted = append(l.trusted, ipnet)
+	}
+	return l, nil
+}
+
+func (l *rateLimiter) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if l == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	c, retry, reason := l.acquire(l.clientKey(r))
+	if c == nil {
+		rateLimitRejectedMetric.WithLabelValues(l.name, reason).Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retry.Seconds())))))
+		http.Error(w, fmt.Sprintf("too many requests: over the %s limit", reason), http.StatusTooManyRequests)
+		return
+	}
+	defer l.release(c)
+
+	if c.bytes != nil {
+		w = &throttledResponseWriter{
+			ResponseWriter: w,
+			limiter:        l,
+			client:         c,
+			ctx:            r.Context(),
+		}
+	}
+	next.ServeHTTP(w, r)
+}
+
+// acquire returns the client with the key, counting its new request, or
+// nil, how long to wait, and the limit it is over.
+func (l *rateLimiter) acquire(key string) (*rateLimitedClient, time.Duration, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateLimitedClient{}
+		if l.limits.RequestsPerSecond > 0 {
+			c.requests = newTokenBucket(now, l.limits.RequestsPerSecond, float64(l.limits.Burst))
+		}
+		if l.limits.BytesPerSecond > 0 {
+			// a second of bandwidth can be used at once
+			c.bytes = newTokenBucket(now, float64(l.limits.BytesPerSecond), float64(l.limits.BytesPerSecond))
+		}
+		l.clients[key] = c
+		rateLimitClientsMetric.WithLabelValues(l.name).Set(float64(len(l.clients)))
+	}
+	c.lastSeen = now
+
+	if l.limits.MaxConcurrent > 0 && c.inFlight >= l.limits.MaxConcurrent {
+		return nil, time.Second, "concurrency"
+	}
+	if c.requests != nil {
+		if wait := c.requests.take(now, 1); wait > 0 {
+			// the request is refused, and its token given back
+			c.requests.tokens++
+			return nil, wait, "rate"
+		}
+	}
+	c.inFlight++
+	return c, 0, ""
+}
+
+func (l *rateLimiter) release(c *rateLimitedClient) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c.inFlight--
+	c.lastSeen = time.Now()
+}
+
+// sweep forgets the idle clients whose budgets are full again, which are
+// the same as new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < clientIdleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for key, c := range l.clients {
+		if c.inFlight == 0 && now.Sub(c.lastSeen) >= clientIdleTimeout && c.requests.full(now) && c.bytes.full(now) {
+			delete(l.clients, key)
+		}
+	}
+	rateLimitClientsMetric.WithLabelValues(l.name).Set(float64(len(l.clients)))
+}
+
+// throttle reserves n bytes of the bandwidth of c, and returns how long to
+// wait before writing them.
+func (l *rateLimiter) throttle(c *rateLimitedClient, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return c.bytes.take(time.Now(), float64(n))
+}
+
+// clientKey returns the address identifying the client of r.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	// each proxy appends the address of its own client, the last one that
+	// is not a trusted proxy being the client
+	if l.isTrusted(ip) {
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			fip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+			if fip == nil {
+				break
+			}
+			ip = fip
+			if !l.isTrusted(ip) {
+				break
+			}
+		}
+	}
+
+	// the clients with IPv6 addresses usually have a whole /64
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+func (l *rateLimiter) isTrusted(ip net.IP) bool {
+	for _, ipnet := range l.trusted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// throttledResponseWriter caps the bandwidth of a client once the response
+// is larger than the LargeResponseBytes of the limits.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	limiter *rateLimiter
+	client  *rateLimitedClient
+	ctx     context.Context
+	written int64
+}
+
+func (tw *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		if over := tw.written + int64(len(chunk)) - tw.limiter.limits.LargeResponseBytes; over > 0 {
+			if over > int64(len(chunk)) {
+				over = int64(len(chunk))
+			}
+			if wait := tw.limiter.throttle(tw.client, int(over)); wait > 0 {
+				rateLimitThrottledMetric.WithLabelValues(tw.limiter.name).Add(wait.Seconds())
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-tw.ctx.Done():
+					t.Stop()
+					return written, tw.ctx.Err()
+				}
+			}
+		}
+
+		n, err := tw.ResponseWriter.Write(chunk)
+		written += n
+		tw.written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush lets the API stream its responses.
+func (tw *throttledResponseWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// tokenBucket holds up to burst tokens, added at rate tokens per second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(now time.Time, rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take takes n tokens, which can be taken in advance, and returns how long
+// until there were enough of them.
+func (b *tokenBucket) take(now time.Time, n float64) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket is full, which a nil bucket always is.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b == nil || b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
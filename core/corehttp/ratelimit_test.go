@@ -0,0 +1,151 @@
+package corehttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	config "github.com/ipfs/go-ipfs-config"
+	testutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newRateLimitedHandler(t *testing.T, gateway, api *config.RateLimits, handler http.HandlerFunc) http.Handler {
+	r := &repo.Mock{}
+	r.C.Gateway.RateLimit = gateway
+	r.C.API.RateLimit = api
+	n := &core.IpfsNode{Repo: r}
+	h, err := makeHandler(n, nil, RateLimitOption(), func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.Handle("/", handler)
+		return mux, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func serveFrom(h http.Handler, path, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitRequests(t *testing.T) {
+	h := newRateLimitedHandler(t, &config.RateLimits{
+		RequestsPerSecond: 0.1,
+		Burst:             2,
+		TrustedProxies:    []string{"10.0.0.0/8"},
+	}, nil, func(w http.ResponseWriter, r *http.Request) {})
+
+	rejected := testutil.ToFloat64(rateLimitRejectedMetric.WithLabelValues("gateway", "rate"))
+	for i, test := range []struct {
+		remoteAddr   string
+		forwardedFor string
+		status       int
+	}{
+		{"192.0.2.1:1000", "", http.StatusOK},
+		{"192.0.2.1:1001", "", http.StatusOK},
+		{"192.0.2.1:1002", "", http.StatusTooManyRequests},
+		// the proxies are trusted to tell their clients apart
+		{"10.0.0.1:1000", "192.0.2.2, 10.0.0.2", http.StatusOK},
+		{"10.0.0.1:1000", "192.0.2.1", http.StatusTooManyRequests},
+		// but not the clients
+		{"192.0.2.3:1000", "192.0.2.4", http.StatusOK},
+		{"192.0.2.3:1000", "192.0.2.5", http.StatusOK},
+		{"192.0.2.3:1000", "192.0.2.6", http.StatusTooManyRequests},
+		// the clients with IPv6 addresses are limited by /64
+		{"[2001:db8::1]:1000", "", http.StatusOK},
+		{"[2001:db8::2]:1000", "", http.StatusOK},
+		{"[2001:db8::3]:1000", "", http.StatusTooManyRequests},
+		{"[2001:db8:0:1::1]:1000", "", http.StatusOK},
+	} {
+		rec := serveFrom(h, emptyDir, test.remoteAddr, test.forwardedFor)
+		if rec.Code != test.status {
+			t.Errorf("%d: expected status %d, got %d", i, test.status, rec.Code)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "10" {
+			t.Errorf("%d: expected to retry after 10s, got %q", i, rec.Header().Get("Retry-After"))
+		}
+	}
+	if testutil.ToFloat64(rateLimitRejectedMetric.WithLabelValues("gateway", "rate")) != rejected+4 {
+		t.Error("expected the rejected requests to be counted")
+	}
+
+	// the API has its own budget, unlimited here
+	for i := 0; i < 3; i++ {
+		if rec := serveFrom(h, APIPath+"/version", "192.0.2.1:1000", ""); rec.Code != http.StatusOK {
+			t.Fatalf("expected the API not to be limited, got %d", rec.Code)
+		}
+	}
+}
+
+func TestRateLimitConcurrency(t *testing.T) {
+	started, unblock := make(chan struct{}), make(chan struct{})
+	h := newRateLimitedHandler(t, nil, &config.RateLimits{MaxConcurrent: 1}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			close(started)
+			<-unblock
+		}
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- serveFrom(h, APIPath+"/cat?block=1", "192.0.2.1:1000", "").Code
+	}()
+	<-started
+	if rec := serveFrom(h, APIPath+"/version", "192.0.2.1:1001", ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the concurrent request to be refused, got %d", rec.Code)
+	}
+	if rec := serveFrom(h, APIPath+"/version", "192.0.2.2:1000", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected the requests of another client to be served, got %d", rec.Code)
+	}
+	close(unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected the first request to be served, got %d", code)
+	}
+	if rec := serveFrom(h, APIPath+"/version", "192.0.2.1:1001", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected the request to be served once the first is done, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitBandwidth(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 100<<10)
+	h := newRateLimitedHandler(t, &config.RateLimits{
+		BytesPerSecond:     200 << 10,
+		LargeResponseBytes: 50 << 10,
+	}, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+
+	// a second of bandwidth is used at once, past the first 50 KiB of each
+	// response, and 200 KiB more take a second
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		if rec := serveFrom(h, emptyDir, "192.0.2.1:1000", ""); !bytes.Equal(rec.Body.Bytes(), data) {
+			t.Fatal("expected the whole response")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Second/2 {
+		t.Fatalf("expected the responses to be throttled, took %s", elapsed)
+	}
+
+	// each client has its own bandwidth
+	start = time.Now()
+	rec := serveFrom(h, emptyDir, "192.0.2.2:1000", "")
+	body, _ := ioutil.ReadAll(rec.Body)
+	if len(body) != len(data) || time.Since(start) > time.Second/4 {
+		t.Fatalf("expected another client not to be throttled, took %s", time.Since(start))
+	}
+}
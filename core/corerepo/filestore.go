@@ -0,0 +1,455 @@
+package corerepo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+
+	"github.com/ipfs/go-cid"
+	filestore "github.com/ipfs/go-filestore"
+	files "github.com/ipfs/go-ipfs-files"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
+	dag "github.com/ipfs/go-merkledag"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Actions taken by FixFilestore.
+const (
+	// FixRemoved is reported for the entries removed from the filestore:
+	// those of files that could not be found, the corrupt ones, and those
+	// of changed files that no longer match.
+	FixRemoved = "removed"
+	// FixMoved is reported for the files that were found elsewhere and
+	// whose entries now reference their new path.
+	FixMoved = "moved"
+	// FixReAdded is reported for the changed files that were added again.
+	FixReAdded = "re-added"
+	// FixFailed is reported for the files that could not be fixed.
+	FixFailed = "failed"
+)
+
+// FilestoreFixOptions selects what FixFilestore does.
+type FilestoreFixOptions struct {
+	// Root is the directory the paths of the filestore are relative to.
+	Root string
+	// Search lists the directories looked into for the files that moved.
+	Search []string
+	// ReAdd adds the changed files again, instead of only removing their
+	// entries that no longer match.
+	ReAdd bool
+	// Pin pins the files added again.
+	Pin bool
+	// DryRun only reports what would be done.
+	DryRun bool
+	// Force removes the entries of pinned blocks too, which are otherwise
+	// kept and reported as failed.
+	Force bool
+}
+
+// FilestoreFix is an action taken by FixFilestore on the entries of a file.
+type FilestoreFix struct {
+	Action   string
+	FilePath string
+	// NewPath is the path a moved file was found at, relative to the
+	// filestore root like FilePath.
+	NewPath string `json:",omitempty"`
+	// Blocks is how many entries the action applied to.
+	Blocks int
+	// Root is the root of a file added again.
+	Root cid.Cid `json:",omitempty"`
+	// Status is the status of the entries before the action.
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// FixFilestore verifies the entries of the filestore of the node and repairs
+// the broken ones, file by file: the files that moved are looked for in
+// opts.Search, by size and then by the hashes of their blocks, and re-indexed
+// at their new path, the changed files are added again when opts.ReAdd is set,
+// and the entries that still cannot be read are removed, but for those of
+// pinned blocks unless opts.Force is set. Each action is passed to emit.
+// Entries of URLs and files that could not be read for other reasons are left
+// alone.
+func FixFilestore(ctx context.Context, n *core.IpfsNode, opts FilestoreFixOptions, emit func(*FilestoreFix) error) error {
+	fs := n.Filestore
+	if fs == nil {
+		return filestore.ErrFilestoreNotEnabled
+	}
+
+	next, err := filestore.VerifyAll(fs, true)
+	if err != nil {
+		return err
+	}
+
+	f := &filestoreFixer{ctx: ctx, n: n, fs: fs, opts: opts}
+	var group []*filestore.ListRes
+	for {
+		r := next()
+		if r == nil || len(group) > 0 && r.FilePath != group[0].FilePath {
+			if len(group) > 0 {
+				fix, err := f.fix(group)
+				if err != nil {
+					return err
+				}
+				for _, fx := range fix {
+					if err := emit(fx); err != nil {
+						return err
+					}
+				}
+			}
+			group = group[:0]
+		}
+		if r == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		group = append(group, r)
+	}
+}
+
+type filestoreFixer struct {
+	ctx  context.Context
+	n    *core.IpfsNode
+	fs   *filestore.Filestore
+	opts FilestoreFixOptions
+
+	// bySize indexes the files of opts.Search by size, once needed.
+	bySize map[int64][]string
+}
+
+// fix repairs the entries of a file, sorted by offset.
+func (f *filestoreFixer) fix(entries []*filestore.ListRes) ([]*FilestoreFix, error) {
+	path := entries[0].FilePath
+	if filestore.IsURL(path) {
+		return nil, nil
+	}
+
+	var broken []*filestore.ListRes
+	status := filestore.StatusOk
+	for _, e := range entries {
+		if e.Status == filestore.StatusOk {
+			continue
+		}
+		broken = append(broken, e)
+		if e.Status > status {
+			status = e.Status
+		}
+	}
+	if len(broken) == 0 {
+		return nil, nil
+	}
+	fix := func(action string, blocks int) *FilestoreFix {
+		return &FilestoreFix{Action: action, FilePath: path, Blocks: blocks, Status: status.String()}
+	}
+
+	switch status {
+	case filestore.StatusFileNotFound:
+		newPath, err := f.find(entries)
+		if err != nil {
+			return nil, err
+		}
+		if newPath == "" {
+			return f.remove(fix(FixRemoved, len(broken)), broken)
+		}
+		fx := fix(FixMoved, len(entries))
+		fx.NewPath = newPath
+		if rel, err := filepath.Rel(f.opts.Root, newPath); err == nil {
+			fx.NewPath = filepath.ToSlash(rel)
+		}
+		if f.opts.DryRun {
+			return []*FilestoreFix{fx}, nil
+		}
+		if err := f.move(entries, newPath); err != nil {
+			fx.Action, fx.Error = FixFailed, err.Error()
+		}
+		return []*FilestoreFix{fx}, nil
+
+	case filestore.StatusFileChanged:
+		if !f.opts.ReAdd {
+			return f.remove(fix(FixRemoved, len(broken)), broken)
+		}
+		fx := fix(FixReAdded, len(entries))
+		if f.opts.DryRun {
+			return []*FilestoreFix{fx}, nil
+		}
+		root, err := f.readd(entries)
+		if err != nil {
+			fx.Action, fx.Error = FixFailed, err.Error()
+			return []*FilestoreFix{fx}, nil
+		}
+		fx.Root = root
+
+		// the entries of the blocks that are still in the file were
+		// replaced, remove the others
+		var stale []*filestore.ListRes
+		for _, e := range broken {
+			if filestore.Verify(f.fs, e.Key).Status != filestore.StatusOk {
+				stale = append(stale, e)
+			}
+		}
+		if len(stale) == 0 {
+			return []*FilestoreFix{fx}, nil
+		}
+		removed, err := f.remove(fix(FixRemoved, len(stale)), stale)
+		return append([]*FilestoreFix{fx}, removed...), err
+
+	case filestore.StatusOtherError:
+		return f.remove(fix(FixRemoved, len(broken)), broken)
+
+	default:
+		fx := fix(FixFailed, len(broken))
+		fx.Error = broken[0].ErrorMsg
+		return []*FilestoreFix{fx}, nil
+	}
+}
+
+// remove deletes the given entries, keeping the pinned ones unless
+// opts.Force is set. The pinned entries kept are reported as failed.
+func (f *filestoreFixer) remove(fx *FilestoreFix, entries []*filestore.ListRes) ([]*FilestoreFix, error) {
+	keys := make([]cid.Cid, 0, len(entries))
+	for _, e := range entries {
+		// the entries without a key have nothing to remove them by
+		if e.Key.Defined() {
+			keys = append(keys, e.Key)
+		}
+	}
+
+	if !f.opts.DryRun {
+		// the pins cannot change until the entries are removed
+		unlocker := f.n.Blockstore.GCLock()
+		defer unlocker.Unlock()
+	}
+
+	pinned := 0
+	if !f.opts.Force && len(keys) > 0 {
+		res, err := f.n.Pinning.CheckIfPinned(f.ctx, keys...)
+		if err != nil {
+			return nil, fmt.Errorf("pin check failed: %s", err)
+		}
+		keys = keys[:0]
+		for _, r := range res {
+			if r.Pinned() {
+				pinned++
+			} else {
+				keys = append(keys, r.Key)
+			}
+		}
+	}
+
+	var fixes []*FilestoreFix
+	if len(keys) > 0 {
+		fx.Blocks = len(keys)
+		fixes = append(fixes, fx)
+	}
+	if pinned > 0 {
+		fixes = append(fixes, &FilestoreFix{
+			Action:   FixFailed,
+			FilePath: fx.FilePath,
+			Blocks:   pinned,
+			Status:   fx.Status,
+			Error:    fmt.Sprintf("%d blocks are pinned", pinned),
+		})
+	}
+	if f.opts.DryRun {
+		return fixes, nil
+	}
+	for _, k := range keys {
+		if err := f.fs.FileManager().DeleteBlock(k); err != nil {
+			return nil, err
+		}
+	}
+	return fixes, nil
+}
+
+// find returns the absolute path of a file holding the blocks of the given
+// entries, or "" if there is none in the searched directories.
+func (f *filestoreFixer) find(entries []*filestore.ListRes) (string, error) {
+	if len(f.opts.Search) == 0 {
+		return "", nil
+	}
+	if f.bySize == nil {
+		if err := f.index(); err != nil {
+			return "", err
+		}
+	}
+
+	last := entries[len(entries)-1]
+	for _, p := range f.bySize[int64(last.Offset+last.Size)] {
+		if ok, err := f.matches(p, entries); err != nil {
+			log.Warnf("filestore fix: reading %s: %s", p, err)
+		} else if ok {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// index lists the regular files of the searched directories by size, leaving
+// out those outside of the filestore root which cannot be referenced.
+func (f *filestoreFixer) index() error {
+	f.bySize = make(map[int64][]string)
+	for _, dir := range f.opts.Search {
+		err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				log.Warnf("filestore fix: %s", err)
+				return nil
+			}
+			if f.ctx.Err() != nil {
+				return f.ctx.Err()
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			if rel, err := filepath.Rel(f.opts.Root, p); err != nil || strings.HasPrefix(rel, "..") {
+				return nil
+			}
+			f.bySize[fi.Size()] = append(f.bySize[fi.Size()], p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns whether the file at path holds the blocks of the entries.
+func (f *filestoreFixer) matches(path string, entries []*filestore.ListRes) (bool, error) {
+	_, err := f.read(path, entries, nil)
+	if _, ok := err.(errMismatch); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type errMismatch struct{ c cid.Cid }
+
+func (e errMismatch) Error() string { return fmt.Sprintf("block %s does not match", e.c) }
+
+// read reads the blocks of the entries from the file at path, calling cb with
+// each.
+func (f *filestoreFixer) read(path string, entries []*filestore.ListRes, cb func(*posinfo.FilestoreNode) error) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	for i, e := range entries {
+		if !e.Key.Defined() {
+			return i, errMismatch{e.Key}
+		}
+		buf := make([]byte, e.Size)
+		if _, err := file.ReadAt(buf, int64(e.Offset)); err != nil {
+			if err == io.EOF {
+				return i, errMismatch{e.Key}
+			}
+			return i, err
+		}
+		nd, err := dag.NewRawNodeWPrefix(buf, e.Key.Prefix())
+		if err != nil {
+			return i, err
+		}
+		if !nd.Cid().Equals(e.Key) {
+			return i, errMismatch{e.Key}
+		}
+		if cb != nil {
+			err := cb(&posinfo.FilestoreNode{
+				Node:    nd,
+				PosInfo: &posinfo.PosInfo{FullPath: path, Offset: e.Offset},
+			})
+			if err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(entries), nil
+}
+
+// move makes the entries reference the file at newPath.
+func (f *filestoreFixer) move(entries []*filestore.ListRes, newPath string) error {
+	nodes := make([]*posinfo.FilestoreNode, 0, len(entries))
+	_, err := f.read(newPath, entries, func(nd *posinfo.FilestoreNode) error {
+		nodes = append(nodes, nd)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return f.fs.FileManager().PutMany(nodes)
+}
+
+// readd adds the changed file of the entries again, with the chunk size and
+// hash function of its blocks.
+func (f *filestoreFixer) readd(entries []*filestore.ListRes) (cid.Cid, error) {
+	path := filepath.Join(f.opts.Root, filepath.FromSlash(entries[0].FilePath))
+	file, err := os.Open(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return cid.Undef, err
+	}
+	rf, err := files.NewReaderPathFile(path, file, stat)
+	if err != nil {
+		file.Close()
+		return cid.Undef, err
+	}
+
+	adder, err := coreunix.NewAdder(f.ctx, f.n.Pinning, f.n.Blockstore, f.n.DAG)
+	if err != nil {
+		return cid.Undef, err
+	}
+	adder.NoCopy = true
+	adder.RawLeaves = true
+	adder.Pin = f.opts.Pin
+	adder.Chunker = inferChunker(entries)
+
+	prefix, err := dag.PrefixForCidVersion(0)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if ht := entries[0].Key.Prefix().MhType; ht != mh.SHA2_256 {
+		prefix, err = dag.PrefixForCidVersion(1)
+		if err != nil {
+			return cid.Undef, err
+		}
+		prefix.MhType = ht
+		prefix.MhLength = -1
+	}
+	adder.CidBuilder = prefix
+
+	nd, err := adder.AddAllAndPin(rf)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+// inferChunker returns the chunker of the fixed size of the blocks of the
+// entries, or the default one if their sizes vary.
+func inferChunker(entries []*filestore.ListRes) string {
+	if len(entries) < 2 {
+		return ""
+	}
+	size := entries[0].Size
+	for _, e := range entries[1 : len(entries)-1] {
+		if e.Size != size {
+			return ""
+		}
+	}
+	if entries[len(entries)-1].Size > size {
+		return ""
+	}
+	return fmt.Sprintf("size-%d", size)
+}
@@ -0,0 +1,170 @@
+package corerepo
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/repo"
+
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	filestore "github.com/ipfs/go-filestore"
+	config "github.com/ipfs/go-ipfs-config"
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+func TestFixFilestore(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "filestore-fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dstore := syncds.MutexWrap(datastore.NewMapDatastore())
+	fm := filestore.NewFileManager(dstore, root)
+	fm.AllowFiles = true
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+			},
+			Experimental: config.Experiments{FilestoreEnabled: true},
+		},
+		D: dstore,
+		F: fm,
+	}
+	n, err := core.NewNode(ctx, &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	add := func(name string, size int) {
+		data := make([]byte, size)
+		rnd.Read(data)
+		p := filepath.Join(root, name)
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rf, err := files.NewReaderPathFile(p, f, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder, err := coreunix.NewAdder(ctx, n.Pinning, n.Blockstore, n.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.NoCopy = true
+		adder.RawLeaves = true
+		adder.Chunker = "size-1000"
+		if _, err := adder.AddAllAndPin(rf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("moved", 5000)
+	add("changed", 5000)
+	add("gone", 3000)
+	add("ok", 2000)
+
+	if err := os.Mkdir(filepath.Join(root, "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "moved"), filepath.Join(root, "new", "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(root, "changed"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("changed"), 2500); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fix := func(opts FilestoreFixOptions) map[string]*FilestoreFix {
+		opts.Root = root
+		fixes := make(map[string]*FilestoreFix)
+		err := FixFilestore(ctx, n, opts, func(fx *FilestoreFix) error {
+			fixes[fx.FilePath+" "+fx.Action] = fx
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fixes
+	}
+
+	// a dry run changes nothing
+	opts := FilestoreFixOptions{Search: []string{filepath.Join(root, "new")}, ReAdd: true, Pin: true}
+	dryRun := opts
+	dryRun.DryRun = true
+	if fixes := fix(dryRun); len(fixes) != 3 {
+		t.Fatalf("expected 3 fixes, got %v", fixes)
+	}
+	if fixes := fix(dryRun); len(fixes) != 3 {
+		t.Fatalf("expected the dry run not to fix anything, got %v", fixes)
+	}
+
+	// the entries of pinned blocks are kept
+	fixes := fix(opts)
+	if fx := fixes["moved "+FixMoved]; fx == nil || fx.NewPath != "new/moved" || fx.Blocks != 5 {
+		t.Fatalf("expected moved to be found in new, got %+v", fx)
+	}
+	if fx := fixes["gone "+FixFailed]; fx == nil || fx.Blocks != 3 || fx.Status != "no-file" {
+		t.Fatalf("expected the 3 pinned blocks of gone to be kept, got %+v", fx)
+	}
+	if fx := fixes["changed "+FixReAdded]; fx == nil || !fx.Root.Defined() {
+		t.Fatalf("expected changed to be added again, got %+v", fx)
+	}
+	if fx := fixes["changed "+FixFailed]; fx == nil || fx.Blocks != 1 || fx.Status != "changed" {
+		t.Fatalf("expected the pinned changed block to be kept, got %+v", fx)
+	}
+	if len(fixes) != 4 {
+		t.Fatalf("expected 4 fixes, got %v", fixes)
+	}
+
+	opts.Force = true
+	fixes = fix(opts)
+	if fx := fixes["gone "+FixRemoved]; fx == nil || fx.Blocks != 3 || fx.Status != "no-file" {
+		t.Fatalf("expected the 3 blocks of gone to be removed, got %+v", fx)
+	}
+	if fx := fixes["changed "+FixRemoved]; fx == nil || fx.Blocks != 1 || fx.Status != "changed" {
+		t.Fatalf("expected the changed block to be removed, got %+v", fx)
+	}
+	if len(fixes) != 3 {
+		t.Fatalf("expected 3 fixes, got %v", fixes)
+	}
+
+	next, err := filestore.VerifyAll(n.Filestore, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for r := next(); r != nil; r = next() {
+		if r.Status != filestore.StatusOk {
+			t.Fatalf("expected %s to be fixed, got %s", r.FilePath, r.Status)
+		}
+		count++
+	}
+	if count != 12 {
+		t.Fatalf("expected 12 entries, got %d", count)
+	}
+}
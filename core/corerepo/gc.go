@@ -4,15 +4,21 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
 
 	"github.com/dustin/go-humanize"
+	bserv "github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	logging "github.com/ipfs/go-log"
+	dag "github.com/ipfs/go-merkledag"
 	"github.com/ipfs/go-mfs"
 )
 
@@ -20,13 +26,32 @@ var log = logging.Logger("corerepo")
 
 var ErrMaxStorageExceeded = errors.New("maximum storage limit exceeded. Try to unpin some files")
 
+// The garbage collection modes of Datastore.GCMode, selecting how periodic
+// and conditional garbage collections run.
+const (
+	// GCModeFull holds the GC lock for the whole collection. This is the
+	// default.
+	GCModeFull = "full"
+	// GCModeConcurrent marks without holding the GC lock and only takes it
+	// to sweep, see gc.Concurrent.
+	GCModeConcurrent = "concurrent"
+)
+
+// defaultStorageGCTarget is used when Datastore.StorageGCTarget, the repo size
+// the lru gc strategy evicts blocks down to as a percentage of
+// Datastore.StorageMax, is not set.
+const defaultStorageGCTarget = 80
+
 type GC struct {
-	Node       *core.IpfsNode
-	Repo       repo.Repo
-	StorageMax uint64
-	StorageGC  uint64
-	SlackGB    uint64
-	Storage    uint64
+	Node          *core.IpfsNode
+	Repo          repo.Repo
+	StorageMax    uint64
+	StorageGC     uint64
+	StorageTarget uint64
+	SlackGB       uint64
+	Storage       uint64
+	Mode          string
+	Strategy      string
 }
 
 func NewGC(n *core.IpfsNode) (*GC, error) {
@@ -65,15 +90,107 @@ func NewGC(n *core.IpfsNode) (*GC, error) {
 		slackGB = 1
 	}
 
+	mode, err := GCMode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, err := GCStrategy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	target := uint64(defaultStorageGCTarget)
+	if pct := cfg.Datastore.StorageGCTarget; pct != 0 {
+		if pct < 0 || pct >= cfg.Datastore.StorageGCWatermark {
+			return nil, fmt.Errorf("invalid Datastore.StorageGCTarget %d, must be a percentage below Datastore.StorageGCWatermark", pct)
+		}
+		target = uint64(pct)
+	} else if target >= uint64(cfg.Datastore.StorageGCWatermark) {
+		target = uint64(cfg.Datastore.StorageGCWatermark) / 2
+	}
+	storageTarget := storageMax * target / 100
+
 	return &GC{
-		Node:       n,
-		Repo:       r,
-		StorageMax: storageMax,
-		StorageGC:  storageGC,
-		SlackGB:    slackGB,
+		Node:          n,
+		Repo:          r,
+		StorageMax:    storageMax,
+		StorageGC:     storageGC,
+		StorageTarget: storageTarget,
+		SlackGB:       slackGB,
+		Mode:          mode,
+		Strategy:      strategy,
 	}, nil
 }
 
+// GCMode returns the garbage collection mode configured in the repo, falling
+// back to GCModeFull when it is not set.
+func GCMode(r repo.Repo) (string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	switch val := cfg.Datastore.GCMode; val {
+	case "", GCModeFull:
+		return GCModeFull, nil
+	case GCModeConcurrent:
+		return GCModeConcurrent, nil
+	default:
+		return "", fmt.Errorf("invalid Datastore.GCMode %q, must be %q or %q", val, GCModeFull, GCModeConcurrent)
+	}
+}
+
+// GCStrategy returns the garbage collection strategy configured in the repo,
+// falling back to node.GCStrategyAll when it is not set.
+func GCStrategy(r repo.Repo) (string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	switch val := cfg.Datastore.GCStrategy; val {
+	case "", node.GCStrategyAll:
+		return node.GCStrategyAll, nil
+	case node.GCStrategyLRU:
+		return node.GCStrategyLRU, nil
+	default:
+		return "", fmt.Errorf("invalid Datastore.GCStrategy %q, must be %q or %q", val, node.GCStrategyAll, node.GCStrategyLRU)
+	}
+}
+
+// GCOptions returns the gc options implementing the given mode on the node.
+func GCOptions(n *core.IpfsNode, mode string) ([]gc.Option, error) {
+	var opts []gc.Option
+	switch mode {
+	case "", GCModeFull:
+	case GCModeConcurrent:
+		opts = append(opts, gc.Concurrent(n.GCBarrier))
+	default:
+		return nil, fmt.Errorf("unknown gc mode %q", mode)
+	}
+
+	if n.GCIndex != nil {
+		opts = append(opts, gc.WithIndex(n.GCIndex))
+	}
+	return opts, nil
+}
+
+// ErrGCIndexDisabled is returned when rebuilding the gc index while it is not
+// enabled.
+var ErrGCIndexDisabled = errors.New("gc index is not enabled, set Datastore.GCIndex to true")
+
+// RebuildGCIndex recomputes the reference count index of the pinned blocks
+// from the pins. It holds the GC lock while doing so.
+func RebuildGCIndex(ctx context.Context, n *core.IpfsNode) error {
+	if n.GCIndex == nil {
+		return ErrGCIndexDisabled
+	}
+
+	defer n.Blockstore.GCLock().Unlock()
+	return n.GCIndex.Rebuild(ctx, n.Pinning)
+}
+
 func BestEffortRoots(filesRoot *mfs.Root) ([]cid.Cid, error) {
 	rootDag, err := filesRoot.GetDirectory().GetNode()
 	if err != nil {
@@ -83,12 +200,41 @@ func BestEffortRoots(filesRoot *mfs.Root) ([]cid.Cid, error) {
 	return []cid.Cid{rootDag.Cid()}, nil
 }
 
-func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
+// bestEffortRoots returns the roots a garbage collection of the node keeps
+// when they are stored: the MFS root, and the leaves of the checkpoints of the
+// interrupted adds.
+func bestEffortRoots(ctx context.Context, n *core.IpfsNode) ([]cid.Cid, error) {
 	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+	leaves, err := coreunix.NewCheckpoints(n.Repo.Datastore()).Leaves(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return append(roots, leaves...), nil
+}
+
+// RemoveStaleCheckpoints removes the checkpoints of the adds that were not
+// resumed within coreunix.CheckpointTTL. Their leaves are not kept by garbage
+// collections anymore.
+func RemoveStaleCheckpoints(ctx context.Context, n *core.IpfsNode) error {
+	removed, err := coreunix.NewCheckpoints(n.Repo.Datastore()).RemoveStale(ctx, time.Now())
+	if removed > 0 {
+		log.Infof("removed %d stale add checkpoints", removed)
+	}
+	return err
+}
+
+func GarbageCollect(n *core.IpfsNode, ctx context.Context, opts ...gc.Option) error {
+	if err := RemoveStaleCheckpoints(ctx, n); err != nil {
+		return err
+	}
+	roots, err := bestEffortRoots(ctx, n)
 	if err != nil {
 		return err
 	}
-	rmed := gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+	rmed := gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots, opts...)
 
 	return CollectResult(ctx, rmed, nil)
 }
@@ -147,16 +293,44 @@ func (e *MultiError) Error() string {
 	return buf.String()
 }
 
-func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
-	roots, err := BestEffortRoots(n.FilesRoot)
+func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context, opts ...gc.Option) <-chan gc.Result {
+	roots, err := bestEffortRoots(ctx, n)
 	if err != nil {
-		out := make(chan gc.Result)
+		out := make(chan gc.Result, 1)
 		out <- gc.Result{Error: err}
 		close(out)
 		return out
 	}
 
-	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots, opts...)
+}
+
+// ExplainRetention returns why the given block would be kept by a garbage
+// collection of the node, see gc.Explain.
+func ExplainRetention(ctx context.Context, n *core.IpfsNode, c cid.Cid) ([]gc.Retention, error) {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	// only look at what is stored locally
+	ng := dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+	retained, err := gc.Explain(ctx, n.Pinning, ng, roots, c)
+	if err != nil {
+		return nil, err
+	}
+
+	leaves, err := coreunix.NewCheckpoints(n.Repo.Datastore()).Leaves(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range leaves {
+		if l.Equals(c) {
+			retained = append(retained, gc.Retention{Reason: gc.RetainedCheckpoint, Root: c, Path: []cid.Cid{c}})
+			break
+		}
+	}
+	return retained, nil
 }
 
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
@@ -188,6 +362,10 @@ func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
 		case <-ctx.Done():
 			return nil
 		case <-time.After(period):
+			// drop expired pins first so that their blocks are collected
+			if err := unpinExpired(ctx, node); err != nil {
+				log.Error(err)
+			}
 			// the private func maybeGC doesn't compute storageMax, storageGC, slackGC so that they are not re-computed for every cycle
 			if err := gc.maybeGC(ctx, 0); err != nil {
 				log.Error(err)
@@ -218,10 +396,27 @@ func (gc *GC) maybeGC(ctx context.Context, offset uint64) error {
 		// Do GC here
 		log.Info("Watermark exceeded. Starting repo GC...")
 
-		if err := GarbageCollect(gc.Node, ctx); err != nil {
+		opts, err := GCOptions(gc.Node, gc.Mode)
+		if err != nil {
+			return err
+		}
+		if gc.Strategy == node.GCStrategyLRU {
+			if gc.Node.GCAccessTimes == nil {
+				return errors.New("block access times are not tracked, restart the node to enable the lru gc strategy")
+			}
+			// only free what is needed to get back to the target
+			opts = append(opts, evictTo(gc.Node, storage+offset, gc.StorageTarget))
+		}
+		if err := GarbageCollect(gc.Node, ctx, opts...); err != nil {
 			return err
 		}
 		log.Infof("Repo GC done. See `ipfs repo stat` to see how much space got freed.\n")
 	}
 	return nil
 }
+
+// evictTo returns the gc option removing the least recently used blocks of
+// the node until its storage goes from size down to target.
+func evictTo(n *core.IpfsNode, size, target uint64) gc.Option {
+	return gc.Evict(n.GCAccessTimes, size-target)
+}
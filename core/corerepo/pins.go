@@ -0,0 +1,90 @@
+package corerepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+)
+
+// UnpinExpired removes the pins of the node whose expiry has passed, and
+// returns them.
+func UnpinExpired(ctx context.Context, n *core.IpfsNode) ([]cid.Cid, error) {
+	now := time.Now()
+	expired, err := n.PinMeta.Expired(ctx, now)
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+
+	defer n.Blockstore.PinLock().Unlock()
+
+	unpinned := make([]cid.Cid, 0, len(expired))
+	for _, c := range expired {
+		// the pin may have been made permanent or extended before the lock
+		m, err := n.PinMeta.Get(c)
+		if err != nil {
+			return unpinned, err
+		}
+		if m == nil || !m.Expired(now) {
+			continue
+		}
+
+		switch err := n.Pinning.Unpin(ctx, c, true); err {
+		case nil:
+			unpinned = append(unpinned, c)
+		case dspinner.ErrNotPinned:
+			// removed meanwhile, only the metadata is left
+			if err := n.PinMeta.Delete(c); err != nil {
+				return unpinned, err
+			}
+		default:
+			return unpinned, err
+		}
+	}
+
+	return unpinned, n.Pinning.Flush(ctx)
+}
+
+// PeriodicUnpinExpired removes the expired pins every Datastore.GCPeriod, for
+// nodes that don't run PeriodicGC.
+func PeriodicUnpinExpired(ctx context.Context, n *core.IpfsNode) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Datastore.GCPeriod == "" {
+		cfg.Datastore.GCPeriod = "1h"
+	}
+
+	period, err := time.ParseDuration(cfg.Datastore.GCPeriod)
+	if err != nil {
+		return err
+	}
+	if int64(period) == 0 {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(period):
+			if err := unpinExpired(ctx, n); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// unpinExpired removes the expired pins and logs them.
+func unpinExpired(ctx context.Context, n *core.IpfsNode) error {
+	unpinned, err := UnpinExpired(ctx, n)
+	for _, c := range unpinned {
+		log.Infof("unpinned expired %s", c)
+	}
+	return err
+}
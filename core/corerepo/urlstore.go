@@ -0,0 +1,441 @@
+package corerepo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+
+	"github.com/ipfs/go-cid"
+	filestore "github.com/ipfs/go-filestore"
+	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// URLVerifyOptions selects what VerifyURLs does.
+type URLVerifyOptions struct {
+	// URLs are the URLs to verify. All the URLs of the urlstore are
+	// verified when it is empty.
+	URLs []string
+	// Refresh adds the URLs whose content changed again, and removes their
+	// entries that no longer match.
+	Refresh bool
+	// Pin pins the URLs added again.
+	Pin bool
+	// Force removes the entries of pinned blocks too.
+	Force bool
+	// Client fetches the URLs, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// URLVerify is the result of the verification of the entries of a URL.
+type URLVerify struct {
+	URL string
+	// Status is the worst status of the entries.
+	Status string
+	Blocks int
+	// Broken is how many entries do not match the content of the URL.
+	Broken int
+	// Size is the length of the content reported by the server, -1 if
+	// unknown.
+	Size int64
+	// Ranges is whether the server announces support for byte ranges.
+	Ranges bool
+	// Root is the root of the URL added again, when refreshed.
+	Root cid.Cid `json:",omitempty"`
+	// Removed is how many entries were removed once refreshed.
+	Removed int `json:",omitempty"`
+	// Pinned is how many entries that no longer match were kept, their
+	// blocks being pinned.
+	Pinned int    `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// URLRemoved is the result of RemoveURLs for a URL.
+type URLRemoved struct {
+	URL string
+	// Blocks is how many entries were removed.
+	Blocks int
+	// Pinned is how many entries were kept, their blocks being pinned.
+	Pinned int `json:",omitempty"`
+}
+
+// VerifyURLs checks that the URLs of the urlstore of the node still serve the
+// content of their entries: the server is asked for the size of the content
+// with a HEAD request, and the blocks are then fetched with range requests
+// and hashed. The result for each URL is passed to emit.
+func VerifyURLs(ctx context.Context, n *core.IpfsNode, opts URLVerifyOptions, emit func(*URLVerify) error) error {
+	fs := n.Filestore
+	if fs == nil || !fs.FileManager().AllowUrls {
+		return filestore.ErrUrlstoreNotEnabled
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	v := &urlVerifier{ctx: ctx, n: n, fs: fs, opts: opts}
+	return forEachURL(fs, opts.URLs, func(entries []*filestore.ListRes) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		res, err := v.verify(entries)
+		if err != nil {
+			return err
+		}
+		return emit(res)
+	})
+}
+
+// RemoveURLs removes the entries of the given URLs from the urlstore of the
+// node. The entries of pinned blocks are kept, unless force is true.
+func RemoveURLs(ctx context.Context, n *core.IpfsNode, urls []string, force bool) ([]*URLRemoved, error) {
+	fs := n.Filestore
+	if fs == nil {
+		return nil, filestore.ErrUrlstoreNotEnabled
+	}
+
+	removed := make(map[string]*URLRemoved)
+	err := forEachURL(fs, urls, func(entries []*filestore.ListRes) error {
+		keys := make([]cid.Cid, len(entries))
+		for i, e := range entries {
+			keys[i] = e.Key
+		}
+		r := &URLRemoved{URL: entries[0].FilePath}
+		var err error
+		r.Blocks, r.Pinned, err = removeEntries(ctx, n, fs, keys, force)
+		removed[r.URL] = r
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*URLRemoved, len(urls))
+	for i, u := range urls {
+		res[i] = removed[u]
+		if res[i] == nil {
+			res[i] = &URLRemoved{URL: u}
+		}
+	}
+	return res, nil
+}
+
+// removeEntries removes the urlstore entries of the given blocks, keeping
+// those of the pinned blocks unless force is true. It returns how many
+// entries were removed and kept.
+func removeEntries(ctx context.Context, n *core.IpfsNode, fs *filestore.Filestore, keys []cid.Cid, force bool) (removed, pinned int, err error) {
+	// the pins cannot change until the entries are removed
+	unlocker := n.Blockstore.GCLock()
+	defer unlocker.Unlock()
+
+	if !force && len(keys) > 0 {
+		res, err := n.Pinning.CheckIfPinned(ctx, keys...)
+		if err != nil {
+			return 0, 0, fmt.Errorf("pin check failed: %s", err)
+		}
+		keys = make([]cid.Cid, 0, len(res))
+		for _, r := range res {
+			if r.Pinned() {
+				pinned++
+			} else {
+				keys = append(keys, r.Key)
+			}
+		}
+	}
+
+	for _, k := range keys {
+		if err := fs.FileManager().DeleteBlock(k); err != nil {
+			return removed, pinned, err
+		}
+		removed++
+	}
+	return removed, pinned, nil
+}
+
+// forEachURL calls f with the entries of each URL of the filestore, or only
+// of the given URLs if any, sorted by offset.
+func forEachURL(fs *filestore.Filestore, urls []string, f func([]*filestore.ListRes) error) error {
+	var only map[string]bool
+	if len(urls) > 0 {
+		only = make(map[string]bool)
+		for _, u := range urls {
+			only[u] = true
+		}
+	}
+
+	next, err := filestore.ListAll(fs, true)
+	if err != nil {
+		return err
+	}
+	var group []*filestore.ListRes
+	for {
+		r := next()
+		if r == nil || len(group) > 0 && r.FilePath != group[0].FilePath {
+			if len(group) > 0 {
+				if err := f(group); err != nil {
+					return err
+				}
+			}
+			group = nil
+		}
+		if r == nil {
+			return nil
+		}
+		if r.Key.Defined() && filestore.IsURL(r.FilePath) && (only == nil || only[r.FilePath]) {
+			group = append(group, r)
+		}
+	}
+}
+
+type urlVerifier struct {
+	ctx  context.Context
+	n    *core.IpfsNode
+	fs   *filestore.Filestore
+	opts URLVerifyOptions
+}
+
+// verify checks the entries of a URL, sorted by offset, refreshing them if
+// needed.
+func (v *urlVerifier) verify(entries []*filestore.ListRes) (*URLVerify, error) {
+	res := &URLVerify{URL: entries[0].FilePath, Blocks: len(entries), Size: -1}
+	statuses := make([]filestore.Status, len(entries))
+	setAll := func(from int, s filestore.Status, err error) {
+		for i := from; i < len(statuses); i++ {
+			statuses[i] = s
+		}
+		if err != nil && res.Error == "" {
+			res.Error = err.Error()
+		}
+	}
+
+	ignoresRanges := false
+	if err := v.head(res); err != nil {
+		setAll(0, statusOf(err), err)
+	} else {
+		ignoresRanges = v.check(entries, statuses, setAll)
+	}
+
+	status := filestore.StatusOk
+	var broken []*filestore.ListRes
+	for i, s := range statuses {
+		if s == filestore.StatusOk {
+			continue
+		}
+		broken = append(broken, entries[i])
+		if s > status {
+			status = s
+		}
+	}
+	if end := contentEnd(entries); status == filestore.StatusOk && res.Size >= 0 && uint64(res.Size) != end {
+		// the blocks are still served, but more content was appended
+		status = filestore.StatusFileChanged
+		res.Error = fmt.Sprintf("the content is %d bytes long, %d were added", res.Size, end)
+	}
+	if status == filestore.StatusOk && ignoresRanges {
+		// the content is the same, but the urlstore reads the blocks with
+		// range requests
+		status = filestore.StatusFileError
+		res.Error = "the server ignores range requests"
+	}
+	res.Status = status.String()
+	res.Broken = len(broken)
+
+	if status != filestore.StatusFileChanged || !v.opts.Refresh {
+		return res, nil
+	}
+	root, err := v.refresh(entries)
+	if err != nil {
+		res.Error = fmt.Sprintf("refreshing: %s", err)
+		return res, nil
+	}
+	res.Root = root
+
+	// the entries of the blocks still served were replaced, remove the
+	// others
+	var keys []cid.Cid
+	for _, e := range broken {
+		r := filestore.List(v.fs, e.Key)
+		if r.FilePath != e.FilePath || r.Offset != e.Offset {
+			continue
+		}
+		keys = append(keys, e.Key)
+	}
+	res.Removed, res.Pinned, err = removeEntries(v.ctx, v.n, v.fs, keys, v.opts.Force)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// head fills in the size of the content of the URL and whether the server
+// serves ranges. Servers that do not support HEAD requests are not checked.
+func (v *urlVerifier) head(res *URLVerify) error {
+	req, err := http.NewRequestWithContext(v.ctx, http.MethodHead, res.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		return nil
+	case resp.StatusCode/100 != 2:
+		return httpStatusError(resp.StatusCode)
+	}
+	res.Size = resp.ContentLength
+	res.Ranges = resp.Header.Get("Accept-Ranges") == "bytes"
+	return nil
+}
+
+// check fetches the blocks of the entries and sets their status. The blocks
+// are fetched with as few range requests as possible. It returns whether the
+// server ignored the ranges of blocks past the start of the content.
+func (v *urlVerifier) check(entries []*filestore.ListRes, statuses []filestore.Status, setAll func(int, filestore.Status, error)) (ignoresRanges bool) {
+	end := contentEnd(entries)
+	var body io.ReadCloser
+	var pos uint64
+	defer func() {
+		if body != nil {
+			body.Close()
+		}
+	}()
+
+	for i, e := range entries {
+		if body == nil || e.Offset < pos {
+			if body != nil {
+				body.Close()
+			}
+			var ranged bool
+			var err error
+			body, ranged, err = v.fetch(entries[0].FilePath, e.Offset, end)
+			if err != nil {
+				setAll(i, statusOf(err), err)
+				return
+			}
+			pos = e.Offset
+			ignoresRanges = ignoresRanges || !ranged && entries[len(entries)-1].Offset > 0
+		}
+		if e.Offset > pos {
+			if _, err := io.CopyN(ioutil.Discard, body, int64(e.Offset-pos)); err != nil {
+				setAll(i, statusOf(err), err)
+				return
+			}
+			pos = e.Offset
+		}
+
+		buf := make([]byte, e.Size)
+		if _, err := io.ReadFull(body, buf); err != nil {
+			setAll(i, statusOf(err), err)
+			return
+		}
+		pos += e.Size
+
+		c, err := e.Key.Prefix().Sum(buf)
+		if err != nil || !c.Equals(e.Key) {
+			statuses[i] = filestore.StatusFileChanged
+		}
+	}
+	return ignoresRanges
+}
+
+// fetch requests the bytes of the URL from offset from to end, returning the
+// body positioned at from, and whether the server served the range.
+func (v *urlVerifier) fetch(u string, from, end uint64) (io.ReadCloser, bool, error) {
+	req, err := http.NewRequestWithContext(v.ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, end-1))
+	resp, err := v.opts.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+	case http.StatusOK:
+		// the range was ignored, the content is read through
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, int64(from)); err != nil {
+			resp.Body.Close()
+			return nil, false, err
+		}
+		return resp.Body, false, nil
+	default:
+		resp.Body.Close()
+		return nil, false, httpStatusError(resp.StatusCode)
+	}
+}
+
+// refresh adds the URL of the entries again, with the chunk size and hash
+// function of its blocks.
+func (v *urlVerifier) refresh(entries []*filestore.ListRes) (cid.Cid, error) {
+	u, err := url.Parse(entries[0].FilePath)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	adder, err := coreunix.NewAdder(v.ctx, v.n.Pinning, v.n.Blockstore, v.n.DAG)
+	if err != nil {
+		return cid.Undef, err
+	}
+	adder.NoCopy = true
+	adder.RawLeaves = true
+	adder.Pin = v.opts.Pin
+	adder.Chunker = inferChunker(entries)
+
+	prefix, err := dag.PrefixForCidVersion(1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	prefix.MhType = entries[0].Key.Prefix().MhType
+	prefix.MhLength = -1
+	adder.CidBuilder = prefix
+
+	nd, err := adder.AddAllAndPin(files.NewWebFile(u))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+// contentEnd returns the end offset of the content covered by the entries.
+func contentEnd(entries []*filestore.ListRes) uint64 {
+	end := uint64(0)
+	for _, e := range entries {
+		if e.Offset+e.Size > end {
+			end = e.Offset + e.Size
+		}
+	}
+	return end
+}
+
+// httpStatusError is returned for the unexpected HTTP statuses.
+type httpStatusError int
+
+func (e httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d %s", int(e), http.StatusText(int(e)))
+}
+
+// statusOf returns the filestore status of the entries that could not be
+// checked because of err.
+func statusOf(err error) filestore.Status {
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, httpStatusError(http.StatusRequestedRangeNotSatisfiable):
+		// the content is shorter than it was
+		return filestore.StatusFileChanged
+	case httpStatusError(http.StatusNotFound), httpStatusError(http.StatusGone):
+		return filestore.StatusFileNotFound
+	default:
+		return filestore.StatusFileError
+	}
+}
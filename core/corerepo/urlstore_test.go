@@ -0,0 +1,170 @@
+package corerepo
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/repo"
+
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	filestore "github.com/ipfs/go-filestore"
+	config "github.com/ipfs/go-ipfs-config"
+	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestVerifyURLs(t *testing.T) {
+	ctx := context.Background()
+
+	data := make([]byte, 13500)
+	rand.New(rand.NewSource(1)).Read(data)
+	// the blocks of a and b differ, as the entries are keyed by block
+	content := map[string][]byte{"/a": data[:10000], "/b": data[10000:13000]}
+	ranges := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := content[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if !ranges {
+			w.Header().Set("Content-Length", strconv.Itoa(len(c)))
+			w.Write(c)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(c))
+	}))
+	defer srv.Close()
+
+	dstore := syncds.MutexWrap(datastore.NewMapDatastore())
+	fm := filestore.NewFileManager(dstore, "/")
+	fm.AllowUrls = true
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+			},
+			Experimental: config.Experiments{UrlstoreEnabled: true},
+		},
+		D: dstore,
+		F: fm,
+	}
+	n, err := core.NewNode(ctx, &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/a", "/b"} {
+		u, err := url.Parse(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder, err := coreunix.NewAdder(ctx, n.Pinning, n.Blockstore, n.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.NoCopy = true
+		adder.RawLeaves = true
+		adder.Chunker = "size-1000"
+		adder.CidBuilder, err = dag.PrefixForCidVersion(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := adder.AddAllAndPin(files.NewWebFile(u)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	verify := func(opts URLVerifyOptions) map[string]*URLVerify {
+		t.Helper()
+		res := make(map[string]*URLVerify)
+		err := VerifyURLs(ctx, n, opts, func(v *URLVerify) error {
+			res[v.URL[len(srv.URL):]] = v
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := verify(URLVerifyOptions{})
+	if v := res["/a"]; v == nil || v.Status != "ok" || v.Blocks != 10 || v.Size != 10000 || !v.Ranges {
+		t.Fatalf("expected the 10 blocks of a to be ok, got %+v", v)
+	}
+	if v := res["/b"]; v == nil || v.Status != "ok" || v.Blocks != 3 {
+		t.Fatalf("expected the 3 blocks of b to be ok, got %+v", v)
+	}
+
+	// the blocks cannot be read from servers that ignore ranges
+	ranges = false
+	res = verify(URLVerifyOptions{URLs: []string{srv.URL + "/a"}})
+	if v := res["/a"]; len(res) != 1 || v == nil || v.Status != "error" || v.Broken != 0 || v.Ranges {
+		t.Fatalf("expected only a to be verified, with an error, got %+v", res)
+	}
+	ranges = true
+
+	changed := append([]byte{}, data[:10000]...)
+	changed[4500]++
+	content["/a"] = changed
+	content["/b"] = data[10000:]
+	res = verify(URLVerifyOptions{})
+	if v := res["/a"]; v.Status != "changed" || v.Broken != 1 {
+		t.Fatalf("expected a block of a to have changed, got %+v", v)
+	}
+	if v := res["/b"]; v.Status != "changed" || v.Broken != 0 {
+		t.Fatalf("expected b to have grown, got %+v", v)
+	}
+
+	// the block of a that changed is still pinned by the previous content
+	res = verify(URLVerifyOptions{Refresh: true, Pin: true})
+	if v := res["/a"]; !v.Root.Defined() || v.Removed != 0 || v.Pinned != 1 {
+		t.Fatalf("expected a to be refreshed, keeping the pinned block, got %+v", v)
+	}
+	if v := res["/b"]; !v.Root.Defined() || v.Removed != 0 {
+		t.Fatalf("expected b to be refreshed, got %+v", v)
+	}
+	res = verify(URLVerifyOptions{URLs: []string{srv.URL + "/a"}, Refresh: true, Pin: true, Force: true})
+	if v := res["/a"]; !v.Root.Defined() || v.Removed != 1 || v.Pinned != 0 {
+		t.Fatalf("expected the pinned block of a to be removed when forced, got %+v", v)
+	}
+	res = verify(URLVerifyOptions{})
+	if v := res["/a"]; v.Status != "ok" || v.Blocks != 10 {
+		t.Fatalf("expected a to be ok once refreshed, got %+v", v)
+	}
+	if v := res["/b"]; v.Status != "ok" || v.Blocks != 4 {
+		t.Fatalf("expected b to be ok once refreshed, got %+v", v)
+	}
+
+	delete(content, "/b")
+	if v := verify(URLVerifyOptions{})["/b"]; v.Status != "no-file" || v.Broken != 4 {
+		t.Fatalf("expected b to be gone, got %+v", v)
+	}
+	removed, err := RemoveURLs(ctx, n, []string{srv.URL + "/b", srv.URL + "/c"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed[0].Blocks != 0 || removed[0].Pinned != 4 || removed[1].Blocks != 0 {
+		t.Fatalf("expected the 4 pinned blocks of b to be kept, got %+v %+v", removed[0], removed[1])
+	}
+	removed, err = RemoveURLs(ctx, n, []string{srv.URL + "/b"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed[0].Blocks != 4 {
+		t.Fatalf("expected the 4 blocks of b to be removed when forced, got %+v", removed[0])
+	}
+	if res := verify(URLVerifyOptions{}); len(res) != 1 {
+		t.Fatalf("expected only a to be left, got %+v", res)
+	}
+}
@@ -22,8 +22,11 @@ import (
 	"github.com/ipfs/go-unixfs/importer/balanced"
 	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
 	"github.com/ipfs/go-unixfs/importer/trickle"
+	uio "github.com/ipfs/go-unixfs/io"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
 )
 
 var log = logging.Logger("coreunix")
@@ -79,6 +82,24 @@ type Adder struct {
 	tempRoot   cid.Cid
 	CidBuilder cid.Builder
 	liveNodes  uint64
+
+	// Dedup, when set, collects how much of the added files was already
+	// present.
+	Dedup *DedupReport
+
+	// Filter, when set, selects the files of the added directories.
+	Filter *Filter
+
+	// Checkpoints, when set, records the progress of the added files, and
+	// resumes the add of those an interrupted add checkpointed.
+	Checkpoints *Checkpoints
+
+	// dirMeta is the metadata of the added directories, by path, recorded
+	// once mfs built them. dirMetaParents holds these paths and their
+	// parents, and metaDirs the resulting nodes.
+	dirMeta        map[string]unixfsmeta.Meta
+	dirMetaParents map[string]struct{}
+	metaDirs       map[string]ipld.Node
 }
 
 func (adder *Adder) mfsRoot() (*mfs.Root, error) {
@@ -100,15 +121,35 @@ func (adder *Adder) SetMfsRoot(r *mfs.Root) {
 	adder.mroot = r
 }
 
-// Constructs a node from reader's data, and adds it. Doesn't pin.
-func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
+// Constructs a node from reader's data, and adds it. Doesn't pin. The chunks
+// are counted in dedup when it is not nil. When fc is not nil, the add is
+// checkpointed, resuming from its leaves; verify is then the reader to check
+// them against, or nil if it was seeked past them.
+func (adder *Adder) add(reader io.Reader, dedup *DedupStats, fc *fileCheckpoint, verify io.Reader) (ipld.Node, error) {
 	chnk, err := chunker.FromString(reader, adder.Chunker)
 	if err != nil {
 		return nil, err
 	}
 
+	var dserv ipld.DAGService = adder.bufferedDS
+	if dedup != nil {
+		dserv = &dedupDAG{DAGService: dserv, report: adder.Dedup, stats: dedup}
+	}
+	if fc != nil {
+		dserv = &checkpointDAG{DAGService: dserv, fc: fc}
+		if len(fc.resumed) > 0 {
+			chnk = &resumeSplitter{
+				Splitter: chnk,
+				ctx:      adder.ctx,
+				dag:      adder.dagService,
+				leaves:   fc.resumed,
+				verify:   verify,
+			}
+		}
+	}
+
 	params := ihelper.DagBuilderParams{
-		Dagserv:    adder.bufferedDS,
+		Dagserv:    dserv,
 		RawLeaves:  adder.RawLeaves,
 		Maxlinks:   ihelper.DefaultLinksPerBlock,
 		NoCopy:     adder.NoCopy,
@@ -132,6 +173,17 @@ func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
 	return nd, adder.bufferedDS.Commit()
 }
 
+// commit writes the nodes added so far to the blockstore.
+func (adder *Adder) commit() error {
+	if err := adder.bufferedDS.Commit(); err != nil {
+		return err
+	}
+	if s, ok := adder.dagService.(syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
 // RootNode returns the mfs root node
 func (adder *Adder) curRootNode() (ipld.Node, error) {
 	mr, err := adder.mfsRoot()
@@ -210,6 +262,9 @@ func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 		if err != nil {
 			return err
 		}
+		if mnd, ok := adder.metaDirs[path]; ok {
+			nd = mnd
+		}
 
 		return outputDagnode(adder.Out, path, nd)
 	default:
@@ -264,6 +319,9 @@ func (adder *Adder) AddAllAndPin(file files.Node) (ipld.Node, error) {
 		}
 	}()
 
+	if d, ok := file.(files.Directory); ok && adder.Filter != nil {
+		file = adder.Filter.Apply(d, false)
+	}
 	if err := adder.addFileNode("", file, true); err != nil {
 		return nil, err
 	}
@@ -313,6 +371,12 @@ func (adder *Adder) AddAllAndPin(file files.Node) (ipld.Node, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, dir := root.(*mfs.Directory); dir {
+		nd, err = adder.setDirMeta(name, nd)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// output directory events
 	err = adder.outputDirs(name, root)
@@ -355,23 +419,39 @@ func (adder *Adder) addFileNode(path string, file files.Node, toplevel bool) err
 	}
 	adder.liveNodes++
 
+	var meta unixfsmeta.Meta
+	if mn, ok := file.(metaNode); ok {
+		if meta, err = mn.unixfsMeta(); err != nil {
+			return err
+		}
+		if _, dir := file.(files.Directory); !dir {
+			file = mn.unwrap()
+		}
+	}
+
 	switch f := file.(type) {
 	case files.Directory:
-		return adder.addDir(path, f, toplevel)
+		return adder.addDir(path, f, toplevel, meta)
 	case *files.Symlink:
-		return adder.addSymlink(path, f)
+		return adder.addSymlink(path, f, meta)
 	case files.File:
-		return adder.addFile(path, f)
+		return adder.addFile(path, f, meta)
 	default:
 		return errors.New("unknown file type")
 	}
 }
 
-func (adder *Adder) addSymlink(path string, l *files.Symlink) error {
+func (adder *Adder) addSymlink(path string, l *files.Symlink, meta unixfsmeta.Meta) error {
 	sdata, err := unixfs.SymlinkData(l.Target)
 	if err != nil {
 		return err
 	}
+	if !meta.Empty() {
+		sdata, err = unixfsmeta.Set(sdata, meta)
+		if err != nil {
+			return err
+		}
+	}
 
 	dagnode := dag.NodeWithData(sdata)
 	dagnode.SetCidBuilder(adder.CidBuilder)
@@ -383,12 +463,31 @@ func (adder *Adder) addSymlink(path string, l *files.Symlink) error {
 	return adder.addNode(dagnode, path)
 }
 
-func (adder *Adder) addFile(path string, file files.File) error {
+func (adder *Adder) addFile(path string, file files.File, meta unixfsmeta.Meta) error {
+	var fc *fileCheckpoint
+	var skipped int64
+	if adder.Checkpoints != nil {
+		var err error
+		fc, err = adder.openCheckpoint(path, file, meta)
+		if err != nil {
+			return err
+		}
+		if fc.offset > 0 {
+			// files sent by clients cannot be seeked, their data is
+			// checked against the checkpoint instead
+			if s, ok := file.(io.Seeker); ok {
+				if _, err := s.Seek(int64(fc.offset), io.SeekStart); err == nil {
+					skipped = int64(fc.offset)
+				}
+			}
+		}
+	}
+
 	// if the progress flag was specified, wrap the file so that we can send
 	// progress updates to the client (over the output channel)
 	var reader io.Reader = file
 	if adder.Progress {
-		rdr := &progressReader{file: reader, path: path, out: adder.Out}
+		rdr := &progressReader{file: reader, path: path, out: adder.Out, bytes: skipped}
 		if fi, ok := file.(files.FileInfo); ok {
 			reader = &progressReader2{rdr, fi}
 		} else {
@@ -396,18 +495,44 @@ func (adder *Adder) addFile(path string, file files.File) error {
 		}
 	}
 
-	dagnode, err := adder.add(reader)
+	var dedup *DedupStats
+	if adder.Dedup != nil {
+		dedup = new(DedupStats)
+	}
+	var verify io.Reader
+	if skipped == 0 {
+		verify = reader
+	}
+	dagnode, err := adder.add(reader, dedup, fc, verify)
 	if err != nil {
 		return err
 	}
+	if fc != nil {
+		if err := fc.done(); err != nil {
+			return err
+		}
+	}
+	if dedup != nil {
+		adder.Dedup.record(path, *dedup)
+	}
+	if !meta.Empty() {
+		dagnode, err = adder.setFileMeta(dagnode, meta)
+		if err != nil {
+			return err
+		}
+	}
 
 	// patch it into the root
 	return adder.addNode(dagnode, path)
 }
 
-func (adder *Adder) addDir(path string, dir files.Directory, toplevel bool) error {
+func (adder *Adder) addDir(path string, dir files.Directory, toplevel bool, meta unixfsmeta.Meta) error {
 	log.Infof("adding directory: %s", path)
 
+	if !meta.Empty() {
+		adder.recordDirMeta(path, meta)
+	}
+
 	if !(toplevel && path == "") {
 		mr, err := adder.mfsRoot()
 		if err != nil {
@@ -435,6 +560,119 @@ func (adder *Adder) addDir(path string, dir files.Directory, toplevel bool) erro
 	return it.Err()
 }
 
+// setFileMeta returns the root of an added file with the given metadata. A raw
+// root cannot hold it, so it becomes the only child of a new root.
+func (adder *Adder) setFileMeta(nd ipld.Node, meta unixfsmeta.Meta) (ipld.Node, error) {
+	if pi, ok := nd.(*posinfo.FilestoreNode); ok {
+		nd = pi.Node
+	}
+
+	var pn *dag.ProtoNode
+	switch nd := nd.(type) {
+	case *dag.ProtoNode:
+		pn = nd.Copy().(*dag.ProtoNode)
+	case *dag.RawNode:
+		fsn := unixfs.NewFSNode(unixfs.TFile)
+		fsn.AddBlockSize(uint64(len(nd.RawData())))
+		data, err := fsn.GetBytes()
+		if err != nil {
+			return nil, err
+		}
+		pn = dag.NodeWithData(data)
+		pn.SetCidBuilder(adder.CidBuilder)
+		if err := pn.AddNodeLink("", nd); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot record the metadata of a %T", nd)
+	}
+
+	data, err := unixfsmeta.Set(pn.Data(), meta)
+	if err != nil {
+		return nil, err
+	}
+	pn.SetData(data)
+	return pn, adder.dagService.Add(adder.ctx, pn)
+}
+
+func (adder *Adder) recordDirMeta(path string, meta unixfsmeta.Meta) {
+	if adder.dirMeta == nil {
+		adder.dirMeta = make(map[string]unixfsmeta.Meta)
+		adder.dirMetaParents = make(map[string]struct{})
+		adder.metaDirs = make(map[string]ipld.Node)
+	}
+	adder.dirMeta[path] = meta
+	for p := path; ; p = gopath.Dir(p) {
+		if p == "." || p == "/" {
+			p = ""
+		}
+		adder.dirMetaParents[p] = struct{}{}
+		if p == "" {
+			break
+		}
+	}
+}
+
+// setDirMeta records the metadata of the added directories at and under
+// path, nd being the directory built by mfs at path. It returns the new node
+// of the directory.
+func (adder *Adder) setDirMeta(path string, nd ipld.Node) (ipld.Node, error) {
+	if _, ok := adder.dirMetaParents[path]; !ok {
+		return nd, nil
+	}
+
+	dir, err := uio.NewDirectoryFromNode(adder.dagService, nd)
+	if err != nil {
+		return nil, err
+	}
+	var links []*ipld.Link
+	err = dir.ForEachLink(adder.ctx, func(l *ipld.Link) error {
+		if _, ok := adder.dirMetaParents[gopath.Join(path, l.Name)]; ok {
+			links = append(links, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		child, err := l.GetNode(adder.ctx, adder.dagService)
+		if err != nil {
+			return nil, err
+		}
+		newChild, err := adder.setDirMeta(gopath.Join(path, l.Name), child)
+		if err != nil {
+			return nil, err
+		}
+		if err := dir.AddChild(adder.ctx, l.Name, newChild); err != nil {
+			return nil, err
+		}
+	}
+
+	nd, err = dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if meta, ok := adder.dirMeta[path]; ok {
+		pn, ok := nd.(*dag.ProtoNode)
+		if !ok {
+			return nil, fmt.Errorf("cannot record the metadata of directory %s: not a protobuf node", path)
+		}
+		pn = pn.Copy().(*dag.ProtoNode)
+		data, err := unixfsmeta.Set(pn.Data(), meta)
+		if err != nil {
+			return nil, err
+		}
+		pn.SetData(data)
+		nd = pn
+	}
+	if err := adder.dagService.Add(adder.ctx, nd); err != nil {
+		return nil, err
+	}
+	adder.metaDirs[path] = nd
+	return nd, nil
+}
+
 func (adder *Adder) maybePauseForGC() error {
 	if adder.unlocker != nil && adder.gcLocker.GCRequested() {
 		rn, err := adder.curRootNode()
@@ -8,12 +8,14 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
@@ -24,7 +26,9 @@ import (
 	config "github.com/ipfs/go-ipfs-config"
 	files "github.com/ipfs/go-ipfs-files"
 	pi "github.com/ipfs/go-ipfs-posinfo"
+	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
+	unixfile "github.com/ipfs/go-unixfs/file"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 )
 
@@ -366,3 +370,141 @@ func (fi *dummyFileInfo) Mode() os.FileMode  { return 0 }
 func (fi *dummyFileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *dummyFileInfo) IsDir() bool        { return false }
 func (fi *dummyFileInfo) Sys() interface{}   { return nil }
+
+func TestAddWithMeta(t *testing.T) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Out = make(chan interface{}, 10)
+	adder.RawLeaves = true
+
+	mtime := time.Unix(981173106, 0)
+	meta := map[string]unixfsmeta.Meta{
+		"":          {Mode: 0700, HasMode: true},
+		"sub":       {Mtime: mtime},
+		"sub/file":  {Mode: 0600, HasMode: true, Mtime: mtime},
+		"sub/link":  {Mtime: mtime},
+		"untouched": {},
+	}
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"file": files.NewBytesFile([]byte("raw leaf")),
+			"link": files.NewLinkFile("file", nil),
+		}),
+		"untouched": files.NewBytesFile([]byte("no metadata")),
+	})
+
+	root, err := adder.AddAllAndPin(WithMeta(dir, func(path string, _ files.Node) (unixfsmeta.Meta, error) {
+		return meta[path], nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	get := func(path string) ipld.Node {
+		nd := root
+		for _, name := range strings.Split(path, "/") {
+			if name == "" {
+				continue
+			}
+			l, _, err := nd.ResolveLink([]string{name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nd, err = l.GetNode(ctx, node.DAG); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nd
+	}
+	for path, m := range meta {
+		nd := get(path)
+		got, err := unixfsmeta.FromNode(nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.HasMode != m.HasMode || got.Mode != m.Mode || !got.Mtime.Equal(m.Mtime) {
+			t.Fatalf("%s: expected %+v, got %+v", path, m, got)
+		}
+		if path == "untouched" {
+			if _, raw := nd.(*dag.RawNode); !raw {
+				t.Fatalf("expected the file without metadata to stay a raw node, got %T", nd)
+			}
+		}
+	}
+
+	// the raw leaf became the child of a root holding the metadata
+	f, err := unixfile.NewUnixfsFile(ctx, node.DAG, get("sub/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(files.ToFile(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "raw leaf" {
+		t.Fatalf("unexpected content %q", data)
+	}
+}
+
+func TestAddDedupReport(t *testing.T) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 4*1024)
+	rand.New(rand.NewSource(3)).Read(data) // Rand.Read never returns an error
+
+	add := func(nd files.Node) *DedupReport {
+		adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Chunker = "size-1024"
+		adder.RawLeaves = true
+		adder.Dedup = NewDedupReport(node.Blockstore)
+		if _, err := adder.AddAllAndPin(nd); err != nil {
+			t.Fatal(err)
+		}
+		return adder.Dedup
+	}
+
+	report := add(files.NewBytesFile(data[:2048]))
+	if exp := (DedupStats{NewChunks: 2, NewBytes: 2048}); report.Total != exp || len(report.Files) != 1 {
+		t.Fatalf("expected %+v for a single file, got %+v", exp, report)
+	}
+
+	// the first half was added already, the last chunk repeats the third one
+	modified := append(append([]byte{}, data[:3072]...), data[2048:3072]...)
+	report = add(files.NewMapDirectory(map[string]files.Node{
+		"modified": files.NewBytesFile(modified),
+	}))
+	exp := DedupStats{NewChunks: 1, NewBytes: 1024, DupChunks: 3, DupBytes: 3072}
+	if len(report.Files) != 1 || report.Files[0].Path != "modified" || report.Files[0].DedupStats != exp {
+		t.Fatalf("expected %+v for modified, got %+v", exp, report.Files)
+	}
+}
@@ -0,0 +1,431 @@
+package coreunix
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
+)
+
+var (
+	checkpointPrefix = ds.NewKey("/local/add/checkpoints")
+	leavesPrefix     = ds.NewKey("/local/add/leaves")
+)
+
+// CheckpointTTL is how long the checkpoint of an interrupted add is kept
+// after its last update. Stale checkpoints are not resumed, and their leaves
+// are left to the garbage collector.
+const CheckpointTTL = 7 * 24 * time.Hour
+
+// checkpointInterval is how many bytes of a file are added between two
+// checkpoints.
+var checkpointInterval uint64 = 64 << 20
+
+// ErrCheckpointMismatch is returned when resuming the add of a file whose
+// data differs from its checkpoint.
+var ErrCheckpointMismatch = errors.New("the added data differs from its checkpoint, add it again without resuming")
+
+// Checkpoints stores, in the repo datastore, the leaves of the files being
+// added, so that the add of a file can resume where an interrupted one
+// stopped. A checkpoint is identified by the file, its size and modification
+// time when known, and the settings of the Adder.
+//
+// The leaves of a file are stored by segments of checkpointInterval bytes,
+// the checkpoint itself only tracking how many there are.
+type Checkpoints struct {
+	dstore ds.Datastore
+}
+
+// NewCheckpoints returns the checkpoints stored in the given datastore.
+func NewCheckpoints(dstore ds.Datastore) *Checkpoints {
+	return &Checkpoints{dstore: dstore}
+}
+
+type checkpoint struct {
+	// Path is the path of the file in the add, for debugging.
+	Path     string
+	Offset   uint64
+	Segments int
+	Updated  time.Time
+}
+
+func (c *checkpoint) stale(now time.Time) bool {
+	return now.Sub(c.Updated) > CheckpointTTL
+}
+
+type checkpointSegment struct {
+	Offset uint64
+	Leaves []cid.Cid
+}
+
+func (s *Checkpoints) get(id string) (*checkpoint, error) {
+	v, err := s.dstore.Get(checkpointPrefix.ChildString(id))
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	cp := new(checkpoint)
+	if err := json.Unmarshal(v, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (s *Checkpoints) segment(id string, i int) (*checkpointSegment, error) {
+	v, err := s.dstore.Get(segmentKey(id, i))
+	if err != nil {
+		return nil, err
+	}
+	seg := new(checkpointSegment)
+	if err := json.Unmarshal(v, seg); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// load returns the checkpoint id and the leaves it records, or nil if there
+// is none. Stale checkpoints are removed.
+func (s *Checkpoints) load(id string) (*checkpoint, []cid.Cid, error) {
+	cp, err := s.get(id)
+	if err != nil || cp == nil {
+		return nil, nil, err
+	}
+	if cp.stale(time.Now()) {
+		return nil, nil, s.remove(id, cp)
+	}
+
+	var leaves []cid.Cid
+	for i := 0; i < cp.Segments; i++ {
+		seg, err := s.segment(id, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		leaves = append(leaves, seg.Leaves...)
+	}
+	return cp, leaves, nil
+}
+
+// save appends seg to the checkpoint id. The segment is written before the
+// checkpoint referencing it.
+func (s *Checkpoints) save(id string, cp *checkpoint, seg *checkpointSegment) error {
+	v, err := json.Marshal(seg)
+	if err != nil {
+		return err
+	}
+	if err := s.dstore.Put(segmentKey(id, cp.Segments), v); err != nil {
+		return err
+	}
+
+	next := *cp
+	next.Segments++
+	next.Offset = seg.Offset
+	next.Updated = time.Now()
+	v, err = json.Marshal(&next)
+	if err != nil {
+		return err
+	}
+	if err := s.dstore.Put(checkpointPrefix.ChildString(id), v); err != nil {
+		return err
+	}
+	*cp = next
+	return nil
+}
+
+// remove deletes the checkpoint id, then its segments.
+func (s *Checkpoints) remove(id string, cp *checkpoint) error {
+	if err := s.dstore.Delete(checkpointPrefix.ChildString(id)); err != nil {
+		return err
+	}
+	for i := 0; i < cp.Segments; i++ {
+		if err := s.dstore.Delete(segmentKey(id, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEach calls f with the checkpoints stored.
+func (s *Checkpoints) forEach(ctx context.Context, f func(id string, cp *checkpoint) error) error {
+	res, err := s.dstore.Query(query.Query{Prefix: checkpointPrefix.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		id := ds.RawKey(r.Key).BaseNamespace()
+		cp := new(checkpoint)
+		if err := json.Unmarshal(r.Value, cp); err != nil {
+			log.Errorf("invalid add checkpoint %s: %s", id, err)
+			continue
+		}
+		if err := f(id, cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Leaves returns the leaves recorded by the checkpoints that are not stale at
+// the given time, which the garbage collector keeps.
+func (s *Checkpoints) Leaves(ctx context.Context, now time.Time) ([]cid.Cid, error) {
+	var leaves []cid.Cid
+	err := s.forEach(ctx, func(id string, cp *checkpoint) error {
+		if cp.stale(now) {
+			return nil
+		}
+		for i := 0; i < cp.Segments; i++ {
+			seg, err := s.segment(id, i)
+			if err != nil {
+				return err
+			}
+			leaves = append(leaves, seg.Leaves...)
+		}
+		return nil
+	})
+	return leaves, err
+}
+
+// RemoveStale removes the checkpoints that are stale at the given time, and
+// returns how many there were.
+func (s *Checkpoints) RemoveStale(ctx context.Context, now time.Time) (int, error) {
+	type entry struct {
+		id string
+		cp *checkpoint
+	}
+	var stale []entry
+	err := s.forEach(ctx, func(id string, cp *checkpoint) error {
+		if cp.stale(now) {
+			stale = append(stale, entry{id, cp})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, e := range stale {
+		if err := s.remove(e.id, e.cp); err != nil {
+			return i, err
+		}
+	}
+	return len(stale), nil
+}
+
+func segmentKey(id string, i int) ds.Key {
+	return leavesPrefix.ChildString(id).ChildString(strconv.Itoa(i))
+}
+
+// checkpointID identifies the add of file at path with the current settings
+// of the Adder.
+func (adder *Adder) checkpointID(path string, file files.File, meta unixfsmeta.Meta) (string, error) {
+	id := struct {
+		Path       string
+		AbsPath    string `json:",omitempty"`
+		Size       int64
+		Mtime      time.Time
+		Chunker    string
+		Trickle    bool
+		RawLeaves  bool
+		NoCopy     bool
+		CidBuilder string
+	}{
+		Path:       path,
+		Size:       -1,
+		Mtime:      meta.Mtime,
+		Chunker:    adder.Chunker,
+		Trickle:    adder.Trickle,
+		RawLeaves:  adder.RawLeaves,
+		NoCopy:     adder.NoCopy,
+		CidBuilder: fmt.Sprintf("%+v", adder.CidBuilder),
+	}
+	if size, err := file.Size(); err == nil {
+		id.Size = size
+	}
+	if fi, ok := file.(files.FileInfo); ok {
+		id.AbsPath = fi.AbsPath()
+		if st := fi.Stat(); st != nil {
+			id.Mtime = st.ModTime()
+		}
+	}
+
+	v, err := json.Marshal(&id)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(v)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fileCheckpoint checkpoints the add of a file.
+type fileCheckpoint struct {
+	store  *Checkpoints
+	id     string
+	cp     checkpoint
+	commit func() error
+
+	// resumed holds the leaves of the checkpoint the add resumes from, up
+	// to offset. skip counts those not added again yet.
+	resumed []cid.Cid
+	offset  uint64
+	skip    int
+
+	pending      []cid.Cid
+	pendingBytes uint64
+}
+
+// openCheckpoint returns the checkpoint of the add of file at path, with the
+// leaves to resume from.
+func (adder *Adder) openCheckpoint(path string, file files.File, meta unixfsmeta.Meta) (*fileCheckpoint, error) {
+	id, err := adder.checkpointID(path, file, meta)
+	if err != nil {
+		return nil, err
+	}
+	fc := &fileCheckpoint{
+		store:  adder.Checkpoints,
+		id:     id,
+		cp:     checkpoint{Path: path},
+		commit: adder.commit,
+	}
+
+	cp, leaves, err := fc.store.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		fc.cp = *cp
+		fc.resumed, fc.offset, fc.skip = leaves, cp.Offset, len(leaves)
+		log.Infof("resuming the add of %q at byte %d", path, cp.Offset)
+	}
+	return fc, nil
+}
+
+// leaf records an added leaf holding size bytes, saving the checkpoint every
+// checkpointInterval bytes.
+func (fc *fileCheckpoint) leaf(c cid.Cid, size uint64) error {
+	if fc.skip > 0 {
+		fc.skip--
+		return nil
+	}
+	fc.pending = append(fc.pending, c)
+	fc.pendingBytes += size
+	if fc.pendingBytes < checkpointInterval {
+		return nil
+	}
+
+	// the leaves must be stored before they are checkpointed
+	if err := fc.commit(); err != nil {
+		return err
+	}
+	seg := &checkpointSegment{Offset: fc.cp.Offset + fc.pendingBytes, Leaves: fc.pending}
+	if err := fc.store.save(fc.id, &fc.cp, seg); err != nil {
+		return err
+	}
+	fc.pending, fc.pendingBytes = nil, 0
+	return nil
+}
+
+// done removes the checkpoint of a file that was added completely.
+func (fc *fileCheckpoint) done() error {
+	if fc.cp.Segments == 0 {
+		return nil
+	}
+	return fc.store.remove(fc.id, &fc.cp)
+}
+
+// checkpointDAG records the leaves added through it in a fileCheckpoint.
+type checkpointDAG struct {
+	ipld.DAGService
+	fc *fileCheckpoint
+}
+
+func (d *checkpointDAG) Add(ctx context.Context, nd ipld.Node) error {
+	return d.AddMany(ctx, []ipld.Node{nd})
+}
+
+func (d *checkpointDAG) AddMany(ctx context.Context, nds []ipld.Node) error {
+	if err := d.DAGService.AddMany(ctx, nds); err != nil {
+		return err
+	}
+	for _, nd := range nds {
+		if len(nd.Links()) != 0 {
+			continue
+		}
+		data, err := leafData(nd)
+		if err != nil {
+			return err
+		}
+		if err := d.fc.leaf(nd.Cid(), uint64(len(data))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeSplitter returns the data of the resumed leaves, read from the
+// blockstore, before the chunks of the rest of the file. When the file could
+// not be seeked past the checkpoint, its data is read and checked against the
+// leaves instead.
+type resumeSplitter struct {
+	chunker.Splitter
+	ctx    context.Context
+	dag    ipld.DAGService
+	leaves []cid.Cid
+	verify io.Reader
+}
+
+func (s *resumeSplitter) NextBytes() ([]byte, error) {
+	if len(s.leaves) == 0 {
+		return s.Splitter.NextBytes()
+	}
+
+	nd, err := s.dag.Get(s.ctx, s.leaves[0])
+	if err != nil {
+		return nil, err
+	}
+	s.leaves = s.leaves[1:]
+	data, err := leafData(nd)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.verify != nil {
+		buf := make([]byte, len(data))
+		if _, err := io.ReadFull(s.verify, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, ErrCheckpointMismatch
+			}
+			return nil, err
+		}
+		if !bytes.Equal(buf, data) {
+			return nil, ErrCheckpointMismatch
+		}
+	}
+	return data, nil
+}
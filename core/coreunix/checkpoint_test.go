@@ -0,0 +1,149 @@
+package coreunix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/repo"
+
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	config "github.com/ipfs/go-ipfs-config"
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// flakyReader counts the bytes read from it, failing once it read failAt
+// of them when failAt is set.
+type flakyReader struct {
+	*bytes.Reader
+	read   int64
+	failAt int64
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if r.failAt > 0 && r.read >= r.failAt {
+		return 0, errInterrupted
+	}
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// Close keeps the reader seekable through files.NewReaderFile, as an
+// *os.File is.
+func (r *flakyReader) Close() error { return nil }
+
+// streamReader hides the Seek method of the reader it wraps.
+type streamReader struct {
+	io.Reader
+}
+
+func TestAddResume(t *testing.T) {
+	defer func(interval uint64) { checkpointInterval = interval }(checkpointInterval)
+	checkpointInterval = 64 << 10
+
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints := NewCheckpoints(r.Datastore())
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	add := func(rd io.Reader, resume bool) (string, error) {
+		adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Chunker = "size-16384"
+		adder.Pin = false
+		if resume {
+			adder.Checkpoints = checkpoints
+		}
+		nd, err := adder.AddAllAndPin(files.NewReaderFile(rd))
+		if err != nil {
+			return "", err
+		}
+		return nd.Cid().String(), nil
+	}
+	interrupt := func() int {
+		_, err := add(&flakyReader{Reader: bytes.NewReader(data), failAt: 300 << 10}, true)
+		if !errors.Is(err, errInterrupted) {
+			t.Fatalf("expected the add to be interrupted, got %v", err)
+		}
+		leaves, err := checkpoints.Leaves(context.Background(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leaves) == 0 {
+			t.Fatal("expected the interrupted add to be checkpointed")
+		}
+		return len(leaves)
+	}
+
+	expected, err := add(bytes.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// seekable data is not read again
+	checkpointed := interrupt()
+	rd := &flakyReader{Reader: bytes.NewReader(data)}
+	got, err := add(rd, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	if skipped := int64(len(data)) - rd.read; skipped != int64(checkpointed)*16384 {
+		t.Fatalf("expected the %d checkpointed leaves to be skipped, %d bytes were", checkpointed, skipped)
+	}
+	if leaves, err := checkpoints.Leaves(context.Background(), time.Now()); err != nil || len(leaves) != 0 {
+		t.Fatalf("expected the checkpoint to be removed, got %d leaves, %v", len(leaves), err)
+	}
+
+	// streamed data is checked against the checkpoint
+	interrupt()
+	changed := append([]byte{}, data...)
+	changed[0]++
+	if _, err := add(streamReader{bytes.NewReader(changed)}, true); err != ErrCheckpointMismatch {
+		t.Fatalf("expected a checkpoint mismatch, got %v", err)
+	}
+	got, err = add(streamReader{bytes.NewReader(data)}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	// stale checkpoints are removed
+	interrupt()
+	if n, err := checkpoints.RemoveStale(context.Background(), time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected no stale checkpoint, got %d, %v", n, err)
+	}
+	if n, err := checkpoints.RemoveStale(context.Background(), time.Now().Add(CheckpointTTL+time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected a stale checkpoint, got %d, %v", n, err)
+	}
+	if leaves, err := checkpoints.Leaves(context.Background(), time.Now()); err != nil || len(leaves) != 0 {
+		t.Fatalf("expected the checkpoint to be removed, got %d leaves, %v", len(leaves), err)
+	}
+}
@@ -0,0 +1,129 @@
+package coreunix
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+)
+
+// DedupStats counts the chunks of added data, and their bytes, which were new
+// to the blockstore or already present in it.
+type DedupStats struct {
+	NewChunks int
+	NewBytes  uint64
+	DupChunks int
+	DupBytes  uint64
+}
+
+// Add adds o to s.
+func (s *DedupStats) Add(o DedupStats) {
+	s.NewChunks += o.NewChunks
+	s.NewBytes += o.NewBytes
+	s.DupChunks += o.DupChunks
+	s.DupBytes += o.DupBytes
+}
+
+// FileDedup is the DedupStats of an added file, at its path relative to the
+// added node.
+type FileDedup struct {
+	Path string
+	DedupStats
+}
+
+// DedupReport collects the DedupStats of the files added by an Adder. Chunks
+// are checked against the blockstore before being written, and chunks repeated
+// within the added files count as duplicates after their first occurrence.
+type DedupReport struct {
+	Files []FileDedup
+	Total DedupStats
+
+	bs   bstore.Blockstore
+	seen *cid.Set
+}
+
+// NewDedupReport returns a report checking the chunks against bs.
+func NewDedupReport(bs bstore.Blockstore) *DedupReport {
+	return &DedupReport{bs: bs, seen: cid.NewSet()}
+}
+
+func (r *DedupReport) record(path string, s DedupStats) {
+	r.Files = append(r.Files, FileDedup{Path: path, DedupStats: s})
+	r.Total.Add(s)
+}
+
+// chunk counts nd in s if it is a chunk, that is a leaf of the file DAG.
+func (r *DedupReport) chunk(s *DedupStats, nd ipld.Node) error {
+	if pn, ok := nd.(*posinfo.FilestoreNode); ok {
+		nd = pn.Node
+	}
+	if len(nd.Links()) != 0 {
+		return nil
+	}
+
+	data, err := leafData(nd)
+	if err != nil {
+		return err
+	}
+	size := uint64(len(data))
+
+	dup := !r.seen.Visit(nd.Cid())
+	if !dup {
+		has, err := r.bs.Has(nd.Cid())
+		if err != nil {
+			return err
+		}
+		dup = has
+	}
+	if dup {
+		s.DupChunks++
+		s.DupBytes += size
+	} else {
+		s.NewChunks++
+		s.NewBytes += size
+	}
+	return nil
+}
+
+// dedupDAG counts the chunks added through it in stats.
+type dedupDAG struct {
+	ipld.DAGService
+	report *DedupReport
+	stats  *DedupStats
+}
+
+func (d *dedupDAG) Add(ctx context.Context, nd ipld.Node) error {
+	if err := d.report.chunk(d.stats, nd); err != nil {
+		return err
+	}
+	return d.DAGService.Add(ctx, nd)
+}
+
+func (d *dedupDAG) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		if err := d.report.chunk(d.stats, nd); err != nil {
+			return err
+		}
+	}
+	return d.DAGService.AddMany(ctx, nds)
+}
+
+// leafData returns the file data held by the leaf nd.
+func leafData(nd ipld.Node) ([]byte, error) {
+	if pn, ok := nd.(*posinfo.FilestoreNode); ok {
+		nd = pn.Node
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return nd.RawData(), nil
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, err
+	}
+	return fsn.Data(), nil
+}
@@ -0,0 +1,66 @@
+package coreunix
+
+import (
+	gopath "path"
+
+	files "github.com/ipfs/go-ipfs-files"
+
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
+)
+
+// MetaFunc returns the UnixFS metadata to record for nd, the node added at
+// the given path, relative to the node passed to WithMeta. An error fails the
+// add.
+type MetaFunc func(path string, nd files.Node) (unixfsmeta.Meta, error)
+
+// WithMeta wraps nd so that the Adder records the metadata returned by fn for
+// it and for the nodes under it.
+func WithMeta(nd files.Node, fn MetaFunc) files.Node {
+	return wrapMeta(nd, "", fn)
+}
+
+func wrapMeta(nd files.Node, path string, fn MetaFunc) files.Node {
+	if d, ok := nd.(files.Directory); ok {
+		return &metaDir{Directory: d, path: path, fn: fn}
+	}
+	return &metaFile{Node: nd, path: path, fn: fn}
+}
+
+// metaNode is a node wrapped by WithMeta.
+type metaNode interface {
+	unixfsMeta() (unixfsmeta.Meta, error)
+	unwrap() files.Node
+}
+
+// metaFile wraps a file or a symlink, which the Adder unwraps before adding.
+type metaFile struct {
+	files.Node
+	path string
+	fn   MetaFunc
+}
+
+func (f *metaFile) unixfsMeta() (unixfsmeta.Meta, error) { return f.fn(f.path, f.Node) }
+func (f *metaFile) unwrap() files.Node                   { return f.Node }
+
+// metaDir wraps a directory and its entries. It stays wrapped while added.
+type metaDir struct {
+	files.Directory
+	path string
+	fn   MetaFunc
+}
+
+func (d *metaDir) unixfsMeta() (unixfsmeta.Meta, error) { return d.fn(d.path, d.Directory) }
+func (d *metaDir) unwrap() files.Node                   { return d.Directory }
+
+func (d *metaDir) Entries() files.DirIterator {
+	return &metaIterator{DirIterator: d.Directory.Entries(), dir: d}
+}
+
+type metaIterator struct {
+	files.DirIterator
+	dir *metaDir
+}
+
+func (it *metaIterator) Node() files.Node {
+	return wrapMeta(it.DirIterator.Node(), gopath.Join(it.dir.path, it.Name()), it.dir.fn)
+}
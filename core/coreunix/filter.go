@@ -0,0 +1,253 @@
+package coreunix
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	gopath "path"
+	"strings"
+
+	ignore "github.com/crackcomm/go-gitignore"
+	files "github.com/ipfs/go-ipfs-files"
+
+	"github.com/ipfs/go-ipfs/thirdparty/unixfsmeta"
+)
+
+// IgnoreFileName is the name of the files holding .gitignore-style patterns
+// excluding files from the directory they are in.
+const IgnoreFileName = ".ipfsignore"
+
+// maxIgnoreFileSize bounds the size of the ignore files read by the Adder.
+const maxIgnoreFileSize = 1 << 20
+
+// Filter selects the files of the added directories, before they are chunked.
+// Patterns follow the .gitignore syntax and match paths relative to the added
+// directory. A file is skipped when it matches an exclude pattern, or the
+// patterns of an ignore file found in one of its parent directories, or when
+// there are include patterns and it matches none. Directories are only
+// skipped when excluded. Ignore files are not added, and only apply to the
+// entries following them in their directory: clients list them first.
+//
+// Filters are applied by wrapping the added directories with Apply, which the
+// Adder does when its Filter is set.
+type Filter struct {
+	exclude     *ignore.GitIgnore
+	include     *ignore.GitIgnore
+	ignoreFiles []string
+}
+
+// NewFilter returns a Filter with the given exclude and include patterns,
+// honoring the ignore files with the given names.
+func NewFilter(exclude, include, ignoreFiles []string) (*Filter, error) {
+	f := &Filter{ignoreFiles: ignoreFiles}
+	var err error
+	if len(exclude) > 0 {
+		if f.exclude, err = ignore.CompileIgnoreLines(exclude...); err != nil {
+			return nil, err
+		}
+	}
+	if len(include) > 0 {
+		if f.include, err = ignore.CompileIgnoreLines(include...); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// IsIgnoreFile returns whether name is the name of an ignore file honored by
+// the filter.
+func (f *Filter) IsIgnoreFile(name string) bool {
+	for _, n := range f.ignoreFiles {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreFile holds the patterns of an ignore file found in dir.
+type ignoreFile struct {
+	dir   string
+	rules *ignore.GitIgnore
+}
+
+// readIgnoreFile reads the ignore file nd found in dir, returning its content
+// as well.
+func readIgnoreFile(dir string, nd files.Node) (ignoreFile, []byte, error) {
+	if mn, ok := nd.(metaNode); ok {
+		nd = mn.unwrap()
+	}
+	f := files.ToFile(nd)
+	if f == nil {
+		return ignoreFile{}, nil, files.ErrNotReader
+	}
+	data, err := ioutil.ReadAll(&limitedReader{f, maxIgnoreFileSize})
+	if err != nil {
+		return ignoreFile{}, nil, err
+	}
+	rules, err := ignore.CompileIgnoreLines(strings.Split(string(data), "\n")...)
+	if err != nil {
+		return ignoreFile{}, nil, err
+	}
+	return ignoreFile{dir: dir, rules: rules}, data, nil
+}
+
+// skip returns whether the file at path is filtered out, given the ignore
+// files of its parent directories.
+func (f *Filter) skip(path string, dir bool, ignored []ignoreFile) bool {
+	match := func(rules *ignore.GitIgnore, p string) bool {
+		if dir {
+			p += "/"
+		}
+		return rules.MatchesPath(p)
+	}
+
+	if f.exclude != nil && match(f.exclude, path) {
+		return true
+	}
+	for _, ig := range ignored {
+		rel := path
+		if ig.dir != "" {
+			rel = strings.TrimPrefix(path, ig.dir+"/")
+		}
+		if match(ig.rules, rel) {
+			return true
+		}
+	}
+	return !dir && f.include != nil && !match(f.include, path)
+}
+
+// Apply returns dir without the entries filtered out. Clients keep the ignore
+// files, to send them along with the files they select.
+func (f *Filter) Apply(dir files.Directory, keepIgnoreFiles bool) files.Directory {
+	return &filterDir{Directory: dir, filter: f, keep: keepIgnoreFiles}
+}
+
+type filterDir struct {
+	files.Directory
+	filter  *Filter
+	keep    bool
+	path    string
+	ignored []ignoreFile
+}
+
+// filterDir keeps the metadata of the directories wrapped by WithMeta.
+func (d *filterDir) unixfsMeta() (unixfsmeta.Meta, error) {
+	if mn, ok := d.Directory.(metaNode); ok {
+		return mn.unixfsMeta()
+	}
+	return unixfsmeta.Meta{}, nil
+}
+
+func (d *filterDir) unwrap() files.Node { return d.Directory }
+
+// Stat returns the stat of the filtered directory, which clients send along
+// with it.
+func (d *filterDir) Stat() os.FileInfo {
+	if s, ok := d.Directory.(interface{ Stat() os.FileInfo }); ok {
+		return s.Stat()
+	}
+	return nil
+}
+
+// Size returns the size of the files selected by the filter.
+func (d *filterDir) Size() (int64, error) {
+	var size int64
+	it := d.Entries()
+	for it.Next() {
+		if d.filter.IsIgnoreFile(it.Name()) {
+			continue
+		}
+		s, err := it.Node().Size()
+		it.Node().Close()
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, it.Err()
+}
+
+func (d *filterDir) Entries() files.DirIterator {
+	return &filterIterator{
+		it:      d.Directory.Entries(),
+		dir:     d,
+		ignored: d.ignored[:len(d.ignored):len(d.ignored)],
+	}
+}
+
+type filterIterator struct {
+	it      files.DirIterator
+	dir     *filterDir
+	ignored []ignoreFile
+
+	name string
+	node files.Node
+	err  error
+}
+
+func (it *filterIterator) Name() string     { return it.name }
+func (it *filterIterator) Node() files.Node { return it.node }
+
+func (it *filterIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
+
+func (it *filterIterator) Next() bool {
+	for it.it.Next() {
+		name, nd := it.it.Name(), it.it.Node()
+		path := gopath.Join(it.dir.path, name)
+
+		if it.dir.filter.IsIgnoreFile(name) {
+			ig, data, err := readIgnoreFile(it.dir.path, nd)
+			nd.Close()
+			if err != nil {
+				it.err = fmt.Errorf("reading %s: %w", path, err)
+				return false
+			}
+			it.ignored = append(it.ignored, ig)
+			if !it.dir.keep {
+				continue
+			}
+			it.name, it.node = name, files.NewBytesFile(data)
+			return true
+		}
+
+		if sub, ok := nd.(files.Directory); ok {
+			if it.dir.filter.skip(path, true, it.ignored) {
+				nd.Close()
+				continue
+			}
+			it.name = name
+			it.node = &filterDir{Directory: sub, filter: it.dir.filter, keep: it.dir.keep, path: path, ignored: it.ignored}
+			return true
+		}
+		if it.dir.filter.skip(path, false, it.ignored) {
+			nd.Close()
+			continue
+		}
+		it.name, it.node = name, nd
+		return true
+	}
+	return false
+}
+
+type limitedReader struct {
+	r files.File
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, fmt.Errorf("larger than %d bytes", maxIgnoreFileSize)
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,148 @@
+package coreunix
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/core"
+
+	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func testTree() files.Directory {
+	return files.NewMapDirectory(map[string]files.Node{
+		".ipfsignore": files.NewBytesFile([]byte("/build/\n*.o\n")),
+		"main.go":     files.NewBytesFile([]byte("a")),
+		"main.o":      files.NewBytesFile([]byte("b")),
+		"README":      files.NewBytesFile([]byte("c")),
+		"build": files.NewMapDirectory(map[string]files.Node{
+			"out": files.NewBytesFile([]byte("d")),
+		}),
+		"pkg": files.NewMapDirectory(map[string]files.Node{
+			".ipfsignore": files.NewBytesFile([]byte("*.tmp\nsub/keep.txt\n")),
+			"x.go":        files.NewBytesFile([]byte("e")),
+			"x.tmp":       files.NewBytesFile([]byte("f")),
+			"build": files.NewMapDirectory(map[string]files.Node{
+				"y.go": files.NewBytesFile([]byte("g")),
+			}),
+			"sub": files.NewMapDirectory(map[string]files.Node{
+				"keep.txt": files.NewBytesFile([]byte("h")),
+				"z.go":     files.NewBytesFile([]byte("i")),
+			}),
+		}),
+	})
+}
+
+// listFiles returns the paths of the files under dir.
+func listFiles(t *testing.T, dir files.Directory) []string {
+	t.Helper()
+	var paths []string
+	err := files.Walk(dir, func(path string, nd files.Node) error {
+		if _, ok := nd.(files.Directory); !ok {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestFilterApply(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		exclude, include []string
+		ignore           []string
+		keep             bool
+		expected         []string
+		size             int64
+	}{{
+		name:     "ignore files",
+		ignore:   []string{IgnoreFileName},
+		expected: []string{"README", "main.go", "pkg/build/y.go", "pkg/sub/z.go", "pkg/x.go"},
+		size:     5,
+	}, {
+		name:     "ignore files kept",
+		ignore:   []string{IgnoreFileName},
+		keep:     true,
+		expected: []string{".ipfsignore", "README", "main.go", "pkg/.ipfsignore", "pkg/build/y.go", "pkg/sub/z.go", "pkg/x.go"},
+		// the size of the ignore files is not counted
+		size: 5,
+	}, {
+		name:     "exclude",
+		exclude:  []string{"build", "pkg/sub/"},
+		expected: []string{".ipfsignore", "README", "main.go", "main.o", "pkg/.ipfsignore", "pkg/x.go", "pkg/x.tmp"},
+		size:     36,
+	}, {
+		name:     "include",
+		exclude:  []string{"pkg/build"},
+		include:  []string{"*.go"},
+		ignore:   []string{IgnoreFileName},
+		expected: []string{"main.go", "pkg/sub/z.go", "pkg/x.go"},
+		size:     3,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewFilter(tc.exclude, tc.include, tc.ignore)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := f.Apply(testTree(), tc.keep)
+			got := listFiles(t, dir)
+			if len(got) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Fatalf("expected %v, got %v", tc.expected, got)
+				}
+			}
+
+			size, err := f.Apply(testTree(), tc.keep).Size()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != tc.size {
+				t.Fatalf("expected a size of %d, got %d", tc.size, size)
+			}
+		})
+	}
+}
+
+func TestAddWithFilter(t *testing.T) {
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Filter, err = NewFilter([]string{"README"}, nil, []string{IgnoreFileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := adder.AddAllAndPin(testTree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, l := range root.Links() {
+		names = append(names, l.Name)
+	}
+	if len(names) != 2 || names[0] != "main.go" || names[1] != "pkg" {
+		t.Fatalf("expected main.go and pkg, got %v", names)
+	}
+
+	pkg, err := root.(*dag.ProtoNode).GetLinkedProtoNode(context.Background(), node.DAG, "pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Links()) != 3 {
+		t.Fatalf("expected build, sub and x.go in pkg, got %v", pkg.Links())
+	}
+}
@@ -3,6 +3,7 @@ package node
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/ipfs/go-bitswap"
@@ -13,6 +14,7 @@ import (
 	"github.com/ipfs/go-filestore"
 	"github.com/ipfs/go-ipfs-blockstore"
 	"github.com/ipfs/go-ipfs-exchange-interface"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	"github.com/ipfs/go-ipfs-pinner"
 	"github.com/ipfs/go-ipfs-pinner/dspinner"
 	"github.com/ipfs/go-ipld-format"
@@ -20,10 +22,16 @@ import (
 	"github.com/ipfs/go-mfs"
 	"github.com/ipfs/go-unixfs"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/filewatch"
+	"github.com/ipfs/go-ipfs/gc"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/remotepin"
 	"github.com/ipfs/go-ipfs/repo"
 )
 
@@ -40,8 +48,60 @@ func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interf
 	return bsvc
 }
 
+// GCIndex opens the garbage collection index when it is enabled in the config.
+// Otherwise the index is invalidated, as it won't follow the pins anymore.
+func GCIndex(bstore blockstore.Blockstore, repo repo.Repo) (*gc.Index, error) {
+	ng := merkledag.NewDAGService(blockservice.New(bstore, offline.Exchange(bstore)))
+	idx, err := gc.NewIndex(repo.Datastore(), ng)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Datastore.GCIndex {
+		return nil, idx.Invalidate()
+	}
+	return idx, nil
+}
+
+// PinMeta opens the metadata of the local pins stored in the repo
+func PinMeta(repo repo.Repo) *pinmeta.Store {
+	return pinmeta.NewStore(repo.Datastore())
+}
+
+// RemotePinQueue opens the queue of remote pin requests stored in the repo
+func RemotePinQueue(repo repo.Repo, id peer.ID) *remotepin.Queue {
+	return remotepin.NewQueue(repo.Datastore(), id)
+}
+
+// RemotePinPolicies applies the automatic remote pinning policies of the
+// config, queuing their pins in the remote pin queue
+func RemotePinPolicies(repo repo.Repo, q *remotepin.Queue, id peer.ID) *remotepin.Mirror {
+	return remotepin.NewMirror(repo, q, id)
+}
+
+// FilestoreWatches keeps the directories watched with 'ipfs filestore watch'
+// in sync with MFS
+func FilestoreWatches(repo repo.Repo, bs blockstore.GCBlockstore, ds format.DAGService, root *mfs.Root) *filewatch.Manager {
+	return filewatch.NewManager(repo.Datastore(), bs, ds, root)
+}
+
+// Denylist loads the list of the content and names not served from the
+// denylist file of the repo, or keeps it in memory for repos without a path
+func Denylist(repo repo.Repo) (*denylist.Denylist, error) {
+	var file string
+	// the repos stored on disk, such as fsrepo, tell their path
+	if r, ok := repo.(interface{ Path() string }); ok && r.Path() != "" {
+		file = filepath.Join(r.Path(), denylist.DefaultFile)
+	}
+	return denylist.Open(file)
+}
+
 // Pinning creates new pinner which tells GC which blocks should be kept
-func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
+func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo, idx *gc.Index, meta *pinmeta.Store) (pin.Pinner, error) {
 	rootDS := repo.Datastore()
 
 	syncFn := func() error {
@@ -60,6 +120,11 @@ func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo)
 		return nil, err
 	}
 
+	if idx != nil {
+		pinning = gc.IndexedPinner(pinning, idx)
+	}
+	pinning = meta.Pinner(pinning)
+
 	return pinning, nil
 }
 
@@ -169,6 +169,9 @@ func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(RepoConfig),
 		fx.Provide(Datastore),
+		fx.Provide(GCWriteBarrier),
+		fx.Provide(GCAccessTimes),
+		fx.Provide(CarStore),
 		fx.Provide(BaseBlockstoreCtor(cacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
 		finalBstore,
 	)
@@ -217,6 +220,7 @@ func Identity(cfg *config.Config) fx.Option {
 // IPNS groups namesys related units
 var IPNS = fx.Options(
 	fx.Provide(RecordValidator),
+	fx.Provide(NewNameTTLs),
 )
 
 // Online groups online-only units
@@ -292,8 +296,14 @@ var Core = fx.Options(
 	fx.Provide(BlockService),
 	fx.Provide(Dag),
 	fx.Provide(resolver.NewBasicResolver),
+	fx.Provide(GCIndex),
+	fx.Provide(PinMeta),
+	fx.Provide(RemotePinQueue),
+	fx.Provide(RemotePinPolicies),
 	fx.Provide(Pinning),
 	fx.Provide(Files),
+	fx.Provide(FilestoreWatches),
+	fx.Provide(Denylist),
 )
 
 func Networked(bcfg *BuildCfg, cfg *config.Config) fx.Option {
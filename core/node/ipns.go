@@ -1,15 +1,24 @@
 package node
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"net"
+	"strings"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/ipfs/go-ipfs-util"
 	"github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
 	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/routing"
 	"github.com/libp2p/go-libp2p-record"
+	"github.com/miekg/dns"
 
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-namesys"
@@ -18,6 +27,15 @@ import (
 
 const DefaultIpnsCacheSize = 128
 
+// nameTTLsCacheSize is how many names NameTTLs keeps the TTL of.
+const nameTTLsCacheSize = 1024
+
+// resolvConf lists the DNS servers queried for the DNSLink records.
+const resolvConf = "/etc/resolv.conf"
+
+// dnsTimeout is the timeout of the queries of the DNSLink records.
+const dnsTimeout = 5 * time.Second
+
 // RecordValidator provides namesys compatible routing record validator
 func RecordValidator(ps peerstore.Peerstore) record.Validator {
 	return record.NamespacedValidator{
@@ -27,10 +45,188 @@ func RecordValidator(ps peerstore.Peerstore) record.Validator {
 }
 
 // Namesys creates new name system
-func Namesys(cacheSize int) func(rt routing.Routing, repo repo.Repo) (namesys.NameSystem, error) {
-	return func(rt routing.Routing, repo repo.Repo) (namesys.NameSystem, error) {
-		return namesys.NewNameSystem(rt, repo.Datastore(), cacheSize), nil
+func Namesys(cacheSize int) func(rt routing.Routing, repo repo.Repo, ttls *NameTTLs) (namesys.NameSystem, error) {
+	return func(rt routing.Routing, repo repo.Repo, ttls *NameTTLs) (namesys.NameSystem, error) {
+		vs := &ttlValueStore{ValueStore: rt, ttls: ttls}
+		return namesys.NewNameSystemWithLookup(vs, repo.Datastore(), cacheSize, ttls.lookupTXT), nil
+	}
+}
+
+// NameTTLs keeps the TTLs of the IPNS records fetched by the name system, and
+// of the DNSLink records it looked up, for the paths of their names to be
+// cached as long as the name system does.
+type NameTTLs struct {
+	expires *lru.Cache // peer.ID or fqdn string -> time.Time
+	// dnsConf are the DNS servers of the system, nil when unknown
+	dnsConf *dns.ClientConfig
+}
+
+// NewNameTTLs returns an empty NameTTLs.
+func NewNameTTLs() *NameTTLs {
+	// lru.New only fails for non-positive sizes
+	expires, _ := lru.New(nameTTLsCacheSize)
+	t := &NameTTLs{expires: expires}
+	if conf, err := dns.ClientConfigFromFile(resolvConf); err == nil && len(conf.Servers) > 0 {
+		t.dnsConf = conf
+	}
+	return t
+}
+
+// TTL returns how long the last IPNS record of id fetched remains valid, and
+// whether one was fetched.
+func (t *NameTTLs) TTL(id peer.ID) (time.Duration, bool) {
+	return t.ttl(id)
+}
+
+// DNSLinkTTL returns how long the last DNSLink record of domain looked up
+// remains valid, and whether one was looked up. Like the name system, the
+// record of _dnslink.<domain> is preferred.
+func (t *NameTTLs) DNSLinkTTL(domain string) (time.Duration, bool) {
+	fqdn := dns.Fqdn(domain)
+	if strings.HasSuffix(fqdn, ".eth.") {
+		// the name system looks up ENS names under .eth.domains
+		fqdn += "domains."
+	}
+	if ttl, ok := t.ttl("_dnslink." + fqdn); ok {
+		return ttl, true
+	}
+	return t.ttl(fqdn)
+}
+
+func (t *NameTTLs) ttl(key interface{}) (time.Duration, bool) {
+	v, ok := t.expires.Get(key)
+	if !ok {
+		return 0, false
+	}
+	ttl := time.Until(v.(time.Time))
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, true
+}
+
+// record keeps the TTL of the value fetched for key, if it is an IPNS record.
+// Like the name system, records without a TTL are valid for the default one,
+// and none past its EOL.
+func (t *NameTTLs) record(key string, val []byte) {
+	if !strings.HasPrefix(key, "/ipns/") {
+		return
+	}
+	entry := new(ipns_pb.IpnsEntry)
+	if err := proto.Unmarshal(val, entry); err != nil {
+		return
+	}
+	ttl := namesys.DefaultResolverCacheTTL
+	if entry.Ttl != nil {
+		ttl = time.Duration(entry.GetTtl())
+	}
+	if eol, err := ipns.GetEOL(entry); err == nil {
+		if untilEOL := time.Until(eol); untilEOL < ttl {
+			ttl = untilEOL
+		}
+	}
+	t.expires.Add(peer.ID(strings.TrimPrefix(key, "/ipns/")), time.Now().Add(ttl))
+}
+
+// lookupTXT looks up the TXT records of the fqdn name for the name system,
+// keeping their TTL when they hold a DNSLink. The DNS servers of the system
+// are queried directly, the resolver of the standard library not telling the
+// TTLs; without them, the records are looked up with it and their TTL is not
+// known.
+func (t *NameTTLs) lookupTXT(name string) ([]string, error) {
+	if t.dnsConf == nil {
+		return net.LookupTXT(name)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	var (
+		in  *dns.Msg
+		err error
+	)
+	for _, server := range t.dnsConf.Servers {
+		if in, err = exchangeDNS(q, net.JoinHostPort(server, t.dnsConf.Port)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return nil, &net.DNSError{
+			Err:        dns.RcodeToString[in.Rcode],
+			Name:       name,
+			IsNotFound: in.Rcode == dns.RcodeNameError,
+		}
+	}
+
+	var (
+		txt     []string
+		dnslink bool
+	)
+	// the TTL of the answer is the lowest of its records, CNAMEs included
+	ttl := uint32(math.MaxUint32)
+	for _, rr := range in.Answer {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+		if r, ok := rr.(*dns.TXT); ok {
+			v := strings.Join(r.Txt, "")
+			txt = append(txt, v)
+			dnslink = dnslink || strings.HasPrefix(v, "dnslink=")
+		}
+	}
+	if dnslink {
+		t.expires.Add(dns.Fqdn(name), time.Now().Add(time.Duration(ttl)*time.Second))
 	}
+	return txt, nil
+}
+
+// exchangeDNS sends q to the DNS server at addr, again over TCP if the answer
+// is truncated.
+func exchangeDNS(q *dns.Msg, addr string) (*dns.Msg, error) {
+	c := &dns.Client{Timeout: dnsTimeout}
+	in, _, err := c.Exchange(q, addr)
+	if err == nil && in.Truncated {
+		c.Net = "tcp"
+		in, _, err = c.Exchange(q, addr)
+	}
+	return in, err
+}
+
+// ttlValueStore records the TTLs of the IPNS records fetched through it.
+type ttlValueStore struct {
+	routing.ValueStore
+	ttls *NameTTLs
+}
+
+func (vs *ttlValueStore) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	val, err := vs.ValueStore.GetValue(ctx, key, opts...)
+	if err == nil {
+		vs.ttls.record(key, val)
+	}
+	return val, err
+}
+
+func (vs *ttlValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	vals, err := vs.ValueStore.SearchValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		// the values get better, the last one is the one resolved
+		for val := range vals {
+			vs.ttls.record(key, val)
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
 }
 
 // IpnsRepublisher runs new IPNS republisher service
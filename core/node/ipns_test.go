@@ -0,0 +1,69 @@
+package node
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serveDNS answers the TXT queries of the names of txt with their records, and
+// returns the config of a client of the server.
+func serveDNS(t *testing.T, txt map[string]dns.RR) *dns.ClientConfig {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+		if rr, ok := txt[q.Question[0].Name]; ok {
+			m.Answer = append(m.Answer, rr)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+
+	host, port, err := net.SplitHostPort(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dns.ClientConfig{Servers: []string{host}, Port: port}
+}
+
+func TestNameTTLsDNSLink(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	ttls := NewNameTTLs()
+	ttls.dnsConf = serveDNS(t, map[string]dns.RR{
+		"_dnslink.example.com.": rr(`_dnslink.example.com. 300 IN TXT "dnslink=/ipfs/bafkqaaa"`),
+		"example.com.":          rr(`example.com. 30 IN TXT "dnslink=/ipfs/bafkqaaa"`),
+		"example.org.":          rr(`example.org. 30 IN TXT "v=spf1 -all"`),
+	})
+
+	for _, name := range []string{"example.com.", "_dnslink.example.com.", "example.org."} {
+		txt, err := ttls.lookupTXT(name)
+		if err != nil || len(txt) != 1 {
+			t.Fatalf("%s: expected a TXT record, got %v, %v", name, txt, err)
+		}
+	}
+	if _, err := ttls.lookupTXT("_dnslink.example.org."); err == nil {
+		t.Fatal("expected the lookup of a missing name to fail")
+	}
+
+	if ttl, ok := ttls.DNSLinkTTL("example.com"); !ok || ttl <= 290*time.Second || ttl > 300*time.Second {
+		t.Fatalf("expected the TTL of the _dnslink record, got %s, %t", ttl, ok)
+	}
+	if _, ok := ttls.DNSLinkTTL("example.org"); ok {
+		t.Fatal("expected the TTL of records without a DNSLink to be unknown")
+	}
+}
@@ -1,13 +1,17 @@
 package node
 
 import (
+	"context"
+
 	"github.com/ipfs/go-datastore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	config "github.com/ipfs/go-ipfs-config"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-filestore"
+	"github.com/ipfs/go-ipfs/carstore"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/thirdparty/cidv0v1"
 	"github.com/ipfs/go-ipfs/thirdparty/verifbs"
@@ -26,9 +30,59 @@ func Datastore(repo repo.Repo) datastore.Datastore {
 // BaseBlocks is the lower level blockstore without GC or Filestore layers
 type BaseBlocks blockstore.Blockstore
 
+// GCWriteBarrier provides the write barrier used by concurrent garbage
+// collection to learn about blocks written while it is marking
+func GCWriteBarrier() *gc.WriteBarrier {
+	return gc.NewWriteBarrier()
+}
+
+// The garbage collection strategies of Datastore.GCStrategy, selecting which
+// unpinned blocks are removed by automatic garbage collection.
+const (
+	// GCStrategyAll removes all unpinned blocks. This is the default.
+	GCStrategyAll = "all"
+	// GCStrategyLRU removes the least recently used unpinned blocks until the
+	// storage target is reached.
+	GCStrategyLRU = "lru"
+)
+
+// GCAccessTimes tracks when blocks are accessed if the least recently used
+// garbage collection strategy is configured.
+func GCAccessTimes(repo repo.Repo, lc fx.Lifecycle) (*gc.AccessTimes, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Datastore.GCStrategy != GCStrategyLRU {
+		return nil, nil
+	}
+
+	at := gc.NewAccessTimes(repo.Datastore())
+	lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			return at.Flush()
+		},
+	})
+	return at, nil
+}
+
+// CarStore opens the CAR files registered as read-only blockstore backings
+func CarStore(repo repo.Repo, lc fx.Lifecycle) (*carstore.Store, error) {
+	cs, err := carstore.NewStore(repo.Datastore())
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			return cs.Close()
+		},
+	})
+	return cs, nil
+}
+
 // BaseBlockstoreCtor creates cached blockstore backed by the provided datastore
-func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
-	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, wb *gc.WriteBarrier, at *gc.AccessTimes, cs *carstore.Store, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+	return func(mctx helpers.MetricsCtx, repo repo.Repo, wb *gc.WriteBarrier, at *gc.AccessTimes, cs *carstore.Store, lc fx.Lifecycle) (bs BaseBlocks, err error) {
 		// hash security
 		bs = blockstore.NewBlockstore(repo.Datastore())
 		bs = &verifbs.VerifBS{Blockstore: bs}
@@ -40,6 +94,9 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 			}
 		}
 
+		// above the cache, whose bloom filter only knows the repo blocks
+		bs = cs.Blockstore(bs)
+
 		bs = blockstore.NewIdStore(bs)
 		bs = cidv0v1.NewBlockstore(bs)
 
@@ -47,27 +104,43 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 			bs.HashOnRead(true)
 		}
 
+		// record writes that bypass the GC blockstore, e.g. from the adder
+		bs = wb.Blockstore(bs)
+		if at != nil {
+			bs = at.Blockstore(bs)
+		}
+
 		return
 	}
 }
 
 // GcBlockstoreCtor wraps the base blockstore with GC and Filestore layers
-func GcBlockstoreCtor(bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore) {
+func GcBlockstoreCtor(bb BaseBlocks, at *gc.AccessTimes) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore) {
 	gclocker = blockstore.NewGCLocker()
-	gcbs = blockstore.NewGCBlockstore(bb, gclocker)
+	// accesses are recorded by the outermost layer, which the GC can read
+	// around
+	gcbs = blockstore.NewGCBlockstore(gc.Untracked(bb), gclocker)
+	if at != nil {
+		gcbs = at.GCBlockstore(gcbs)
+	}
 
 	bs = gcbs
 	return
 }
 
 // GcBlockstoreCtor wraps GcBlockstore and adds Filestore support
-func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
+func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks, wb *gc.WriteBarrier, at *gc.AccessTimes) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
 	gclocker = blockstore.NewGCLocker()
 
 	// hash security
-	fstore = filestore.NewFilestore(bb, repo.FileManager())
+	fstore = filestore.NewFilestore(gc.Untracked(bb), repo.FileManager())
 	gcbs = blockstore.NewGCBlockstore(fstore, gclocker)
 	gcbs = &verifbs.VerifBSGC{GCBlockstore: gcbs}
+	// filestore references never reach the base blockstore
+	gcbs = wb.GCBlockstore(gcbs)
+	if at != nil {
+		gcbs = at.GCBlockstore(gcbs)
+	}
 
 	bs = gcbs
 	return
@@ -0,0 +1,61 @@
+package denylist
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// DAGService returns a DAG service failing to get the nodes in the denylist,
+// for the content under a path not to serve the blocked content it links to,
+// nor resolve the paths going through it. Its sessions check the nodes too.
+func (d *Denylist) DAGService(ds ipld.DAGService) ipld.DAGService {
+	if d == nil {
+		return ds
+	}
+	return &dagService{DAGService: ds, getter: &nodeGetter{NodeGetter: ds, d: d}}
+}
+
+type dagService struct {
+	ipld.DAGService
+	getter *nodeGetter
+}
+
+func (s *dagService) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	return s.getter.Get(ctx, c)
+}
+
+func (s *dagService) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	return s.getter.GetMany(ctx, cids)
+}
+
+// Session implements ipld.SessionMaker, see merkledag.NewSession.
+func (s *dagService) Session(ctx context.Context) ipld.NodeGetter {
+	return &nodeGetter{NodeGetter: dag.NewSession(ctx, s.DAGService), d: s.getter.d}
+}
+
+type nodeGetter struct {
+	ipld.NodeGetter
+	d *Denylist
+}
+
+func (g *nodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if err := g.d.CheckCid(c); err != nil {
+		return nil, err
+	}
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func (g *nodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	for _, c := range cids {
+		if err := g.d.CheckCid(c); err != nil {
+			out := make(chan *ipld.NodeOption, 1)
+			out <- &ipld.NodeOption{Err: err}
+			close(out)
+			return out
+		}
+	}
+	return g.NodeGetter.GetMany(ctx, cids)
+}
@@ -0,0 +1,420 @@
+// Package denylist implements the list of the content a node refuses to
+// serve, such as after takedown requests.
+//
+// The list is a text file with an entry per line, blank lines and lines
+// starting with # being ignored:
+//
+//	/ipfs/{cid}          the content of the CID, and all the paths under it
+//	/ipfs/{cid}/{path}   the content at the path, and all the paths under it
+//	/ipns/{name}         an IPNS name or DNSLink domain, and all its paths
+//	//{hash}             an entry above double-hashed
+//
+// Double-hashed entries do not reveal what they block: the hash is the hex
+// encoded SHA-256 of the key of the entry, "{multihash}/{path}" for content,
+// with the multihash of the CID in base58 and the path without its leading
+// slash, and "/ipns/{name}" for names. Plain entries are checked through the
+// same keys, so that a CID blocks its content whatever the version and codec
+// of the CIDs it is requested with.
+//
+// The file is reloaded when it changes, so that it can be edited by hand or
+// replaced by a list kept up to date elsewhere.
+package denylist
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("denylist")
+
+// DefaultFile is the name of the denylist file in the repo.
+const DefaultFile = "denylist"
+
+// ReloadInterval is how often the file is checked for changes.
+var ReloadInterval = time.Second
+
+// ErrBlocked is returned for the content and names in the denylist.
+var ErrBlocked = errors.New("blocked by the denylist")
+
+// Entry is an entry of the denylist.
+type Entry struct {
+	// Line is the entry as written in the file.
+	Line string
+	// Hash is the double hash of the entry.
+	Hash string
+}
+
+// Denylist is the list of the content and names a node does not serve. The
+// methods of a nil Denylist block nothing.
+type Denylist struct {
+	file string
+
+	mu      sync.RWMutex
+	entries []Entry
+	hashes  map[string]bool
+
+	// the state of the file when loaded, to reload it when it changes
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+// Open loads the denylist from file, which may not exist yet. The denylist
+// is only kept in memory if file is empty.
+func Open(file string) (*Denylist, error) {
+	d := &Denylist{file: file, hashes: make(map[string]bool)}
+	if file == "" {
+		return d, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// load reads the file, skipping the invalid lines.
+func (d *Denylist) load() error {
+	d.checked = time.Now()
+	st, err := os.Stat(d.file)
+	if os.IsNotExist(err) {
+		d.entries, d.hashes = nil, make(map[string]bool)
+		d.modTime, d.size = time.Time{}, 0
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(d.file)
+	if err != nil {
+		return err
+	}
+
+	var entries []Entry
+	hashes := make(map[string]bool)
+	s := bufio.NewScanner(strings.NewReader(string(data)))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h, err := hashEntry(line)
+		if err != nil {
+			log.Warnf("%s:%d: %s", d.file, n, err)
+			continue
+		}
+		entries = append(entries, Entry{Line: line, Hash: h})
+		hashes[h] = true
+	}
+	d.entries, d.hashes = entries, hashes
+	d.modTime, d.size = st.ModTime(), st.Size()
+	return nil
+}
+
+// reload loads the file again if it changed since the last check, at most
+// once per ReloadInterval.
+func (d *Denylist) reload() {
+	d.mu.RLock()
+	due := d.file != "" && time.Since(d.checked) >= ReloadInterval
+	d.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.checked) < ReloadInterval {
+		return
+	}
+	d.checked = time.Now()
+	st, err := os.Stat(d.file)
+	switch {
+	case os.IsNotExist(err) && d.modTime.IsZero():
+		return
+	case err == nil && st.ModTime().Equal(d.modTime) && st.Size() == d.size:
+		return
+	}
+	if err := d.load(); err != nil {
+		log.Errorf("reloading %s: %s", d.file, err)
+	}
+}
+
+// Check returns an error wrapping ErrBlocked if p, an /ipfs/ or /ipns/ path,
+// or one of its parents, is in the denylist.
+func (d *Denylist) Check(p string) error {
+	if d == nil {
+		return nil
+	}
+	d.reload()
+
+	keys, err := pathKeys(p)
+	if err != nil {
+		// paths that cannot be parsed are not served anyway
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, k := range keys {
+		if d.hashes[hash(k)] {
+			return fmt.Errorf("%s is %w", p, ErrBlocked)
+		}
+	}
+	return nil
+}
+
+// CheckResolved returns an error wrapping ErrBlocked if the requested path,
+// the /ipfs/ path it resolved to, or the content it resolved to is in the
+// denylist.
+func (d *Denylist) CheckResolved(requested string, resolved ipath.Resolved) error {
+	if err := d.Check(requested); err != nil {
+		return err
+	}
+	if err := d.Check(resolved.String()); err != nil {
+		return fmt.Errorf("%s is %w", requested, ErrBlocked)
+	}
+	if err := d.CheckCid(resolved.Cid()); err != nil {
+		return fmt.Errorf("%s is %w", requested, ErrBlocked)
+	}
+	return nil
+}
+
+// CheckCid returns an error wrapping ErrBlocked if the content of c is in the
+// denylist.
+func (d *Denylist) CheckCid(c cid.Cid) error {
+	if d == nil {
+		return nil
+	}
+	d.reload()
+
+	d.mu.RLock()
+	blocked := d.hashes[hash(cidKey(c))]
+	d.mu.RUnlock()
+	if blocked {
+		return fmt.Errorf("/ipfs/%s is %w", c, ErrBlocked)
+	}
+	return nil
+}
+
+// List returns the entries of the denylist, in the order of the file.
+func (d *Denylist) List() []Entry {
+	if d == nil {
+		return nil
+	}
+	d.reload()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]Entry(nil), d.entries...)
+}
+
+// Add adds the entries to the denylist, double-hashed if hashed is true, and
+// returns those added. The entries already in the list are skipped.
+func (d *Denylist) Add(lines []string, hashed bool) ([]Entry, error) {
+	var added []Entry
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		h, err := hashEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		if hashed {
+			line = "//" + h
+		}
+		added = append(added, Entry{Line: line, Hash: h})
+	}
+
+	d.reload()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries := append([]Entry(nil), d.entries...)
+	seen := make(map[string]bool)
+	var newEntries []Entry
+	for _, e := range added {
+		if d.hashes[e.Hash] || seen[e.Hash] {
+			continue
+		}
+		seen[e.Hash] = true
+		entries = append(entries, e)
+		newEntries = append(newEntries, e)
+	}
+	if len(newEntries) == 0 {
+		return nil, nil
+	}
+	if err := d.write(entries); err != nil {
+		return nil, err
+	}
+	return newEntries, nil
+}
+
+// Remove removes the entries with the same key as the given ones, plain or
+// double-hashed, from the denylist, and returns those removed.
+func (d *Denylist) Remove(lines []string) ([]Entry, error) {
+	remove := make(map[string]bool)
+	for _, line := range lines {
+		h, err := hashEntry(strings.TrimSpace(line))
+		if err != nil {
+			return nil, err
+		}
+		remove[h] = true
+	}
+
+	d.reload()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var kept, removed []Entry
+	for _, e := range d.entries {
+		if remove[e.Hash] {
+			removed = append(removed, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if err := d.write(kept); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// write replaces the entries of the denylist and its file, keeping the
+// comments of the file.
+func (d *Denylist) write(entries []Entry) error {
+	if d.file != "" {
+		keep := make(map[string]bool)
+		for _, e := range entries {
+			keep[e.Line] = true
+		}
+
+		var b strings.Builder
+		written := make(map[string]bool)
+		data, err := ioutil.ReadFile(d.file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s := bufio.NewScanner(strings.NewReader(string(data)))
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line != "" && !strings.HasPrefix(line, "#") && !keep[line] {
+				continue
+			}
+			written[line] = true
+			b.WriteString(s.Text() + "\n")
+		}
+		for _, e := range entries {
+			if !written[e.Line] {
+				b.WriteString(e.Line + "\n")
+			}
+		}
+
+		// the file is replaced at once, for its readers not to see it
+		// half written
+		tmp, err := ioutil.TempFile(filepath.Dir(d.file), DefaultFile+"-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.WriteString(b.String()); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), d.file); err != nil {
+			return err
+		}
+		st, err := os.Stat(d.file)
+		if err != nil {
+			return err
+		}
+		d.modTime, d.size = st.ModTime(), st.Size()
+	}
+
+	d.entries = entries
+	d.hashes = make(map[string]bool, len(entries))
+	for _, e := range entries {
+		d.hashes[e.Hash] = true
+	}
+	return nil
+}
+
+// hashEntry returns the double hash of an entry of the file.
+func hashEntry(line string) (string, error) {
+	if strings.HasPrefix(line, "//") {
+		h := strings.ToLower(line[2:])
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return "", fmt.Errorf("invalid double-hashed entry %q", line)
+		}
+		return h, nil
+	}
+	if !strings.HasPrefix(line, "/") {
+		// a bare CID
+		line = "/ipfs/" + line
+	}
+	if strings.HasPrefix(line, "/ipns/") && strings.Count(strings.Trim(line, "/"), "/") > 1 {
+		return "", fmt.Errorf("invalid entry %q: the paths of names cannot be blocked, only whole names", line)
+	}
+	keys, err := pathKeys(line)
+	if err != nil {
+		return "", fmt.Errorf("invalid entry %q: %s", line, err)
+	}
+	return hash(keys[len(keys)-1]), nil
+}
+
+// pathKeys returns the keys of the path and its parents, the path last.
+func pathKeys(p string) ([]string, error) {
+	segs := strings.Split(strings.Trim(gopath.Clean("/"+p), "/"), "/")
+	if len(segs) < 2 {
+		return nil, fmt.Errorf("path %q has no CID or name", p)
+	}
+
+	switch segs[0] {
+	case "ipfs":
+		c, err := cid.Decode(segs[1])
+		if err != nil {
+			return nil, err
+		}
+		root := cidKey(c)
+		keys := []string{root}
+		for i := 3; i <= len(segs); i++ {
+			keys = append(keys, root+strings.Join(segs[2:i], "/"))
+		}
+		return keys, nil
+	case "ipns":
+		name := strings.ToLower(segs[1])
+		if id, err := peer.Decode(segs[1]); err == nil {
+			// the names of keys are the same in all their encodings
+			name = peer.ToCid(id).String()
+		}
+		return []string{"/ipns/" + name}, nil
+	default:
+		return nil, fmt.Errorf("path %q is neither an /ipfs/ nor an /ipns/ path", p)
+	}
+}
+
+// cidKey returns the key of the content of c, the same for all the CIDs of
+// its multihash.
+func cidKey(c cid.Cid) string {
+	return c.Hash().B58String() + "/"
+}
+
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,111 @@
+package denylist
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestDenylist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, DefaultFile)
+	if err := ioutil.WriteFile(file, []byte("# takedowns\n\n/ipns/Example.net\nnot-a-cid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := d.List(); len(entries) != 1 || entries[0].Line != "/ipns/Example.net" {
+		t.Fatalf("expected the valid entry to be loaded, got %v", entries)
+	}
+
+	hash, err := mh.Sum([]byte("blocked"), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v0 := cid.NewCidV0(hash)
+	v1 := cid.NewCidV1(cid.DagProtobuf, hash)
+	otherHash, err := mh.Sum([]byte("other"), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := cid.NewCidV1(cid.DagProtobuf, otherHash)
+	otherRaw := cid.NewCidV1(cid.Raw, otherHash)
+
+	added, err := d.Add([]string{"/ipfs/" + v0.String() + "/a/b", other.String()}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 2 || !strings.HasPrefix(added[0].Line, "//") || strings.Contains(added[0].Line, v0.String()) {
+		t.Fatalf("expected the entries to be double-hashed, got %v", added)
+	}
+	if added, err := d.Add([]string{"/ipfs/" + v1.String() + "/a/b/"}, false); err != nil || len(added) != 0 {
+		t.Fatalf("expected the entry to be there already, got %v, %v", added, err)
+	}
+	if _, err := d.Add([]string{"/ipns/example.net/a"}, false); err == nil {
+		t.Fatal("expected the paths of names not to be blocked")
+	}
+
+	for p, blocked := range map[string]bool{
+		"/ipfs/" + v1.String() + "/a/b":      true,
+		"/ipfs/" + v0.String() + "/a/b/c":    true,
+		"/ipfs/" + v1.String() + "/a":        false,
+		"/ipfs/" + v0.String():               false,
+		"/ipfs/" + other.String() + "/a":     true,
+		"/ipfs/" + otherRaw.String():         true,
+		"/ipns/example.net/index.html":       true,
+		"/ipns/www.example.net/index.html":   false,
+		"/ipfs/" + v0.String() + "/a/../a/b": true,
+	} {
+		err := d.Check(p)
+		if blocked != errors.Is(err, ErrBlocked) {
+			t.Errorf("expected %s to be blocked: %v, got %v", p, blocked, err)
+		}
+	}
+
+	removed, err := d.Remove([]string{"/ipns/example.net", "/ipfs/" + v1.String() + "/a/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || d.Check("/ipns/example.net") != nil {
+		t.Fatalf("expected the name and path to be removed, got %v", removed)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# takedowns\n") || len(d.List()) != 1 {
+		t.Fatalf("expected the comments and the other entry to be kept, got %q", data)
+	}
+
+	// the file is reloaded when it changes
+	ReloadInterval = 0
+	defer func() { ReloadInterval = time.Second }()
+	if err := ioutil.WriteFile(file, []byte("/ipns/other.example.net\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Check("/ipns/other.example.net"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected the file to be reloaded, got %v", err)
+	}
+	if err := d.CheckCid(otherRaw); err != nil {
+		t.Fatalf("expected the entries gone from the file to be removed, got %v", err)
+	}
+
+	var nilList *Denylist
+	if err := nilList.Check("/ipns/other.example.net"); err != nil {
+		t.Fatalf("expected a nil denylist to block nothing, got %v", err)
+	}
+}
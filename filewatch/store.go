@@ -0,0 +1,135 @@
+// Package filewatch keeps directories added to the filestore in sync with
+// MFS, adding their files again as they are created, modified or removed.
+package filewatch
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+)
+
+var (
+	watchPrefix = ds.NewKey("/local/filestore/watch/dirs")
+	filesPrefix = ds.NewKey("/local/filestore/watch/files")
+)
+
+// Watch is a directory kept in sync with an MFS directory.
+type Watch struct {
+	// Dir is the absolute path of the watched directory.
+	Dir string
+	// MFSPath is the MFS directory mirroring Dir.
+	MFSPath string
+	Created time.Time
+}
+
+func (w *Watch) id() string {
+	sum := sha256.Sum256([]byte(w.Dir))
+	return hex.EncodeToString(sum[:16])
+}
+
+// fileState is what was added for a file, so that the file is not hashed
+// again while its size and modification time stay the same.
+type fileState struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Cid     cid.Cid
+}
+
+var pathEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func watchKey(w *Watch) ds.Key {
+	return watchPrefix.ChildString(w.id())
+}
+
+func fileKey(w *Watch, rel string) ds.Key {
+	return filesPrefix.ChildString(w.id()).ChildString(pathEncoding.EncodeToString([]byte(rel)))
+}
+
+// store keeps the watches and the state of their files in a datastore.
+type store struct {
+	dstore ds.Datastore
+}
+
+func (s *store) watches() ([]*Watch, error) {
+	res, err := s.dstore.Query(query.Query{Prefix: watchPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var watches []*Watch
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		w := new(Watch)
+		if err := json.Unmarshal(r.Value, w); err != nil {
+			return nil, err
+		}
+		watches = append(watches, w)
+	}
+	return watches, nil
+}
+
+func (s *store) putWatch(w *Watch) error {
+	v, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return s.dstore.Put(watchKey(w), v)
+}
+
+// removeWatch removes w and the state of its files.
+func (s *store) removeWatch(w *Watch) error {
+	states, err := s.files(w)
+	if err != nil {
+		return err
+	}
+	for rel := range states {
+		if err := s.dstore.Delete(fileKey(w, rel)); err != nil {
+			return err
+		}
+	}
+	return s.dstore.Delete(watchKey(w))
+}
+
+// files returns the state of the files of w by path.
+func (s *store) files(w *Watch) (map[string]*fileState, error) {
+	res, err := s.dstore.Query(query.Query{Prefix: filesPrefix.ChildString(w.id()).String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	states := make(map[string]*fileState)
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		st := new(fileState)
+		if err := json.Unmarshal(r.Value, st); err != nil {
+			return nil, err
+		}
+		states[st.Path] = st
+	}
+	return states, nil
+}
+
+func (s *store) putFile(w *Watch, st *fileState) error {
+	v, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.dstore.Put(fileKey(w, st.Path), v)
+}
+
+func (s *store) removeFile(w *Watch, rel string) error {
+	return s.dstore.Delete(fileKey(w, rel))
+}
@@ -0,0 +1,547 @@
+package filewatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fsnotify "github.com/fsnotify/fsnotify"
+	ds "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+	mfs "github.com/ipfs/go-mfs"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+)
+
+var log = logging.Logger("filestore/watch")
+
+// DefaultDebounce is how long a watched directory must stay quiet before its
+// changes are synced.
+const DefaultDebounce = time.Second
+
+// maxDelay bounds, in debounce periods, how long changes wait for a directory
+// that never stays quiet.
+const maxDelay = 10
+
+var (
+	// ErrWatched is returned when adding a watch for a directory that is
+	// already watched.
+	ErrWatched = errors.New("directory is already watched")
+	// ErrNotWatched is returned when removing a watch that does not exist.
+	ErrNotWatched = errors.New("directory is not watched")
+)
+
+// SyncStats counts what a sync of a watched directory did.
+type SyncStats struct {
+	Added     int
+	Unchanged int
+	Removed   int
+}
+
+// Manager keeps the watched directories in sync with MFS. Watches are
+// persisted, and synced only while the manager runs, after Start.
+type Manager struct {
+	store store
+	bs    bstore.GCBlockstore
+	dag   ipld.DAGService
+	root  *mfs.Root
+
+	// Debounce is how long a watched directory must stay quiet before its
+	// changes are synced.
+	Debounce time.Duration
+
+	lk      sync.Mutex
+	ctx     context.Context
+	running map[string]context.CancelFunc
+}
+
+// NewManager returns the manager of the watches stored in the given
+// datastore, adding files to the given blockstore and MFS root.
+func NewManager(dstore ds.Datastore, bs bstore.GCBlockstore, dag ipld.DAGService, root *mfs.Root) *Manager {
+	return &Manager{
+		store:    store{dstore: dstore},
+		bs:       bs,
+		dag:      dag,
+		root:     root,
+		Debounce: DefaultDebounce,
+		running:  make(map[string]context.CancelFunc),
+	}
+}
+
+// List returns the watches, ordered by directory.
+func (m *Manager) List() ([]*Watch, error) {
+	watches, err := m.store.watches()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(watches, func(i, j int) bool { return watches[i].Dir < watches[j].Dir })
+	return watches, nil
+}
+
+func (m *Manager) get(dir string) (*Watch, error) {
+	watches, err := m.store.watches()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range watches {
+		if w.Dir == dir {
+			return w, nil
+		}
+	}
+	return nil, nil
+}
+
+// Add watches dir, mirroring it at mfsPath. The directory is synced before
+// Add returns, and kept in sync afterwards if the manager runs.
+func (m *Manager) Add(ctx context.Context, dir, mfsPath string) (*Watch, SyncStats, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, SyncStats{}, fmt.Errorf("%s is not an absolute path", dir)
+	}
+	if !strings.HasPrefix(mfsPath, "/") {
+		return nil, SyncStats{}, fmt.Errorf("MFS path %s is not absolute", mfsPath)
+	}
+	w := &Watch{
+		Dir:     filepath.Clean(dir),
+		MFSPath: gopath.Clean(mfsPath),
+		Created: time.Now(),
+	}
+	if w.MFSPath == "/" {
+		return nil, SyncStats{}, errors.New("cannot mirror a directory at the MFS root")
+	}
+	if st, err := os.Stat(w.Dir); err != nil {
+		return nil, SyncStats{}, err
+	} else if !st.IsDir() {
+		return nil, SyncStats{}, fmt.Errorf("%s is not a directory", w.Dir)
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if existing, err := m.get(w.Dir); err != nil {
+		return nil, SyncStats{}, err
+	} else if existing != nil {
+		return nil, SyncStats{}, ErrWatched
+	}
+
+	if err := m.store.putWatch(w); err != nil {
+		return nil, SyncStats{}, err
+	}
+	s, err := m.newSyncer(w)
+	if err == nil {
+		err = s.syncAll(ctx)
+	}
+	if err != nil {
+		if rmErr := m.store.removeWatch(w); rmErr != nil {
+			log.Errorf("removing the watch of %s: %s", w.Dir, rmErr)
+		}
+		return nil, SyncStats{}, err
+	}
+
+	if m.ctx != nil {
+		m.start(s)
+	}
+	return w, s.stats, nil
+}
+
+// Remove stops watching dir. What was mirrored in MFS is left in place.
+func (m *Manager) Remove(dir string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	w, err := m.get(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		return ErrNotWatched
+	}
+	if cancel, ok := m.running[w.Dir]; ok {
+		cancel()
+		delete(m.running, w.Dir)
+	}
+	return m.store.removeWatch(w)
+}
+
+// Start syncs and watches all the watched directories until ctx is done.
+func (m *Manager) Start(ctx context.Context) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.ctx != nil {
+		return errors.New("watches already started")
+	}
+	m.ctx = ctx
+
+	watches, err := m.store.watches()
+	if err != nil {
+		return err
+	}
+	for _, w := range watches {
+		s, err := m.newSyncer(w)
+		if err != nil {
+			return err
+		}
+		m.start(s)
+	}
+	return nil
+}
+
+// start runs s in the background. m.lk must be held.
+func (m *Manager) start(s *syncer) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.running[s.w.Dir] = cancel
+	go func() {
+		if err := s.run(ctx, m.Debounce); err != nil && ctx.Err() == nil {
+			log.Errorf("watching %s: %s", s.w.Dir, err)
+		}
+	}()
+}
+
+func (m *Manager) newSyncer(w *Watch) (*syncer, error) {
+	states, err := m.store.files(w)
+	if err != nil {
+		return nil, err
+	}
+	return &syncer{m: m, w: w, states: states}, nil
+}
+
+// syncer syncs one watched directory. It is not safe for concurrent use.
+type syncer struct {
+	m      *Manager
+	w      *Watch
+	states map[string]*fileState
+	stats  SyncStats
+
+	// watcher is notified of the changes in the directories met while
+	// syncing, if set.
+	watcher *fsnotify.Watcher
+}
+
+// run syncs the whole directory, then syncs the changed paths as they are
+// notified, once they stayed quiet for debounce.
+func (s *syncer) run(ctx context.Context, debounce time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	s.watcher = watcher
+
+	// the watches are set up while walking the directory, so that nothing
+	// changed during the walk is missed
+	if err := s.syncAll(ctx); err != nil {
+		return err
+	}
+
+	pending := make(map[string]struct{})
+	var first time.Time
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			rel, err := filepath.Rel(s.w.Dir, e.Name)
+			if err != nil || rel == "." || hidden(rel) {
+				continue
+			}
+			now := time.Now()
+			if len(pending) == 0 {
+				first = now
+			}
+			pending[rel] = struct{}{}
+
+			wait := debounce
+			if left := first.Add(maxDelay * debounce).Sub(now); left < wait {
+				wait = left
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Errorf("watching %s: %s", s.w.Dir, err)
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for rel := range pending {
+				paths = append(paths, rel)
+			}
+			pending = make(map[string]struct{})
+			sort.Strings(paths)
+
+			s.stats = SyncStats{}
+			if err := s.sync(ctx, paths); err != nil {
+				log.Errorf("syncing %s: %s", s.w.Dir, err)
+				continue
+			}
+			log.Debugf("synced %s: %d added, %d removed", s.w.Dir, s.stats.Added, s.stats.Removed)
+		}
+	}
+}
+
+// syncAll syncs the whole directory, removing from MFS the files that are
+// gone since the last sync.
+func (s *syncer) syncAll(ctx context.Context) error {
+	seen := make(map[string]bool)
+	err := s.walk(ctx, "", seen)
+	if err == nil {
+		for rel := range s.states {
+			if !seen[rel] {
+				if err = s.remove(rel); err != nil {
+					break
+				}
+			}
+		}
+	}
+	return s.flush(ctx, err)
+}
+
+// sync syncs the given paths, relative to the directory.
+func (s *syncer) sync(ctx context.Context, paths []string) error {
+	var err error
+	for _, rel := range paths {
+		if err = s.syncPath(ctx, rel); err != nil {
+			break
+		}
+	}
+	return s.flush(ctx, err)
+}
+
+func (s *syncer) flush(ctx context.Context, err error) error {
+	if _, ferr := mfs.FlushPath(ctx, s.m.root, s.w.MFSPath); err == nil && ferr != nil && ferr != os.ErrNotExist {
+		err = ferr
+	}
+	return err
+}
+
+func (s *syncer) syncPath(ctx context.Context, rel string) error {
+	st, err := os.Lstat(filepath.Join(s.w.Dir, rel))
+	switch {
+	case os.IsNotExist(err):
+		return s.remove(rel)
+	case err != nil:
+		return err
+	case st.IsDir():
+		return s.walk(ctx, rel, nil)
+	case st.Mode().IsRegular():
+		return s.syncFile(ctx, rel, st)
+	default:
+		return nil
+	}
+}
+
+// walk syncs the files under the directory rel, marking them in seen when
+// set.
+func (s *syncer) walk(ctx context.Context, rel string, seen map[string]bool) error {
+	return filepath.Walk(filepath.Join(s.w.Dir, rel), func(p string, st os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(s.w.Dir, p)
+		if err != nil {
+			return err
+		}
+		if rel != "." && hidden(rel) {
+			if st.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case st.IsDir():
+			if s.watcher != nil {
+				return s.watcher.Add(p)
+			}
+		case st.Mode().IsRegular():
+			if seen != nil {
+				seen[rel] = true
+			}
+			return s.syncFile(ctx, rel, st)
+		}
+		return nil
+	})
+}
+
+// syncFile adds the file rel, unless its size and modification time did not
+// change since it was last added.
+func (s *syncer) syncFile(ctx context.Context, rel string, st os.FileInfo) error {
+	// GC must not remove the added blocks before they are linked in MFS
+	defer s.m.bs.PinLock().Unlock()
+
+	mfsPath := s.mfsPath(rel)
+	if state, ok := s.states[rel]; ok && state.Size == st.Size() && state.ModTime.Equal(st.ModTime()) {
+		// the file is not hashed again, only linked back if MFS changed
+		if fsn, err := mfs.Lookup(s.m.root, mfsPath); err == nil {
+			if nd, err := fsn.GetNode(); err == nil && nd.Cid().Equals(state.Cid) {
+				s.stats.Unchanged++
+				return nil
+			}
+		}
+		nd, err := s.m.dag.Get(ctx, state.Cid)
+		if err == nil {
+			s.stats.Unchanged++
+			return s.put(mfsPath, nd)
+		}
+		log.Debugf("adding %s again: %s", rel, err)
+	}
+
+	nd, err := s.add(ctx, rel, st)
+	if err != nil {
+		return err
+	}
+	if err := s.put(mfsPath, nd); err != nil {
+		return err
+	}
+	state := &fileState{
+		Path:    rel,
+		Size:    st.Size(),
+		ModTime: st.ModTime(),
+		Cid:     nd.Cid(),
+	}
+	if err := s.m.store.putFile(s.w, state); err != nil {
+		return err
+	}
+	s.states[rel] = state
+	s.stats.Added++
+	return nil
+}
+
+// add adds the file rel to the filestore, as 'ipfs add --nocopy' does.
+func (s *syncer) add(ctx context.Context, rel string, st os.FileInfo) (ipld.Node, error) {
+	p := filepath.Join(s.w.Dir, rel)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := files.NewReaderPathFile(p, f, st)
+	if err != nil {
+		return nil, err
+	}
+	spl, err := chunk.FromString(file, "")
+	if err != nil {
+		return nil, err
+	}
+	bufferedDS := ipld.NewBufferedDAG(ctx, s.m.dag)
+	params := ihelper.DagBuilderParams{
+		Dagserv:   bufferedDS,
+		RawLeaves: true,
+		Maxlinks:  ihelper.DefaultLinksPerBlock,
+		NoCopy:    true,
+	}
+	db, err := params.New(spl)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return nil, err
+	}
+	return nd, bufferedDS.Commit()
+}
+
+// remove removes rel, a file or a directory, from MFS.
+func (s *syncer) remove(rel string) error {
+	removed := false
+	for p := range s.states {
+		if p == rel || strings.HasPrefix(p, rel+string(filepath.Separator)) {
+			if err := s.m.store.removeFile(s.w, p); err != nil {
+				return err
+			}
+			delete(s.states, p)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	dir, name := gopath.Split(s.mfsPath(rel))
+	fsn, err := mfs.Lookup(s.m.root, dir)
+	if err == os.ErrNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pdir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil
+	}
+	if err := pdir.Unlink(name); err != nil {
+		if err == os.ErrNotExist {
+			return nil
+		}
+		return err
+	}
+	s.stats.Removed++
+	return pdir.Flush()
+}
+
+// put links nd at mfsPath, replacing what is there.
+func (s *syncer) put(mfsPath string, nd ipld.Node) error {
+	dir, name := gopath.Split(mfsPath)
+	if err := mfs.Mkdir(s.m.root, dir, mfs.MkdirOpts{Mkparents: true}); err != nil {
+		return err
+	}
+	fsn, err := mfs.Lookup(s.m.root, dir)
+	if err != nil {
+		return err
+	}
+	pdir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := pdir.Unlink(name); err != nil && err != os.ErrNotExist {
+		return err
+	}
+	if err := pdir.AddChild(name, nd); err != nil {
+		return err
+	}
+	return pdir.Flush()
+}
+
+func (s *syncer) mfsPath(rel string) string {
+	return gopath.Join(s.w.MFSPath, filepath.ToSlash(rel))
+}
+
+// hidden returns whether a path relative to the watched directory is hidden,
+// or in a hidden directory, such as the swap files of editors.
+func hidden(rel string) bool {
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
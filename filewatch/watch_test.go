@@ -0,0 +1,153 @@
+package filewatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	filestore "github.com/ipfs/go-filestore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	dag "github.com/ipfs/go-merkledag"
+	mfs "github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root, err := ioutil.TempDir("", "filewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "dir")
+	write := func(rel, data string) {
+		p := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", "a")
+	write("sub/b", "b")
+	write(".hidden", "c")
+
+	dstore := syncds.MutexWrap(ds.NewMapDatastore())
+	fm := filestore.NewFileManager(dstore, root)
+	fm.AllowFiles = true
+	fs := filestore.NewFilestore(bstore.NewBlockstore(dstore), fm)
+	bs := bstore.NewGCBlockstore(fs, bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	mroot, err := mfs.NewRoot(ctx, dserv, ft.EmptyDirNode(), func(context.Context, cid.Cid) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(p string) cid.Cid {
+		fsn, err := mfs.Lookup(mroot, p)
+		if err == os.ErrNotExist {
+			return cid.Undef
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		nd, err := fsn.GetNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nd.Cid()
+	}
+
+	m := NewManager(dstore, bs, dserv, mroot)
+	w, stats, err := m.Add(ctx, dir, "/data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.MFSPath != "/data" || stats != (SyncStats{Added: 2}) {
+		t.Fatalf("expected a and sub/b to be added at /data, got %s %+v", w.MFSPath, stats)
+	}
+	if _, _, err := m.Add(ctx, dir, "/other"); err != ErrWatched {
+		t.Fatalf("expected %s, got %v", ErrWatched, err)
+	}
+	a := lookup("/data/a")
+	if !a.Defined() || !lookup("/data/sub/b").Defined() || lookup("/data/.hidden").Defined() {
+		t.Fatal("expected a and sub/b to be mirrored, without .hidden")
+	}
+	if has, err := fm.Has(a); err != nil || !has {
+		t.Fatalf("expected a to be in the filestore, got %v, %v", has, err)
+	}
+
+	// a restart only adds the changed files
+	write("a", "aa")
+	if err := os.Chtimes(filepath.Join(dir, "a"), time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	write("c", "c")
+	s, err := NewManager(dstore, bs, dserv, mroot).newSyncer(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.stats != (SyncStats{Added: 2, Removed: 1}) {
+		t.Fatalf("expected a and c to be added and sub/b removed, got %+v", s.stats)
+	}
+	if a2 := lookup("/data/a"); !a2.Defined() || a2 == a {
+		t.Fatalf("expected a to be updated, got %s", a2)
+	}
+	if lookup("/data/sub/b").Defined() {
+		t.Fatal("expected sub/b to be removed")
+	}
+	s.stats = SyncStats{}
+	if err := s.syncAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.stats != (SyncStats{Unchanged: 2}) {
+		t.Fatalf("expected nothing to be added again, got %+v", s.stats)
+	}
+
+	// changes are synced while the manager runs
+	m.Debounce = 20 * time.Millisecond
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for i := 0; !cond(); i++ {
+			if i == 500 {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	write("new/d", "d")
+	waitFor("new/d to be added", func() bool { return lookup("/data/new/d").Defined() })
+	if err := os.Remove(filepath.Join(dir, "c")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("c to be removed", func() bool { return !lookup("/data/c").Defined() })
+
+	if err := m.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if watches, err := m.List(); err != nil || len(watches) != 0 {
+		t.Fatalf("expected no watch, got %v, %v", watches, err)
+	}
+	if states, err := m.store.files(w); err != nil || len(states) != 0 {
+		t.Fatalf("expected the state of the files to be removed, got %v, %v", states, err)
+	}
+}
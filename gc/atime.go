@@ -0,0 +1,277 @@
+package gc
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var atimePrefix = ds.NewKey("/local/gc/atime")
+
+const (
+	// atimeResolution is how precisely access times are tracked, it is also
+	// the granularity of the eviction order.
+	atimeResolution = time.Minute
+
+	// atimeFlushThreshold is the number of pending access times above which
+	// they are written to the datastore.
+	atimeFlushThreshold = 16 << 10
+)
+
+// AccessTimes records when blocks were last written or read, so that the
+// garbage collector can evict the least recently used ones first, see Evict.
+//
+// Accesses are buffered in memory and written to the datastore in batches;
+// Flush should be called before shutting down.
+type AccessTimes struct {
+	dstore ds.Datastore
+	now    func() time.Time
+
+	lk        sync.Mutex
+	pending   map[string]int64    // by multihash bytes
+	forgotten map[string]struct{} // the keys forgotten while flushing
+	// flushing is set while a flush started by Touch runs
+	flushing bool
+
+	flushLk sync.Mutex
+}
+
+// NewAccessTimes returns the access times stored in the given datastore.
+func NewAccessTimes(dstore ds.Datastore) *AccessTimes {
+	return &AccessTimes{
+		dstore:  dstore,
+		now:     time.Now,
+		pending: make(map[string]int64),
+	}
+}
+
+// Blockstore wraps the given blockstore so that reads and writes through it
+// are recorded.
+func (at *AccessTimes) Blockstore(bs bstore.Blockstore) bstore.Blockstore {
+	return &atimeBlockstore{Blockstore: bs, at: at}
+}
+
+// GCBlockstore wraps the given GC blockstore so that reads and writes through
+// it are recorded.
+func (at *AccessTimes) GCBlockstore(bs bstore.GCBlockstore) bstore.GCBlockstore {
+	return &atimeGCBlockstore{GCBlockstore: bs, at: at}
+}
+
+// Untracked returns the blockstore wrapped by AccessTimes.Blockstore, whose
+// reads and writes are not recorded, or bs if it is not wrapped.
+func Untracked(bs bstore.Blockstore) bstore.Blockstore {
+	if abs, ok := bs.(*atimeBlockstore); ok {
+		return abs.Blockstore
+	}
+	return bs
+}
+
+// Touch records an access to the given blocks.
+func (at *AccessTimes) Touch(keys ...cid.Cid) {
+	now := at.now().Truncate(atimeResolution).Unix()
+
+	at.lk.Lock()
+	for _, k := range keys {
+		at.pending[string(k.Hash())] = now
+	}
+	// a single flush runs at a time, the accesses recorded meanwhile wait
+	// for the next one
+	flush := len(at.pending) >= atimeFlushThreshold && !at.flushing
+	if flush {
+		at.flushing = true
+	}
+	at.lk.Unlock()
+
+	if flush {
+		go func() {
+			err := at.Flush()
+
+			at.lk.Lock()
+			at.flushing = false
+			at.lk.Unlock()
+
+			if err != nil {
+				log.Errorf("failed to write block access times: %s", err)
+			}
+		}()
+	}
+}
+
+// LastAccess returns when the given block was last accessed. The zero time
+// is returned for blocks that were never recorded.
+func (at *AccessTimes) LastAccess(c cid.Cid) (time.Time, error) {
+	at.lk.Lock()
+	t, ok := at.pending[string(c.Hash())]
+	at.lk.Unlock()
+	if ok {
+		return time.Unix(t, 0), nil
+	}
+
+	v, err := at.dstore.Get(atimeKey(c))
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return time.Time{}, nil
+	default:
+		return time.Time{}, err
+	}
+
+	t, n := binary.Varint(v)
+	if n <= 0 {
+		return time.Time{}, errors.New("gc: invalid access time")
+	}
+	return time.Unix(t, 0), nil
+}
+
+// Forget drops the access time of a removed block.
+func (at *AccessTimes) Forget(c cid.Cid) error {
+	k := string(c.Hash())
+	at.lk.Lock()
+	delete(at.pending, k)
+	if at.forgotten != nil {
+		at.forgotten[k] = struct{}{}
+	}
+	at.lk.Unlock()
+
+	return at.dstore.Delete(atimeKey(c))
+}
+
+// Flush writes the pending access times to the datastore.
+func (at *AccessTimes) Flush() error {
+	at.flushLk.Lock()
+	defer at.flushLk.Unlock()
+
+	at.lk.Lock()
+	pending := at.pending
+	at.pending = make(map[string]int64)
+	if len(pending) > 0 {
+		at.forgotten = make(map[string]struct{})
+	}
+	at.lk.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := at.write(pending)
+
+	// the blocks removed meanwhile may have had their access times
+	// written back, after Forget deleted them
+	at.lk.Lock()
+	forgotten := at.forgotten
+	at.forgotten = nil
+	at.lk.Unlock()
+	for k := range forgotten {
+		if _, ok := pending[k]; !ok {
+			continue
+		}
+		if derr := at.dstore.Delete(atimeKeyOf(k)); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
+func (at *AccessTimes) write(pending map[string]int64) error {
+	b, err := batching(at.dstore)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	for k, t := range pending {
+		v := append([]byte(nil), buf[:binary.PutVarint(buf, t)]...)
+		if err := b.Put(atimeKeyOf(k), v); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+func atimeKey(c cid.Cid) ds.Key {
+	return atimeKeyOf(string(c.Hash()))
+}
+
+// atimeKeyOf returns the datastore key of the access time of the block with
+// the given multihash bytes.
+func atimeKeyOf(k string) ds.Key {
+	return atimePrefix.ChildString(mh.Multihash(k).B58String())
+}
+
+type atimeBlockstore struct {
+	bstore.Blockstore
+	at *AccessTimes
+}
+
+func (bs *atimeBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	b, err := bs.Blockstore.Get(c)
+	if err == nil {
+		bs.at.Touch(c)
+	}
+	return b, err
+}
+
+func (bs *atimeBlockstore) Put(b blocks.Block) error {
+	err := bs.Blockstore.Put(b)
+	if err == nil {
+		bs.at.Touch(b.Cid())
+	}
+	return err
+}
+
+func (bs *atimeBlockstore) PutMany(blks []blocks.Block) error {
+	err := bs.Blockstore.PutMany(blks)
+	if err == nil {
+		bs.at.Touch(blockKeys(blks)...)
+	}
+	return err
+}
+
+func (bs *atimeBlockstore) DeleteBlock(c cid.Cid) error {
+	if err := bs.Blockstore.DeleteBlock(c); err != nil {
+		return err
+	}
+	return bs.at.Forget(c)
+}
+
+type atimeGCBlockstore struct {
+	bstore.GCBlockstore
+	at *AccessTimes
+}
+
+func (bs *atimeGCBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	b, err := bs.GCBlockstore.Get(c)
+	if err == nil {
+		bs.at.Touch(c)
+	}
+	return b, err
+}
+
+func (bs *atimeGCBlockstore) Put(b blocks.Block) error {
+	err := bs.GCBlockstore.Put(b)
+	if err == nil {
+		bs.at.Touch(b.Cid())
+	}
+	return err
+}
+
+func (bs *atimeGCBlockstore) PutMany(blks []blocks.Block) error {
+	err := bs.GCBlockstore.PutMany(blks)
+	if err == nil {
+		bs.at.Touch(blockKeys(blks)...)
+	}
+	return err
+}
+
+func (bs *atimeGCBlockstore) DeleteBlock(c cid.Cid) error {
+	if err := bs.GCBlockstore.DeleteBlock(c); err != nil {
+		return err
+	}
+	return bs.at.Forget(c)
+}
@@ -0,0 +1,133 @@
+package gc
+
+import (
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// WriteBarrier records the keys of all blocks written while a concurrent
+// garbage collection is marking the live set. The collector treats every
+// recorded block as an additional root so that content written after the mark
+// started is never swept.
+//
+// A WriteBarrier is shared by all the blockstores wrapped with it, and only
+// one concurrent collection may use it at a time.
+type WriteBarrier struct {
+	// running serializes concurrent collections using this barrier.
+	running sync.Mutex
+
+	lk     sync.RWMutex
+	active bool
+	keys   *cid.Set
+}
+
+// NewWriteBarrier returns an inactive write barrier.
+func NewWriteBarrier() *WriteBarrier {
+	return &WriteBarrier{}
+}
+
+// Blockstore wraps the given blockstore so that writes to it are recorded
+// by the barrier.
+func (wb *WriteBarrier) Blockstore(bs bstore.Blockstore) bstore.Blockstore {
+	return &barrierBlockstore{Blockstore: bs, wb: wb}
+}
+
+// GCBlockstore wraps the given GC blockstore so that writes to it are
+// recorded by the barrier.
+func (wb *WriteBarrier) GCBlockstore(bs bstore.GCBlockstore) bstore.GCBlockstore {
+	return &barrierGCBlockstore{GCBlockstore: bs, wb: wb}
+}
+
+// start activates the barrier. It blocks while another collection is using
+// the barrier.
+func (wb *WriteBarrier) start() {
+	wb.running.Lock()
+
+	wb.lk.Lock()
+	wb.active = true
+	wb.keys = cid.NewSet()
+	wb.lk.Unlock()
+}
+
+// stop deactivates the barrier and forgets all recorded keys.
+func (wb *WriteBarrier) stop() {
+	wb.lk.Lock()
+	wb.active = false
+	wb.keys = nil
+	wb.lk.Unlock()
+
+	wb.running.Unlock()
+}
+
+// record adds the given keys to the barrier if it is active.
+func (wb *WriteBarrier) record(keys ...cid.Cid) {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+
+	if !wb.active {
+		return
+	}
+	for _, k := range keys {
+		wb.keys.Add(k)
+	}
+}
+
+// has returns whether the given key was written since the barrier started.
+func (wb *WriteBarrier) has(k cid.Cid) bool {
+	wb.lk.RLock()
+	defer wb.lk.RUnlock()
+
+	return wb.active && wb.keys.Has(k)
+}
+
+// recorded returns the keys written since the barrier started.
+func (wb *WriteBarrier) recorded() []cid.Cid {
+	wb.lk.RLock()
+	defer wb.lk.RUnlock()
+
+	if !wb.active {
+		return nil
+	}
+	return wb.keys.Keys()
+}
+
+type barrierBlockstore struct {
+	bstore.Blockstore
+	wb *WriteBarrier
+}
+
+func (bs *barrierBlockstore) Put(b blocks.Block) error {
+	bs.wb.record(b.Cid())
+	return bs.Blockstore.Put(b)
+}
+
+func (bs *barrierBlockstore) PutMany(blks []blocks.Block) error {
+	bs.wb.record(blockKeys(blks)...)
+	return bs.Blockstore.PutMany(blks)
+}
+
+type barrierGCBlockstore struct {
+	bstore.GCBlockstore
+	wb *WriteBarrier
+}
+
+func (bs *barrierGCBlockstore) Put(b blocks.Block) error {
+	bs.wb.record(b.Cid())
+	return bs.GCBlockstore.Put(b)
+}
+
+func (bs *barrierGCBlockstore) PutMany(blks []blocks.Block) error {
+	bs.wb.record(blockKeys(blks)...)
+	return bs.GCBlockstore.PutMany(blks)
+}
+
+func blockKeys(blks []blocks.Block) []cid.Cid {
+	keys := make([]cid.Cid, len(blks))
+	for i, b := range blks {
+		keys[i] = b.Cid()
+	}
+	return keys
+}
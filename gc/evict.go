@@ -0,0 +1,123 @@
+package gc
+
+import (
+	"context"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// Evict makes the collector only remove the least recently used unpinned
+// blocks, according to the given access times, until at least the given
+// number of bytes is freed. Blocks that were never accessed since access
+// times are recorded are removed first.
+func Evict(at *AccessTimes, bytes uint64) Option {
+	return func(o *options) {
+		o.evict = &evictOptions{at: at, bytes: bytes}
+	}
+}
+
+type evictOptions struct {
+	at    *AccessTimes
+	bytes uint64
+}
+
+// evictor selects the unpinned blocks to remove to free a number of bytes,
+// oldest access first.
+type evictor struct {
+	bs bstore.Blockstore
+	at *AccessTimes
+
+	// blocks accessed before cutoff are removed, and the ones accessed at
+	// cutoff while budget lasts.
+	cutoff int64
+	budget uint64
+}
+
+// newEvictor goes over all the unpinned blocks to find out the access time
+// up to which blocks must be removed to free the requested bytes.
+func newEvictor(ctx context.Context, bs bstore.Blockstore, o *evictOptions, live func(cid.Cid) bool) (*evictor, error) {
+	if err := o.at.Flush(); err != nil {
+		return nil, err
+	}
+
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// bytes of unpinned blocks by access time
+	sizes := make(map[int64]uint64)
+	for k := range keys {
+		if live(k) {
+			continue
+		}
+		t, err := o.at.LastAccess(k)
+		if err != nil {
+			return nil, err
+		}
+		size, err := bs.GetSize(k)
+		if err != nil {
+			continue // removed meanwhile
+		}
+		sizes[accessBucket(t.Unix())] += uint64(size)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	buckets := make([]int64, 0, len(sizes))
+	for t := range sizes {
+		buckets = append(buckets, t)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	e := &evictor{bs: bs, at: o.at}
+	var total uint64
+	for _, t := range buckets {
+		if total+sizes[t] >= o.bytes {
+			e.cutoff = t
+			e.budget = o.bytes - total
+			return e, nil
+		}
+		total += sizes[t]
+	}
+
+	// not enough unpinned blocks, remove them all.
+	if len(buckets) > 0 {
+		e.cutoff = buckets[len(buckets)-1]
+		e.budget = sizes[e.cutoff]
+	}
+	return e, nil
+}
+
+// selects returns whether the given unpinned block should be removed.
+func (e *evictor) selects(k cid.Cid) (bool, error) {
+	t, err := e.at.LastAccess(k)
+	if err != nil {
+		return false, err
+	}
+
+	switch b := accessBucket(t.Unix()); {
+	case b < e.cutoff:
+		return true, nil
+	case b > e.cutoff || e.budget == 0:
+		return false, nil
+	}
+
+	size, err := e.bs.GetSize(k)
+	if err != nil {
+		return false, err
+	}
+	if uint64(size) >= e.budget {
+		e.budget = 0
+	} else {
+		e.budget -= uint64(size)
+	}
+	return true, nil
+}
+
+func accessBucket(unix int64) int64 {
+	return unix / int64(atimeResolution.Seconds())
+}
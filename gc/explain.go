@@ -0,0 +1,119 @@
+package gc
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Reasons for a block to be retained, as reported by Explain.
+const (
+	RetainedDirect     = "direct"
+	RetainedRecursive  = "recursive"
+	RetainedBestEffort = "best-effort"
+	RetainedInternal   = "internal"
+	// RetainedCheckpoint is reported for the leaves recorded by the
+	// checkpoint of an interrupted add, which are kept until it is stale.
+	RetainedCheckpoint = "checkpoint"
+)
+
+// Retention describes why a block is kept by the garbage collector.
+type Retention struct {
+	// Reason is one of the Retained* constants.
+	Reason string
+	// Root is the pin or best effort root keeping the block.
+	Root cid.Cid
+	// Path lists the blocks linking Root to the block, both included.
+	Path []cid.Cid
+}
+
+// Explain returns every reason for the given block to be kept by a garbage
+// collection using the given pinner and best effort roots. It returns no
+// retention if the block would be removed.
+//
+// Unlike ColoredSet, each root is walked separately so that all the pins
+// keeping the block are reported. This is meant for auditing a repo and is
+// about as expensive as a full mark.
+func Explain(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, c cid.Cid) ([]Retention, error) {
+	var retained []Retention
+
+	dkeys, err := pn.DirectKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range dkeys {
+		if sameBlock(k, c) {
+			retained = append(retained, Retention{Reason: RetainedDirect, Root: k, Path: []cid.Cid{k}})
+		}
+	}
+
+	rkeys, err := pn.RecursiveKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ikeys, err := pn.InternalPins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, roots := range []struct {
+		reason     string
+		keys       []cid.Cid
+		bestEffort bool
+	}{
+		{RetainedRecursive, rkeys, false},
+		{RetainedBestEffort, bestEffortRoots, true},
+		{RetainedInternal, ikeys, false},
+	} {
+		for _, root := range roots.keys {
+			path, err := findPath(ctx, ng, root, c, cid.NewSet(), roots.bestEffort)
+			if err != nil {
+				return nil, &CannotFetchLinksError{root, err}
+			}
+			if path != nil {
+				retained = append(retained, Retention{Reason: roots.reason, Root: root, Path: path})
+			}
+		}
+	}
+
+	return retained, nil
+}
+
+// findPath returns the blocks linking root to target, both included, or nil
+// if target is not reachable from root. Missing blocks are skipped when
+// bestEffort is set.
+func findPath(ctx context.Context, ng ipld.NodeGetter, root, target cid.Cid, visited *cid.Set, bestEffort bool) ([]cid.Cid, error) {
+	if sameBlock(root, target) {
+		return []cid.Cid{root}, nil
+	}
+	if !visited.Visit(root) || root.Type() == cid.Raw {
+		return nil, nil
+	}
+
+	links, err := ipld.GetLinks(ctx, ng, root)
+	if err != nil {
+		if bestEffort && err == ipld.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, l := range links {
+		path, err := findPath(ctx, ng, l.Cid, target, visited, bestEffort)
+		if err != nil {
+			return nil, err
+		}
+		if path != nil {
+			return append([]cid.Cid{root}, path...), nil
+		}
+	}
+	return nil, nil
+}
+
+// sameBlock returns whether both cids refer to the same block in the
+// blockstore, which is keyed by multihash.
+func sameBlock(a, b cid.Cid) bool {
+	return string(a.Hash()) == string(b.Hash())
+}
@@ -22,12 +22,51 @@ import (
 var log = logging.Logger("gc")
 
 // Result represents an incremental output from a garbage collection
-// run.  It contains either an error, or the cid of a removed object.
+// run.  It contains either an error, or the cid of a removed object. In a
+// dry run, the object is only reported and not actually removed.
 type Result struct {
 	KeyRemoved cid.Cid
 	Error      error
 }
 
+// Option configures a garbage collection run.
+type Option func(*options)
+
+type options struct {
+	barrier *WriteBarrier
+	index   *Index
+	dryRun  bool
+	evict   *evictOptions
+}
+
+// Concurrent makes the collector mark the live set without holding the GC
+// lock. Blocks written while marking are recorded by the given write barrier
+// and kept, along with their descendants. The GC lock is only taken at the
+// end of the run to pick up pins added in the meantime and to sweep.
+func Concurrent(wb *WriteBarrier) Option {
+	return func(o *options) {
+		o.barrier = wb
+	}
+}
+
+// WithIndex makes the collector use the given reference count index, when it
+// is valid, to tell which blocks are reachable from the recursive pins instead
+// of walking them.
+func WithIndex(idx *Index) Option {
+	return func(o *options) {
+		o.index = idx
+	}
+}
+
+// DryRun makes the collector report the blocks it would remove without
+// removing them. A dry run never takes the GC lock, so it does not block
+// writers, but blocks added or pinned while it runs may be reported.
+func DryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
 // GC performs a mark and sweep garbage collection of the blocks in the blockstore
 // first, it creates a 'marked' set and adds to it the following:
 // - all recursively pinned blocks, plus all of their descendants (recursively)
@@ -37,12 +76,34 @@ type Result struct {
 //
 // The routine then iterates over every block in the blockstore and
 // deletes any block that is not found in the marked set.
-func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid) <-chan Result {
+//
+// By default the GC lock is held for the whole run, see Concurrent for a mode
+// that only holds it during the sweep.
+func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid, opts ...Option) <-chan Result {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
-	unlocker := bs.GCLock()
+	var unlocker bstore.Unlocker
+	switch {
+	case o.dryRun:
+		// nothing gets deleted, no need to block writers.
+		o.barrier = nil
+	case o.barrier != nil:
+		o.barrier.start()
+	default:
+		unlocker = bs.GCLock()
+	}
 
-	bsrv := bserv.New(bs, offline.Exchange(bs))
+	// the mark reads every pinned block, which are not accesses to record
+	var reads bstore.Blockstore = bs
+	if abs, ok := bs.(*atimeGCBlockstore); ok {
+		reads = abs.GCBlockstore
+	}
+	bsrv := bserv.New(reads, offline.Exchange(reads))
 	ds := dag.NewDAGService(bsrv)
 
 	output := make(chan Result, 128)
@@ -50,9 +111,30 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 	go func() {
 		defer cancel()
 		defer close(output)
-		defer unlocker.Unlock()
+		if o.barrier != nil {
+			defer o.barrier.stop()
+		}
+		if unlocker != nil {
+			defer unlocker.Unlock()
+		}
 
-		gcs, err := ColoredSet(ctx, pn, ds, bestEffortRoots, output)
+		indexed := false
+		if o.index != nil {
+			var err error
+			indexed, err = o.index.Valid()
+			if err != nil {
+				select {
+				case output <- Result{Error: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			if !indexed {
+				log.Warn(ErrIndexInvalid)
+			}
+		}
+
+		gcs, err := coloredSet(ctx, pn, ds, bestEffortRoots, !indexed, output)
 		if err != nil {
 			select {
 			case output <- Result{Error: err}:
@@ -60,6 +142,80 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 			}
 			return
 		}
+
+		if o.barrier != nil {
+			unlocker := bs.GCLock()
+			defer unlocker.Unlock()
+
+			// pin changes may have invalidated the index since the
+			// mark started.
+			if indexed {
+				indexed, err = o.index.Valid()
+				if err != nil {
+					select {
+					case output <- Result{Error: err}:
+					case <-ctx.Done():
+					}
+					return
+				}
+			}
+
+			err = remark(ctx, pn, ds, o.barrier, gcs, !indexed, output)
+			if err != nil {
+				select {
+				case output <- Result{Error: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+
+		errors := false
+		reportErr := func(err error) bool {
+			errors = true
+			select {
+			case output <- Result{Error: err}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// live returns whether a block must be kept.
+		live := func(k cid.Cid) (bool, error) {
+			if gcs.Has(k) {
+				return true, nil
+			}
+			if indexed {
+				pinned, err := o.index.Has(k)
+				if err != nil || pinned {
+					return true, err
+				}
+			}
+			// Writes are not blocked by the GC lock, keep anything
+			// that showed up since the re-mark.
+			if o.barrier != nil && o.barrier.has(k) {
+				return true, nil
+			}
+			return false, nil
+		}
+
+		var ev *evictor
+		if o.evict != nil {
+			ev, err = newEvictor(ctx, bs, o.evict, func(k cid.Cid) bool {
+				// errors are reported by the sweep
+				keep, err := live(k)
+				return keep || err != nil
+			})
+			if err != nil {
+				select {
+				case output <- Result{Error: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+
 		keychan, err := bs.AllKeysChan(ctx)
 		if err != nil {
 			select {
@@ -69,7 +225,6 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 			return
 		}
 
-		errors := false
 		var removed uint64
 
 	loop:
@@ -79,24 +234,52 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 				if !ok {
 					break loop
 				}
-				if !gcs.Has(k) {
-					err := bs.DeleteBlock(k)
-					removed++
+				keep, err := live(k)
+				if err != nil {
+					if !reportErr(&CannotDeleteBlockError{k, err}) {
+						break loop
+					}
+					continue loop
+				}
+				if keep {
+					continue loop
+				}
+				if ev != nil {
+					evict, err := ev.selects(k)
 					if err != nil {
-						errors = true
-						select {
-						case output <- Result{Error: &CannotDeleteBlockError{k, err}}:
-						case <-ctx.Done():
+						if !reportErr(&CannotDeleteBlockError{k, err}) {
 							break loop
 						}
-						// continue as error is non-fatal
 						continue loop
 					}
+					if !evict {
+						continue loop
+					}
+				}
+				if o.dryRun {
 					select {
 					case output <- Result{KeyRemoved: k}:
 					case <-ctx.Done():
 						break loop
 					}
+					continue loop
+				}
+				err = bs.DeleteBlock(k)
+				removed++
+				if err != nil {
+					errors = true
+					select {
+					case output <- Result{Error: &CannotDeleteBlockError{k, err}}:
+					case <-ctx.Done():
+						break loop
+					}
+					// continue as error is non-fatal
+					continue loop
+				}
+				select {
+				case output <- Result{KeyRemoved: k}:
+				case <-ctx.Done():
+					break loop
 				}
 			case <-ctx.Done():
 				break loop
@@ -110,6 +293,10 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 			}
 		}
 
+		if o.dryRun {
+			return
+		}
+
 		gds, ok := dstor.(dstore.GCDatastore)
 		if !ok {
 			return
@@ -128,6 +315,61 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 	return output
 }
 
+// remark extends a set computed by ColoredSet without the GC lock held with
+// everything that became live while it was being computed: the pins added in
+// the meantime and the blocks recorded by the write barrier, plus all of
+// their descendants. Recursive pins are skipped when walkRecursive is false.
+// It must be called with the GC lock held.
+//
+// Subtrees that are already in the set are not walked again, so the cost is
+// proportional to what changed during the mark.
+func remark(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, wb *WriteBarrier, gcs *cid.Set, walkRecursive bool, output chan<- Result) error {
+	errors := false
+	getLinks := func(ctx context.Context, cid cid.Cid) ([]*ipld.Link, error) {
+		links, err := ipld.GetLinks(ctx, ng, cid)
+		if err != nil && err != ipld.ErrNotFound {
+			errors = true
+			select {
+			case output <- Result{Error: &CannotFetchLinksError{cid, err}}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return links, nil
+	}
+
+	var roots []cid.Cid
+	if walkRecursive {
+		rkeys, err := pn.RecursiveKeys(ctx)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, rkeys...)
+	}
+	ikeys, err := pn.InternalPins(ctx)
+	if err != nil {
+		return err
+	}
+	roots = append(roots, ikeys...)
+	roots = append(roots, wb.recorded()...)
+	if err := Descendants(ctx, getLinks, gcs, roots); err != nil {
+		return err
+	}
+
+	dkeys, err := pn.DirectKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range dkeys {
+		gcs.Add(k)
+	}
+
+	if errors {
+		return ErrCannotFetchAllLinks
+	}
+	return nil
+}
+
 // Descendants recursively finds all the descendants of the given roots and
 // adds them to the given cid.Set, using the provided dag.GetLinks function
 // to walk the tree.
@@ -168,6 +410,12 @@ func Descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots
 // ColoredSet computes the set of nodes in the graph that are pinned by the
 // pins in the given pinner.
 func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, output chan<- Result) (*cid.Set, error) {
+	return coloredSet(ctx, pn, ng, bestEffortRoots, true, output)
+}
+
+// coloredSet is ColoredSet, leaving out the descendants of the recursive pins
+// when walkRecursive is false.
+func coloredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, walkRecursive bool, output chan<- Result) (*cid.Set, error) {
 	// KeySet currently implemented in memory, in the future, may be bloom filter or
 	// disk backed to conserve memory.
 	errors := false
@@ -184,17 +432,19 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		}
 		return links, nil
 	}
-	rkeys, err := pn.RecursiveKeys(ctx)
-	if err != nil {
-		return nil, err
-	}
-	err = Descendants(ctx, getLinks, gcs, rkeys)
-	if err != nil {
-		errors = true
-		select {
-		case output <- Result{Error: err}:
-		case <-ctx.Done():
-			return nil, ctx.Err()
+	if walkRecursive {
+		rkeys, err := pn.RecursiveKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = Descendants(ctx, getLinks, gcs, rkeys)
+		if err != nil {
+			errors = true
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
 		}
 	}
 
@@ -210,7 +460,7 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		}
 		return links, nil
 	}
-	err = Descendants(ctx, bestEffortGetLinks, gcs, bestEffortRoots)
+	err := Descendants(ctx, bestEffortGetLinks, gcs, bestEffortRoots)
 	if err != nil {
 		errors = true
 		select {
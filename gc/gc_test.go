@@ -0,0 +1,465 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	mh "github.com/multiformats/go-multihash"
+)
+
+type testRepo struct {
+	dstore ds.Batching
+	bs     bstore.GCBlockstore
+	dserv  ipld.DAGService
+	pinner pin.Pinner
+	wb     *WriteBarrier
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	wb := NewWriteBarrier()
+	bs := wb.GCBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker()))
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{dstore: dstore, bs: bs, dserv: dserv, pinner: pinner, wb: wb}
+}
+
+func (r *testRepo) add(t *testing.T, data string, links ...ipld.Node) ipld.Node {
+	t.Helper()
+
+	nd := dag.NodeWithData([]byte(data))
+	for _, l := range links {
+		if err := nd.AddNodeLink(l.Cid().String(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.dserv.Add(context.Background(), nd); err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+func (r *testRepo) has(t *testing.T, c cid.Cid) bool {
+	t.Helper()
+
+	has, err := r.bs.Has(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return has
+}
+
+func collect(t *testing.T, out <-chan Result) []cid.Cid {
+	t.Helper()
+
+	var removed []cid.Cid
+	for res := range out {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed = append(removed, res.KeyRemoved)
+	}
+	return removed
+}
+
+// duringMark runs a callback the first time the live set is computed, to
+// simulate activity happening while a concurrent collection is marking.
+type duringMark struct {
+	pin.Pinner
+	fn func()
+}
+
+func (p *duringMark) RecursiveKeys(ctx context.Context) ([]cid.Cid, error) {
+	keys, err := p.Pinner.RecursiveKeys(ctx)
+	if p.fn != nil {
+		p.fn()
+		p.fn = nil
+	}
+	return keys, err
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	leaf := r.add(t, "leaf")
+	pinned := r.add(t, "pinned", leaf)
+	unpinned := r.add(t, "unpinned")
+	if err := r.pinner.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := collect(t, GC(ctx, r.bs, r.dstore, r.pinner, nil))
+	if len(removed) != 1 || !removed[0].Equals(unpinned.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", unpinned.Cid(), removed)
+	}
+	if !r.has(t, pinned.Cid()) || !r.has(t, leaf.Cid()) {
+		t.Fatal("pinned blocks were removed")
+	}
+}
+
+func TestConcurrentGC(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	unpinned := r.add(t, "unpinned")
+	existing := r.add(t, "existing")
+	var written, pinnedLater ipld.Node
+
+	pn := &duringMark{Pinner: r.pinner, fn: func() {
+		// The GC lock is not held while marking so writes and
+		// pins go through.
+		written = r.add(t, "written during mark", existing)
+		pinnedLater = r.add(t, "pinned during mark")
+		if err := r.pinner.Pin(ctx, pinnedLater, true); err != nil {
+			t.Fatal(err)
+		}
+	}}
+
+	removed := collect(t, GC(ctx, r.bs, r.dstore, pn, nil, Concurrent(r.wb)))
+	if len(removed) != 1 || !removed[0].Equals(unpinned.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", unpinned.Cid(), removed)
+	}
+	for _, nd := range []ipld.Node{written, existing, pinnedLater} {
+		if !r.has(t, nd.Cid()) {
+			t.Errorf("%s was removed", nd.Cid())
+		}
+	}
+
+	// The barrier is released once the run is over.
+	if r.wb.has(written.Cid()) {
+		t.Fatal("write barrier still active after gc")
+	}
+	removed = collect(t, GC(ctx, r.bs, r.dstore, r.pinner, nil, Concurrent(r.wb)))
+	if len(removed) != 2 {
+		t.Fatalf("expected the blocks kept by the barrier to be removed, got %v", removed)
+	}
+}
+
+// noWalk fails the collection if it asks for the recursive pins.
+type noWalk struct {
+	pin.Pinner
+}
+
+func (p *noWalk) RecursiveKeys(ctx context.Context) ([]cid.Cid, error) {
+	return nil, errors.New("recursive pins were walked")
+}
+
+func TestIndexedGC(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	idx, err := NewIndex(r.dstore, r.dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Rebuild(ctx, r.pinner); err != nil {
+		t.Fatal(err)
+	}
+	pn := IndexedPinner(r.pinner, idx)
+
+	shared := r.add(t, "shared")
+	a := r.add(t, "a", shared)
+	b := r.add(t, "b", shared)
+	unpinned := r.add(t, "unpinned")
+	if err := pn.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	pn.PinWithMode(b.Cid(), pin.Recursive)
+	if err := pn.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := collect(t, GC(ctx, r.bs, r.dstore, &noWalk{pn}, nil, WithIndex(idx)))
+	if len(removed) != 1 || !removed[0].Equals(unpinned.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", unpinned.Cid(), removed)
+	}
+
+	// shared is still referenced by b.
+	if err := pn.Unpin(ctx, a.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	removed = collect(t, GC(ctx, r.bs, r.dstore, &noWalk{pn}, nil, WithIndex(idx)))
+	if len(removed) != 1 || !removed[0].Equals(a.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", a.Cid(), removed)
+	}
+	if !r.has(t, shared.Cid()) || !r.has(t, b.Cid()) {
+		t.Fatal("pinned blocks were removed")
+	}
+}
+
+func TestIndexDirty(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	idx, err := NewIndex(r.dstore, r.dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Rebuild(ctx, r.pinner); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of a pin change
+	if err := idx.begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err = NewIndex(r.dstore, r.dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := idx.Valid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Fatal("index should be invalid after an interrupted update")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	unpinned := r.add(t, "unpinned")
+
+	removed := collect(t, GC(ctx, r.bs, r.dstore, r.pinner, nil, DryRun()))
+	if len(removed) != 1 || !removed[0].Equals(unpinned.Cid()) {
+		t.Fatalf("expected %s to be reported, got %v", unpinned.Cid(), removed)
+	}
+	if !r.has(t, unpinned.Cid()) {
+		t.Fatal("dry run removed a block")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	leaf := r.add(t, "leaf")
+	mid := r.add(t, "mid", leaf)
+	root := r.add(t, "root", mid)
+	mfsRoot := r.add(t, "mfs", leaf)
+	unpinned := r.add(t, "unpinned")
+	if err := r.pinner.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.pinner.Pin(ctx, leaf, false); err != nil {
+		t.Fatal(err)
+	}
+
+	retained, err := Explain(ctx, r.pinner, r.dserv, []cid.Cid{mfsRoot.Cid()}, leaf.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retained) != 3 {
+		t.Fatalf("expected 3 reasons, got %v", retained)
+	}
+	for i, reason := range []string{RetainedDirect, RetainedRecursive, RetainedBestEffort} {
+		if retained[i].Reason != reason {
+			t.Errorf("expected reason %d to be %s, got %s", i, reason, retained[i].Reason)
+		}
+	}
+	if path := retained[1].Path; len(path) != 3 || !path[0].Equals(root.Cid()) || !path[1].Equals(mid.Cid()) {
+		t.Errorf("unexpected path %v", path)
+	}
+
+	retained, err = Explain(ctx, r.pinner, r.dserv, []cid.Cid{mfsRoot.Cid()}, unpinned.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retained) != 0 {
+		t.Fatalf("expected %s not to be retained, got %v", unpinned.Cid(), retained)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	at := NewAccessTimes(r.dstore)
+	bs := at.GCBlockstore(r.bs)
+	now := time.Unix(1600000000, 0)
+	at.now = func() time.Time { return now }
+
+	old := r.add(t, "old")
+	recent := r.add(t, "recent")
+	pinned := r.add(t, "pinned")
+	if err := r.pinner.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+	at.Touch(old.Cid(), pinned.Cid())
+	now = now.Add(time.Hour)
+	at.Touch(recent.Cid())
+
+	// freeing a single byte only takes the oldest unpinned block
+	removed := collect(t, GC(ctx, bs, r.dstore, r.pinner, nil, Evict(at, 1)))
+	if len(removed) != 1 || !removed[0].Equals(old.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", old.Cid(), removed)
+	}
+	if last, err := at.LastAccess(old.Cid()); err != nil || !last.IsZero() {
+		t.Fatalf("access time of a removed block was kept: %v %v", last, err)
+	}
+
+	removed = collect(t, GC(ctx, bs, r.dstore, r.pinner, nil, Evict(at, 1<<20)))
+	if len(removed) != 1 || !removed[0].Equals(recent.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", recent.Cid(), removed)
+	}
+	if !r.has(t, pinned.Cid()) {
+		t.Fatal("pinned block was evicted")
+	}
+}
+
+// putHookDatastore calls onPut before each write, as another goroutine
+// could.
+type putHookDatastore struct {
+	ds.Datastore
+	onPut func(ds.Key)
+}
+
+func (d *putHookDatastore) Put(k ds.Key, v []byte) error {
+	d.onPut(k)
+	return d.Datastore.Put(k, v)
+}
+
+func TestAccessTimesForgetDuringFlush(t *testing.T) {
+	r := newTestRepo(t)
+	removed := r.add(t, "removed")
+	kept := r.add(t, "kept")
+
+	hooked := &putHookDatastore{Datastore: r.dstore}
+	at := NewAccessTimes(hooked)
+	forgotten := false
+	hooked.onPut = func(ds.Key) {
+		if !forgotten {
+			forgotten = true
+			if err := at.Forget(removed.Cid()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	at.Touch(removed.Cid(), kept.Cid())
+	if err := at.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if last, err := at.LastAccess(removed.Cid()); err != nil || !last.IsZero() {
+		t.Fatalf("access time of a block forgotten during the flush was written back: %v %v", last, err)
+	}
+	if last, err := at.LastAccess(kept.Cid()); err != nil || last.IsZero() {
+		t.Fatalf("expected the access time to be written: %v %v", last, err)
+	}
+}
+
+func TestAccessTimesSingleFlush(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	hooked := &putHookDatastore{Datastore: dssync.MutexWrap(ds.NewMapDatastore()), onPut: func(ds.Key) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+	}}
+	at := NewAccessTimes(hooked)
+
+	var n int
+	touch := func(count int) {
+		keys := make([]cid.Cid, count)
+		for i := range keys {
+			h, err := mh.Sum([]byte(fmt.Sprint(n)), mh.SHA2_256, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys[i] = cid.NewCidV1(cid.Raw, h)
+			n++
+		}
+		at.Touch(keys...)
+	}
+	pending := func() int {
+		at.lk.Lock()
+		defer at.lk.Unlock()
+		return len(at.pending)
+	}
+	waitFlushed := func() {
+		t.Helper()
+		for i := 0; ; i++ {
+			at.lk.Lock()
+			flushing := at.flushing
+			at.lk.Unlock()
+			if !flushing {
+				return
+			}
+			if i > 1000 {
+				t.Fatal("the flush did not end")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	touch(atimeFlushThreshold)
+	<-started
+	// the accesses recorded during the flush do not start another one
+	touch(atimeFlushThreshold)
+	close(release)
+	waitFlushed()
+	if p := pending(); p != atimeFlushThreshold {
+		t.Fatalf("expected the accesses recorded during the flush to be pending, got %d", p)
+	}
+
+	touch(1)
+	waitFlushed()
+	if p := pending(); p != 0 {
+		t.Fatalf("expected the pending accesses to be flushed, got %d", p)
+	}
+}
+
+func TestAccessTimesNotTouchedByMark(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	at := NewAccessTimes(r.dstore)
+	bs := at.GCBlockstore(r.bs)
+	now := time.Unix(1600000000, 0)
+	at.now = func() time.Time { return now }
+
+	child := r.add(t, "child")
+	root := r.add(t, "root", child)
+	if err := r.pinner.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	at.Touch(root.Cid(), child.Cid())
+	touched, err := at.LastAccess(root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+
+	collect(t, GC(ctx, bs, r.dstore, r.pinner, nil))
+	for _, c := range []cid.Cid{root.Cid(), child.Cid()} {
+		if last, err := at.LastAccess(c); err != nil || !last.Equal(touched) {
+			t.Fatalf("the mark recorded an access to %s: %v %v", c, last, err)
+		}
+	}
+}
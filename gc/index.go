@@ -0,0 +1,296 @@
+package gc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var (
+	indexPrefix   = ds.NewKey("/local/gc/index")
+	indexValidKey = indexPrefix.ChildString("valid")
+	indexDirtyKey = indexPrefix.ChildString("dirty")
+	indexRefs     = indexPrefix.ChildString("refs")
+	indexRoots    = indexPrefix.ChildString("roots")
+)
+
+// ErrIndexInvalid is returned when the reference count index does not
+// reflect the current pins and must be rebuilt before being used.
+var ErrIndexInvalid = errors.New("gc index is out of date, run 'ipfs repo gc --rebuild-index'")
+
+// Index is a persistent reference count index of the blocks reachable from
+// the recursive pins. Each block is counted once per recursive pin whose DAG
+// contains it, so the garbage collector can tell whether a block is pinned
+// without walking any pinned DAG.
+//
+// The index is kept up to date by wrapping the pinner with IndexedPinner.
+// Updates are bracketed by a persisted dirty marker, so that an index left
+// half updated by a crash is detected and invalidated when it is opened again.
+type Index struct {
+	lk     sync.Mutex
+	dstore ds.Datastore
+	ng     ipld.NodeGetter
+
+	inflightLk sync.Mutex
+	inflight   int
+}
+
+// NewIndex opens the index stored in the given datastore. Blocks are read
+// from ng when counting the descendants of a root, it should not fetch them
+// from the network.
+func NewIndex(dstore ds.Datastore, ng ipld.NodeGetter) (*Index, error) {
+	idx := &Index{dstore: dstore, ng: ng}
+
+	dirty, err := dstore.Has(indexDirtyKey)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		log.Warn("gc index was not cleanly updated, it needs to be rebuilt")
+		if err := idx.Invalidate(); err != nil {
+			return nil, err
+		}
+		if err := dstore.Delete(indexDirtyKey); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// Valid returns whether the index reflects the current pins.
+func (idx *Index) Valid() (bool, error) {
+	return idx.dstore.Has(indexValidKey)
+}
+
+// Invalidate marks the index as out of date, e.g. because the pins were
+// modified without maintaining it.
+func (idx *Index) Invalidate() error {
+	return idx.dstore.Delete(indexValidKey)
+}
+
+// begin marks the start of a pin change that the index must follow. Every
+// call must be matched by a call to end once the index was updated.
+func (idx *Index) begin() error {
+	idx.inflightLk.Lock()
+	defer idx.inflightLk.Unlock()
+
+	if idx.inflight == 0 {
+		if err := idx.dstore.Put(indexDirtyKey, []byte{}); err != nil {
+			return err
+		}
+	}
+	idx.inflight++
+	return nil
+}
+
+// end marks the index as updated for a pin change started with begin.
+func (idx *Index) end() {
+	idx.inflightLk.Lock()
+	defer idx.inflightLk.Unlock()
+
+	idx.inflight--
+	if idx.inflight == 0 {
+		if err := idx.dstore.Delete(indexDirtyKey); err != nil {
+			log.Errorf("failed to mark gc index clean: %s", err)
+		}
+	}
+}
+
+// Has returns whether the given block is reachable from a recursive pin.
+func (idx *Index) Has(c cid.Cid) (bool, error) {
+	return idx.dstore.Has(refKey(c))
+}
+
+// Add counts the blocks reachable from the given recursively pinned root. It
+// does nothing if the root is already counted.
+func (idx *Index) Add(ctx context.Context, root cid.Cid) error {
+	has, err := idx.dstore.Has(rootKey(root))
+	if err != nil || has {
+		return err
+	}
+
+	// walk before taking the lock, other updates can proceed meanwhile.
+	set := cid.NewSet()
+	if err := Descendants(ctx, idx.getLinks, set, []cid.Cid{root}); err != nil {
+		return err
+	}
+
+	idx.lk.Lock()
+	defer idx.lk.Unlock()
+
+	// re-check, the root may have been added while walking
+	has, err = idx.dstore.Has(rootKey(root))
+	if err != nil || has {
+		return err
+	}
+	return idx.update(set, 1, rootKey(root), true)
+}
+
+// Remove uncounts the blocks reachable from the given root. It does nothing
+// if the root is not counted.
+func (idx *Index) Remove(ctx context.Context, root cid.Cid) error {
+	has, err := idx.dstore.Has(rootKey(root))
+	if err != nil || !has {
+		return err
+	}
+
+	set := cid.NewSet()
+	if err := Descendants(ctx, idx.getLinks, set, []cid.Cid{root}); err != nil {
+		return err
+	}
+
+	idx.lk.Lock()
+	defer idx.lk.Unlock()
+
+	has, err = idx.dstore.Has(rootKey(root))
+	if err != nil || !has {
+		return err
+	}
+	return idx.update(set, -1, rootKey(root), false)
+}
+
+// Rebuild recomputes the index from the recursive pins of the given pinner
+// and marks it valid. Pins must not change while the index is rebuilt, the
+// caller should hold the GC lock.
+func (idx *Index) Rebuild(ctx context.Context, pn pin.Pinner) error {
+	if err := idx.Invalidate(); err != nil {
+		return err
+	}
+
+	idx.lk.Lock()
+	err := idx.clear()
+	idx.lk.Unlock()
+	if err != nil {
+		return err
+	}
+
+	rkeys, err := pn.RecursiveKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range rkeys {
+		if err := idx.Add(ctx, k); err != nil {
+			return err
+		}
+	}
+
+	return idx.dstore.Put(indexValidKey, []byte{})
+}
+
+func (idx *Index) getLinks(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+	// raw blocks never have links, don't read them back.
+	if c.Type() == cid.Raw {
+		return nil, nil
+	}
+	return ipld.GetLinks(ctx, idx.ng, c)
+}
+
+// update applies delta to the count of every block in set and records or
+// forgets the root. It must be called with the lock held.
+func (idx *Index) update(set *cid.Set, delta int, root ds.Key, addRoot bool) error {
+	b, err := batching(idx.dstore)
+	if err != nil {
+		return err
+	}
+
+	err = set.ForEach(func(c cid.Cid) error {
+		k := refKey(c)
+		count, err := idx.count(k)
+		if err != nil {
+			return err
+		}
+
+		count += delta
+		if count <= 0 {
+			return b.Delete(k)
+		}
+		return b.Put(k, encodeCount(count))
+	})
+	if err != nil {
+		return err
+	}
+
+	if addRoot {
+		err = b.Put(root, []byte{})
+	} else {
+		err = b.Delete(root)
+	}
+	if err != nil {
+		return err
+	}
+	return b.Commit()
+}
+
+func (idx *Index) count(k ds.Key) (int, error) {
+	v, err := idx.dstore.Get(k)
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return 0, nil
+	default:
+		return 0, err
+	}
+
+	count, n := binary.Uvarint(v)
+	if n <= 0 {
+		return 0, errors.New("gc index: invalid reference count")
+	}
+	return int(count), nil
+}
+
+// clear removes all counts and roots. It must be called with the lock held.
+func (idx *Index) clear() error {
+	b, err := batching(idx.dstore)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range []ds.Key{indexRefs, indexRoots} {
+		res, err := idx.dstore.Query(query.Query{Prefix: prefix.String(), KeysOnly: true})
+		if err != nil {
+			return err
+		}
+		for r := range res.Next() {
+			if r.Error != nil {
+				res.Close()
+				return r.Error
+			}
+			if err := b.Delete(ds.RawKey(r.Key)); err != nil {
+				res.Close()
+				return err
+			}
+		}
+		res.Close()
+	}
+	return b.Commit()
+}
+
+func refKey(c cid.Cid) ds.Key {
+	// blocks are stored by multihash, so are their counts.
+	return indexRefs.ChildString(c.Hash().B58String())
+}
+
+func rootKey(c cid.Cid) ds.Key {
+	return indexRoots.ChildString(c.String())
+}
+
+func encodeCount(count int) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, uint64(count))]
+}
+
+// batching returns a batch on dstore, or a batch writing through to it if it
+// does not support batching.
+func batching(dstore ds.Datastore) (ds.Batch, error) {
+	if bds, ok := dstore.(ds.Batching); ok {
+		return bds.Batch()
+	}
+	return ds.NewBasicBatch(dstore), nil
+}
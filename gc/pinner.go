@@ -0,0 +1,166 @@
+package gc
+
+import (
+	"context"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// IndexedPinner wraps a pinner so that the given index is kept up to date with
+// its recursive pins.
+//
+// Pins made with PinWithMode and RemovePinWithMode are applied to the index
+// on the next Flush, as those methods cannot report errors. When updating the
+// index fails it is invalidated, and GC falls back to walking the pins until
+// it is rebuilt.
+func IndexedPinner(pn pin.Pinner, idx *Index) pin.Pinner {
+	return &indexedPinner{Pinner: pn, idx: idx}
+}
+
+type indexedPinner struct {
+	pin.Pinner
+	idx *Index
+
+	lk      sync.Mutex
+	added   []cid.Cid
+	removed []cid.Cid
+}
+
+func (p *indexedPinner) Pin(ctx context.Context, node ipld.Node, recursive bool) error {
+	if !recursive {
+		return p.Pinner.Pin(ctx, node, recursive)
+	}
+
+	if err := p.idx.begin(); err != nil {
+		return err
+	}
+	defer p.idx.end()
+
+	if err := p.Pinner.Pin(ctx, node, recursive); err != nil {
+		return err
+	}
+	return p.indexed(p.idx.Add(ctx, node.Cid()))
+}
+
+func (p *indexedPinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := p.idx.begin(); err != nil {
+		return err
+	}
+	defer p.idx.end()
+
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	return p.removeIfUnpinned(ctx, c)
+}
+
+func (p *indexedPinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := p.idx.begin(); err != nil {
+		return err
+	}
+	defer p.idx.end()
+
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+	if err := p.idx.Add(ctx, to); err != nil {
+		return p.indexed(err)
+	}
+	if !unpin {
+		return nil
+	}
+	return p.removeIfUnpinned(ctx, from)
+}
+
+func (p *indexedPinner) PinWithMode(c cid.Cid, mode pin.Mode) {
+	if mode != pin.Recursive {
+		p.Pinner.PinWithMode(c, mode)
+		return
+	}
+
+	p.pending(&p.added, c)
+	p.Pinner.PinWithMode(c, mode)
+}
+
+func (p *indexedPinner) RemovePinWithMode(c cid.Cid, mode pin.Mode) {
+	if mode != pin.Recursive {
+		p.Pinner.RemovePinWithMode(c, mode)
+		return
+	}
+
+	p.pending(&p.removed, c)
+	p.Pinner.RemovePinWithMode(c, mode)
+}
+
+// pending queues a pin change to be indexed on the next Flush.
+func (p *indexedPinner) pending(queue *[]cid.Cid, c cid.Cid) {
+	if err := p.idx.begin(); err != nil {
+		// the change can't be tracked, stop trusting the index.
+		_ = p.indexed(err)
+		return
+	}
+
+	p.lk.Lock()
+	*queue = append(*queue, c)
+	p.lk.Unlock()
+}
+
+func (p *indexedPinner) Flush(ctx context.Context) error {
+	p.lk.Lock()
+	added, removed := p.added, p.removed
+	p.added, p.removed = nil, nil
+	p.lk.Unlock()
+
+	defer func() {
+		for range added {
+			p.idx.end()
+		}
+		for range removed {
+			p.idx.end()
+		}
+	}()
+
+	for _, c := range added {
+		if err := p.idx.Add(ctx, c); err != nil {
+			return p.indexed(err)
+		}
+	}
+
+	if err := p.Pinner.Flush(ctx); err != nil {
+		return err
+	}
+
+	for _, c := range removed {
+		if err := p.removeIfUnpinned(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIfUnpinned uncounts c unless it is still pinned recursively.
+func (p *indexedPinner) removeIfUnpinned(ctx context.Context, c cid.Cid) error {
+	_, pinned, err := p.Pinner.IsPinnedWithType(ctx, c, pin.Recursive)
+	if err != nil {
+		return p.indexed(err)
+	}
+	if pinned {
+		return nil
+	}
+	return p.indexed(p.idx.Remove(ctx, c))
+}
+
+// indexed invalidates the index if err is not nil, and returns err.
+func (p *indexedPinner) indexed(err error) error {
+	if err == nil {
+		return nil
+	}
+	log.Errorf("gc index update failed, invalidating it: %s", err)
+	if ierr := p.idx.Invalidate(); ierr != nil {
+		log.Errorf("failed to invalidate gc index: %s", ierr)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+// Package pinmeta stores metadata about the local pins, such as their name or
+// when they expire, next to the pins in the repo datastore.
+package pinmeta
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("pinmeta")
+
+var metaPrefix = ds.NewKey("/local/pins/meta")
+
+// Meta is the metadata attached to a pin.
+type Meta struct {
+	// Name is a free form name for the pin. Names need not be unique.
+	Name string `json:",omitempty"`
+	// Attrs are arbitrary key/value annotations.
+	Attrs map[string]string `json:",omitempty"`
+	// Expires is when the pin is removed. The zero time means never.
+	Expires time.Time
+}
+
+func (m *Meta) empty() bool {
+	return m.Name == "" && len(m.Attrs) == 0 && m.Expires.IsZero()
+}
+
+// Expired returns whether the pin has expired at the given time.
+func (m *Meta) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && !now.Before(m.Expires)
+}
+
+// Pin is implemented by the pins listed by the CoreAPI, to expose their
+// metadata.
+type Pin interface {
+	// Meta returns the metadata of the pin, or nil if it has none.
+	Meta() *Meta
+}
+
+// Store keeps the metadata of the pins in a datastore. As the blocks, the
+// metadata is keyed by multihash: the CIDs of a block share it.
+type Store struct {
+	dstore ds.Datastore
+}
+
+// record is the metadata of a pin as stored, with the CID it was pinned
+// with.
+type record struct {
+	Meta
+	Cid cid.Cid
+}
+
+// NewStore returns the pin metadata stored in the given datastore.
+func NewStore(dstore ds.Datastore) *Store {
+	return &Store{dstore: dstore}
+}
+
+// Get returns the metadata of the given pin, or nil if it has none.
+func (s *Store) Get(c cid.Cid) (*Meta, error) {
+	v, err := s.dstore.Get(metaKey(c))
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	r := new(record)
+	if err := json.Unmarshal(v, r); err != nil {
+		return nil, err
+	}
+	return &r.Meta, nil
+}
+
+// Put sets the metadata of the given pin, removing it if m is empty.
+func (s *Store) Put(c cid.Cid, m *Meta) error {
+	if m == nil || m.empty() {
+		return s.Delete(c)
+	}
+
+	v, err := json.Marshal(&record{Meta: *m, Cid: c})
+	if err != nil {
+		return err
+	}
+	return s.dstore.Put(metaKey(c), v)
+}
+
+// Delete removes the metadata of the given pin.
+func (s *Store) Delete(c cid.Cid) error {
+	return s.dstore.Delete(metaKey(c))
+}
+
+// Expired returns the pins that have expired at the given time.
+func (s *Store) Expired(ctx context.Context, now time.Time) ([]cid.Cid, error) {
+	res, err := s.dstore.Query(query.Query{Prefix: metaPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var expired []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		rec := new(record)
+		if err := json.Unmarshal(r.Value, rec); err != nil || !rec.Cid.Defined() {
+			log.Errorf("invalid pin metadata %s: %v", r.Key, err)
+			continue
+		}
+		if rec.Expired(now) {
+			expired = append(expired, rec.Cid)
+		}
+	}
+	return expired, nil
+}
+
+func metaKey(c cid.Cid) ds.Key {
+	return metaPrefix.ChildString(c.Hash().B58String())
+}
@@ -0,0 +1,126 @@
+package pinmeta
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestPinnerMeta(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	inner, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(dstore)
+	pn := s.Pinner(inner)
+
+	a := dag.NodeWithData([]byte("a"))
+	b := dag.NodeWithData([]byte("b"))
+	for _, nd := range []*dag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	if err := pn.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(a.Cid(), &Meta{Name: "a", Expires: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := s.Expired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expected no expired pin, got %v", expired)
+	}
+	expired, err = s.Expired(ctx, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || !expired[0].Equals(a.Cid()) {
+		t.Fatalf("expected %s to be expired, got %v", a.Cid(), expired)
+	}
+
+	// the expiry follows the pin
+	if err := pn.Update(ctx, a.Cid(), b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := s.Get(a.Cid()); err != nil || m != nil {
+		t.Fatalf("metadata of the old pin was kept: %v %v", m, err)
+	}
+	m, err := s.Get(b.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "a" || !m.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("metadata was not moved to the new pin: %v", m)
+	}
+
+	// pinning again without expiry makes the pin permanent but keeps its name
+	if err := pn.Pin(ctx, b, true); err != nil {
+		t.Fatal(err)
+	}
+	m, err = s.Get(b.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "a" || !m.Expires.IsZero() {
+		t.Fatalf("unexpected metadata after pinning again: %v", m)
+	}
+
+	// unpinning drops the metadata
+	if err := pn.Unpin(ctx, b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := s.Get(b.Cid()); err != nil || m != nil {
+		t.Fatalf("metadata of a removed pin was kept: %v %v", m, err)
+	}
+}
+
+func TestStoreMultihash(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(dssync.MutexWrap(ds.NewMapDatastore()))
+
+	nd := dag.NodeWithData([]byte("a"))
+	v0 := nd.Cid()
+	v1 := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+	now := time.Now()
+	if err := s.Put(v0, &Meta{Name: "a", Expires: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the CIDs of a block share its metadata
+	m, err := s.Get(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "a" {
+		t.Fatalf("expected the metadata to be found by multihash, got %v", m)
+	}
+
+	// the expired pins are returned with the CID they were pinned with
+	expired, err := s.Expired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || !expired[0].Equals(v0) {
+		t.Fatalf("expected %s to be expired, got %v", v0, expired)
+	}
+}
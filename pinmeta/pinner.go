@@ -0,0 +1,80 @@
+package pinmeta
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Pinner wraps a pinner so that the metadata of the pins follows them: it is
+// dropped along with the pin, moved by Update, and pinning again without an
+// expiry makes the pin permanent.
+func (s *Store) Pinner(pn pin.Pinner) pin.Pinner {
+	return &pinner{Pinner: pn, s: s}
+}
+
+type pinner struct {
+	pin.Pinner
+	s *Store
+}
+
+func (p *pinner) Pin(ctx context.Context, node ipld.Node, recursive bool) error {
+	if err := p.Pinner.Pin(ctx, node, recursive); err != nil {
+		return err
+	}
+	return p.clearExpiry(node.Cid())
+}
+
+func (p *pinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	return p.s.Delete(c)
+}
+
+func (p *pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+
+	m, err := p.s.Get(from)
+	if err != nil || m == nil {
+		return err
+	}
+	if err := p.s.Put(to, m); err != nil {
+		return err
+	}
+	// the CIDs of the same block share their metadata
+	if !unpin || bytes.Equal(from.Hash(), to.Hash()) {
+		return nil
+	}
+	return p.s.Delete(from)
+}
+
+func (p *pinner) PinWithMode(c cid.Cid, mode pin.Mode) {
+	p.Pinner.PinWithMode(c, mode)
+	if err := p.clearExpiry(c); err != nil {
+		log.Errorf("failed to clear expiry of pin %s: %s", c, err)
+	}
+}
+
+func (p *pinner) RemovePinWithMode(c cid.Cid, mode pin.Mode) {
+	p.Pinner.RemovePinWithMode(c, mode)
+	if err := p.s.Delete(c); err != nil {
+		log.Errorf("failed to remove metadata of pin %s: %s", c, err)
+	}
+}
+
+// clearExpiry makes the pin of c permanent, keeping its other metadata.
+func (p *pinner) clearExpiry(c cid.Cid) error {
+	m, err := p.s.Get(c)
+	if err != nil || m == nil || m.Expires.IsZero() {
+		return err
+	}
+	m.Expires = time.Time{}
+	return p.s.Put(c, m)
+}
@@ -0,0 +1,22 @@
+package remotepin
+
+import (
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// CreatorMetaKey is the key of the meta of the remote pins naming the peer
+// that created them, so that a node can tell its pins from the pins other
+// nodes created on the same service.
+const CreatorMetaKey = "ipfs-creator"
+
+// CreatorMeta returns the option recording self as the creator of a remote
+// pin.
+func CreatorMeta(self peer.ID) pinclient.AddOption {
+	return pinclient.PinOpts.AddMeta(map[string]string{CreatorMetaKey: self.Pretty()})
+}
+
+// CreatedBy reports whether the remote pin p was created by self.
+func CreatedBy(p pinclient.PinGetter, self peer.ID) bool {
+	return p.GetMeta()[CreatorMetaKey] == self.Pretty()
+}
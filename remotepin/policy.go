@@ -0,0 +1,315 @@
+package remotepin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	config "github.com/ipfs/go-ipfs-config"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+// PolicyKind is the local data a policy mirrors to a remote service.
+type PolicyKind string
+
+const (
+	// PolicyPins mirrors every local recursive pin.
+	PolicyPins PolicyKind = "Pins"
+	// PolicyAdd mirrors the result of every 'ipfs add'.
+	PolicyAdd PolicyKind = "Add"
+	// PolicyIPNS mirrors the value of every IPNS record published with a key.
+	PolicyIPNS PolicyKind = "IPNS"
+)
+
+// PolicyKinds are the policies handled by Mirror. The MFS policy is handled
+// by the daemon directly.
+var PolicyKinds = []PolicyKind{PolicyPins, PolicyAdd, PolicyIPNS}
+
+// DefaultPolicyKey is the key followed by the IPNS policy when none is set.
+const DefaultPolicyKey = "self"
+
+// PolicyPinPrefix starts the default names of the remote pins of the
+// policies, policy/<peer id>/<kind>.
+const PolicyPinPrefix = "policy/"
+
+var policyPrefix = ds.NewKey("/local/pins/remote/policy")
+
+// Policy is an automatic remote pinning policy of a service. Policies are
+// read from Pinning.RemoteServices.<service>.Policies.<kind> in the config.
+type Policy struct {
+	Service string     `json:"-"`
+	Kind    PolicyKind `json:"-"`
+
+	Enable bool
+	// NamePrefix restricts the Pins policy to the local pins whose name starts
+	// with it.
+	NamePrefix string `json:",omitempty"`
+	// Key is the name of the key whose records the IPNS policy mirrors.
+	Key string `json:",omitempty"`
+	// PinName is the name of the remote pins. It defaults to the name of the
+	// local pin, or to policy/<peer id>/<kind>.
+	PinName string `json:",omitempty"`
+}
+
+// PolicyStatus reports what a policy mirrored so far.
+type PolicyStatus struct {
+	Policy
+	// Mirrored is the number of cids handed over to the queue, or of keys for
+	// the IPNS policy.
+	Mirrored int
+	// Queued and Failed are the number of intents of the policy still in the
+	// queue, see Queue.List.
+	Queued int
+	Failed int
+	// Last is the last value mirrored by the IPNS policy.
+	Last cid.Cid
+}
+
+// Mirror applies the policies, queuing the remote pins they call for. Each
+// pin is only queued once per policy: unpinning it locally, or on the
+// service, does not queue it again.
+type Mirror struct {
+	dstore ds.Datastore
+	repo   repo.Repo
+	queue  *Queue
+	self   peer.ID
+}
+
+// NewMirror returns the Mirror applying the policies of the repo config.
+func NewMirror(r repo.Repo, q *Queue, self peer.ID) *Mirror {
+	return &Mirror{
+		dstore: r.Datastore(),
+		repo:   r,
+		queue:  q,
+		self:   self,
+	}
+}
+
+// Policies returns the enabled policies of the given kind, sorted by service.
+func (m *Mirror) Policies(kind PolicyKind) ([]*Policy, error) {
+	all, err := m.policies()
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []*Policy
+	for _, p := range all {
+		if p.Kind == kind && p.Enable {
+			policies = append(policies, p)
+		}
+	}
+	return policies, nil
+}
+
+// policies reads all the policies from the config.
+func (m *Mirror) policies() ([]*Policy, error) {
+	cfg, err := m.repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	var policies []*Policy
+	for service, svc := range cfg.Pinning.RemoteServices {
+		configured := map[PolicyKind]*config.RemotePinningServicePolicy{
+			PolicyPins: svc.Policies.Pins,
+			PolicyAdd:  svc.Policies.Add,
+			PolicyIPNS: svc.Policies.IPNS,
+		}
+		for _, kind := range PolicyKinds {
+			c := configured[kind]
+			if c == nil {
+				continue
+			}
+			p := &Policy{
+				Service:    service,
+				Kind:       kind,
+				Enable:     c.Enable,
+				NamePrefix: c.NamePrefix,
+				Key:        c.Key,
+				PinName:    c.PinName,
+			}
+			if p.Kind == PolicyIPNS && p.Key == "" {
+				p.Key = DefaultPolicyKey
+			}
+			policies = append(policies, p)
+		}
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Service != policies[j].Service {
+			return policies[i].Service < policies[j].Service
+		}
+		return policies[i].Kind < policies[j].Kind
+	})
+	return policies, nil
+}
+
+// Added mirrors the result of an 'ipfs add'.
+func (m *Mirror) Added(c cid.Cid) error {
+	policies, err := m.Policies(PolicyAdd)
+	if err != nil {
+		return err
+	}
+	for _, p := range policies {
+		if _, err := m.mirror(p, c.String(), c, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Published mirrors c, the value of an IPNS record published with the given
+// key. Unlike the other policies, the remote pin is replaced every time the
+// value changes.
+func (m *Mirror) Published(key string, c cid.Cid) error {
+	policies, err := m.Policies(PolicyIPNS)
+	if err != nil {
+		return err
+	}
+	for _, p := range policies {
+		if p.Key != key {
+			continue
+		}
+		if _, err := m.mirror(p, key, c, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MirrorPins mirrors the recursive pins of the pinner, named after meta.
+func (m *Mirror) MirrorPins(ctx context.Context, pinner pin.Pinner, meta *pinmeta.Store) (int, error) {
+	policies, err := m.Policies(PolicyPins)
+	if err != nil || len(policies) == 0 {
+		return 0, err
+	}
+
+	keys, err := pinner.RecursiveKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, c := range keys {
+		if ctx.Err() != nil {
+			return queued, ctx.Err()
+		}
+		pm, err := meta.Get(c)
+		if err != nil {
+			return queued, err
+		}
+		var name string
+		if pm != nil {
+			name = pm.Name
+		}
+
+		for _, p := range policies {
+			if !strings.HasPrefix(name, p.NamePrefix) {
+				continue
+			}
+			ok, err := m.mirror(p, c.String(), c, name)
+			if err != nil {
+				return queued, err
+			}
+			if ok {
+				queued++
+			}
+		}
+	}
+	return queued, nil
+}
+
+// mirror queues the pin of c on the service of the policy, unless the policy
+// already mirrored c under the given entry. It reports whether the pin was
+// queued.
+func (m *Mirror) mirror(p *Policy, entry string, c cid.Cid, localName string) (bool, error) {
+	k := policyPrefix.ChildString(p.Service).ChildString(string(p.Kind)).ChildString(entry)
+	last, err := m.dstore.Get(k)
+	switch err {
+	case nil:
+		if p.Kind != PolicyIPNS || string(last) == string(c.Bytes()) {
+			return false, nil
+		}
+	case ds.ErrNotFound:
+	default:
+		return false, err
+	}
+
+	name := p.PinName
+	if name == "" {
+		name = localName
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s%s/%s", PolicyPinPrefix, m.self, strings.ToLower(string(p.Kind)))
+		if p.Kind == PolicyIPNS {
+			name += "/" + p.Key
+		}
+	}
+
+	in := &Intent{
+		Service: p.Service,
+		Cid:     c,
+		Name:    name,
+		Policy:  p.Kind,
+		Replace: p.Kind == PolicyIPNS,
+	}
+	if err := m.queue.add(in); err != nil {
+		return false, err
+	}
+	log.Debugf("%s policy of %s queued the pin of %s", p.Kind, p.Service, c)
+	return true, m.dstore.Put(k, c.Bytes())
+}
+
+// Status returns the status of all the configured policies.
+func (m *Mirror) Status() ([]*PolicyStatus, error) {
+	policies, err := m.policies()
+	if err != nil {
+		return nil, err
+	}
+	intents, err := m.queue.List()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*PolicyStatus, 0, len(policies))
+	for _, p := range policies {
+		st := &PolicyStatus{Policy: *p}
+
+		prefix := policyPrefix.ChildString(p.Service).ChildString(string(p.Kind))
+		res, err := m.dstore.Query(query.Query{Prefix: prefix.String()})
+		if err != nil {
+			return nil, err
+		}
+		for r := range res.Next() {
+			if r.Error != nil {
+				res.Close()
+				return nil, r.Error
+			}
+			st.Mirrored++
+			if p.Kind == PolicyIPNS && ds.RawKey(r.Key).BaseNamespace() == p.Key {
+				st.Last, _ = cid.Cast(r.Value)
+			}
+		}
+		res.Close()
+
+		for _, in := range intents {
+			if in.Service != p.Service || in.Policy != p.Kind {
+				continue
+			}
+			if in.State == StateFailed {
+				st.Failed++
+			} else {
+				st.Queued++
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,191 @@
+package remotepin
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	config "github.com/ipfs/go-ipfs-config"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+)
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	svc, clients := newTestService(t)
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := &repo.Mock{D: dstore}
+	r.C.Pinning.RemoteServices = map[string]config.RemotePinningService{
+		"test": {
+			Policies: config.RemotePinningServicePolicies{
+				Pins: &config.RemotePinningServicePolicy{Enable: true, NamePrefix: "keep/"},
+				Add:  &config.RemotePinningServicePolicy{Enable: false},
+				IPNS: &config.RemotePinningServicePolicy{Enable: true},
+			},
+		},
+	}
+	q := NewQueue(dstore, "peer")
+	now := time.Now()
+	q.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+	m := NewMirror(r, q, "peer")
+	ipnsName := fmt.Sprintf("policy/%s/ipns/self", m.self)
+
+	// the Pins policy only mirrors the pins matching its prefix, once
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := pinmeta.NewStore(dstore)
+	for _, name := range []string{"keep/a", "other"} {
+		nd := dag.NodeWithData([]byte(name))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := pinner.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := meta.Put(nd.Cid(), &pinmeta.Meta{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []int{1, 0} {
+		n, err := m.MirrorPins(ctx, pinner, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("expected %d local pins to be mirrored, got %d", want, n)
+		}
+	}
+
+	// the Add policy is disabled
+	if err := m.Added(testCid(t, "added")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the IPNS policy replaces its pin when the value changes
+	for _, v := range []string{"v1", "v2", "v2"} {
+		if err := m.Published("other", testCid(t, v)); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Published(DefaultPolicyKey, testCid(t, v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	intents, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 3 || intents[0].Name != "keep/a" || intents[1].Name != ipnsName || !intents[2].Replace {
+		for _, in := range intents {
+			t.Logf("%+v", in)
+		}
+		t.Fatal("unexpected intents")
+	}
+
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.pins) != 2 {
+		t.Fatalf("expected the IPNS pin to be replaced, got %d pins", len(svc.pins))
+	}
+	for _, p := range svc.pins {
+		if p.Pin["name"] == ipnsName && p.Pin["cid"] != testCid(t, "v2").String() {
+			t.Fatalf("IPNS pin was not replaced: %+v", p)
+		}
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("expected 3 policies, got %d", len(statuses))
+	}
+	for _, st := range statuses {
+		switch st.Kind {
+		case PolicyAdd:
+			if st.Enable || st.Mirrored != 0 {
+				t.Errorf("unexpected status of the disabled policy: %+v", st)
+			}
+		case PolicyIPNS:
+			if st.Mirrored != 1 || st.Queued != 2 || !st.Last.Equals(testCid(t, "v2")) {
+				t.Errorf("unexpected status of the IPNS policy: %+v", st)
+			}
+		case PolicyPins:
+			if st.Mirrored != 1 || st.Queued != 1 {
+				t.Errorf("unexpected status of the Pins policy: %+v", st)
+			}
+		}
+	}
+}
+
+func TestMirrorPoliciesSetConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotepin-policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf, err := config.Init(ioutil.Discard, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Datastore.Spec = map[string]interface{}{"type": "mem"}
+	conf.Pinning.RemoteServices = map[string]config.RemotePinningService{"test": {}}
+	if err := fsrepo.Init(dir, conf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := fsrepo.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// as with `ipfs config --json`
+	policy := map[string]interface{}{"Enable": true, "NamePrefix": "keep/"}
+	if err := r.SetConfigKey("Pinning.RemoteServices.test.Policies.Pins", policy); err != nil {
+		t.Fatal(err)
+	}
+
+	// as with `ipfs pin remote service add`
+	cfg, err := r.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := *cfg
+	updated.Pinning.RemoteServices = map[string]config.RemotePinningService{"other": {}}
+	for name, svc := range cfg.Pinning.RemoteServices {
+		updated.Pinning.RemoteServices[name] = svc
+	}
+	if err := r.SetConfig(&updated); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMirror(r, NewQueue(r.Datastore(), "peer"), "peer")
+	policies, err := m.Policies(PolicyPins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0].Service != "test" || policies[0].NamePrefix != "keep/" {
+		t.Fatalf("expected the Pins policy of test to be kept, got %v", policies)
+	}
+}
@@ -0,0 +1,298 @@
+// Package remotepin keeps track of the pins requested from remote pinning
+// services, so that they are retried when a service is unavailable and
+// survive daemon restarts.
+package remotepin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("remotepinning/queue")
+
+var queuePrefix = ds.NewKey("/local/pins/remote/queue")
+
+// ErrNotFound is returned when an intent is not in the queue.
+var ErrNotFound = errors.New("no such remote pin intent")
+
+// State is the local state of a remote pin intent.
+type State string
+
+const (
+	// StateQueued intents are waiting to be sent to the service, or to be
+	// retried after an error.
+	StateQueued State = "queued"
+	// StatePending intents were accepted by the service, which is still
+	// pinning them.
+	StatePending State = "pending"
+	// StateFailed intents are not retried anymore until Retry is called.
+	StateFailed State = "failed"
+	// StateCanceled intents are left to be removed from the queue, and their
+	// pin from the service if it was requested already.
+	StateCanceled State = "canceled"
+)
+
+// Intent is a pin requested from a remote pinning service. It leaves the
+// queue once the service reports the pin as pinned.
+type Intent struct {
+	ID      string
+	Service string
+	Cid     cid.Cid
+	Name    string `json:",omitempty"`
+	Created time.Time
+	// Policy is the kind of the policy that queued the intent, if any.
+	Policy PolicyKind `json:",omitempty"`
+	// Replace makes the pin replace the pin of the service with the same
+	// name, instead of being added next to it.
+	Replace bool `json:",omitempty"`
+
+	State State
+	// RequestID identifies the pin on the service once it was accepted.
+	RequestID   string `json:",omitempty"`
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string `json:",omitempty"`
+}
+
+// Queue is the persistent queue of remote pin intents.
+type Queue struct {
+	dstore ds.Datastore
+	self   peer.ID
+	now    func() time.Time
+
+	lk   sync.Mutex
+	wake chan struct{}
+}
+
+// NewQueue returns the queue stored in the given datastore. The pins it
+// sends are recorded as created by self.
+func NewQueue(dstore ds.Datastore, self peer.ID) *Queue {
+	return &Queue{
+		dstore: dstore,
+		self:   self,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Add queues a pin of c on the given service.
+func (q *Queue) Add(service string, c cid.Cid, name string) (*Intent, error) {
+	in := &Intent{
+		Service: service,
+		Cid:     c,
+		Name:    name,
+	}
+	return in, q.add(in)
+}
+
+// add queues in, filling in its ID and state.
+func (q *Queue) add(in *Intent) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	now := q.now()
+	in.ID = hex.EncodeToString(id)
+	in.Created = now
+	in.State = StateQueued
+	in.NextAttempt = now
+
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	if err := q.put(in); err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// List returns the queued intents, oldest first.
+func (q *Queue) List() ([]*Intent, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	return q.list()
+}
+
+// Retry makes the given intent be attempted again right away, resetting its
+// attempts.
+func (q *Queue) Retry(id string) (*Intent, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	in, err := q.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if in.State == StateFailed {
+		in.State = StateQueued
+	}
+	in.Attempts = 0
+	in.NextAttempt = q.now()
+	if err := q.put(in); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return in, nil
+}
+
+// Get returns the given intent.
+func (q *Queue) Get(id string) (*Intent, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	return q.get(id)
+}
+
+// Cancel marks the given intent as canceled and returns it. Process removes
+// it from the queue, after deleting its pin from the service if it was
+// requested, including by an attempt still under way.
+func (q *Queue) Cancel(id string) (*Intent, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	in, err := q.get(id)
+	if err != nil {
+		return nil, err
+	}
+	in.State = StateCanceled
+	in.Attempts = 0
+	in.NextAttempt = q.now()
+	if err := q.put(in); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return in, nil
+}
+
+// notify wakes up Run.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) get(id string) (*Intent, error) {
+	v, err := q.dstore.Get(queuePrefix.ChildString(id))
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, err
+	}
+
+	in := new(Intent)
+	return in, json.Unmarshal(v, in)
+}
+
+func (q *Queue) put(in *Intent) error {
+	v, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return q.dstore.Put(queuePrefix.ChildString(in.ID), v)
+}
+
+func (q *Queue) list() ([]*Intent, error) {
+	res, err := q.dstore.Query(query.Query{Prefix: queuePrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var intents []*Intent
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		in := new(Intent)
+		if err := json.Unmarshal(r.Value, in); err != nil {
+			log.Errorf("invalid remote pin intent %s: %s", r.Key, err)
+			continue
+		}
+		intents = append(intents, in)
+	}
+
+	sort.Slice(intents, func(i, j int) bool {
+		return intents[i].Created.Before(intents[j].Created)
+	})
+	return intents, nil
+}
+
+// update applies fn to the stored intent. An intent canceled meanwhile stays
+// canceled, with the RequestID of the pin the attempt requested, until its pin
+// is deleted from the service.
+func (q *Queue) update(id string, fn func(in *Intent) (remove bool)) error {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	in, err := q.get(id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	canceled := in.State == StateCanceled
+	remove := fn(in)
+	if canceled {
+		if remove && in.RequestID == "" {
+			return q.dstore.Delete(queuePrefix.ChildString(id))
+		}
+		if in.State != StateCanceled {
+			// canceled while being sent or checked
+			in.State = StateCanceled
+			in.NextAttempt = q.now()
+			q.notify()
+		}
+		return q.put(in)
+	}
+	if remove {
+		return q.dstore.Delete(queuePrefix.ChildString(id))
+	}
+	return q.put(in)
+}
+
+// Run processes the queue until the context is canceled, checking it every
+// interval and whenever an intent is added or retried.
+func (q *Queue) Run(ctx context.Context, interval time.Duration, clients ClientFunc, origins OriginsFunc) {
+	tmr := time.NewTimer(0)
+	defer tmr.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tmr.C:
+		case <-q.wake:
+			if !tmr.Stop() {
+				select {
+				case <-tmr.C:
+				default:
+				}
+			}
+		}
+
+		if err := q.Process(ctx, clients, origins); err != nil {
+			log.Errorf("processing remote pin queue: %s", err)
+		}
+		tmr.Reset(interval)
+	}
+}
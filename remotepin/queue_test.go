@@ -0,0 +1,357 @@
+package remotepin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// testService is a minimal stand-in of the Pinning Service API.
+type testService struct {
+	lk    sync.Mutex
+	down  bool
+	pins  map[string]*testPin
+	added int
+	// onAdd is called when a pin is added, before the response
+	onAdd func()
+}
+
+type testPin struct {
+	Requestid string            `json:"requestid"`
+	Status    string            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       map[string]string `json:"pin"`
+	Delegates []string          `json:"delegates"`
+}
+
+func newTestService(t *testing.T) (*testService, ClientFunc) {
+	s := &testService{pins: make(map[string]*testPin)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, func(service string) (*pinclient.Client, error) {
+		if service != "test" {
+			return nil, fmt.Errorf("service not known")
+		}
+		return pinclient.NewClient(srv.URL, "secret"), nil
+	}
+}
+
+func (s *testService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/pins":
+		var pin map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.added++
+		p := &testPin{
+			Requestid: fmt.Sprint(s.added),
+			Status:    "queued",
+			Created:   time.Now(),
+			Pin:       map[string]string{"cid": pin["cid"].(string)},
+			Delegates: []string{"/ip4/127.0.0.1/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupGMd"},
+		}
+		if name, ok := pin["name"].(string); ok {
+			p.Pin["name"] = name
+		}
+		s.pins[p.Requestid] = p
+		if s.onAdd != nil {
+			s.onAdd()
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(p)
+	case r.Method == http.MethodGet && r.URL.Path == "/pins":
+		var results []*testPin
+		for _, p := range s.pins {
+			if c := r.URL.Query().Get("cid"); c != "" && c != p.Pin["cid"] {
+				continue
+			}
+			if st := r.URL.Query().Get("status"); st != "" && !strings.Contains(st, p.Status) {
+				continue
+			}
+			if name := r.URL.Query().Get("name"); name != "" && name != p.Pin["name"] {
+				continue
+			}
+			results = append(results, p)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"count": len(results), "results": results})
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/pins/"):
+		p, ok := s.pins[strings.TrimPrefix(r.URL.Path, "/pins/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var pin map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.Pin["cid"] = pin["cid"].(string)
+		p.Status = "queued"
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(p)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/pins/"):
+		p, ok := s.pins[strings.TrimPrefix(r.URL.Path, "/pins/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(p)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/pins/"):
+		id := strings.TrimPrefix(r.URL.Path, "/pins/")
+		if _, ok := s.pins[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.pins, id)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *testService) setDown(down bool) {
+	s.lk.Lock()
+	s.down = down
+	s.lk.Unlock()
+}
+
+func (s *testService) setStatus(requestID, status string) {
+	s.lk.Lock()
+	s.pins[requestID].Status = status
+	s.lk.Unlock()
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	h, err := mh.Sum([]byte(data), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func TestQueueRetries(t *testing.T) {
+	ctx := context.Background()
+	svc, clients := newTestService(t)
+	q := NewQueue(dssync.MutexWrap(ds.NewMapDatastore()), "peer")
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	in, err := q.Add("test", testCid(t, "a"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the service is down, the intent is kept for later
+	svc.setDown(true)
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	intents, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 1 || intents[0].Attempts != 1 || intents[0].State != StateQueued || intents[0].LastError == "" {
+		t.Fatalf("unexpected intent after a failed attempt: %+v", intents)
+	}
+
+	// not retried before the backoff
+	svc.setDown(false)
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	if svc.added != 0 {
+		t.Fatal("intent retried before its backoff")
+	}
+
+	now = now.Add(initialBackoff)
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	intents, err = q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 1 || intents[0].State != StatePending || intents[0].RequestID != "1" {
+		t.Fatalf("expected the intent to be pending, got %+v", intents)
+	}
+
+	// done once pinned
+	svc.setStatus("1", "pinned")
+	now = now.Add(statusInterval)
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Cancel(in.ID); err != ErrNotFound {
+		t.Fatalf("expected the pinned intent to leave the queue, got %v", err)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	ctx := context.Background()
+	svc, clients := newTestService(t)
+	q := NewQueue(dssync.MutexWrap(ds.NewMapDatastore()), "peer")
+
+	expectQueue := func(n int) []*Intent {
+		t.Helper()
+		intents, err := q.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(intents) != n {
+			t.Fatalf("expected %d intents, got %+v", n, intents)
+		}
+		return intents
+	}
+
+	// not sent yet, even to an unknown service
+	for _, service := range []string{"test", "unknown"} {
+		in, err := q.Add(service, testCid(t, "d"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in, err = q.Cancel(in.ID); err != nil || in.State != StateCanceled {
+			t.Fatalf("expected the intent to be canceled, got %+v, %v", in, err)
+		}
+	}
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectQueue(0)
+	if svc.added != 0 {
+		t.Fatalf("expected the canceled intents not to be sent, got %d pins", svc.added)
+	}
+
+	// accepted by the service
+	in, err := q.Add("test", testCid(t, "e"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Cancel(in.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectQueue(0)
+	if len(svc.pins) != 0 {
+		t.Fatalf("expected the pin to be removed from the service, got %+v", svc.pins)
+	}
+
+	// canceled while being sent
+	if in, err = q.Add("test", testCid(t, "f"), ""); err != nil {
+		t.Fatal(err)
+	}
+	svc.onAdd = func() {
+		if _, err := q.Cancel(in.ID); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	svc.onAdd = nil
+	if intents := expectQueue(1); intents[0].State != StateCanceled || intents[0].RequestID == "" {
+		t.Fatalf("expected the intent to stay canceled with the pin to remove, got %+v", intents[0])
+	}
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectQueue(0)
+	if len(svc.pins) != 0 {
+		t.Fatalf("expected the pin to be removed from the service, got %+v", svc.pins)
+	}
+}
+
+func TestQueueReconcile(t *testing.T) {
+	ctx := context.Background()
+	svc, clients := newTestService(t)
+	q := NewQueue(dssync.MutexWrap(ds.NewMapDatastore()), "peer")
+
+	c := testCid(t, "b")
+	c1, err := clients("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c1.Add(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the service already has the pin, it is not requested again
+	if _, err := q.Add("test", c, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Process(ctx, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	if svc.added != 1 {
+		t.Fatalf("expected the existing pin to be reused, got %d pins", svc.added)
+	}
+	intents, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 1 || intents[0].RequestID != "1" {
+		t.Fatalf("expected the intent to track the existing pin, got %+v", intents)
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(dssync.MutexWrap(ds.NewMapDatastore()), "peer")
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	in, err := q.Add("unknown", testCid(t, "c"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clients := newTestService(t)
+	for i := 0; i < MaxAttempts; i++ {
+		if err := q.Process(ctx, clients, nil); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(maxBackoff)
+	}
+
+	intents, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 1 || intents[0].State != StateFailed {
+		t.Fatalf("expected the intent to be failed, got %+v", intents)
+	}
+
+	in, err = q.Retry(in.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.State != StateQueued || in.Attempts != 0 {
+		t.Fatalf("unexpected intent after retry: %+v", in)
+	}
+}
@@ -0,0 +1,250 @@
+package remotepin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// initialBackoff is the delay before retrying a failed attempt, doubled
+	// after each subsequent failure up to maxBackoff.
+	initialBackoff = 30 * time.Second
+	maxBackoff     = time.Hour
+
+	// MaxAttempts is the number of failed attempts after which an intent is
+	// marked as failed.
+	MaxAttempts = 20
+
+	// statusInterval is how often pending intents are checked.
+	statusInterval = time.Minute
+)
+
+// ClientFunc returns the client of the remote pinning service with the given
+// name.
+type ClientFunc func(service string) (*pinclient.Client, error)
+
+// OriginsFunc returns the addresses given to the services as origins of the
+// pinned data.
+type OriginsFunc func() []ma.Multiaddr
+
+// Process goes over the due intents once: queued intents are sent to their
+// service, and the status of pending ones is checked.
+func (q *Queue) Process(ctx context.Context, clients ClientFunc, origins OriginsFunc) error {
+	intents, err := q.List()
+	if err != nil {
+		return err
+	}
+
+	now := q.now()
+	for _, in := range intents {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if in.State == StateFailed || in.NextAttempt.After(now) {
+			continue
+		}
+
+		var step func(context.Context, *pinclient.Client, *Intent, OriginsFunc) (func(*Intent) bool, error)
+		switch in.State {
+		case StateQueued:
+			step = q.send
+		case StatePending:
+			step = q.check
+		case StateCanceled:
+			step = q.cancel
+		default:
+			log.Errorf("remote pin intent %s has unknown state %q", in.ID, in.State)
+			continue
+		}
+
+		var apply func(*Intent) bool
+		c, err := clients(in.Service)
+		// canceled intents without a pin on the service are removed even if
+		// the service is not configured anymore
+		if err == nil || (in.State == StateCanceled && in.RequestID == "") {
+			apply, err = step(ctx, c, in, origins)
+		}
+		if err != nil {
+			log.Debugf("remote pin intent %s on %s failed: %s", in.ID, in.Service, err)
+			apply = q.failed(err)
+		}
+		if err := q.update(in.ID, apply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send requests the pin from the service, unless the service already has it.
+func (q *Queue) send(ctx context.Context, c *pinclient.Client, in *Intent, origins OriginsFunc) (func(*Intent) bool, error) {
+	// a previous attempt may have reached the service without the intent
+	// recording it, look for it first to avoid pinning twice.
+	var requestID, replaced string
+	var status pinclient.Status
+	var err error
+	if in.Replace {
+		requestID, status, replaced, err = named(ctx, c, in)
+	} else {
+		requestID, status, err = existing(ctx, c, in)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while listing remote pins: %w", err)
+	}
+
+	if requestID == "" {
+		opts := []pinclient.AddOption{CreatorMeta(q.self)}
+		if in.Name != "" {
+			opts = append(opts, pinclient.PinOpts.WithName(in.Name))
+		}
+		if origins != nil {
+			if addrs := origins(); len(addrs) > 0 {
+				opts = append(opts, pinclient.PinOpts.WithOrigins(addrs...))
+			}
+		}
+
+		var ps pinclient.PinStatusGetter
+		if replaced != "" {
+			ps, err = c.Replace(ctx, replaced, in.Cid, opts...)
+		} else {
+			ps, err = c.Add(ctx, in.Cid, opts...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		requestID, status = ps.GetRequestId(), ps.GetStatus()
+	}
+
+	return q.accepted(requestID, status), nil
+}
+
+// check updates a pending intent with the status reported by the service.
+func (q *Queue) check(ctx context.Context, c *pinclient.Client, in *Intent, _ OriginsFunc) (func(*Intent) bool, error) {
+	ps, err := c.GetStatusByID(ctx, in.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	return q.accepted(in.RequestID, ps.GetStatus()), nil
+}
+
+// cancel deletes the pin of a canceled intent from the service, if it was
+// requested, for the intent to be removed from the queue.
+func (q *Queue) cancel(ctx context.Context, c *pinclient.Client, in *Intent, _ OriginsFunc) (func(*Intent) bool, error) {
+	if in.RequestID != "" {
+		if err := c.DeleteByID(ctx, in.RequestID); err != nil {
+			return nil, fmt.Errorf("removing the remote pin identified by requestid=%q: %w", in.RequestID, err)
+		}
+		log.Infof("remote pin of %s on %s canceled", in.Cid, in.Service)
+	}
+	requestID := in.RequestID
+	return func(in *Intent) bool {
+		// the pin of a later attempt is still to be deleted
+		if in.RequestID == requestID {
+			in.RequestID = ""
+		}
+		return true
+	}, nil
+}
+
+// accepted returns the update of an intent the service reported with the
+// given status. The RequestID is kept for a canceled intent to delete the
+// pin.
+func (q *Queue) accepted(requestID string, status pinclient.Status) func(*Intent) bool {
+	return func(in *Intent) bool {
+		in.RequestID = requestID
+		switch status {
+		case pinclient.StatusPinned:
+			log.Infof("remote pin of %s on %s done", in.Cid, in.Service)
+			return true
+		case pinclient.StatusFailed:
+			// pin it again
+			in.RequestID = ""
+			in.State = StateQueued
+			q.retryLater(in, fmt.Errorf("remote service failed to pin requestid=%q", requestID))
+		default:
+			in.RequestID = requestID
+			in.State = StatePending
+			in.LastError = ""
+			in.NextAttempt = q.now().Add(statusInterval)
+		}
+		return false
+	}
+}
+
+// failed returns the update of an intent whose attempt failed.
+func (q *Queue) failed(err error) func(*Intent) bool {
+	return func(in *Intent) bool {
+		q.retryLater(in, err)
+		return false
+	}
+}
+
+func (q *Queue) retryLater(in *Intent, err error) {
+	in.Attempts++
+	in.LastError = err.Error()
+	// canceled intents are retried until their pin is deleted
+	if in.Attempts >= MaxAttempts && in.State != StateCanceled {
+		in.State = StateFailed
+		log.Errorf("giving up remote pin of %s on %s after %d attempts: %s", in.Cid, in.Service, in.Attempts, err)
+		return
+	}
+	in.NextAttempt = q.now().Add(backoff(in.Attempts))
+}
+
+// backoff returns the delay before the next attempt after the given number of
+// failed ones.
+func backoff(attempts int) time.Duration {
+	d := initialBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// existing looks for a pin of the intent that the service did not fail.
+func existing(ctx context.Context, c *pinclient.Client, in *Intent) (string, pinclient.Status, error) {
+	opts := []pinclient.LsOption{
+		pinclient.PinOpts.FilterCIDs(in.Cid),
+		pinclient.PinOpts.FilterStatus(pinclient.StatusQueued, pinclient.StatusPinning, pinclient.StatusPinned),
+	}
+	if in.Name != "" {
+		opts = append(opts, pinclient.PinOpts.FilterName(in.Name))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	psCh, errCh := c.Ls(ctx, opts...)
+	for ps := range psCh {
+		if ps.GetPin().GetName() == in.Name {
+			return ps.GetRequestId(), ps.GetStatus(), nil
+		}
+	}
+	return "", pinclient.StatusUnknown, <-errCh
+}
+
+// named looks for the pins with the name of the intent. It returns the pin of
+// the intent if the service did not fail it, or else the pin to replace.
+func named(ctx context.Context, c *pinclient.Client, in *Intent) (requestID string, status pinclient.Status, replaced string, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	psCh, errCh := c.Ls(ctx,
+		pinclient.PinOpts.FilterName(in.Name),
+		pinclient.PinOpts.FilterStatus(pinclient.StatusQueued, pinclient.StatusPinning, pinclient.StatusPinned, pinclient.StatusFailed),
+	)
+	for ps := range psCh {
+		if ps.GetPin().GetName() != in.Name {
+			continue
+		}
+		if ps.GetPin().GetCid() == in.Cid && ps.GetStatus() != pinclient.StatusFailed {
+			return ps.GetRequestId(), ps.GetStatus(), "", nil
+		}
+		replaced = ps.GetRequestId()
+	}
+	return "", pinclient.StatusUnknown, replaced, <-errCh
+}
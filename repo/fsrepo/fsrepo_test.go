@@ -116,3 +116,69 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestConfigGCAndRateLimits(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("config", t)
+	defer os.RemoveAll(path)
+	conf, err := config.Init(ioutil.Discard, 2048)
+	assert.Nil(err, t)
+	assert.Nil(Init(path, conf), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	limits := map[string]interface{}{"RequestsPerSecond": 10.0, "Burst": 20.0}
+	assert.Nil(r.SetConfigKey("Datastore.GCMode", "concurrent"), t)
+	assert.Nil(r.SetConfigKey("Datastore.GCIndex", true), t)
+	assert.Nil(r.SetConfigKey("Gateway.RateLimit", limits), t)
+	assert.Nil(r.SetConfigKey("API.RateLimit", limits), t)
+
+	cfg, err := r.Config()
+	assert.Nil(err, t)
+	if cfg.Datastore.GCMode != "concurrent" || !cfg.Datastore.GCIndex {
+		t.Fatalf("expected the gc settings to be set, got %+v", cfg.Datastore)
+	}
+	if l := cfg.Gateway.RateLimit; l == nil || l.RequestsPerSecond != 10 || l.Burst != 20 {
+		t.Fatalf("expected the gateway rate limits to be set, got %+v", l)
+	}
+
+	// as with `ipfs bootstrap add`, `ipfs config replace` and `ipfs config
+	// profile apply`
+	updated := *cfg
+	updated.Bootstrap = []string{"/ip4/127.0.0.1/tcp/4001/p2p/QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"}
+	assert.Nil(r.SetConfig(&updated), t)
+	for _, key := range []string{"Datastore.GCMode", "Datastore.GCIndex", "Gateway.RateLimit", "API.RateLimit"} {
+		if _, err := r.GetConfigKey(key); err != nil {
+			t.Errorf("expected %s to be kept: %s", key, err)
+		}
+	}
+
+	// and they can be cleared
+	updated.Datastore.GCMode = ""
+	updated.Datastore.GCIndex = false
+	updated.Gateway.RateLimit = nil
+	assert.Nil(r.SetConfig(&updated), t)
+	for _, key := range []string{"Datastore.GCMode", "Datastore.GCIndex", "Gateway.RateLimit"} {
+		if v, err := r.GetConfigKey(key); err == nil {
+			t.Errorf("expected %s to be cleared, got %v", key, v)
+		}
+	}
+}
+
+func TestOpenPath(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("path", t)
+	defer os.RemoveAll(path)
+	conf := &config.Config{Datastore: config.Datastore{Spec: map[string]interface{}{"type": "mem"}}}
+	assert.Nil(Init(path, conf), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	// the repo is wrapped by onlyOne, but still tells its path
+	p, ok := r.(interface{ Path() string })
+	if !ok || p.Path() != path {
+		t.Fatalf("expected the repo to tell its path %s", path)
+	}
+}
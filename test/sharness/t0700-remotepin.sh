@@ -200,6 +200,13 @@ test_expect_success "verify pinning service removal works" '
   test_expect_code 1 grep test_invalid_url_dns_svc ls_out
 '
 
+test_expect_success "'ipfs pin remote add --queue' fails for a removed service" '
+  test_expect_code 1 ipfs pin remote add --service=test_invalid_key_svc --queue bafkqaaa 2>add_err &&
+  grep -q "not found in Pinning.RemoteServices" add_err &&
+  ipfs pin remote queue ls > queue_out &&
+  test_must_be_empty queue_out
+'
+
 # pin remote add
 
 # we leverage the fact that inlined CID can be pinned instantly on the remote service
@@ -0,0 +1,169 @@
+package unixfsmeta
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	gopath "path"
+	"strconv"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	uio "github.com/ipfs/go-unixfs/io"
+)
+
+// The PAX records marking the metadata recorded in UnixFS, the other entries
+// get default modes and times which are not restored.
+const (
+	PAXModeRecord  = "IPFS.mode"
+	PAXMtimeRecord = "IPFS.mtime"
+)
+
+// TarWriter writes UnixFS DAGs as tar archives, with the modes and
+// modification times recorded in them.
+type TarWriter struct {
+	ctx context.Context
+	dag ipld.DAGService
+	tw  *tar.Writer
+
+	// ModTime is the modification time of the entries without a recorded
+	// one, the time they are written at when zero.
+	ModTime time.Time
+}
+
+// NewTarWriter returns a writer of the tar archives of the DAGs of dag to w.
+func NewTarWriter(ctx context.Context, dag ipld.DAGService, w io.Writer) *TarWriter {
+	return &TarWriter{ctx: ctx, dag: dag, tw: tar.NewWriter(w)}
+}
+
+// WriteNode writes the DAG of nd at fpath in the archive.
+func (w *TarWriter) WriteNode(nd ipld.Node, fpath string) error {
+	return w.walk(nd, fpath, func(h *tar.Header, f files.Node) error {
+		if err := w.tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if f, ok := f.(files.File); ok && h.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(w.tw, f); err != nil {
+				return err
+			}
+			return w.tw.Flush()
+		}
+		return nil
+	})
+}
+
+// Size returns how many bytes WriteNode followed by Close writes for the DAG
+// of nd, without reading the content of the files.
+func (w *TarWriter) Size(nd ipld.Node, fpath string) (int64, error) {
+	// the end of the archive is two empty blocks
+	size := int64(2 * blockSize)
+	err := w.walk(nd, fpath, func(h *tar.Header, f files.Node) error {
+		// the header does not depend on the previous entries
+		cw := &countingWriter{}
+		if err := tar.NewWriter(cw).WriteHeader(h); err != nil {
+			return err
+		}
+		size += cw.n
+		if h.Typeflag == tar.TypeReg {
+			size += (h.Size + blockSize - 1) / blockSize * blockSize
+		}
+		return nil
+	})
+	return size, err
+}
+
+const blockSize = 512
+
+type countingWriter struct{ n int64 }
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// walk calls f with the header and the file of each entry of the DAG of nd,
+// in the order of the archive.
+func (w *TarWriter) walk(nd ipld.Node, fpath string, f func(*tar.Header, files.Node) error) error {
+	h, file, err := w.header(nd, fpath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := f(h, file); err != nil {
+		return err
+	}
+
+	if _, ok := file.(files.Directory); !ok {
+		return nil
+	}
+	dir, err := uio.NewDirectoryFromNode(w.dag, nd)
+	if err != nil {
+		return err
+	}
+	return dir.ForEachLink(w.ctx, func(l *ipld.Link) error {
+		child, err := l.GetNode(w.ctx, w.dag)
+		if err != nil {
+			return err
+		}
+		return w.walk(child, gopath.Join(fpath, l.Name), f)
+	})
+}
+
+func (w *TarWriter) header(nd ipld.Node, fpath string) (*tar.Header, files.Node, error) {
+	meta, err := FromNode(nd)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := unixfile.NewUnixfsFile(w.ctx, w.dag, nd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	modTime := w.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	h := &tar.Header{Name: fpath, ModTime: modTime, PAXRecords: map[string]string{}}
+	switch f := f.(type) {
+	case *files.Symlink:
+		h.Typeflag = tar.TypeSymlink
+		h.Linkname = f.Target
+		h.Mode = 0777
+		h.ModTime = time.Time{}
+	case files.File:
+		size, err := f.Size()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		h.Typeflag = tar.TypeReg
+		h.Size = size
+		h.Mode = 0644
+	case files.Directory:
+		h.Typeflag = tar.TypeDir
+		h.Mode = 0777
+	default:
+		f.Close()
+		return nil, nil, fmt.Errorf("file type %T is not supported", f)
+	}
+	if meta.HasMode {
+		h.Mode = int64(ToPosix(meta.Mode))
+		h.PAXRecords[PAXModeRecord] = strconv.FormatInt(h.Mode, 8)
+	}
+	if !meta.Mtime.IsZero() {
+		h.ModTime = meta.Mtime
+		h.PAXRecords[PAXMtimeRecord] = meta.Mtime.UTC().Format(time.RFC3339Nano)
+	}
+	if len(h.PAXRecords) != 0 {
+		h.Format = tar.FormatPAX
+	}
+	return h, f, nil
+}
+
+// Close writes the end of the archive.
+func (w *TarWriter) Close() error {
+	return w.tw.Close()
+}
@@ -0,0 +1,234 @@
+// Package unixfsmeta reads and writes the optional mode and mtime of UnixFS
+// 1.5 nodes, which go-unixfs does not know about yet. They are stored as the
+// mode (7) and mtime (8) fields of the unixfs protobuf, which go-unixfs keeps
+// untouched as unknown fields.
+package unixfsmeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+const (
+	modeField  = 7
+	mtimeField = 8
+
+	mtimeSecondsField = 1
+	mtimeNanosField   = 2
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errInvalid = errors.New("invalid unixfs protobuf")
+
+// Meta is the metadata of a UnixFS node.
+type Meta struct {
+	// Mode holds the permission, setuid, setgid and sticky bits. It is only
+	// recorded when HasMode is set.
+	Mode    os.FileMode
+	HasMode bool
+	// Mtime is the zero time when not recorded.
+	Mtime time.Time
+}
+
+// Empty returns whether no metadata is recorded.
+func (m Meta) Empty() bool {
+	return !m.HasMode && m.Mtime.IsZero()
+}
+
+// Perm returns the recorded mode, or def when none is.
+func (m Meta) Perm(def os.FileMode) os.FileMode {
+	if !m.HasMode {
+		return def
+	}
+	return m.Mode
+}
+
+// FromFileMode returns the bits of mode recorded by UnixFS.
+func FromFileMode(mode os.FileMode) os.FileMode {
+	return mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// ToPosix returns the POSIX bits of mode, as recorded by UnixFS and tar.
+func ToPosix(mode os.FileMode) uint64 {
+	m := uint64(mode & os.ModePerm)
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// FromPosix returns the os.FileMode of POSIX mode bits.
+func FromPosix(m uint64) os.FileMode {
+	mode := os.FileMode(m) & os.ModePerm
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// field is a top-level field of a protobuf message.
+type field struct {
+	num   uint64
+	wire  uint64
+	value []byte // the value, without the tag
+	raw   []byte // the whole field, tag included
+}
+
+func parse(data []byte) ([]field, error) {
+	var fields []field
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errInvalid
+		}
+		f := field{num: tag >> 3, wire: tag & 7}
+		rest := data[n:]
+
+		var size int
+		switch f.wire {
+		case wireVarint:
+			_, size = binary.Uvarint(rest)
+			if size <= 0 {
+				return nil, errInvalid
+			}
+			f.value = rest[:size]
+		case wireFixed64:
+			size = 8
+		case wireFixed32:
+			size = 4
+		case wireBytes:
+			l, ln := binary.Uvarint(rest)
+			if ln <= 0 || uint64(len(rest)-ln) < l {
+				return nil, errInvalid
+			}
+			f.value = rest[ln : ln+int(l)]
+			size = ln + int(l)
+		default:
+			return nil, errInvalid
+		}
+		if len(rest) < size {
+			return nil, errInvalid
+		}
+		if f.value == nil {
+			f.value = rest[:size]
+		}
+		f.raw = data[:n+size]
+		fields = append(fields, f)
+		data = rest[size:]
+	}
+	return fields, nil
+}
+
+// Get returns the metadata recorded in the data of a unixfs node.
+func Get(data []byte) (Meta, error) {
+	fields, err := parse(data)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	var m Meta
+	for _, f := range fields {
+		switch {
+		case f.num == modeField && f.wire == wireVarint:
+			v, _ := binary.Uvarint(f.value)
+			m.Mode = FromPosix(v)
+			m.HasMode = true
+		case f.num == mtimeField && f.wire == wireBytes:
+			tf, err := parse(f.value)
+			if err != nil {
+				return Meta{}, err
+			}
+			var secs int64
+			var nanos uint32
+			for _, f := range tf {
+				switch {
+				case f.num == mtimeSecondsField && f.wire == wireVarint:
+					v, _ := binary.Uvarint(f.value)
+					secs = int64(v)
+				case f.num == mtimeNanosField && f.wire == wireFixed32:
+					nanos = binary.LittleEndian.Uint32(f.value)
+				}
+			}
+			if nanos >= uint32(time.Second) {
+				return Meta{}, errors.New("invalid unixfs mtime: more than a second of nanoseconds")
+			}
+			m.Mtime = time.Unix(secs, int64(nanos))
+		}
+	}
+	return m, nil
+}
+
+// Set returns the data of a unixfs node with the given metadata, replacing
+// the recorded one.
+func Set(data []byte, m Meta) ([]byte, error) {
+	fields, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(data)+32)
+	for _, f := range fields {
+		if f.num != modeField && f.num != mtimeField {
+			out = append(out, f.raw...)
+		}
+	}
+
+	if m.HasMode {
+		out = appendVarint(out, modeField<<3|wireVarint, ToPosix(m.Mode))
+	}
+	if !m.Mtime.IsZero() {
+		var t []byte
+		t = appendVarint(t, mtimeSecondsField<<3|wireVarint, uint64(m.Mtime.Unix()))
+		if nanos := m.Mtime.Nanosecond(); nanos != 0 {
+			t = appendUvarint(t, mtimeNanosField<<3|wireFixed32)
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], uint32(nanos))
+			t = append(t, b[:]...)
+		}
+		out = appendUvarint(out, mtimeField<<3|wireBytes)
+		out = appendUvarint(out, uint64(len(t)))
+		out = append(out, t...)
+	}
+	return out, nil
+}
+
+// FromNode returns the metadata of a unixfs node. Raw nodes have none.
+func FromNode(nd ipld.Node) (Meta, error) {
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return Meta{}, nil
+	}
+	return Get(pn.Data())
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, tag uint64, v uint64) []byte {
+	return appendUvarint(appendUvarint(b, tag), v)
+}
@@ -0,0 +1,77 @@
+package unixfsmeta
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	ft "github.com/ipfs/go-unixfs"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := ft.FilePBData([]byte("hello"), 5)
+	if m, err := Get(data); err != nil || !m.Empty() {
+		t.Fatalf("expected no metadata, got %+v, %v", m, err)
+	}
+
+	for _, m := range []Meta{
+		{Mode: 0640, HasMode: true},
+		{Mode: os.ModeSetgid | os.ModeSticky | 0755, HasMode: true, Mtime: time.Unix(981173106, 789000000)},
+		{Mtime: time.Unix(-1, 0)},
+	} {
+		withMeta, err := Set(data, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Get(withMeta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.HasMode != m.HasMode || got.Mode != m.Mode || !got.Mtime.Equal(m.Mtime) {
+			t.Fatalf("expected %+v, got %+v", m, got)
+		}
+
+		// go-unixfs keeps the fields it does not know about
+		fsn, err := ft.FSNodeFromBytes(withMeta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fsn.Data()) != "hello" {
+			t.Fatalf("unexpected data %q", fsn.Data())
+		}
+		fsn.SetData([]byte("hello world"))
+		rewritten, err := fsn.GetBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := Get(rewritten); err != nil || got.Mode != m.Mode || !got.Mtime.Equal(m.Mtime) {
+			t.Fatalf("expected the metadata to be kept, got %+v, %v", got, err)
+		}
+
+		// setting the metadata again replaces it
+		cleared, err := Set(withMeta, Meta{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(cleared) != string(data) {
+			t.Fatal("expected clearing the metadata to restore the original data")
+		}
+	}
+
+	if _, err := Get([]byte{0x0a, 0x05}); err == nil {
+		t.Fatal("expected truncated data to fail")
+	}
+}
+
+func TestPosix(t *testing.T) {
+	mode := os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0751
+	if p := ToPosix(mode); p != 07751 {
+		t.Fatalf("expected 07751, got %o", p)
+	}
+	if m := FromPosix(07751); m != mode {
+		t.Fatalf("expected %s, got %s", mode, m)
+	}
+	if m := FromFileMode(os.ModeDir | 0755); m != 0755 {
+		t.Fatalf("expected the type bits to be dropped, got %s", m)
+	}
+}